@@ -2,379 +2,141 @@ package main
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"sort"
+	"strings"
+	"github.com/acheong08/apple-corelocation-experiments/lib/binindex"
 	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
 
+	"github.com/DataDog/zstd"
+	"github.com/leaanthony/clir"
 	"github.com/schollz/progressbar/v3"
 )
 
-func main() {
-	if _, err := os.Stat(sortedName); os.IsNotExist(err) {
-		if _, err := os.Stat(inputName); os.IsNotExist(err) {
-			panic("no input files found")
-		}
-		sortBin()
-	}
-	if len(os.Args) != 2 {
-		log.Fatal("Usage: bsearch <BSSID>")
-	}
-	m, err := mac.Encode(os.Args[1])
-	if err != nil {
-		panic(err)
-	}
-	log.Println(m)
-	_, geocode, err := binarySearch(m)
-	if err != nil {
-		panic(err)
-	}
-	fmt.Println("Geocode: ", geocode)
-}
-
 const (
-	recordSize   = 16
-	int64Size    = 8
-	chunkSize    = 1000000         // Number of records per chunk
-	maxOpenFiles = 100             // Maximum number of files to merge at once
-	bufferSize   = 4 * 1024 * 1024 // 4MB buffer for reading
-	sortedName   = "sortedaps.bin"
-	inputName    = "wtfps.bin"
+	sortedName = "sortedaps.bin"
+	inputName  = "wtfps.bin"
 )
 
-func binarySearch(searchKey int64) (int64, int64, error) {
-	file, err := os.Open(sortedName)
-	if err != nil {
-		return 0, 0, fmt.Errorf("error opening file: %v", err)
-	}
-	defer file.Close()
-
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return 0, 0, fmt.Errorf("error getting file info: %v", err)
-	}
-
-	totalRecords := fileInfo.Size() / int64(recordSize)
-
-	low, high := int64(0), totalRecords-1
-
-	for low <= high {
-		mid := (low + high) / 2
-		offset := mid * int64(recordSize)
-
-		_, err = file.Seek(offset, 0)
+func main() {
+	layoutFlag := binindex.Geocode.String()
+	cli := clir.NewCli("bsearch", "Sort binary BSSID dumps and look up BSSIDs in them", "v0.0.1")
+	cli.StringFlag("layout", "Record layout as KEY:VALUE bytes, 8:8 for wtfps.bin or 6:16 for beacons.bin.zst", &layoutFlag)
+
+	in, out := inputName, sortedName
+	options := binindex.DefaultSortOptions
+	sortCmd := cli.NewSubCommandInheritFlags("sort", "Sort a dump into an index, decompressing .zst input")
+	sortCmd.StringFlag("in", "Unsorted dump", &in)
+	sortCmd.StringFlag("out", "Sorted index to write", &out)
+	sortCmd.IntFlag("chunk", "Records sorted in memory at once", &options.ChunkRecords)
+	sortCmd.StringFlag("tmp", "Directory for sorted chunks", &options.TempDir)
+	sortCmd.BoolFlag("unique", "Keep only the first record of each BSSID", &options.Unique)
+	sortCmd.Action(func() error {
+		layout, err := binindex.ParseLayout(layoutFlag)
 		if err != nil {
-			return 0, 0, fmt.Errorf("error seeking in file: %v", err)
+			return err
 		}
+		return sortDump(in, out, layout, options)
+	})
 
-		record := make([]byte, recordSize)
-		_, err = io.ReadFull(file, record)
+	index := sortedName
+	var bssids []string
+	getCmd := cli.NewSubCommandInheritFlags("get", "Look up BSSIDs in a sorted index")
+	getCmd.StringFlag("index", "Sorted index to search", &index)
+	getCmd.StringsFlag("bssid", "One or more BSSIDs", &bssids)
+	getCmd.Action(func() error {
+		layout, err := binindex.ParseLayout(layoutFlag)
 		if err != nil {
-			return 0, 0, fmt.Errorf("error reading record: %v", err)
+			return err
 		}
+		return lookup(index, layout, append(bssids, getCmd.OtherArgs()...))
+	})
 
-		key := int64(binary.BigEndian.Uint64(record[:8]))
-		value := int64(binary.BigEndian.Uint64(record[8:]))
-
-		if key == searchKey {
-			return key, value, nil
-		} else if key < searchKey {
-			low = mid + 1
-		} else {
-			high = mid - 1
+	// bsearch <BSSID> keeps working as before
+	cli.Action(func() error {
+		if len(cli.OtherArgs()) == 0 {
+			cli.PrintHelp()
+			return nil
 		}
-	}
-
-	return 0, 0, fmt.Errorf("key not found")
-}
-
-type Record struct {
-	Key   int64
-	Value int64
-}
-
-func sortBin() {
-	inputFile := inputName
-	outputFile := sortedName
-
-	// Get the total number of records
-	totalRecords, err := getTotalRecords(inputFile)
-	if err != nil {
-		fmt.Printf("Error getting total records: %v\n", err)
-		return
-	}
-
-	fmt.Printf("Total records: %d\n", totalRecords)
-
-	// Step 1: Split the input file into sorted chunks
-	fmt.Println("Splitting and sorting chunks...")
-	bar := progressbar.Default(int64(totalRecords))
-	chunkFiles, err := splitAndSortChunks(inputFile, bar)
-	if err != nil {
-		fmt.Printf("Error splitting and sorting chunks: %v\n", err)
-		return
-	}
-
-	// Step 2: Merge the sorted chunks
-	fmt.Println("\nMerging chunks...")
-	bar = progressbar.Default(int64(totalRecords))
-	err = mergeChunks(chunkFiles, outputFile, bar)
-	if err != nil {
-		fmt.Printf("Error merging chunks: %v\n", err)
-		return
-	}
-
-	// Clean up temporary chunk files
-	for _, file := range chunkFiles {
-		os.Remove(file)
-	}
-
-	fmt.Println("\nSorting completed successfully.")
-}
-
-func getTotalRecords(filename string) (int, error) {
-	fileInfo, err := os.Stat(filename)
-	if err != nil {
-		return 0, err
-	}
-	return int(fileInfo.Size()) / recordSize, nil
-}
-
-func splitAndSortChunks(inputFile string, bar *progressbar.ProgressBar) ([]string, error) {
-	file, err := os.Open(inputFile)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	var chunkFiles []string
-	chunkNum := 0
-	buffer := make([]byte, bufferSize)
-	records := make([]Record, 0, chunkSize)
-
-	for {
-		bytesRead, err := file.Read(buffer)
-		if err != nil && err != io.EOF {
-			return nil, err
+		layout, err := binindex.ParseLayout(layoutFlag)
+		if err != nil {
+			return err
 		}
-
-		for i := 0; i < bytesRead; i += recordSize {
-			if len(records) >= chunkSize {
-				// Sort and write the current chunk
-				sort.Slice(records, func(i, j int) bool {
-					return records[i].Key < records[j].Key
-				})
-
-				chunkFile := fmt.Sprintf("chunk_%d.tmp", chunkNum)
-				err = writeRecords(chunkFile, records)
-				if err != nil {
-					return nil, err
-				}
-
-				chunkFiles = append(chunkFiles, chunkFile)
-				chunkNum++
-				records = records[:0] // Clear the slice
-			}
-
-			if i+recordSize <= bytesRead {
-				record := Record{
-					Key:   int64(binary.BigEndian.Uint64(buffer[i : i+8])),
-					Value: int64(binary.BigEndian.Uint64(buffer[i+8 : i+16])),
-				}
-				records = append(records, record)
-				_ = bar.Add(1)
+		if _, err := os.Stat(sortedName); errors.Is(err, os.ErrNotExist) {
+			if err := sortDump(inputName, sortedName, layout, binindex.DefaultSortOptions); err != nil {
+				return err
 			}
 		}
-
-		if err == io.EOF {
-			break
-		}
+		return lookup(sortedName, layout, cli.OtherArgs())
+	})
+	if err := cli.Run(); err != nil {
+		log.Fatal(err)
 	}
-
-	// Handle the last chunk if there are any remaining records
-	if len(records) > 0 {
-		sort.Slice(records, func(i, j int) bool {
-			return records[i].Key < records[j].Key
-		})
-
-		chunkFile := fmt.Sprintf("chunk_%d.tmp", chunkNum)
-		err = writeRecords(chunkFile, records)
-		if err != nil {
-			return nil, err
-		}
-
-		chunkFiles = append(chunkFiles, chunkFile)
-	}
-
-	return chunkFiles, nil
 }
 
-func mergeChunks(chunkFiles []string, outputFile string, bar *progressbar.ProgressBar) error {
-	out, err := os.Create(outputFile)
+func sortDump(inPath, outPath string, layout binindex.Layout, options binindex.SortOptions) error {
+	f, err := os.Open(inPath)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-
-	for len(chunkFiles) > 0 {
-		// Merge up to maxOpenFiles chunks at a time
-		numFiles := min(len(chunkFiles), maxOpenFiles)
-		err := mergeNWay(chunkFiles[:numFiles], out, bar)
-		if err != nil {
-			return err
-		}
-		chunkFiles = chunkFiles[numFiles:]
-	}
-
-	return nil
-}
-
-func mergeNWay(inputFiles []string, out *os.File, bar *progressbar.ProgressBar) error {
-	if len(inputFiles) == 0 {
-		return fmt.Errorf("no input files provided")
-	}
-
-	files := make([]*os.File, len(inputFiles))
-	records := make([]Record, len(inputFiles))
-	valid := make([]bool, len(inputFiles))
-	buffers := make([][]byte, len(inputFiles))
-
-	for i, filename := range inputFiles {
-		f, err := os.Open(filename)
-		if err != nil {
-			return fmt.Errorf("error opening file %s: %v", filename, err)
-		}
-		defer f.Close()
-		files[i] = f
-
-		// Initialize buffer with actual data
-		buffers[i] = make([]byte, 0, bufferSize)
-		n, err := f.Read(buffers[i][:cap(buffers[i])])
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("error reading from file %s: %v", filename, err)
-		}
-		buffers[i] = buffers[i][:n]
-
-		if len(buffers[i]) >= recordSize {
-			records[i] = Record{
-				Key:   int64(binary.BigEndian.Uint64(buffers[i][:8])),
-				Value: int64(binary.BigEndian.Uint64(buffers[i][8:16])),
-			}
-			valid[i] = true
-			buffers[i] = buffers[i][recordSize:]
-		}
-	}
-
-	writeBuffer := make([]byte, bufferSize)
-	writeBufferPos := 0
-
-	for {
-		minIdx := -1
-		for i, v := range valid {
-			if v && (minIdx == -1 || records[i].Key < records[minIdx].Key) {
-				minIdx = i
-			}
-		}
-
-		if minIdx == -1 {
-			break // All files exhausted
-		}
-
-		// Write the smallest record to the buffer
-		binary.BigEndian.PutUint64(writeBuffer[writeBufferPos:], uint64(records[minIdx].Key))
-		binary.BigEndian.PutUint64(writeBuffer[writeBufferPos+8:], uint64(records[minIdx].Value))
-		writeBufferPos += recordSize
-
-		if writeBufferPos == bufferSize {
-			// Write the full buffer to the output file
-			_, err := out.Write(writeBuffer)
-			if err != nil {
-				return fmt.Errorf("error writing to output file: %v", err)
-			}
-			writeBufferPos = 0
-		}
-
-		_ = bar.Add(1)
-
-		// Read the next record from the file we just used
-		if len(buffers[minIdx]) >= recordSize {
-			records[minIdx] = Record{
-				Key:   int64(binary.BigEndian.Uint64(buffers[minIdx][:8])),
-				Value: int64(binary.BigEndian.Uint64(buffers[minIdx][8:16])),
-			}
-			buffers[minIdx] = buffers[minIdx][recordSize:]
-		} else {
-			// Refill the buffer
-			buffers[minIdx] = buffers[minIdx][:cap(buffers[minIdx])]
-			n, err := files[minIdx].Read(buffers[minIdx])
-			if err != nil && err != io.EOF {
-				return fmt.Errorf("error reading from file %s: %v", inputFiles[minIdx], err)
-			}
-			buffers[minIdx] = buffers[minIdx][:n]
-
-			if len(buffers[minIdx]) >= recordSize {
-				records[minIdx] = Record{
-					Key:   int64(binary.BigEndian.Uint64(buffers[minIdx][:8])),
-					Value: int64(binary.BigEndian.Uint64(buffers[minIdx][8:16])),
-				}
-				buffers[minIdx] = buffers[minIdx][recordSize:]
-			} else {
-				valid[minIdx] = false
-			}
-		}
+	defer f.Close()
+	var r io.Reader = f
+	total := int64(-1)
+	if strings.HasSuffix(inPath, ".zst") {
+		zr := zstd.NewReader(f)
+		defer zr.Close()
+		r = zr
+	} else if info, err := f.Stat(); err == nil {
+		total = info.Size() / int64(layout.Size())
+	}
+	bar := progressbar.Default(total)
+	options.Progress = func(records int64) {
+		_ = bar.Set64(records)
+	}
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
 	}
-
-	// Write any remaining records in the write buffer
-	if writeBufferPos > 0 {
-		_, err := out.Write(writeBuffer[:writeBufferPos])
-		if err != nil {
-			return fmt.Errorf("error writing final buffer to output file: %v", err)
-		}
+	if err := binindex.Sort(r, out, layout, options); err != nil {
+		out.Close()
+		return err
 	}
-
-	return nil
+	_ = bar.Finish()
+	return out.Close()
 }
 
-func writeRecords(filename string, records []Record) error {
-	file, err := os.Create(filename)
+func lookup(path string, layout binindex.Layout, bssids []string) error {
+	if len(bssids) == 0 {
+		return errors.New("no BSSIDs given")
+	}
+	idx, err := binindex.Open(path, layout)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	buffer := make([]byte, bufferSize)
-	bufferPos := 0
-
-	for _, record := range records {
-		binary.BigEndian.PutUint64(buffer[bufferPos:], uint64(record.Key))
-		binary.BigEndian.PutUint64(buffer[bufferPos+8:], uint64(record.Value))
-		bufferPos += recordSize
-
-		if bufferPos == bufferSize {
-			_, err := file.Write(buffer)
-			if err != nil {
-				return err
-			}
-			bufferPos = 0
-		}
-	}
-
-	// Write any remaining records in the buffer
-	if bufferPos > 0 {
-		_, err := file.Write(buffer[:bufferPos])
+	defer idx.Close()
+	keys := make([][]byte, len(bssids))
+	for i, b := range bssids {
+		a, err := mac.ParseAddr(b)
 		if err != nil {
 			return err
 		}
+		keys[i] = layout.Uint64Key(uint64(a))
 	}
-
-	return nil
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
+	for i, value := range idx.LookupBatch(keys) {
+		switch {
+		case value == nil:
+			fmt.Printf("%s: not found\n", bssids[i])
+		case layout.ValueSize == 16:
+			lat, lon := binindex.Coordinates(value)
+			fmt.Printf("%s: %f, %f\n", bssids[i], lat, lon)
+		case layout.ValueSize == 8:
+			fmt.Printf("%s: Geocode %d\n", bssids[i], int64(binary.BigEndian.Uint64(value)))
+		default:
+			fmt.Printf("%s: %x\n", bssids[i], value)
+		}
 	}
-	return b
+	return nil
 }
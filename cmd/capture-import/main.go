@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/capture"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+	"io"
+	"log"
+	"os"
+)
+
+// capture-import decodes the requests our own devices made to Apple's
+// location endpoints from mitmproxy dumps, HAR files or JSONL, and writes
+// them as JSON lines and/or into the captures table of the store. Fields
+// missing from our .proto files are recorded in unknown_fields as the
+// crawlers do.
+func main() {
+	var (
+		jsonPath = flag.String("json", "", "Write one JSON object per exchange to this file, - for stdout (default stdout without -db)")
+		dbPath   = flag.String("db", "", "Store to save exchanges and unknown protobuf fields to")
+		all      = flag.Bool("all", false, "Keep flows to other URLs too, undecoded")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-json out.jsonl] [-db wloc.db] [capture.flow | capture.har | capture.jsonl]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *jsonPath == "" && *dbPath == "" {
+		*jsonPath = "-"
+	}
+
+	audit := lib.NewAudit()
+	var exchanges []capture.Exchange
+	for _, path := range flag.Args() {
+		flows, err := capture.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to read %s after %d flows: %v", path, len(flows), err)
+		}
+		kept, failed := 0, 0
+		for _, f := range flows {
+			e := capture.Decode(f)
+			if e.Endpoint == "" && !*all {
+				continue
+			}
+			audit.Observe(e.Request)
+			audit.Observe(e.Response)
+			if len(e.Errors) > 0 {
+				failed++
+			}
+			exchanges = append(exchanges, e)
+			kept++
+		}
+		log.Printf("Decoded %d of %d flows from %s, %d with errors", kept, len(flows), path, failed)
+	}
+
+	if *jsonPath != "" {
+		if err := writeJSON(*jsonPath, exchanges); err != nil {
+			log.Fatalf("Failed to write JSON: %v", err)
+		}
+	}
+	if *dbPath != "" {
+		s, err := store.Open(*dbPath)
+		if err != nil {
+			log.Fatalf("Failed to open store: %v", err)
+		}
+		defer s.Close()
+		ctx := context.Background()
+		n, err := s.SaveCaptures(ctx, exchanges)
+		if err != nil {
+			log.Fatalf("Failed to save captures: %v", err)
+		}
+		if err := s.SaveUnknownFields(ctx, audit.Take()); err != nil {
+			log.Fatalf("Failed to save unknown protobuf fields: %v", err)
+		}
+		log.Printf("Saved %d new exchanges to %s", n, *dbPath)
+	}
+}
+
+func writeJSON(path string, exchanges []capture.Exchange) error {
+	var w io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, e := range exchanges {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
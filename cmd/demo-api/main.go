@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"errors"
 	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/distance"
+	"github.com/acheong08/apple-corelocation-experiments/lib/geo/datum"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mbtiles"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/a-h/templ"
 	"github.com/acheong08/clir"
 
 	"github.com/labstack/echo/v4"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
 )
 
 //go:embed main.js
@@ -23,20 +34,139 @@ type gps struct {
 	Long float64 `json:"long"`
 }
 
+type bounds struct {
+	North float64 `query:"north"`
+	South float64 `query:"south"`
+	East  float64 `query:"east"`
+	West  float64 `query:"west"`
+}
+
+// Every point returned so far, so panning the map can redraw them without
+// asking Apple again
+var (
+	seen     = distance.NewIndex(nil)
+	seenIds  = make(map[string]bool)
+	seenLock sync.Mutex
+)
+
+// apWriter persists results when the demo is started with -db
+var apWriter *store.Writer[store.AP]
+
+// loadStore fills the index of seen points from the store and keeps new
+// results in it
+func loadStore(path string) error {
+	s, err := store.Open(path)
+	if err != nil {
+		return err
+	}
+	idx, err := s.Index(context.Background())
+	if err != nil {
+		return err
+	}
+	seenLock.Lock()
+	seen = idx
+	for _, p := range idx.Within(orb.Bound{Min: orb.Point{-180, -90}, Max: orb.Point{180, 90}}) {
+		seenIds[p.Id] = true
+	}
+	seenLock.Unlock()
+	log.Printf("Loaded %d points from %s", idx.Len(), path)
+	apWriter = s.APWriter("demo-api").WithBatchSize(1)
+	return nil
+}
+
+// serveTiles adds routes for a coverage tile set made by cmd/mbtiles. Tiles
+// are stored gzipped, so they are sent as they are.
+func serveTiles(e *echo.Echo, path string) error {
+	m, err := mbtiles.Open(path)
+	if err != nil {
+		return err
+	}
+	e.GET("/tiles.json", func(c echo.Context) error {
+		metadata, err := m.Metadata(c.Request().Context())
+		if err != nil {
+			return err
+		}
+		return c.JSON(200, metadata)
+	})
+	e.GET("/tiles/:z/:x/:y", func(c echo.Context) error {
+		z, errZ := strconv.ParseUint(c.Param("z"), 10, 8)
+		x, errX := strconv.ParseUint(c.Param("x"), 10, 32)
+		y, errY := strconv.ParseUint(strings.TrimSuffix(c.Param("y"), ".pbf"), 10, 32)
+		if errZ != nil || errX != nil || errY != nil {
+			return c.String(400, "Bad Request")
+		}
+		t := maptile.New(uint32(x), uint32(y), maptile.Zoom(z))
+		if !t.Valid() {
+			return c.String(400, "Bad Request")
+		}
+		data, err := m.Tile(c.Request().Context(), t)
+		if errors.Is(err, mbtiles.ErrNotFound) {
+			return c.NoContent(204)
+		}
+		if err != nil {
+			return err
+		}
+		c.Response().Header().Set("content-encoding", "gzip")
+		return c.Blob(200, "application/x-protobuf", data)
+	})
+	log.Printf("Serving coverage tiles from %s", path)
+	return nil
+}
+
+// mapDatum is what the map tiles expect. Baidu's tiles are in BD-09.
+var mapDatum = datum.WGS84
+
+func toMap(points []distance.Point) []distance.Point {
+	out := make([]distance.Point, len(points))
+	for i, p := range points {
+		out[i] = p
+		out[i].Y, out[i].X = datum.Convert(p.Y, p.X, datum.WGS84, mapDatum)
+	}
+	return out
+}
+
+func remember(points []distance.Point) {
+	seenLock.Lock()
+	defer seenLock.Unlock()
+	for _, p := range points {
+		if !seenIds[p.Id] {
+			seenIds[p.Id] = true
+			seen.Insert(p)
+		}
+	}
+}
+
 func main() {
 	lat := 51.51493459648336
 	long := -3.1548554460964624
 	var china bool
+	var dbPath string
+	var tilesPath string
 	cli := clir.NewCli("demo", "Interactive user interface to demonstrate the functionality of Apple's Geolocation services", "v0.0.1")
 
 	cli.WithFlags(
 		clir.Float64Flag("lat", "default latitude", &lat),
 		clir.Float64Flag("long", "default longitude", &long),
 		clir.BoolFlag("china", "use the Chinese API", &china),
+		clir.StringFlag("db", "store database to load points from and save results to", &dbPath),
+		clir.StringFlag("mbtiles", "coverage tile set from cmd/mbtiles to show on the map", &tilesPath),
 	)
 
 	cli.Action(func() error {
+		if china {
+			mapDatum = datum.BD09
+		}
+		if dbPath != "" {
+			if err := loadStore(dbPath); err != nil {
+				return err
+			}
+		}
 		e := echo.New()
+		if tilesPath != "" {
+			if err := serveTiles(e, tilesPath); err != nil {
+				return err
+			}
+		}
 		e.GET("/", func(c echo.Context) error {
 			return Render(c, 200, Index(lat, long, china))
 		})
@@ -61,17 +191,52 @@ func main() {
 				options = append(options, lib.Options.WithRegion(lib.Options.China))
 			}
 
-			points, err := lib.SearchProximity(g.Lat, g.Long, 20, options...)
-			if err != nil {
+			g.Lat, g.Long = datum.Convert(g.Lat, g.Long, mapDatum, datum.WGS84)
+			neighbours, err := lib.Nearest(g.Lat, g.Long,
+				lib.Options.WithK(100),
+				lib.Options.WithRequestBudget(20),
+				lib.Options.WithQueryOptions(options...))
+			if err != nil || len(neighbours) == 0 {
 				log.Println(err)
 				return c.String(404, "did not find any points nearby")
 			}
+			aps := make([]lib.AP, len(neighbours))
+			points := make([]distance.Point, len(neighbours))
+			for i, n := range neighbours {
+				aps[i] = n.AP
+				points[i] = distance.Point{
+					Id: n.BSSID.String(),
+					Y:  n.Location.Lat,
+					X:  n.Location.Long,
+				}
+			}
+
+			remember(points)
+			if apWriter != nil {
+				if err := apWriter.Add(store.NewAPs(aps)...); err != nil {
+					log.Println(err)
+				}
+			}
+			points = toMap(points)
 
 			return c.JSON(200, map[string]any{
 				"closest": points[0],
 				"points":  points[1:],
 			})
 		})
+		e.GET("/points", func(c echo.Context) error {
+			var b bounds
+			if err := c.Bind(&b); err != nil || b.South > b.North {
+				return c.String(400, "Bad Request")
+			}
+			b.South, b.West = datum.Convert(b.South, b.West, mapDatum, datum.WGS84)
+			b.North, b.East = datum.Convert(b.North, b.East, mapDatum, datum.WGS84)
+			points := seen.Within(orb.Bound{
+				Min: orb.Point{b.West, b.South},
+				Max: orb.Point{b.East, b.North},
+			})
+			return c.JSON(200, toMap(points))
+		})
 		e.Logger.Fatal(e.Start("127.0.0.1:1974"))
 		return nil
 	})
@@ -64,9 +64,62 @@ if (china == "true") {
   map = L.map("map", {}).setView([lat, long], 13).addLayer(osmLayer);
 }
 
+// Coverage density from a tile set served with -mbtiles. The tiles are in
+// WGS-84, so they are not shown over Baidu's map.
+async function addCoverage() {
+  if (china == "true") return;
+  const resp = await fetch("/tiles.json");
+  if (!resp.ok) return;
+  const metadata = await resp.json();
+  await new Promise((resolve, reject) => {
+    const script = document.createElement("script");
+    script.src =
+      "https://unpkg.com/leaflet.vectorgrid@1.3.0/dist/Leaflet.VectorGrid.bundled.min.js";
+    script.onload = resolve;
+    script.onerror = reject;
+    document.head.appendChild(script);
+  });
+  const style = {};
+  style[metadata.name] = (properties) => {
+    const count = properties.count || 1;
+    return {
+      radius: Math.min(2 + Math.log2(count) * 2, 20),
+      fill: true,
+      fillOpacity: 0.4,
+      fillColor: count > 1 ? "#d33" : "#36c",
+      stroke: false,
+    };
+  };
+  L.vectorGrid
+    .protobuf("/tiles/{z}/{x}/{y}.pbf", {
+      vectorTileLayerStyles: style,
+      maxNativeZoom: parseInt(metadata.maxzoom),
+      interactive: false,
+    })
+    .addTo(map);
+}
+addCoverage();
+
+const plotted = new Set();
 function plotPoint(p) {
+  if (plotted.has(p.id)) return;
+  plotted.add(p.id);
   L.marker([p.y, p.x]).addTo(map).bindPopup(p.id);
 }
+
+async function onMapMove() {
+  const b = map.getBounds();
+  const params = new URLSearchParams({
+    north: b.getNorth(),
+    south: b.getSouth(),
+    east: b.getEast(),
+    west: b.getWest(),
+  });
+  const resp = await fetch("/points?" + params);
+  if (!resp.ok) return;
+  (await resp.json()).forEach(plotPoint);
+}
+map.on("moveend", onMapMove);
 let blocker = false;
 async function onMapClick(e) {
   if (blocker) return;
@@ -2,285 +2,150 @@ package main
 
 import (
 	"context"
-	"database/sql"
-	"encoding/binary"
-	"fmt"
-	"log"
-	"math"
+	"flag"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
-	"strings"
-	"sync"
 	"syscall"
+	"time"
 	"github.com/acheong08/apple-corelocation-experiments/lib"
-	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
-	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+	"github.com/acheong08/apple-corelocation-experiments/lib/crawl"
+	"github.com/acheong08/apple-corelocation-experiments/lib/recstream"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
 
-	"github.com/DataDog/zstd"
-	"github.com/tidwall/btree"
-	_ "modernc.org/sqlite"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const NUM_THREADS = 8
 
-type BeSet struct {
-	btree.Set[int64]
-	sync.RWMutex
-}
+// DB_PATH is shared with seedcrawl, which collects the seeds
+const DB_PATH = "wloc.db"
 
-var explored BeSet
+// FRONTIER_PATH is shared with seedcrawl and recovery
+const FRONTIER_PATH = "crawl.db"
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
+	streamPath := flag.String("stream", "", "Append results to a record stream such as beacons.wlrs instead of the store")
+	regionSpec := flag.String("region", "", "Only expand within a bounding box (west,south,east,north), .geojson file or country code")
+	metricsAddr := flag.String("metrics", "", "Serve Prometheus /metrics and JSON /status on an address such as :9100")
+	flag.Parse()
+	var region *crawl.Region
+	if *regionSpec != "" {
+		var err error
+		if region, err = crawl.ParseRegion(*regionSpec); err != nil {
+			panic(err)
+		}
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
-	db, err := sql.Open("sqlite", "seeds.db")
+	s, err := store.Open(DB_PATH)
 	if err != nil {
 		panic(err)
 	}
-	// Start thread for database fetching (with buffered channel streaming bssids to crawl)
-	log.Println("Starting database stream")
-	seeds := databaseStream(ctx, db)
-	log.Println("Starting writer")
-	// Use separate cancellation as this must be done after all threads are done
-	writerCtx, writerCancel := context.WithCancel(ctx)
-	defer writerCancel()
-	// go zstApWriter(ctx)
-	go sqliteApWriter(writerCtx)
-	threadCtx, threadCancel := context.WithCancel(ctx)
-	// Start threads to process and explore the bssids
-	wait := sync.WaitGroup{}
-	for i := range NUM_THREADS {
-		wait.Add(1)
-		go func() {
-			defer wait.Done()
-			log.Println("Starting thread: #", i)
-			exploreArea(threadCtx, seeds)
-		}()
-	}
-	log.Println("Waiting for exit")
-	die := make(chan os.Signal, 1)
-	signal.Notify(die, os.Interrupt, syscall.SIGTERM)
-	<-die
-	threadCancel()
-	wait.Wait()
-}
-
-type Seed struct {
-	bssid   int64
-	tilekey int64
-}
-
-const DB_BATCHSIZE = 128
-
-func databaseStream(ctx context.Context, db *sql.DB) <-chan Seed {
-	c := make(chan Seed, 512)
-	// Select bssid group by tilekey
-	go func() {
-		offset := 0
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				rows, err := db.Query("SELECT bssid, tilekey FROM seeds GROUP BY tilekey LIMIT ? OFFSET ?", DB_BATCHSIZE, offset*DB_BATCHSIZE)
-				if err != nil {
-					log.Println(err)
-					return
-				}
-
-				offset++
-				for rows.Next() {
-
-					var bssid int64
-					var tilekey int64
-					err = rows.Scan(&bssid, &tilekey)
-					if err != nil {
-						panic(err)
-					}
-					c <- Seed{bssid, tilekey}
-				}
-				rows.Close()
-				log.Println("Streamed 1 batch")
-			}
-		}
-	}()
-	return c
-}
-
-const SAVE_FILE = "beacons.bin.zst"
-
-// 6 bytes for bssid, 16 for lat/lon = 22
-const RECORD_SIZE = 6 + 8 + 8
-
-type Record struct {
-	bssid    int64
-	lat, lon float64
-}
-
-var writeCh = make(chan Record)
-
-func sqliteApWriter(ctx context.Context) {
-	db, err := sql.Open("sqlite", "beacons.db")
+	defer s.Close()
+	f, err := crawl.OpenFrontier(FRONTIER_PATH)
 	if err != nil {
 		panic(err)
 	}
-	defer db.Close()
-	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS beacons (bssid INTEGER PRIMARY KEY, lat REAL NOT NULL, lon REAL NOT NULL)"); err != nil {
-		panic(err)
+	defer f.Close()
+
+	var sink interface {
+		crawl.Sink
+		Close() error
 	}
-	bulkInsert := func(unsavedRows []Record) error {
-		valueStrings := make([]string, len(unsavedRows))
-		valueArgs := make([]interface{}, len(unsavedRows)*3)
-		for i, post := range unsavedRows {
-			valueStrings[i] = "(?,?,?)"
-			valueArgs[i*3] = post.bssid
-			valueArgs[i*3+1] = post.lat
-			valueArgs[i*3+2] = post.lon
+	if *streamPath != "" {
+		w, err := recstream.OpenWriter(*streamPath, recstream.BeaconHeader)
+		if err != nil {
+			panic(err)
 		}
-		stmt := fmt.Sprintf("INSERT OR IGNORE INTO beacons (bssid, lat, lon) VALUES %s",
-			strings.Join(valueStrings, ","))
-		_, err := db.Exec(stmt, valueArgs...)
-		return err
+		sink = streamSink{w}
+	} else {
+		sink = crawl.NewStoreSink(s, "domain-expansion")
 	}
-	records := make([]Record, 400)
-	n := 0
-	for record := range writeCh {
-		select {
-		case <-ctx.Done():
-			close(writeCh)
-			if err := bulkInsert(records); err != nil {
-				panic(err)
-			}
-			return
-		default:
-			if n == len(records) {
-				if err := bulkInsert(records); err != nil {
-					panic(err)
-				}
-				records = make([]Record, 400*NUM_THREADS)
-				n = 0
-				log.Println("Written to DB")
-			}
-			records[n] = record
-			n++
+	defer func() {
+		if err := sink.Close(); err != nil {
+			slog.Error("failed to flush access points", "err", err)
 		}
-	}
-}
-
-const BUFFER_SIZE = RECORD_SIZE * 400 * NUM_THREADS
+	}()
 
-func zstApWriter(ctx context.Context) {
-	var f *os.File
-	var err error
-	if _, err = os.Stat(SAVE_FILE); os.IsNotExist(err) {
-		f, err = os.Create(SAVE_FILE)
-	} else {
-		f, err = os.Open(SAVE_FILE)
+	reg := prometheus.NewRegistry()
+	metrics := crawl.NewMetrics(reg, f)
+	audit := lib.NewAudit()
+	engine := crawl.New(f).
+		WithWorkers(NUM_THREADS).
+		WithFeed(seedFeed(s, region)).
+		WithMetrics(metrics).
+		WithReport(time.Minute).
+		Handle(crawl.KindBSSID, crawl.ExpandHandler(metrics.Sink(sink), region, lib.Options.WithClient(metrics.Client()), lib.Options.WithAudit(audit)))
+	if *metricsAddr != "" {
+		go func() {
+			slog.Error("metrics server stopped", "err", http.ListenAndServe(*metricsAddr, crawl.MonitorHandler(engine, reg)))
+		}()
 	}
-	if err != nil {
-		panic(err)
+	if err := engine.Run(ctx); err != nil && err != context.Canceled {
+		slog.Error("crawl stopped", "err", err)
 	}
-	zf := zstd.NewWriter(f)
-	defer zf.Close()
-	var buffer []byte = make([]byte, BUFFER_SIZE)
-	n := 0
-	for record := range writeCh {
-		select {
-		case <-ctx.Done():
-		default:
-			if (n * RECORD_SIZE) == len(buffer) {
-				_, err := zf.Write(buffer)
-				if err != nil {
-					panic(err)
-				}
-				buffer = make([]byte, BUFFER_SIZE)
-				n = 0
-
-				log.Println("Buffer written")
-			}
-			bRecord := make([]byte, RECORD_SIZE)
-			bbssid := make([]byte, 8)
-			binary.BigEndian.PutUint64(bbssid, uint64(record.bssid))
-			copy(bRecord[0:6], bbssid[2:])
-			binary.BigEndian.PutUint64(bRecord[6:14], math.Float64bits(record.lat))
-			binary.BigEndian.PutUint64(bRecord[14:22], math.Float64bits(record.lon))
-			copy(buffer[RECORD_SIZE*n:RECORD_SIZE*(n+1)], bRecord)
-			n++
-		}
+	if err := s.SaveUnknownFields(context.Background(), audit.Take()); err != nil {
+		slog.Error("failed to save unknown protobuf fields", "err", err)
 	}
 }
 
-const MORTON_LEVEL = 20
-
-func apsToMap(a []lib.AP, b map[int64]int64) {
-	for _, ap := range a {
-		bssid, _ := mac.Encode(ap.BSSID)
-		code := morton.Encode(ap.Location.Lat, ap.Location.Long, MORTON_LEVEL)
-		writeCh <- Record{
-			lat:   ap.Location.Lat,
-			lon:   ap.Location.Long,
-			bssid: bssid,
+// seedFeed queues one access point from every tile in the store, or only
+// those in region, once
+func seedFeed(s *store.Store, region *crawl.Region) crawl.Feed {
+	checkpoint := "domain-expansion"
+	if region != nil {
+		checkpoint += ":" + region.Name
+	}
+	return func(ctx context.Context, f *crawl.Frontier, _ string) (bool, error) {
+		var seeded bool
+		if _, err := f.LoadCheckpoint(ctx, checkpoint, &seeded); err != nil || seeded {
+			return false, err
+		}
+		var batch []crawl.Job
+		err := s.EachTileSeed(ctx, func(ap store.AP) error {
+			if region != nil && !region.Contains(ap.Lat, ap.Lon) {
+				return nil
+			}
+			batch = append(batch, crawl.BSSIDJob(lib.AP{
+				BSSID:    ap.BSSID,
+				Location: lib.Location{Lat: ap.Lat, Long: ap.Lon},
+			}, 0))
+			if len(batch) < 1000 {
+				return nil
+			}
+			_, err := f.Push(ctx, batch...)
+			batch = batch[:0]
+			return err
+		})
+		if err != nil {
+			return false, err
 		}
-		explored.RLock()
-		if explored.Contains(code) {
-			explored.RUnlock()
-			continue
+		if _, err := f.Push(ctx, batch...); err != nil {
+			return false, err
 		}
-		explored.RUnlock()
-		b[code] = bssid
-		explored.Lock()
-		explored.Insert(code)
-		explored.Unlock()
+		slog.Info("queued all seeds")
+		return false, f.SaveCheckpoint(ctx, checkpoint, true)
 	}
 }
 
-func getRandomFromMap[T comparable, V any](m map[T]V) (T, V, bool) {
-	for t, v := range m {
-		delete(m, t)
-		return t, v, true
-	}
-	var dt T
-	var dv V
-	return dt, dv, false
+// streamSink appends access points to a record stream instead of the
+// store, for crawls too large to index as they run
+type streamSink struct {
+	w *recstream.Writer
 }
 
-func exploreArea(ctx context.Context, c <-chan Seed) {
-	// Loop through channel
-	for seed := range c {
-		log.Println("Crawling seed: ", seed.tilekey)
-		// Tilekey to bssid
-		toExplore := make(map[int64]int64)
-		// Populate via seed
-		aps, err := lib.QueryBssid([]string{mac.Decode(seed.bssid)}, 0)
-		if err != nil {
-			log.Println("Failed to query bssid: ", err)
-			continue
-		}
-		apsToMap(aps, toExplore)
-	explorationLoop:
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				// Explore seed and keep adding to explored until there's nothing left
-				if len(toExplore) == 0 {
-					log.Println("broke")
-					break explorationLoop
-				}
-				tilekey, bssid, ok := getRandomFromMap(toExplore)
-				if !ok {
-					break explorationLoop
-				}
-				delete(toExplore, tilekey)
+func (s streamSink) Tile(int64, []lib.AP, error) {}
 
-				aps, err := lib.QueryBssid([]string{mac.Decode(bssid)}, 0)
-				if err != nil {
-					log.Println("Failed to query bssid: ", err)
-					continue
-				}
-				apsToMap(aps, toExplore)
-			}
+func (s streamSink) APs(aps []lib.AP) {
+	for _, ap := range store.NewAPs(aps) {
+		if err := s.w.Write(recstream.EncodeBeacon(ap.BSSID, ap.Lat, ap.Lon)); err != nil {
+			panic(err)
 		}
 	}
 }
+
+func (s streamSink) Close() error {
+	return s.w.Close()
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"slices"
 	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
 	"github.com/acheong08/apple-corelocation-experiments/lib/multilateration"
 
 	"github.com/labstack/echo/v4"
@@ -33,9 +34,12 @@ func main() {
 			if result.Location.Long == -180 {
 				continue
 			}
-			if i := slices.Index(macs, result.BSSID); i != -1 {
-				merger[result.BSSID] = multilateration.AccessPoint{
-					Mac:            result.BSSID,
+			if i := slices.IndexFunc(macs, func(m string) bool {
+				a, err := mac.ParseAddr(m)
+				return err == nil && a == result.BSSID
+			}); i != -1 {
+				merger[result.BSSID.String()] = multilateration.AccessPoint{
+					Mac:            result.BSSID.String(),
 					Location:       result.Location,
 					SignalStrength: req.APs[i].SignalStrength,
 				}
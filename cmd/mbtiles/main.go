@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mbtiles"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+	"log"
+	"os"
+
+	"github.com/paulmach/orb/maptile"
+)
+
+// mbtiles builds a vector tile set of every access point in the store, for
+// demo-api -mbtiles or any MBTiles viewer such as QGIS
+func main() {
+	opts := mbtiles.DefaultOptions
+	var (
+		dbPath    = flag.String("db", "wloc.db", "Path to the store database")
+		minZoom   = flag.Uint("minzoom", uint(opts.MinZoom), "Lowest zoom level")
+		maxZoom   = flag.Uint("maxzoom", uint(opts.MaxZoom), "Highest zoom level")
+		pointZoom = flag.Uint("pointzoom", uint(opts.PointZoom), "First zoom level with individual access points instead of clusters")
+		maxPoints = flag.Int("maxpoints", opts.MaxTilePoints, "Cluster tiles with more access points than this")
+	)
+	flag.StringVar(&opts.Layer, "layer", opts.Layer, "Name of the vector tile layer")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] out.mbtiles\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.MinZoom = maptile.Zoom(*minZoom)
+	opts.MaxZoom = maptile.Zoom(*maxZoom)
+	opts.PointZoom = maptile.Zoom(*pointZoom)
+	opts.MaxTilePoints = *maxPoints
+
+	s, err := store.Open(*dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+	defer s.Close()
+	m, err := mbtiles.Create(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", flag.Arg(0), err)
+	}
+	defer m.Close()
+
+	stats, err := mbtiles.Generate(context.Background(), s, m, opts)
+	if err != nil {
+		log.Fatalf("Failed to generate tiles: %v", err)
+	}
+	for z := opts.MinZoom; z <= opts.MaxZoom; z++ {
+		log.Printf("Zoom %2d: %d tiles", z, stats.Zooms[z])
+	}
+	log.Printf("Wrote %d tiles of %d access points to %s", stats.Tiles, stats.APs, flag.Arg(0))
+}
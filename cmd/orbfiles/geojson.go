@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/paulmach/orb"
+)
+
+// GeoJSON is read and written with encoding/json since only polygons are
+// needed, and orb.Point already marshals as a coordinate pair. Any altitude
+// in the input is dropped.
+type geoJSONCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string           `json:"type"`
+	Geometry   *geoJSONGeometry `json:"geometry"`
+	Properties map[string]any   `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+func newGeoJSONFeature(g any, properties map[string]any) geoJSONFeature {
+	typ := "MultiPolygon"
+	if _, ok := g.(orb.Polygon); ok {
+		typ = "Polygon"
+	}
+	coordinates, _ := json.Marshal(g)
+	return geoJSONFeature{Type: "Feature", Geometry: &geoJSONGeometry{typ, coordinates}, Properties: properties}
+}
+
+func readGeoJSON(path, field string) ([]feature, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fc geoJSONCollection
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return nil, err
+	}
+	if fc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("expected a FeatureCollection, got %q", fc.Type)
+	}
+	var features []feature
+	for i, gf := range fc.Features {
+		if gf.Geometry == nil {
+			continue
+		}
+		var f feature
+		switch gf.Geometry.Type {
+		case "Polygon":
+			var p orb.Polygon
+			err = json.Unmarshal(gf.Geometry.Coordinates, &p)
+			f.polygons = []orb.Polygon{p}
+		case "MultiPolygon":
+			err = json.Unmarshal(gf.Geometry.Coordinates, &f.polygons)
+		default:
+			return nil, fmt.Errorf("feature %d: unsupported geometry %s", i, gf.Geometry.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %w", i, err)
+		}
+		if field != "" {
+			code, ok := gf.Properties[field]
+			if !ok {
+				return nil, fmt.Errorf("feature %d: property %s not found", i, field)
+			}
+			f.code = strings.TrimSpace(fmt.Sprint(code))
+		}
+		features = append(features, f)
+	}
+	return features, nil
+}
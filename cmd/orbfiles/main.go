@@ -2,13 +2,16 @@ package main
 
 import (
 	"encoding/gob"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+	"github.com/acheong08/apple-corelocation-experiments/lib/shapefiles"
 	"log"
 	"os"
-	"slices"
+	"path/filepath"
+	"strings"
 
-	shp "github.com/jonas-p/go-shp"
 	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/planar"
 	"github.com/paulmach/orb/project"
@@ -16,101 +19,188 @@ import (
 
 const level = 7
 
+var (
+	countries   = flag.String("countries", "", "Group polygons by this attribute, such as Natural Earth's ISO_A2")
+	bucket      = flag.Bool("map", false, "Bucket polygons by their level 7 morton tile, as used for water.morb")
+	format      = flag.String("format", "gob", "Output format: gob, geojson or bitmap")
+	bitmapLevel = flag.Int("level", 13, "Tile level of the bitmap format")
+	mercator    = flag.Bool("mercator", false, "Input coordinates are EPSG:3857 metres rather than longitude/latitude (default true with -map or .morb input)")
+	tolerance   = flag.Float64("simplify", 0, "Douglas-Peucker tolerance in input units, 0 to keep every point")
+)
+
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: shp-to-orb path/to/shp path/to/output")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: orbfiles [flags] path/to/input.{shp,geojson,morb} path/to/output")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
 	}
-	path := os.Args[1]
-	// Open the shapefile
-	shape, err := shp.Open(path)
+	if (*bucket || strings.EqualFold(filepath.Ext(flag.Arg(0)), ".morb")) && !isFlagSet("mercator") {
+		// water.morb has always been built from the EPSG:3857 water polygons
+		*mercator = true
+	}
+	features, err := readFeatures(flag.Arg(0), *countries)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer shape.Close()
+	var s stats
 	var polygons orb.MultiPolygon
-	for shape.Next() {
-		_, p := shape.Shape()
-		var orbPoly orb.Polygon
-		switch poly := p.(type) {
-		case *shp.Polygon:
-			for i := range poly.NumParts {
-				var points []shp.Point
-				if poly.NumParts == 1 {
-					points = poly.Points
-				} else if i == poly.NumParts-1 {
-					points = poly.Points[poly.Parts[i]:]
-				} else {
-					points = poly.Points[poly.Parts[i]:poly.Parts[i+1]]
-				}
-				ring := make(orb.Ring, len(points))
-				for i := range len(points) {
-					ring[i] = orb.Point{points[i].X, points[i].Y}
-				}
-				orbPoly = append(orbPoly, ring)
-			}
+	for i := range features {
+		features[i].polygons = normalise(features[i].polygons, *tolerance, &s)
+		polygons = append(polygons, features[i].polygons...)
+	}
+	s.features = len(features)
 
-		default:
-			panic("unexpected shape type")
+	switch *format {
+	case "bitmap":
+		if *bitmapLevel < 1 || *bitmapLevel > 16 {
+			log.Fatalf("Invalid bitmap level %d", *bitmapLevel)
 		}
-		if len(orbPoly) == 0 {
-			continue
+		// Water polygons from osmdata.openstreetmap.de are already in EPSG:3857
+		project := shapefiles.TileFromWGS84(*bitmapLevel)
+		if *mercator {
+			project = shapefiles.TileFromMercator(*bitmapLevel)
 		}
-		polygons = append(polygons, orbPoly)
+		saveBitmap(shapefiles.RasterizeWater(polygons, *bitmapLevel, project))
+	case "gob", "geojson":
+		save(group(features, polygons))
+	default:
+		log.Fatalf("Unknown format %s", *format)
 	}
-	if slices.Contains(os.Args, "-map") {
-		// Build a map of zoom level 9 morton blocks
+	report(s, polygons)
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// group arranges the polygons the way lib/shapefiles expects them for the
+// selected mode
+func group(features []feature, polygons orb.MultiPolygon) any {
+	switch {
+	case *countries != "":
+		byCode := make(map[string][]orb.Polygon)
+		for _, f := range features {
+			// Natural Earth uses -99 for disputed areas without a code
+			if f.code == "" || f.code == "-99" {
+				continue
+			}
+			byCode[f.code] = append(byCode[f.code], f.polygons...)
+		}
+		log.Println(len(byCode), "countries")
+		return byCode
+	case *bucket:
 		watery := make(map[int64][]orb.Polygon)
-		count := 0
 		for _, poly := range polygons {
-			center := project.Mercator.ToWGS84(poly.Bound().Bound().Center())
+			center := poly.Bound().Center()
+			if *mercator {
+				center = project.Mercator.ToWGS84(center)
+			}
 			code := morton.Encode(center.Lat(), center.Lon(), level)
 			watery[code] = append(watery[code], poly)
-			count++
-		}
-		log.Println(count)
-		save(watery)
-	} else {
-		if len(polygons) == 1 {
-			save(polygons[0])
-		} else {
-			save(polygons)
 		}
+		log.Println(len(watery), "tiles")
+		return watery
+	case len(polygons) == 1:
+		return polygons[0]
 	}
-	test(polygons)
+	return polygons
 }
 
 func save(a any) {
-	// Save polygons as gob file
-	f, err := os.Create(os.Args[2])
+	f, err := os.Create(flag.Arg(1))
 	if err != nil {
-		log.Fatalf("Failed to create destination file %s: %s", os.Args[2], err.Error())
+		log.Fatalf("Failed to create destination file %s: %s", flag.Arg(1), err.Error())
+	}
+	defer f.Close()
+	if *format == "geojson" {
+		err = json.NewEncoder(f).Encode(toGeoJSON(a))
+	} else {
+		err = gob.NewEncoder(f).Encode(a)
 	}
-	enc := gob.NewEncoder(f)
-	if err := enc.Encode(a); err != nil {
+	if err != nil {
 		log.Fatalf("Failed to encode polygons: %s", err.Error())
 	}
 }
 
-func test(poly orb.MultiPolygon) {
-	fmt.Println(len(poly))
-	points := map[string]struct {
-		X float64
-		Y float64
+func toGeoJSON(a any) geoJSONCollection {
+	fc := geoJSONCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	switch v := a.(type) {
+	case map[string][]orb.Polygon:
+		for code, polygons := range v {
+			fc.Features = append(fc.Features, newGeoJSONFeature(orb.MultiPolygon(polygons), map[string]any{"code": code}))
+		}
+	case map[int64][]orb.Polygon:
+		for tile, polygons := range v {
+			fc.Features = append(fc.Features, newGeoJSONFeature(orb.MultiPolygon(polygons), map[string]any{"tile": tile}))
+		}
+	case orb.Polygon:
+		fc.Features = append(fc.Features, newGeoJSONFeature(v, map[string]any{}))
+	case orb.MultiPolygon:
+		for _, poly := range v {
+			fc.Features = append(fc.Features, newGeoJSONFeature(poly, map[string]any{}))
+		}
+	}
+	return fc
+}
+
+func saveBitmap(b *shapefiles.Bitmap) {
+	f, err := os.Create(flag.Arg(1))
+	if err != nil {
+		log.Fatalf("Failed to create destination file %s: %s", flag.Arg(1), err.Error())
+	}
+	defer f.Close()
+	size, err := b.WriteTo(f)
+	if err != nil {
+		log.Fatalf("Failed to write bitmap: %s", err.Error())
+	}
+	land, water, coast := b.Coverage()
+	total := float64(land + water + coast)
+	fmt.Printf("Level %d bitmap is %d bytes: %.2f%% land, %.2f%% water, %.2f%% coast\n",
+		b.Level, size, float64(land)/total*100, float64(water)/total*100, float64(coast)/total*100)
+}
+
+// report prints what was read and checks a few known points against the
+// output so regenerated assets can be compared with the embedded ones
+func report(s stats, polygons orb.MultiPolygon) {
+	fmt.Printf("%d features, %d polygons, %d rings\n", s.features, s.polygons, s.rings)
+	if s.pointsIn > 0 {
+		fmt.Printf("%d points kept of %d (%.1f%%)\n", s.pointsOut, s.pointsIn, float64(s.pointsOut)/float64(s.pointsIn)*100)
+	}
+	fmt.Printf("%d rings reoriented, %d degenerate rings dropped\n", s.reoriented, s.dropped)
+	bound := polygons.Bound()
+	if *mercator {
+		bound = orb.Bound{Min: project.Mercator.ToWGS84(bound.Min), Max: project.Mercator.ToWGS84(bound.Max)}
+	}
+	fmt.Printf("Bounds: %.4f,%.4f to %.4f,%.4f\n", bound.Min.Lat(), bound.Min.Lon(), bound.Max.Lat(), bound.Max.Lon())
+	points := []struct {
+		name     string
+		lat, lon float64
 	}{
-		"North Pacific": {32.890398, 146.864834},
-		"China":         {45.964474, 119.773672},
-		"Penang coast":  {5.419154, 100.343326},
-		"Penang bridge": {5.304548, 100.359499},
+		{"North Pacific", 32.890398, 146.864834},
+		{"China", 45.964474, 119.773672},
+		{"Beijing", 39.916668, 116.383331},
+		{"Penang coast", 5.419154, 100.343326},
+		{"Penang bridge", 5.304548, 100.359499},
 	}
-	for name, p := range points {
-		point := project.Point(orb.Point{p.Y, p.X}, project.WGS84.ToMercator)
+	for _, p := range points {
+		name, point := p.name, orb.Point{p.lon, p.lat}
+		if *mercator {
+			point = project.Point(point, project.WGS84.ToMercator)
+		}
 		found := false
-		for i, poly := range poly {
+		for i, poly := range polygons {
 			if planar.PolygonContains(poly, point) {
-				center := project.Mercator.ToWGS84(poly.Bound().Center())
-				code := morton.Encode(center.Lat(), center.Lon(), level)
-
-				fmt.Printf("%s is in polygon %d with code %d\n", name, i, code)
+				fmt.Printf("%s is in polygon %d\n", name, i)
 				found = true
 				break
 			}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	shp "github.com/jonas-p/go-shp"
+	"github.com/paulmach/orb"
+)
+
+// Squares with their corners listed clockwise and counter-clockwise
+var (
+	cwOuter  = orb.Ring{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}
+	ccwHole  = orb.Ring{{2, 2}, {4, 2}, {4, 4}, {2, 4}, {2, 2}}
+	cwOuter2 = orb.Ring{{20, 0}, {20, 10}, {30, 10}, {30, 0}, {20, 0}}
+	ccwHole2 = orb.Ring{{22, 2}, {24, 2}, {24, 4}, {22, 4}, {22, 2}}
+	ccwAlone = orb.Ring{{40, 0}, {50, 0}, {50, 10}, {40, 10}, {40, 0}}
+)
+
+func TestAssembleRings(t *testing.T) {
+	tests := []struct {
+		name  string
+		rings []orb.Ring
+		want  []orb.Polygon
+	}{
+		{"outer", []orb.Ring{cwOuter}, []orb.Polygon{{cwOuter}}},
+		{"hole", []orb.Ring{cwOuter, ccwHole}, []orb.Polygon{{cwOuter, ccwHole}}},
+		{"several outers", []orb.Ring{cwOuter, ccwHole, cwOuter2, ccwHole2},
+			[]orb.Polygon{{cwOuter, ccwHole}, {cwOuter2, ccwHole2}}},
+		{"hole listed first", []orb.Ring{ccwHole, cwOuter}, []orb.Polygon{{cwOuter, ccwHole}}},
+		{"unowned hole", []orb.Ring{cwOuter, ccwAlone}, []orb.Polygon{{cwOuter}, {ccwAlone}}},
+		{"degenerate", []orb.Ring{{{0, 0}, {1, 1}, {0, 0}}, cwOuter}, []orb.Polygon{{cwOuter}}},
+	}
+	for _, tt := range tests {
+		if got := assembleRings(tt.rings); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReadShapefile(t *testing.T) {
+	points := func(rings ...orb.Ring) (parts []int32, points []shp.Point) {
+		for _, r := range rings {
+			parts = append(parts, int32(len(points)))
+			for _, p := range r {
+				points = append(points, shp.Point{X: p[0], Y: p[1]})
+			}
+		}
+		return parts, points
+	}
+	parts, pts := points(cwOuter, ccwHole, cwOuter2)
+	n := int32(len(pts))
+	tests := []struct {
+		name  string
+		typ   shp.ShapeType
+		shape shp.Shape
+	}{
+		{"Polygon", shp.POLYGON, &shp.Polygon{NumParts: int32(len(parts)), NumPoints: n, Parts: parts, Points: pts}},
+		{"PolygonZ", shp.POLYGONZ, &shp.PolygonZ{NumParts: int32(len(parts)), NumPoints: n, Parts: parts, Points: pts,
+			ZArray: make([]float64, n), MArray: make([]float64, n)}},
+		{"PolygonM", shp.POLYGONM, &shp.PolygonM{NumParts: int32(len(parts)), NumPoints: n, Parts: parts, Points: pts,
+			MArray: make([]float64, n)}},
+	}
+	want := []orb.Polygon{{cwOuter, ccwHole}, {cwOuter2}}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "test.shp")
+		w, err := shp.Create(path, tt.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetFields([]shp.Field{shp.StringField("ISO_A2", 2)}); err != nil {
+			t.Fatal(err)
+		}
+		w.Write(tt.shape)
+		w.WriteAttribute(0, 0, "FR")
+		w.Close()
+		// go-shp's writer leaves out the dot before the dbf extension
+		if err := os.Rename(filepath.Join(dir, "testdbf"), filepath.Join(dir, "test.dbf")); err != nil {
+			t.Fatal(err)
+		}
+
+		features, err := readFeatures(path, "ISO_A2")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(features) != 1 || features[0].code != "FR" || !reflect.DeepEqual(features[0].polygons, want) {
+			t.Errorf("%s: got %+v", tt.name, features)
+		}
+	}
+}
+
+func TestReadGeoJSON(t *testing.T) {
+	tests := []struct {
+		name, json string
+		want       []feature
+		fails      bool
+	}{
+		{"polygon", `{"type":"FeatureCollection","features":[{"type":"Feature","properties":{"iso":" FR "},
+			"geometry":{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,0]]]}}]}`,
+			[]feature{{"FR", []orb.Polygon{{{{0, 0}, {10, 0}, {10, 10}, {0, 0}}}}}}, false},
+		{"multipolygon with altitude", `{"type":"FeatureCollection","features":[{"type":"Feature","properties":{"iso":"DE"},
+			"geometry":{"type":"MultiPolygon","coordinates":[[[[0,0,5],[1,0,5],[1,1,5],[0,0,5]]],[[[2,2],[3,2],[3,3],[2,2]]]]}}]}`,
+			[]feature{{"DE", []orb.Polygon{{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, {{{2, 2}, {3, 2}, {3, 3}, {2, 2}}}}}}, false},
+		{"null geometry", `{"type":"FeatureCollection","features":[{"type":"Feature","properties":{"iso":"XX"},"geometry":null}]}`,
+			nil, false},
+		{"point", `{"type":"FeatureCollection","features":[{"type":"Feature","properties":{"iso":"XX"},
+			"geometry":{"type":"Point","coordinates":[0,0]}}]}`, nil, true},
+		{"missing property", `{"type":"FeatureCollection","features":[{"type":"Feature","properties":{},
+			"geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}}]}`, nil, true},
+		{"not a collection", `{"type":"Feature"}`, nil, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "test.geojson")
+		if err := os.WriteFile(path, []byte(tt.json), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readFeatures(path, "iso")
+		if (err != nil) != tt.fails {
+			t.Errorf("%s: got error %v", tt.name, err)
+		} else if !tt.fails && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNormalise(t *testing.T) {
+	ccwOuter := orb.Ring{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+	cwHole := orb.Ring{{2, 2}, {2, 4}, {4, 4}, {4, 2}, {2, 2}}
+	// A square with a point that is almost on its bottom edge
+	bumpy := orb.Ring{{0, 0}, {5, 0.01}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+	tests := []struct {
+		name       string
+		in         []orb.Polygon
+		tolerance  float64
+		want       []orb.Polygon
+		reoriented int
+		dropped    int
+	}{
+		{"already wound", []orb.Polygon{{ccwOuter.Clone(), cwHole.Clone()}}, 0, []orb.Polygon{{ccwOuter, cwHole}}, 0, 0},
+		{"rewound", []orb.Polygon{{cwOuter.Clone(), ccwHole.Clone()}}, 0, []orb.Polygon{{ccwOuter, cwHole}}, 2, 0},
+		{"unclosed", []orb.Polygon{{ccwOuter[:4].Clone()}}, 0, []orb.Polygon{{ccwOuter}}, 0, 0},
+		{"simplified", []orb.Polygon{{bumpy.Clone()}}, 0.1, []orb.Polygon{{ccwOuter}}, 0, 0},
+		{"degenerate hole", []orb.Polygon{{ccwOuter.Clone(), {{2, 2}, {2, 2.01}, {2.01, 2}, {2, 2}}}}, 0.1,
+			[]orb.Polygon{{ccwOuter}}, 0, 1},
+		{"degenerate outer", []orb.Polygon{{{{0, 0}, {0.01, 0}, {0, 0.01}, {0, 0}}, cwHole.Clone()}}, 0.1,
+			[]orb.Polygon{}, 0, 1},
+	}
+	for _, tt := range tests {
+		var s stats
+		got := normalise(tt.in, tt.tolerance, &s)
+		if !reflect.DeepEqual(got, tt.want) || s.reoriented != tt.reoriented || s.dropped != tt.dropped {
+			t.Errorf("%s: got %v with %d reoriented and %d dropped", tt.name, got, s.reoriented, s.dropped)
+		}
+	}
+}
+
+func TestReadMorb(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "water.morb")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buckets := map[int64][]orb.Polygon{7: {{cwOuter2}}, 3: {{cwOuter, ccwHole}, {ccwAlone}}}
+	if err := gob.NewEncoder(f).Encode(buckets); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	features, err := readFeatures(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []feature{{polygons: buckets[3]}, {polygons: buckets[7]}}
+	if !reflect.DeepEqual(features, want) {
+		t.Errorf("got %+v, want %+v", features, want)
+	}
+}
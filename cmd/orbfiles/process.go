@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/simplify"
+)
+
+type stats struct {
+	features, polygons, rings int
+	pointsIn, pointsOut       int
+	reoriented, dropped       int
+}
+
+// normalise applies the GeoJSON winding order, outer rings counter-clockwise
+// and holes clockwise, and drops rings left degenerate by simplification
+func normalise(polygons []orb.Polygon, tolerance float64, s *stats) []orb.Polygon {
+	var simplifier *simplify.DouglasPeuckerSimplifier
+	if tolerance > 0 {
+		simplifier = simplify.DouglasPeucker(tolerance)
+	}
+	out := make([]orb.Polygon, 0, len(polygons))
+	for _, poly := range polygons {
+		var kept orb.Polygon
+		for i, ring := range poly {
+			s.pointsIn += len(ring)
+			if !ring.Closed() && len(ring) > 0 {
+				ring = append(ring, ring[0])
+			}
+			if simplifier != nil {
+				ring = simplifier.Ring(ring)
+			}
+			if len(ring) < 4 {
+				s.dropped++
+				if i == 0 {
+					// Holes of a dropped outer ring go with it
+					break
+				}
+				continue
+			}
+			want := orb.CCW
+			if i > 0 {
+				want = orb.CW
+			}
+			if ring.Orientation() != want {
+				ring.Reverse()
+				s.reoriented++
+			}
+			s.pointsOut += len(ring)
+			kept = append(kept, ring)
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		s.rings += len(kept)
+		out = append(out, kept)
+	}
+	s.polygons += len(out)
+	return out
+}
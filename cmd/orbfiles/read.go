@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	shp "github.com/jonas-p/go-shp"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// feature is one input record, keyed by the attribute given with -countries
+type feature struct {
+	code     string
+	polygons []orb.Polygon
+}
+
+func readFeatures(path, field string) ([]feature, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".geojson":
+		return readGeoJSON(path, field)
+	case ".shp":
+		return readShapefile(path, field)
+	case ".morb":
+		return readMorb(path)
+	}
+	return nil, fmt.Errorf("unsupported input %s, expected .shp, .geojson or .morb", path)
+}
+
+// readMorb reads the polygons back out of a file written with -map, so the
+// bitmap can be derived from the embedded water.morb
+func readMorb(path string) ([]feature, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var buckets map[int64][]orb.Polygon
+	if err := gob.NewDecoder(f).Decode(&buckets); err != nil {
+		return nil, err
+	}
+	keys := make([]int64, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	features := make([]feature, 0, len(keys))
+	for _, k := range keys {
+		features = append(features, feature{polygons: buckets[k]})
+	}
+	return features, nil
+}
+
+func readShapefile(path, field string) ([]feature, error) {
+	shape, err := shp.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer shape.Close()
+	fieldIndex := -1
+	if field != "" {
+		for i, f := range shape.Fields() {
+			if f.String() == field {
+				fieldIndex = i
+			}
+		}
+		if fieldIndex == -1 {
+			return nil, fmt.Errorf("field %s not found in shapefile", field)
+		}
+	}
+	var features []feature
+	for shape.Next() {
+		n, p := shape.Shape()
+		var parts []int32
+		var points []shp.Point
+		switch poly := p.(type) {
+		case *shp.Polygon:
+			parts, points = poly.Parts, poly.Points
+		case *shp.PolygonZ:
+			parts, points = poly.Parts, poly.Points
+		case *shp.PolygonM:
+			parts, points = poly.Parts, poly.Points
+		case *shp.Null:
+			continue
+		default:
+			return nil, fmt.Errorf("record %d: unsupported shape type %T", n, p)
+		}
+		f := feature{polygons: assembleRings(splitParts(parts, points))}
+		if len(f.polygons) == 0 {
+			continue
+		}
+		if fieldIndex != -1 {
+			f.code = strings.TrimSpace(shape.ReadAttribute(n, fieldIndex))
+		}
+		features = append(features, f)
+	}
+	return features, nil
+}
+
+func splitParts(parts []int32, points []shp.Point) []orb.Ring {
+	rings := make([]orb.Ring, 0, len(parts))
+	for i, start := range parts {
+		end := int32(len(points))
+		if i+1 < len(parts) {
+			end = parts[i+1]
+		}
+		ring := make(orb.Ring, 0, end-start)
+		for _, p := range points[start:end] {
+			ring = append(ring, orb.Point{p.X, p.Y})
+		}
+		rings = append(rings, ring)
+	}
+	return rings
+}
+
+// assembleRings turns the flat ring list of a shapefile record into polygons.
+// Shapefiles store outer rings clockwise and holes counter-clockwise, and a
+// record may hold several outer rings, each followed by its holes.
+func assembleRings(rings []orb.Ring) []orb.Polygon {
+	var polygons []orb.Polygon
+	var holes []orb.Ring
+	for _, r := range rings {
+		if len(r) < 4 {
+			continue
+		}
+		if r.Orientation() == orb.CCW {
+			holes = append(holes, r)
+		} else {
+			polygons = append(polygons, orb.Polygon{r})
+		}
+	}
+	for _, h := range holes {
+		owner := -1
+		for i, p := range polygons {
+			if planar.RingContains(p[0], h[0]) {
+				owner = i
+				break
+			}
+		}
+		if owner == -1 {
+			// Some exporters ignore the winding rule, so an unowned hole
+			// is really an outer ring
+			polygons = append(polygons, orb.Polygon{h})
+			continue
+		}
+		polygons[owner] = append(polygons[owner], h)
+	}
+	return polygons
+}
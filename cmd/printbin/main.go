@@ -8,122 +8,53 @@ import (
 	"os"
 	"slices"
 	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/protoinspect"
+	"github.com/acheong08/apple-corelocation-experiments/pb"
 
-	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
-func decodeRawProtobuf(data []byte) (map[string]any, error) {
-	result := make(map[string]any)
-
-	for len(data) > 0 {
-		fieldNum, wireType, n := protowire.ConsumeTag(data)
-		if n < 0 {
-			return nil, fmt.Errorf("invalid tag")
-		}
-		data = data[n:]
-
-		fieldName := fmt.Sprintf("%d", fieldNum)
-
-		var value any
-
-		switch wireType {
-		case protowire.VarintType:
-			v, n := protowire.ConsumeVarint(data)
-			if n < 0 {
-				return nil, fmt.Errorf("invalid varint")
-			}
-			value = v
-			data = data[n:]
-
-		case protowire.Fixed64Type:
-			v, n := protowire.ConsumeFixed64(data)
-			if n < 0 {
-				return nil, fmt.Errorf("invalid fixed64")
-			}
-			value = v
-			data = data[n:]
-
-		case protowire.BytesType:
-			v, n := protowire.ConsumeBytes(data)
-			if n < 0 {
-				return nil, fmt.Errorf("invalid bytes")
-			}
-
-			// Try to decode as nested message
-			if nested, err := decodeRawProtobuf(v); err == nil && len(nested) > 0 {
-				value = nested
-			} else {
-				// Treat as string if valid UTF-8, otherwise as bytes
-				if isValidUTF8(v) {
-					value = string(v)
-				} else {
-					value = fmt.Sprintf("bytes[%d]: %x", len(v), v)
-				}
-			}
-			data = data[n:]
-
-		case protowire.Fixed32Type:
-			v, n := protowire.ConsumeFixed32(data)
-			if n < 0 {
-				return nil, fmt.Errorf("invalid fixed32")
-			}
-			value = v
-			data = data[n:]
-
-		default:
-			return nil, fmt.Errorf("unknown wire type: %d", wireType)
-		}
-
-		// Handle repeated fields by creating arrays
-		if existing, exists := result[fieldName]; exists {
-			// Convert to array if not already
-			if arr, isArray := existing.([]any); isArray {
-				result[fieldName] = append(arr, value)
-			} else {
-				result[fieldName] = []any{existing, value}
-			}
-		} else {
-			result[fieldName] = value
-		}
+func tryDecodeProtobuf(data []byte, outputFile string, schema protoreflect.MessageDescriptor) {
+	fields, err := protoinspect.Decode(data)
+	if err != nil {
+		fmt.Printf("Failed to decode as protobuf: %v\n", err)
+		return
+	}
+	var diffs []protoinspect.Difference
+	if schema != nil {
+		diffs = protoinspect.Annotate(fields, schema)
 	}
 
-	return result, nil
-}
-
-func isValidUTF8(data []byte) bool {
-	for _, b := range data {
-		if b < 32 && b != 9 && b != 10 && b != 13 {
-			return false
+	if outputFile != "" {
+		j, err := json.MarshalIndent(fields, "", "  ")
+		if err != nil {
+			fmt.Printf("Failed to encode JSON: %v\n", err)
+			return
 		}
-		if b > 126 {
-			return false
+		if err := os.WriteFile(outputFile, j, 0644); err != nil {
+			fmt.Printf("Failed to write JSON to file: %v\n", err)
+			return
 		}
+		fmt.Printf("JSON output written to: %s\n", outputFile)
+	} else {
+		fmt.Println("=== Raw Protobuf Structure ===")
+		protoinspect.Dump(os.Stdout, fields)
 	}
-	return true
-}
-
-func tryDecodeProtobuf(data []byte, outputFile string) {
-	if result, err := decodeRawProtobuf(data); err == nil {
-		j, _ := json.MarshalIndent(result, "", "  ")
-
-		if outputFile != "" {
-			if err := os.WriteFile(outputFile, j, 0644); err != nil {
-				fmt.Printf("Failed to write JSON to file: %v\n", err)
-				return
-			}
-			fmt.Printf("JSON output written to: %s\n", outputFile)
-		} else {
-			fmt.Println("=== Raw Protobuf Structure ===")
-			fmt.Println(string(j))
+	if schema != nil {
+		fmt.Printf("\n=== Differences from %s ===\n", schema.FullName())
+		for _, d := range diffs {
+			fmt.Println(d)
 		}
-	} else {
-		fmt.Printf("Failed to decode as protobuf: %v\n", err)
 	}
 }
 
+// wlocSchema is compared against ARPC payloads, and raw files with -wloc, to
+// find fields pb.AppleWLoc does not document
+var wlocSchema = (&pb.AppleWLoc{}).ProtoReflect().Descriptor()
+
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatal("Usage: printbin <file> [-hex] [-proto] [-o output.json]")
+		log.Fatal("Usage: printbin <file> [-hex] [-proto] [-wloc] [-o output.json]")
 	}
 
 	filePath := os.Args[1]
@@ -152,7 +83,11 @@ func main() {
 	if err := arpcData.Deserialize(b); err != nil {
 		log.Printf("Failed to parse as ARPC: %v\n", err)
 		log.Println("Trying direct protobuf decode...")
-		tryDecodeProtobuf(b, outputFile)
+		var schema protoreflect.MessageDescriptor
+		if slices.Contains(os.Args, "-wloc") {
+			schema = wlocSchema
+		}
+		tryDecodeProtobuf(b, outputFile, schema)
 		return
 	}
 
@@ -170,6 +105,6 @@ func main() {
 
 	if slices.Contains(os.Args, "-proto") {
 		fmt.Println("\n=== Payload Analysis ===")
-		tryDecodeProtobuf(arpcData.Payload, outputFile)
+		tryDecodeProtobuf(arpcData.Payload, outputFile, wlocSchema)
 	}
 }
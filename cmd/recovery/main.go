@@ -1,101 +1,85 @@
 package main
 
 import (
-	"encoding/gob"
+	"context"
+	"flag"
 	"fmt"
+	"github.com/acheong08/apple-corelocation-experiments/lib/crawl"
 	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
-	"os"
-	"sync"
+	"slices"
 )
 
+// Inspects and repairs the frontier shared by seedcrawl and domain-expansion
 func main() {
-	g := Generator{}
-	g.LoadState("state.gob")
-	fmt.Println(g.Current.X, g.Current.Y)
-	g.Current.Y = 4356
-	g.Current.X = 3845
-	g.Start()
-	fmt.Println(morton.Pack(g.Current.X, g.Current.Y, 13))
-	g.SaveState("state.gob")
-}
-
-type Coordinate struct {
-	X, Y int
-}
-
-type Generator struct {
-	Current   Coordinate
-	MaxTile   int
-	ch        chan Coordinate
-	done      chan struct{}
-	stateLock sync.Mutex
-}
+	frontierPath := flag.String("frontier", "crawl.db", "crawl frontier")
+	feed := flag.String("feed", "", "only show shards of this feed, such as seedcrawl")
+	shard := flag.Int("shard", -1, "shard of -feed to move")
+	x := flag.Int("x", -1, "move the shard to this column")
+	y := flag.Int("y", -1, "move the shard to this row")
+	release := flag.Bool("release", false, "return every leased job and shard to the queue, after a crash")
+	requeue := flag.String("requeue", "", `retry failed jobs of a kind ("tile", "bssid" or "all")`)
+	flag.Parse()
 
-func NewGenerator() *Generator {
-	return &Generator{
-		Current: Coordinate{X: 0, Y: 0},
-		MaxTile: 9000,
-		ch:      make(chan Coordinate),
-		done:    make(chan struct{}),
+	ctx := context.Background()
+	f, err := crawl.OpenFrontier(*frontierPath)
+	if err != nil {
+		panic(err)
 	}
-}
+	defer f.Close()
 
-func (g *Generator) Start() {
-	go func() {
-		defer close(g.ch)
-		for {
-			select {
-			case <-g.done:
-				return
-			case g.ch <- g.Current:
-				g.stateLock.Lock()
-				g.Current.X++
-				if g.Current.X > g.MaxTile {
-					g.Current.X = 0
-					g.Current.Y++
-					if g.Current.Y > g.MaxTile {
-						g.stateLock.Unlock()
-						return
-					}
-				}
-				g.stateLock.Unlock()
-			}
+	if *shard >= 0 {
+		shards, err := f.Shards(ctx, *feed)
+		if err != nil {
+			panic(err)
 		}
-	}()
-}
-
-func (g *Generator) Stop() {
-	close(g.done)
-}
-
-func (g *Generator) Channel() <-chan Coordinate {
-	return g.ch
-}
-
-func (g *Generator) SaveState(filename string) error {
-	g.stateLock.Lock()
-	defer g.stateLock.Unlock()
-
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
+		i := slices.IndexFunc(shards, func(s crawl.Shard) bool { return s.ID == *shard })
+		if *feed == "" || i < 0 {
+			panic("no such shard, give -feed and -shard")
+		}
+		pos := shards[i].Next
+		if *x >= 0 {
+			pos.X = *x
+		}
+		if *y >= 0 {
+			pos.Y = *y
+		}
+		if err := f.MoveShard(ctx, *feed, *shard, pos); err != nil {
+			panic(err)
+		}
+		fmt.Println(morton.Pack(pos.X, pos.Y, 13))
 	}
-	defer file.Close()
-
-	encoder := gob.NewEncoder(file)
-	return encoder.Encode(g.Current)
-}
-
-func (g *Generator) LoadState(filename string) error {
-	g.stateLock.Lock()
-	defer g.stateLock.Unlock()
-
-	file, err := os.Open(filename)
+	shards, err := f.Shards(ctx, *feed)
 	if err != nil {
-		return err
+		panic(err)
+	}
+	states := []string{"pending", "leased", "done", "failed"}
+	for _, s := range shards {
+		fmt.Printf("%s shard %d: at %d,%d of %d,%d-%d,%d, %s %s\n", s.Feed, s.ID, s.Next.X, s.Next.Y,
+			s.First.X, s.First.Y, s.Last.X, s.Last.Y, states[s.State], s.Owner)
+	}
+	if *release {
+		n, err := f.Release(ctx, "")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Released %d jobs\n", n)
+	}
+	if *requeue != "" {
+		kind := *requeue
+		if kind == "all" {
+			kind = ""
+		}
+		n, err := f.Requeue(ctx, kind)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Requeued %d jobs\n", n)
+	}
+	for _, kind := range []string{crawl.KindTile, crawl.KindBSSID} {
+		c, err := f.Counts(ctx, kind)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s: %d pending, %d leased, %d done, %d failed\n", kind, c.Pending, c.Leased, c.Done, c.Failed)
 	}
-	defer file.Close()
-
-	decoder := gob.NewDecoder(file)
-	return decoder.Decode(&g.Current)
 }
@@ -1,6 +1,11 @@
 package main
 
 const (
-	MaxTile = 8191
-	MinTile = 0
+	// DatabasePath is shared with domain-expansion, which reads its seeds
+	DatabasePath = "wloc.db"
+	// FrontierPath is shared with domain-expansion and recovery. Run more
+	// seedcrawl processes on the same frontier to crawl faster.
+	FrontierPath = "crawl.db"
+	// FeedName names seedcrawl's shards in the frontier
+	FeedName = "seedcrawl"
 )
@@ -2,105 +2,100 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 	"github.com/acheong08/apple-corelocation-experiments/lib"
-	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+	"github.com/acheong08/apple-corelocation-experiments/lib/crawl"
 	"github.com/acheong08/apple-corelocation-experiments/lib/shapefiles"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
 
-	"github.com/schollz/progressbar/v3"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-var progress = progressbar.Default(MaxTile * MaxTile)
+// logSink logs tiles with access points as it stores them
+type logSink struct {
+	crawl.Sink
+}
+
+func (s logSink) Tile(tileKey int64, aps []lib.AP, err error) {
+	s.Sink.Tile(tileKey, aps, err)
+	if len(aps) > 0 {
+		slog.Debug("found access points", "tile", tileKey, "count", len(aps))
+	}
+}
 
 func main() {
+	dbPath := flag.String("db", DatabasePath, "store to save access points and the fetch log to")
+	frontierPath := flag.String("frontier", FrontierPath, "crawl frontier to resume from, a SQLite file or a postgres:// URL shared by several workers")
+	shards := flag.Int("shards", crawl.DefaultGridOptions.Shards, "parts of the grid that workers take one at a time, fixed by the first run")
+	workers := flag.Int("workers", 500, "concurrent requests")
+	regionSpec := flag.String("region", "", "only crawl tiles in a bounding box (west,south,east,north), .geojson file or country code")
+	metricsAddr := flag.String("metrics", "", "address to serve Prometheus /metrics and JSON /status on, such as :9100")
+	flag.Parse()
+
 	if !shapefiles.IsInWater(82.940327, -180.000000) {
 		panic("something went wrong with shapefiles")
 	}
-	gen := NewGenerator()
-	err := gen.LoadState("state.gob")
+	f, err := crawl.OpenFrontier(*frontierPath)
 	if err != nil {
-		log.Println("Failed to load state. This is expected for new runs")
+		panic(fmt.Errorf("Failed to open frontier: %w", err))
 	}
-	gen.Start()
-	if err := progress.Add(gen.Current.Y*(MaxTile+1) + gen.Current.X); err != nil {
-		panic(err)
+	defer f.Close()
+	s, err := store.Open(*dbPath)
+	if err != nil {
+		panic(fmt.Errorf("Failed to open database: %w", err))
 	}
-	defer func() {
-		err := recover()
-		if err != nil {
-			log.Println("Panic caught ", err)
-		}
-		err = gen.SaveState("state.gob")
+	defer s.Close()
+
+	// Catch ctrl+c for graceful exit
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	opts := crawl.DefaultGridOptions
+	opts.Shards = *shards
+	opts.Size = 10 * *workers
+	opts.Skip = shapefiles.IsInWater
+	feedName := FeedName
+	if *regionSpec != "" {
+		region, err := crawl.ParseRegion(*regionSpec)
 		if err != nil {
-			b, _ := json.Marshal(gen)
-			fmt.Println(string(b))
-			return
+			panic(err)
 		}
-		log.Println("State saved")
-	}()
-	database := InitDatabase()
-	ctx, cancel := context.WithCancel(context.Background())
-	wait := sync.WaitGroup{}
-	for i := range 500 {
-		wait.Add(1)
+		// Each region has shards of its own
+		opts.Region = region
+		feedName += ":" + region.Name
+	}
+	feed := crawl.GridFeed(feedName, opts)
+
+	reg := prometheus.NewRegistry()
+	metrics := crawl.NewMetrics(reg, f)
+	sink := crawl.NewStoreSink(s, "seedcrawl")
+	audit := lib.NewAudit()
+	engine := crawl.New(f).
+		WithWorkers(*workers).
+		WithFeed(feed).
+		WithMetrics(metrics).
+		WithReport(time.Minute).
+		Handle(crawl.KindTile, crawl.TileHandler(metrics.Sink(logSink{sink}), lib.Options.WithClient(metrics.Client()), lib.Options.WithAudit(audit)))
+	if *metricsAddr != "" {
 		go func() {
-			Datafetcher(ctx, &database, gen.Channel())
-			wait.Done()
-			log.Println("Thread completed")
+			slog.Error("metrics server stopped", "err", http.ListenAndServe(*metricsAddr, crawl.MonitorHandler(engine, reg)))
 		}()
-		log.Println("Started thread ", i)
 	}
-	// Catch ctrl+c for graceful exit
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
-	log.Println("Exiting gracefully...")
-	cancel()
-	// Wait for fetchers to finish
-	wait.Wait()
-	log.Println("Tasks completed")
-}
-
-func Datafetcher(ctx context.Context, database *db, c <-chan Coordinate) {
-	for coord := range c {
-		if err := progress.Add(1); err != nil {
-			panic(err)
-		}
-		lat, lon := morton.FromTile(coord.X, coord.Y, 13)
-		if shapefiles.IsInWater(lat, lon) {
-			continue
-		}
-	outerLoop:
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				code := morton.Pack(coord.X, coord.Y, 13)
-				aps, err := lib.GetTile(code)
-				if err != nil {
-					if err.Error() == "unexpected status code: 404" {
-						break outerLoop
-					}
-					if err.Error() == "unexected status code: 503" {
-						log.Println("Rate limited. Exiting...")
-						return
-					}
-					log.Println("Something went from in tile call: ", err)
-					time.Sleep(100 * time.Millisecond)
-					continue outerLoop
-				}
-				log.Printf("\nFound %d access points at %f, %f\n", len(aps), lat, lon)
-				database.Add(aps)
-				break outerLoop
-			}
-		}
+	err = engine.Run(ctx)
+	if err := sink.Close(); err != nil {
+		slog.Error("failed to flush results", "err", err)
+	}
+	if err := s.SaveUnknownFields(context.Background(), audit.Take()); err != nil {
+		slog.Error("failed to save unknown protobuf fields", "err", err)
+	}
+	if err != nil && err != context.Canceled {
+		slog.Error("crawl stopped", "err", err)
 	}
+	slog.Info("tasks completed")
 }
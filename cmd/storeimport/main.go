@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/acheong08/apple-corelocation-experiments/lib/recstream"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataDog/zstd"
+)
+
+// storeimport copies the databases written by older versions of seedcrawl
+// (seeds.db), domain-expansion (beacons.db) and tile-sampler
+// (bssid_tracking.db) into the store, which keeps tile keys for every row.
+// Record streams (.wlrs) and the raw beacons.bin.zst dumps of
+// domain-expansion are imported too, and -export writes the store out as a
+// record stream. Public datasets (WiGLE CSV, .kismet logs and OpenCellID or
+// MLS cell exports) go into their own tables for comparison.
+func main() {
+	var (
+		dbPath = flag.String("db", "wloc.db", "Path to the store database")
+		dryRun = flag.Bool("dry-run", false, "Only report the schema version of the store")
+		export = flag.String("export", "", "Write every access point in the store to this record stream")
+		cells  = flag.String("cells", "", "Source of cell export CSVs, opencellid or mls (default guessed from the file name)")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [-db wloc.db] [-export out.wlrs] [legacy.db | beacons.wlrs | beacons.bin.zst | wigle.csv | log.kismet | cell_towers.csv.gz]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	s, err := store.Open(*dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+	defer s.Close()
+	version, err := s.Version()
+	if err != nil {
+		log.Fatalf("Failed to read schema version: %v", err)
+	}
+	log.Printf("Store %s is at schema version %d", *dbPath, version)
+	if *dryRun {
+		return
+	}
+	if flag.NArg() == 0 && *export == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	for _, path := range flag.Args() {
+		var n int
+		var err error
+		switch {
+		case strings.HasSuffix(path, ".wlrs"):
+			n, err = importStream(s, path)
+		case strings.HasSuffix(path, ".bin.zst"):
+			n, err = importDump(s, path)
+		case strings.HasSuffix(path, ".kismet"), strings.HasSuffix(path, ".csv"), strings.HasSuffix(path, ".csv.gz"):
+			n, err = s.ImportDataset(ctx, path, cellSource(path, *cells))
+		default:
+			n, err = s.ImportLegacy(ctx, path)
+		}
+		if err != nil {
+			log.Fatalf("Failed to import %s after %d access points: %v", path, n, err)
+		}
+		log.Printf("Imported %d access points from %s", n, path)
+	}
+	total, err := s.CountAPs(ctx)
+	if err != nil {
+		log.Fatalf("Failed to count access points: %v", err)
+	}
+	log.Printf("Store now holds %d access points", total)
+
+	if *export != "" {
+		w, err := recstream.Create(*export, recstream.BeaconHeader)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *export, err)
+		}
+		n, err := recstream.FromStore(ctx, s, w)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			log.Fatalf("Failed to export to %s: %v", *export, err)
+		}
+		log.Printf("Exported %d access points to %s", n, *export)
+	}
+}
+
+func cellSource(path, source string) string {
+	if source != "" {
+		return source
+	}
+	if strings.Contains(strings.ToLower(filepath.Base(path)), "mls") {
+		return store.MLS
+	}
+	return store.OpenCellID
+}
+
+// Streams have no timestamps, so records are dated by the file
+func importStream(s *store.Store, path string) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	r, err := recstream.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	if !r.Indexed() {
+		log.Printf("%s was not closed cleanly, reading %d recovered blocks", path, len(r.Blocks()))
+	}
+	w := s.APWriter("domain-expansion")
+	n, err := recstream.ToStore(r, w, info.ModTime())
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// importDump reads the unframed zstd stream of 22 byte beacon records that
+// domain-expansion used to write
+func importDump(s *store.Store, path string) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	zr := zstd.NewReader(f)
+	defer zr.Close()
+	w := s.APWriter("domain-expansion")
+	record := make([]byte, recstream.BeaconHeader.Layout.Size())
+	n := 0
+	for {
+		if _, err = io.ReadFull(zr, record); err != nil {
+			break
+		}
+		bssid, lat, lon := recstream.DecodeBeacon(record)
+		// The old writer flushed whole zeroed buffers
+		if bssid == 0 && lat == 0 && lon == 0 {
+			continue
+		}
+		if err = w.Add(store.AP{BSSID: bssid, Lat: lat, Lon: lon, LastSeen: info.ModTime()}); err != nil {
+			break
+		}
+		n++
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+	"github.com/acheong08/apple-corelocation-experiments/lib/tilestats"
+	"log"
+	"os"
+	"time"
+)
+
+// tilestats reports how the tiles sampled by tile-sampler change over time:
+// access point counts, additions, removals and moves per interval, the
+// distribution of move distances and how often updates appear. Only
+// aggregates are written, never the history of an access point.
+func main() {
+	var (
+		dbPath   = flag.String("db", "wloc.db", "Path to the store database")
+		interval = flag.Duration("interval", tilestats.DefaultOptions.Interval, "Width of each interval of the time series")
+		since    = flag.String("since", "", "Only use fetches from this time on (RFC 3339)")
+		until    = flag.String("until", "", "Only use fetches before this time (RFC 3339)")
+		jsonPath = flag.String("json", "", "Write the full report, with per-tile time series, as JSON to this file")
+		mdPath   = flag.String("markdown", "", "Write the report as Markdown tables to this file (default stdout)")
+	)
+	flag.Parse()
+
+	opts := tilestats.DefaultOptions
+	opts.Interval = *interval
+	var err error
+	if opts.Since, err = parseTime(*since); err != nil {
+		log.Fatalf("Invalid -since: %v", err)
+	}
+	if opts.Until, err = parseTime(*until); err != nil {
+		log.Fatalf("Invalid -until: %v", err)
+	}
+	if flag.NArg() > 0 {
+		tiles, err := parseTileKeys(flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Tiles = tiles
+	}
+
+	s, err := store.Open(*dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+	defer s.Close()
+	report, err := tilestats.Compute(context.Background(), s, opts)
+	if err != nil {
+		log.Fatalf("Failed to compute report: %v", err)
+	}
+	if len(report.Tiles) == 0 {
+		log.Fatal("No tile fetches to report on, run tile-sampler with -db first")
+	}
+
+	if *jsonPath != "" {
+		f, err := os.Create(*jsonPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	out := os.Stdout
+	if *mdPath != "" {
+		out, err = os.Create(*mdPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if *jsonPath != "" {
+		return
+	}
+	if err := report.WriteMarkdown(out); err != nil {
+		log.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseTileKeys(args []string) ([]int64, error) {
+	keys := make([]int64, len(args))
+	for i, arg := range args {
+		if _, err := fmt.Sscan(arg, &keys[i]); err != nil {
+			return nil, fmt.Errorf("invalid tile key %q: %w", arg, err)
+		}
+	}
+	return keys, nil
+}
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/export"
+	"github.com/acheong08/apple-corelocation-experiments/lib/oui"
 	"github.com/acheong08/apple-corelocation-experiments/pb"
 
-	"github.com/gptlang/oui/ouidb"
 	"github.com/leaanthony/clir"
 )
 
@@ -16,17 +18,29 @@ func main() {
 	cli := clir.NewCli("wloc", "Retrieve BSSID geolocation using Apple's API", "v0.0.1")
 	cli.BoolFlag("china", "Use the China region for the request", &china)
 	var displayVendor bool
+	var registries []string
+	var format string
+	const formatHelp = "Output format: text, geojson, kml, csv or gpx"
 	getCmd := cli.NewSubCommandInheritFlags("get", "Gets and displays adjacent BSSID locations given an existing BSSID")
 	var bssids []string
 	var less bool
 	getCmd.StringsFlag("bssid", "One or more known bssid strings", &bssids)
 	getCmd.BoolFlag("less", "Only return requested BSSID location", &less)
 	getCmd.BoolFlag("vendor", "Tells the CLI to append the vendor of the MAC address to outpus", &displayVendor)
+	getCmd.StringsFlag("oui", "IEEE MA-L/MA-M/MA-S registry CSV files to use for vendor lookups", &registries)
+	getCmd.StringFlag("format", formatHelp, &format)
 	getCmd.Action(func() error {
 		if len(bssids) == 0 {
 			log.Fatalln("BSSIDs cannot be empty")
 		}
-		var options []lib.Modifier
+		out, err := parseFormat(format)
+		if err != nil {
+			return err
+		}
+		options, err := vendorOptions(displayVendor, registries)
+		if err != nil {
+			return err
+		}
 		if china {
 			options = append(options, lib.Options.WithRegion(lib.Options.China))
 		}
@@ -35,13 +49,12 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
+		if out != "" {
+			return export.Write(os.Stdout, out, export.FromAPs(blocks))
+		}
 		for _, ap := range blocks {
 			if displayVendor {
-				man, err := ouidb.Lookup(ap.BSSID)
-				if err != nil {
-					man = "Unknown"
-				}
-				fmt.Printf("BSSID: %s (%s) found at Lat: %f Long: %f\n", ap.BSSID, man, ap.Location.Lat, ap.Location.Long)
+				fmt.Printf("BSSID: %s (%s) found at Lat: %f Long: %f\n", ap.BSSID, vendorName(ap), ap.Location.Lat, ap.Location.Long)
 			} else {
 				fmt.Printf("BSSID: %s found at Lat: %f Long: %f\n", ap.BSSID, ap.Location.Lat, ap.Location.Long)
 			}
@@ -49,30 +62,69 @@ func main() {
 		fmt.Println(len(blocks), "number of devices found in area")
 		return nil
 	})
+	var mcc, mnc, cellid, tacid uint32
 	tileKey := int64(81644853)
 	tileCmd := cli.NewSubCommandInheritFlags("tile", "Returns a list of BSSIDs and their associated GPS locations")
 	tileCmd.Int64Flag("key", "The tile key used to determine region", &tileKey)
 	tileCmd.BoolFlag("vendor", "Tells the CLI to append the vendor of the MAC address to outpus", &displayVendor)
+	tileCmd.StringsFlag("oui", "IEEE MA-L/MA-M/MA-S registry CSV files to use for vendor lookups", &registries)
+	tileCmd.StringFlag("format", formatHelp, &format)
 	tileCmd.Action(func() error {
-		tiles, err := lib.GetTile(tileKey)
+		out, err := parseFormat(format)
+		if err != nil {
+			return err
+		}
+		options, err := vendorOptions(displayVendor, registries)
+		if err != nil {
+			return err
+		}
+		tiles, err := lib.GetTile(tileKey, options...)
 		if err != nil {
 			panic(err)
 		}
+		if out != "" {
+			return export.Write(os.Stdout, out, export.FromAPs(tiles))
+		}
 		for _, d := range tiles {
 			if displayVendor {
-				manufacturer, err := ouidb.Lookup(d.BSSID)
-				if err != nil {
-					continue
-				}
-				fmt.Printf("MAC: %s (%s) - %f %f\n", d.BSSID, manufacturer, d.Location.Lat, d.Location.Long)
+				fmt.Printf("MAC: %s (%s) - %f %f\n", d.BSSID, vendorName(d), d.Location.Lat, d.Location.Long)
 			} else {
 				fmt.Printf("MAC: %s - %f %f\n", d.BSSID, d.Location.Lat, d.Location.Long)
 			}
 		}
 		return nil
 	})
+	cellCmd := cli.NewSubCommandInheritFlags("cell", "Gets the locations of cell towers near a known tower")
+	var cellLimit int
+	cellCmd.Uint32Flag("mcc", "Mobile Country Code", &mcc)
+	cellCmd.Uint32Flag("mnc", "Mobile Network Code", &mnc)
+	cellCmd.Uint32Flag("cellid", "Cell ID", &cellid)
+	cellCmd.Uint32Flag("tacid", "Tracking Area Code", &tacid)
+	cellCmd.IntFlag("limit", "Maximum number of nearby towers to return, 0 for only the requested one", &cellLimit)
+	cellCmd.StringFlag("format", formatHelp, &format)
+	cellCmd.Action(func() error {
+		out, err := parseFormat(format)
+		if err != nil {
+			return err
+		}
+		var options []lib.Modifier
+		if china {
+			options = append(options, lib.Options.WithRegion(lib.Options.China))
+		}
+		cells, err := lib.QueryCell(mcc, mnc, cellid, tacid, int32(cellLimit), options...)
+		if err != nil {
+			return err
+		}
+		if out != "" {
+			return export.Write(os.Stdout, out, export.FromCells(cells))
+		}
+		for _, c := range cells {
+			fmt.Printf("Cell: %d-%d-%d-%d found at Lat: %f Long: %f\n", c.Tower.Mcc, c.Tower.Mnc, c.Tower.TacId, c.Tower.CellId, c.Location.Lat, c.Location.Long)
+		}
+		fmt.Println(len(cells), "number of towers found in area")
+		return nil
+	})
 	experiment := cli.NewSubCommandInheritFlags("exp", "Experimental command for WLOC requests")
-	var mcc, mnc, cellid, tacid uint32
 	experiment.Uint32Flag("mcc", "Mobile Country Code", &mcc)
 	experiment.Uint32Flag("mnc", "Mobile Network Code", &mnc)
 	experiment.Uint32Flag("cellid", "Cell ID", &cellid)
@@ -106,6 +158,36 @@ func main() {
 	}
 }
 
+// parseFormat returns the export format, or an empty format for the plain
+// text output
+func parseFormat(format string) (export.Format, error) {
+	if format == "" || format == "text" {
+		return "", nil
+	}
+	return export.ParseFormat(format)
+}
+
+func vendorOptions(displayVendor bool, registries []string) ([]lib.Modifier, error) {
+	if !displayVendor {
+		return nil, nil
+	}
+	if len(registries) != 0 {
+		r, err := oui.Load(registries...)
+		if err != nil {
+			return nil, err
+		}
+		oui.SetDefault(r)
+	}
+	return []lib.Modifier{lib.Options.WithVendors()}, nil
+}
+
+func vendorName(ap lib.AP) string {
+	if ap.Vendor == nil {
+		return "Unknown"
+	}
+	return ap.Vendor.Name
+}
+
 type anyNum interface {
 	int | int32 | int64 | uint | uint32 | uint64 | float32 | float64
 }
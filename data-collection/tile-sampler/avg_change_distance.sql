@@ -11,14 +11,14 @@ SELECT
             SQRT(
               POWER(SIN(RADIANS((new_lat - old_lat) / 2)), 2) +
               COS(RADIANS(old_lat)) * COS(RADIANS(new_lat)) *
-              POWER(SIN(RADIANS((new_long - old_long) / 2)), 2)
+              POWER(SIN(RADIANS((new_lon - old_lon) / 2)), 2)
             )
           ) AS distance
-        FROM location_changes
+        FROM ap_moves
       )
       ORDER BY distance
-      LIMIT 2 - (SELECT COUNT(*) FROM location_changes) % 2
-      OFFSET (SELECT (COUNT(*) - 1) / 2 FROM location_changes)
+      LIMIT 2 - (SELECT COUNT(*) FROM ap_moves) % 2
+      OFFSET (SELECT (COUNT(*) - 1) / 2 FROM ap_moves)
     )
   ) AS median_distance,
   MAX(distance) AS max_distance,
@@ -30,8 +30,8 @@ FROM (
       SQRT(
         POWER(SIN(RADIANS((new_lat - old_lat) / 2)), 2) +
         COS(RADIANS(old_lat)) * COS(RADIANS(new_lat)) *
-        POWER(SIN(RADIANS((new_long - old_long) / 2)), 2)
+        POWER(SIN(RADIANS((new_lon - old_lon) / 2)), 2)
       )
     ) AS distance
-  FROM location_changes
+  FROM ap_moves
 )
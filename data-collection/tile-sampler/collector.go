@@ -2,98 +2,148 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
 	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/schedule"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
 )
 
+// pollInterval is how often the schedule is checked for tiles that are due
+const pollInterval = 10 * time.Second
+
 type Collector struct {
-	db       *Database
+	db       *store.Store
+	aps      *store.Writer[store.AP]
+	fetches  *store.Writer[store.Fetch]
 	tileKeys []int64
-	interval time.Duration
+	schedule *schedule.Scheduler
+	audit    *lib.Audit
+	options  []lib.Modifier
 }
 
-func NewCollector(db *Database, tileKeys []int64, interval time.Duration) *Collector {
+// NewCollector resumes the schedule of tileKeys from the fetch history in db.
+// Unknown protobuf fields in the tiles are saved to db too.
+func NewCollector(db *store.Store, tileKeys []int64, opts schedule.Options, options ...lib.Modifier) (*Collector, error) {
+	states, err := db.TileStates(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tile history: %w", err)
+	}
+	audit := lib.NewAudit()
 	return &Collector{
 		db:       db,
+		aps:      db.APWriter("tile-sampler"),
+		fetches:  db.FetchWriter(),
 		tileKeys: tileKeys,
-		interval: interval,
-	}
+		schedule: schedule.New(tileKeys, states, opts),
+		audit:    audit,
+		options:  append(options, lib.Options.WithAudit(audit)),
+	}, nil
 }
 
 func (c *Collector) Start(ctx context.Context) error {
-	ticker := time.NewTicker(c.interval)
+	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
-	log.Printf("Starting data collection with %d tile keys, interval: %v", len(c.tileKeys), c.interval)
+	slog.Info("starting data collection", "tiles", len(c.tileKeys))
 
-	if err := c.collectData(); err != nil {
-		log.Printf("Error collecting data: %v", err)
-	}
 	for {
+		if err := c.collectData(ctx); err != nil {
+			slog.Error("failed to collect data", "err", err)
+		}
 		select {
 		case <-ctx.Done():
-			log.Println("Collection stopped")
+			slog.Info("collection stopped")
 			return ctx.Err()
 		case <-ticker.C:
-			if err := c.collectData(); err != nil {
-				log.Printf("Error collecting data: %v", err)
-			}
 		}
 	}
 }
 
-func (c *Collector) collectData() error {
-	for _, tileKey := range c.tileKeys {
-		if err := c.processTile(tileKey); err != nil {
-			log.Printf("Error processing tile %d: %v", tileKey, err)
+// collectData fetches every tile that the schedule and budget allow
+func (c *Collector) collectData(ctx context.Context) error {
+	keys := c.schedule.Next(time.Now(), len(c.tileKeys))
+	if len(keys) == 0 {
+		return nil
+	}
+	slog.Info("fetching due tiles", "tiles", len(keys))
+	for _, tileKey := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := c.processTile(ctx, tileKey); err != nil {
+			c.schedule.Failed(tileKey, time.Now())
+			slog.Warn("failed to process tile", "tile", tileKey, "err", err)
 			continue
 		}
 	}
+	if err := c.fetches.Flush(context.Background()); err != nil {
+		return fmt.Errorf("failed to write fetch log: %w", err)
+	}
+	if err := c.db.SaveUnknownFields(context.Background(), c.audit.Take()); err != nil {
+		return fmt.Errorf("failed to save unknown protobuf fields: %w", err)
+	}
 	return nil
 }
 
-func (c *Collector) processTile(tileKey int64) error {
-	aps, err := lib.GetTile(tileKey)
+func (c *Collector) processTile(ctx context.Context, tileKey int64) error {
+	start := time.Now()
+	aps, err := lib.GetTile(tileKey, c.options...)
+	cost := time.Since(start)
+	if err := c.fetches.Add(store.NewFetch(tileKey, aps, err)); err != nil {
+		slog.Warn("failed to log fetch", "tile", tileKey, "err", err)
+	}
+	// A tile that has lost all its access points is a change like any other
+	var status *lib.StatusError
+	if errors.As(err, &status) && status.Code == http.StatusNotFound {
+		err = nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get tile %d: %w", tileKey, err)
 	}
 
-	log.Printf("Processing tile %d with %d APs", tileKey, len(aps))
+	state := c.schedule.Record(tileKey, start, schedule.Digest(aps), cost)
+	if err := c.db.SaveTileState(ctx, state); err != nil {
+		slog.Warn("failed to save tile history", "tile", tileKey, "err", err)
+	}
+	slog.Info("processing tile", "tile", tileKey, "aps", len(aps), "changes", state.Changes, "fetches", state.Fetches)
 
-	for _, ap := range aps {
-		if err := c.processBSSID(ap.BSSID, ap.Location.Lat, ap.Location.Long); err != nil {
-			log.Printf("Error processing BSSID %s: %v", ap.BSSID, err)
+	// The store records moves itself, this only logs them
+	records := store.NewAPs(aps)
+	for _, ap := range records {
+		if err := c.logChange(ap); err != nil {
+			slog.Warn("failed to process BSSID", "bssid", ap.BSSID, "err", err)
 		}
 	}
 
-	return nil
+	if err := c.aps.Add(records...); err != nil {
+		return err
+	}
+	return c.aps.Flush(context.Background())
 }
 
-func (c *Collector) processBSSID(bssid string, lat, long float64) error {
-	existing, err := c.db.GetBSSID(bssid)
-	if err != nil {
-		return fmt.Errorf("failed to get BSSID %s: %w", bssid, err)
+func (c *Collector) logChange(ap store.AP) error {
+	existing, err := c.db.AP(context.Background(), ap.BSSID)
+	if errors.Is(err, store.ErrNotFound) {
+		slog.Info("new BSSID discovered", "bssid", ap.BSSID, "lat", ap.Lat, "lon", ap.Lon)
+		return nil
 	}
-
-	if existing == nil {
-		log.Printf("New BSSID discovered: %s at (%.6f, %.6f)", bssid, lat, long)
-		return c.db.InsertBSSID(bssid, lat, long)
+	if err != nil {
+		return fmt.Errorf("failed to get BSSID %s: %w", ap.BSSID, err)
 	}
 
-	const epsilon = 1e-6
-	if existing.Lat != lat || existing.Long != long {
-		distance := calculateDistance(existing.Lat, existing.Long, lat, long)
-		log.Printf("Location changed for BSSID %s: (%.6f, %.6f) -> (%.6f, %.6f), distance: %.2fm",
-			bssid, existing.Lat, existing.Long, lat, long, distance)
-		return c.db.UpdateBSSIDLocation(bssid, existing.Lat, existing.Long, lat, long)
+	if existing.Lat != ap.Lat || existing.Lon != ap.Lon {
+		distance := calculateDistance(existing.Lat, existing.Lon, ap.Lat, ap.Lon)
+		slog.Info("location changed", "bssid", ap.BSSID, "old_lat", existing.Lat, "old_lon", existing.Lon,
+			"new_lat", ap.Lat, "new_lon", ap.Lon, "distance_m", math.Round(distance*100)/100)
 	}
-
-	return c.db.UpdateLastSeen(bssid)
+	return nil
 }
 
 func calculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
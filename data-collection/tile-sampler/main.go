@@ -4,46 +4,83 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/crawl"
+	"github.com/acheong08/apple-corelocation-experiments/lib/schedule"
+	"github.com/acheong08/apple-corelocation-experiments/lib/shapefiles"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
 	var (
-		tileFile = flag.String("tiles", "", "Path to text file containing comma-separated tile keys")
-		dbPath   = flag.String("db", "bssid_tracking.db", "Path to SQLite database file")
-		interval = flag.Duration("interval", 5*time.Minute, "Collection interval (e.g., 5m, 30s, 1h)")
+		tileFile    = flag.String("tiles", "", "Path to text file containing comma-separated tile keys")
+		dbPath      = flag.String("db", "wloc.db", "Path to the store database")
+		interval    = flag.Duration("interval", schedule.DefaultOptions.MinInterval, "Shortest time between fetches of a tile (e.g., 5m, 30s, 1h)")
+		maxInterval = flag.Duration("max-interval", schedule.DefaultOptions.MaxInterval, "Longest time a tile goes without being fetched")
+		budget      = flag.Int("budget", 0, "Requests per hour, spent on the tiles most likely to have changed (0 fetches every tile each -interval)")
+		metricsAddr = flag.String("metrics", "", "Address to serve Prometheus /metrics on, such as :9100")
+		region      = flag.String("region", "", "Sample every land tile in a bounding box (west,south,east,north), .geojson file or country code instead")
 	)
 	flag.Parse()
 
-	if *tileFile == "" {
-		fmt.Fprintf(os.Stderr, "Usage: %s -tiles <file> [-db <path>] [-interval <duration>]\n", os.Args[0])
+	var tileKeys []int64
+	switch {
+	case *region != "":
+		r, err := crawl.ParseRegion(*region)
+		if err != nil {
+			fatal("failed to parse region", "err", err)
+		}
+		tileKeys = r.Tiles(shapefiles.IsInWater)
+		if len(tileKeys) == 0 {
+			fatal("no land tiles in region", "region", *region)
+		}
+		slog.Info("sampling region", "tiles", len(tileKeys), "region", *region)
+	case *tileFile != "":
+		tileContent, err := os.ReadFile(*tileFile)
+		if err != nil {
+			fatal("failed to read tile file", "path", *tileFile, "err", err)
+		}
+
+		tileKeys, err = parseTileKeys(string(tileContent))
+		if err != nil {
+			fatal("failed to parse tile keys", "err", err)
+		}
+
+		slog.Info("loaded tile keys", "tiles", len(tileKeys), "path", *tileFile)
+	default:
+		fmt.Fprintf(os.Stderr, "Usage: %s -tiles <file> | -region <region> [-db <path>] [-interval <duration>] [-budget <requests/hour>]\n", os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	tileContent, err := os.ReadFile(*tileFile)
+	db, err := store.Open(*dbPath)
 	if err != nil {
-		log.Fatalf("Failed to read tile file %s: %v", *tileFile, err)
+		fatal("failed to open database", "err", err)
 	}
+	defer db.Close()
 
-	tileKeys, err := parseTileKeys(string(tileContent))
-	if err != nil {
-		log.Fatalf("Failed to parse tile keys: %v", err)
+	reg := prometheus.NewRegistry()
+	metrics := crawl.NewMetrics(reg, nil)
+	if *metricsAddr != "" {
+		go func() {
+			slog.Error("metrics server stopped", "err", http.ListenAndServe(*metricsAddr, crawl.MonitorHandler(nil, reg)))
+		}()
 	}
-
-	log.Printf("Loaded %d tile keys from %s", len(tileKeys), *tileFile)
-
-	db, err := NewDatabase(*dbPath)
+	opts := schedule.DefaultOptions
+	opts.MinInterval = *interval
+	opts.MaxInterval = *maxInterval
+	opts.Budget = *budget
+	collector, err := NewCollector(db, tileKeys, opts, lib.Options.WithClient(metrics.Client()))
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("failed to start collector", "err", err)
 	}
-	defer db.Close()
-
-	collector := NewCollector(db, tileKeys, *interval)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -53,17 +90,20 @@ func main() {
 
 	go func() {
 		<-sigChan
-		log.Println("Received shutdown signal, stopping collection...")
+		slog.Info("received shutdown signal, stopping collection")
 		cancel()
 	}()
 
-	log.Printf("Starting BSSID location tracking with %d tile keys, checking every %v", len(tileKeys), *interval)
-	log.Printf("Database: %s", *dbPath)
-	log.Println("Press Ctrl+C to stop")
+	slog.Info("starting BSSID location tracking", "tiles", len(tileKeys), "interval", *interval, "budget", *budget, "db", *dbPath)
 
 	if err := collector.Start(ctx); err != nil && err != context.Canceled {
-		log.Fatalf("Collection failed: %v", err)
+		fatal("collection failed", "err", err)
 	}
 
-	log.Println("Collection stopped")
+	slog.Info("collection stopped")
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -1,5 +1,5 @@
 WITH ordered AS (
-  SELECT tilekey FROM seeds GROUP BY tilekey ORDER BY tilekey
+  SELECT tile_key AS tilekey FROM aps GROUP BY tile_key ORDER BY tile_key
 ),
 groups AS (
   SELECT t1.tilekey AS group_start
@@ -1,21 +1,21 @@
 WITH gaps AS (
   SELECT
     id,
-    LAG(id) OVER (ORDER BY substr(change_time, 1, 23)) AS prev_id,
-    change_time,
-    LAG(change_time) OVER (ORDER BY substr(change_time, 1, 23)) AS prev_change_time
-  FROM location_changes
+    LAG(id) OVER (ORDER BY moved_at, id) AS prev_id,
+    moved_at,
+    LAG(moved_at) OVER (ORDER BY moved_at, id) AS prev_moved_at
+  FROM ap_moves
 )
-SELECT lc.*
-FROM location_changes lc
+SELECT m.*
+FROM ap_moves m
 JOIN (
   SELECT id FROM gaps
-  WHERE prev_change_time IS NOT NULL
-    AND (strftime('%s', substr(change_time, 1, 23)) - strftime('%s', substr(prev_change_time, 1, 23))) > 60
+  WHERE prev_moved_at IS NOT NULL
+    AND moved_at - prev_moved_at > 60
   UNION
   SELECT prev_id FROM gaps
-  WHERE prev_change_time IS NOT NULL
-    AND (strftime('%s', substr(change_time, 1, 23)) - strftime('%s', substr(prev_change_time, 1, 23))) > 60
+  WHERE prev_moved_at IS NOT NULL
+    AND moved_at - prev_moved_at > 60
 ) gap_ids
-ON lc.id = gap_ids.id
-ORDER BY lc.id;
+ON m.id = gap_ids.id
+ORDER BY m.id;
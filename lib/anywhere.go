@@ -5,11 +5,15 @@ import (
 	"github.com/acheong08/apple-corelocation-experiments/lib/distance"
 	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
 	"github.com/acheong08/apple-corelocation-experiments/lib/spiral"
-	"log"
+	"log/slog"
 )
 
 const ErrInvalidInput = "invalid input"
 
+// SearchProximity returns nearby access points with the closest in slot 0.
+//
+// Deprecated: the rest of the result is unordered and the search stops as soon
+// as the closest point settles. Use Nearest instead.
 func SearchProximity(lat, long float64, limit uint8, options ...Modifier) ([]distance.Point, error) {
 	if options == nil {
 		options = make([]Modifier, 0)
@@ -27,7 +31,7 @@ func SearchProximity(lat, long float64, limit uint8, options ...Modifier) ([]dis
 		Y: lat,
 		X: long,
 	}
-	var closest *distance.Point
+	idx := distance.NewIndex(nil)
 	for i := 0; i < int(limit); i++ {
 		mLat, mLong = sp.Next()
 		tile, err := GetTile(morton.Pack(mLat, mLong, 13))
@@ -35,25 +39,26 @@ func SearchProximity(lat, long float64, limit uint8, options ...Modifier) ([]dis
 			continue
 		}
 		for _, d := range tile {
-			closest = distance.Closer(&target, closest, &distance.Point{
-				Id: d.BSSID,
+			idx.Insert(distance.Point{
+				Id: d.BSSID.String(),
 				Y:  d.Location.Lat,
 				X:  d.Location.Long,
 			})
 		}
 		break
 	}
-	if closest == nil {
+	if idx.Len() == 0 {
 		return nil, errors.New("no devices found")
 	}
+	closest := &idx.Nearest(target, 1)[0]
 	var points []distance.Point
 	for {
 		devices, err := QueryBssid([]string{closest.Id}, 0, options...)
 		if err != nil {
-			log.Println(closest)
+			slog.Debug("failed to query closest access point", "bssid", closest.Id, "err", err)
 			return nil, err
 		}
-		log.Println(len(devices))
+		slog.Debug("queried closest access point", "bssid", closest.Id, "found", len(devices))
 		if len(devices) == 0 {
 			return nil, errors.New("could not find given BSSID")
 		}
@@ -62,12 +67,13 @@ func SearchProximity(lat, long float64, limit uint8, options ...Modifier) ([]dis
 		points = append(points, make([]distance.Point, len(devices))...)
 		for i, device := range devices {
 			points[offset+i] = distance.Point{
-				Id: device.BSSID,
+				Id: device.BSSID.String(),
 				Y:  device.Location.Lat,
 				X:  device.Location.Long,
 			}
+			idx.Insert(points[offset+i])
 		}
-		newClosest := distance.Closest(target, points)
+		newClosest := idx.Nearest(target, 1)[0]
 		if newClosest.Id == closest.Id {
 			break
 		}
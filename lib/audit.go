@@ -0,0 +1,207 @@
+package lib
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// maxAuditSamples is how many distinct values are kept for each field
+const maxAuditSamples = 5
+
+// UnknownField is a field Apple sent that our .proto files do not describe
+type UnknownField struct {
+	// Message is the full name of the message type the field appeared in
+	Message  string `json:"message"`
+	Number   int32  `json:"number"`
+	WireType int8   `json:"wire_type"`
+	// Count is how many times the field was seen
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Samples are distinct values as text, varints in decimal and bytes as
+	// quoted text or hex
+	Samples []string `json:"samples"`
+}
+
+type unknownFieldKey struct {
+	message  protoreflect.FullName
+	number   protowire.Number
+	wireType protowire.Type
+}
+
+// Audit collects unknown fields from decoded responses, so that changes to
+// Apple's schema are noticed. Pass it to requests with Options.WithAudit. It
+// is safe for concurrent use.
+type Audit struct {
+	lock   sync.Mutex
+	fields map[unknownFieldKey]*UnknownField
+	// seen outlives Take so fields are only reported as new once
+	seen  map[unknownFieldKey]bool
+	onNew func(UnknownField)
+}
+
+// NewAudit logs a warning the first time each unknown field is seen
+func NewAudit() *Audit {
+	return &Audit{
+		fields: make(map[unknownFieldKey]*UnknownField),
+		seen:   make(map[unknownFieldKey]bool),
+		onNew: func(f UnknownField) {
+			slog.Warn("unknown protobuf field", "message", f.Message, "number", f.Number,
+				"wire_type", f.WireType, "sample", f.Samples[0])
+		},
+	}
+}
+
+// OnNew replaces what is done the first time each unknown field is seen
+func (a *Audit) OnNew(fn func(UnknownField)) *Audit {
+	a.onNew = fn
+	return a
+}
+
+// Observe records the unknown fields of m and every message within it. It is
+// called for responses when requests are made with Options.WithAudit, and can
+// be given anything else decoded, such as captured PbcWlocRequests.
+func (a *Audit) Observe(m proto.Message) {
+	if a == nil || m == nil {
+		return
+	}
+	now := time.Now()
+	var added []UnknownField
+	a.lock.Lock()
+	a.observe(m.ProtoReflect(), now, &added)
+	a.lock.Unlock()
+	if a.onNew != nil {
+		for _, f := range added {
+			a.onNew(f)
+		}
+	}
+}
+
+func (a *Audit) observe(m protoreflect.Message, now time.Time, added *[]UnknownField) {
+	unknown := m.GetUnknown()
+	for len(unknown) > 0 {
+		num, typ, n := protowire.ConsumeTag(unknown)
+		if n < 0 {
+			return
+		}
+		v := unknown[n:]
+		size := protowire.ConsumeFieldValue(num, typ, v)
+		if size < 0 {
+			return
+		}
+		a.record(unknownFieldKey{m.Descriptor().FullName(), num, typ}, sample(typ, v[:size]), now, added)
+		unknown = v[size:]
+	}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					a.observe(mv.Message(), now, added)
+					return true
+				})
+			}
+		case fd.Message() == nil:
+		case fd.IsList():
+			for i := range v.List().Len() {
+				a.observe(v.List().Get(i).Message(), now, added)
+			}
+		default:
+			a.observe(v.Message(), now, added)
+		}
+		return true
+	})
+}
+
+func (a *Audit) record(key unknownFieldKey, value string, now time.Time, added *[]UnknownField) {
+	f, ok := a.fields[key]
+	if !ok {
+		f = &UnknownField{
+			Message:   string(key.message),
+			Number:    int32(key.number),
+			WireType:  int8(key.wireType),
+			FirstSeen: now,
+		}
+		a.fields[key] = f
+	}
+	f.Count++
+	f.LastSeen = now
+	if len(f.Samples) < maxAuditSamples && !slices.Contains(f.Samples, value) {
+		f.Samples = append(f.Samples, value)
+	}
+	if !a.seen[key] {
+		a.seen[key] = true
+		*added = append(*added, *f)
+	}
+}
+
+// sample formats a raw field value for a report
+func sample(typ protowire.Type, v []byte) string {
+	switch typ {
+	case protowire.VarintType:
+		x, _ := protowire.ConsumeVarint(v)
+		return fmt.Sprint(x)
+	case protowire.Fixed32Type:
+		x, _ := protowire.ConsumeFixed32(v)
+		return fmt.Sprint(x)
+	case protowire.Fixed64Type:
+		x, _ := protowire.ConsumeFixed64(v)
+		return fmt.Sprint(x)
+	case protowire.BytesType:
+		b, _ := protowire.ConsumeBytes(v)
+		if len(b) > 64 {
+			return fmt.Sprintf("%x... (%d bytes)", b[:64], len(b))
+		}
+		if utf8.Valid(b) {
+			return fmt.Sprintf("%q", b)
+		}
+		return fmt.Sprintf("%x", b)
+	}
+	return fmt.Sprintf("%x", v)
+}
+
+// Fields returns every unknown field collected so far, by message and number
+func (a *Audit) Fields() []UnknownField {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.sorted()
+}
+
+// Take returns the unknown fields collected since the last Take and starts
+// counting again, for saving them periodically
+func (a *Audit) Take() []UnknownField {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	fields := a.sorted()
+	clear(a.fields)
+	return fields
+}
+
+func (a *Audit) sorted() []UnknownField {
+	fields := make([]UnknownField, 0, len(a.fields))
+	for _, f := range a.fields {
+		c := *f
+		c.Samples = slices.Clone(f.Samples)
+		fields = append(fields, c)
+	}
+	slices.SortFunc(fields, func(x, y UnknownField) int {
+		return cmp.Or(cmp.Compare(x.Message, y.Message), cmp.Compare(x.Number, y.Number), cmp.Compare(x.WireType, y.WireType))
+	})
+	return fields
+}
+
+// WithAudit records unknown fields in every decoded response
+func (o _options) WithAudit(a *Audit) Modifier {
+	return func(wa *wlocArgs) {
+		wa.audit = a
+	}
+}
@@ -0,0 +1,117 @@
+package binindex_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/binindex"
+)
+
+func TestSortAndLookup(t *testing.T) {
+	layout := binindex.Geocode
+	r := rand.New(rand.NewPCG(1, 2))
+	const n = 10000
+	input := make([]byte, 0, n*layout.Size())
+	values := make(map[uint64]uint64)
+	for range n {
+		// Small key space so some keys repeat
+		k, v := r.Uint64N(n*4), r.Uint64()
+		if _, ok := values[k]; !ok {
+			values[k] = v
+		}
+		input = binary.BigEndian.AppendUint64(input, k)
+		input = binary.BigEndian.AppendUint64(input, v)
+	}
+
+	dir := t.TempDir()
+	sorted := filepath.Join(dir, "sorted.bin")
+	out, err := os.Create(sorted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tiny chunks and fan-in force several merge passes
+	err = binindex.Sort(bytes.NewReader(input), out, layout, binindex.SortOptions{
+		ChunkRecords: 97,
+		MaxOpenFiles: 4,
+		TempDir:      dir,
+		Unique:       true,
+	})
+	out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, "*.chunk")); len(leftovers) != 0 {
+		t.Fatalf("temporary chunks left behind: %v", leftovers)
+	}
+
+	idx, err := binindex.OpenWithBlockSize(sorted, layout, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if idx.Len() != len(values) {
+		t.Fatalf("sorted file has %d records, want %d unique keys", idx.Len(), len(values))
+	}
+
+	var keys [][]byte
+	var want []uint64
+	for k, v := range values {
+		got, ok := idx.Lookup(layout.Uint64Key(k))
+		if !ok || binary.BigEndian.Uint64(got) != v {
+			t.Fatalf("Lookup(%d) = %x, %t, want %x", k, got, ok, v)
+		}
+		keys = append(keys, layout.Uint64Key(k))
+		want = append(want, v)
+	}
+	for _, k := range []uint64{n*4 + 1, 1 << 63} {
+		if _, ok := idx.Lookup(layout.Uint64Key(k)); ok {
+			t.Fatalf("found missing key %d", k)
+		}
+	}
+	keys = append(keys, layout.Uint64Key(n*4+1))
+	got := idx.LookupBatch(keys)
+	for i, v := range want {
+		if binary.BigEndian.Uint64(got[i]) != v {
+			t.Fatalf("LookupBatch returned %x for key %x", got[i], keys[i])
+		}
+	}
+	if got[len(got)-1] != nil {
+		t.Fatal("LookupBatch found missing key")
+	}
+}
+
+func TestLookupAll(t *testing.T) {
+	layout := binindex.Layout{KeySize: 2, ValueSize: 1}
+	var input []byte
+	// A run of equal keys crossing several blocks
+	for i := range 20 {
+		input = append(input, 0, 5, byte(i))
+	}
+	input = append(input, 0, 1, 100, 0, 9, 101)
+	var out bytes.Buffer
+	if err := binindex.Sort(bytes.NewReader(input), &out, layout, binindex.SortOptions{ChunkRecords: 7}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "sorted.bin")
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := binindex.OpenWithBlockSize(path, layout, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	all := idx.LookupAll([]byte{0, 5})
+	if len(all) != 20 {
+		t.Fatalf("found %d records, want 20", len(all))
+	}
+	// Sorting is stable, so equal keys keep their input order
+	if !slices.IsSortedFunc(all, bytes.Compare) {
+		t.Fatal("equal keys were reordered")
+	}
+}
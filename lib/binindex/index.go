@@ -0,0 +1,152 @@
+package binindex
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+)
+
+// DefaultBlockRecords is how many records share one entry of the sparse index
+const DefaultBlockRecords = 512
+
+// Index looks up records in a sorted file. The file is memory mapped and
+// every BlockRecords-th key is kept in memory, so a lookup binary searches
+// the sparse keys and then a single block of the file.
+type Index struct {
+	layout       Layout
+	blockRecords int
+	data         []byte
+	unmap        func() error
+	// sparse holds the first key of every block
+	sparse [][]byte
+}
+
+// Open maps a file written by Sort. Keys must be sorted, which is checked
+// while building the sparse index.
+func Open(path string, layout Layout) (*Index, error) {
+	return OpenWithBlockSize(path, layout, DefaultBlockRecords)
+}
+
+func OpenWithBlockSize(path string, layout Layout, blockRecords int) (*Index, error) {
+	if err := layout.validate(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size()%int64(layout.Size()) != 0 {
+		return nil, fmt.Errorf("%s is not a whole number of %s records", path, layout)
+	}
+	data, unmap, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, err
+	}
+	idx := &Index{
+		layout:       layout,
+		blockRecords: max(blockRecords, 1),
+		data:         data,
+		unmap:        unmap,
+	}
+	if err := idx.buildSparse(); err != nil {
+		unmap()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return idx, nil
+}
+
+func (idx *Index) buildSparse() error {
+	n := idx.Len()
+	idx.sparse = make([][]byte, 0, n/idx.blockRecords+1)
+	for i := 0; i < n; i += idx.blockRecords {
+		key := idx.layout.Key(idx.record(i))
+		if len(idx.sparse) > 0 && bytes.Compare(idx.sparse[len(idx.sparse)-1], key) > 0 {
+			return fmt.Errorf("records are not sorted at %d", i)
+		}
+		idx.sparse = append(idx.sparse, bytes.Clone(key))
+	}
+	return nil
+}
+
+func (idx *Index) Close() error {
+	idx.data = nil
+	return idx.unmap()
+}
+
+// Len returns the number of records
+func (idx *Index) Len() int {
+	return len(idx.data) / idx.layout.Size()
+}
+
+func (idx *Index) record(i int) []byte {
+	size := idx.layout.Size()
+	return idx.data[i*size : (i+1)*size]
+}
+
+// find returns the position of the first record with the key, or -1
+func (idx *Index) find(key []byte) int {
+	if len(key) != idx.layout.KeySize {
+		return -1
+	}
+	// The last block starting at or before the key, stepping back one more
+	// when a run of equal keys crosses into it
+	block := sort.Search(len(idx.sparse), func(i int) bool {
+		return bytes.Compare(idx.sparse[i], key) >= 0
+	})
+	start := max(block-1, 0) * idx.blockRecords
+	end := min((block+1)*idx.blockRecords, idx.Len())
+	i := start + sort.Search(end-start, func(i int) bool {
+		return bytes.Compare(idx.layout.Key(idx.record(start+i)), key) >= 0
+	})
+	if i < end && bytes.Equal(idx.layout.Key(idx.record(i)), key) {
+		return i
+	}
+	return -1
+}
+
+// Lookup returns the value of the first record with the key. The value
+// points into the mapped file and is only valid until Close.
+func (idx *Index) Lookup(key []byte) ([]byte, bool) {
+	i := idx.find(key)
+	if i == -1 {
+		return nil, false
+	}
+	return idx.layout.Value(idx.record(i)), true
+}
+
+// LookupAll returns the values of every record with the key
+func (idx *Index) LookupAll(key []byte) [][]byte {
+	var values [][]byte
+	for i := idx.find(key); i != -1 && i < idx.Len(); i++ {
+		record := idx.record(i)
+		if !bytes.Equal(idx.layout.Key(record), key) {
+			break
+		}
+		values = append(values, idx.layout.Value(record))
+	}
+	return values
+}
+
+// LookupBatch looks up many keys, visiting the file in key order so lookups
+// for nearby keys share pages. Missing keys have a nil value.
+func (idx *Index) LookupBatch(keys [][]byte) [][]byte {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return bytes.Compare(keys[a], keys[b])
+	})
+	values := make([][]byte, len(keys))
+	for _, i := range order {
+		values[i], _ = idx.Lookup(keys[i])
+	}
+	return values
+}
@@ -0,0 +1,94 @@
+// Package binindex sorts fixed size binary records by key and looks them up
+// from the sorted file. Keys are compared as big-endian unsigned integers, so
+// any width works and integer keys must be written big-endian.
+package binindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Layout describes a record as a key followed by a value
+type Layout struct {
+	KeySize   int
+	ValueSize int
+}
+
+var (
+	// Geocode is the layout of wtfps.bin and sortedaps.bin: an 8 byte BSSID
+	// followed by an 8 byte geocode
+	Geocode = Layout{KeySize: 8, ValueSize: 8}
+	// Beacon is the layout of domain-expansion's beacon dumps: a 6 byte
+	// BSSID followed by latitude and longitude as float64 bits
+	Beacon = Layout{KeySize: 6, ValueSize: 16}
+)
+
+// ParseLayout reads a layout written as KEY:VALUE sizes in bytes, such as 6:16
+func ParseLayout(s string) (Layout, error) {
+	key, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return Layout{}, fmt.Errorf("invalid layout %q, expected KEY:VALUE", s)
+	}
+	var l Layout
+	var err error
+	if l.KeySize, err = strconv.Atoi(key); err != nil {
+		return Layout{}, fmt.Errorf("invalid layout %q: %w", s, err)
+	}
+	if l.ValueSize, err = strconv.Atoi(value); err != nil {
+		return Layout{}, fmt.Errorf("invalid layout %q: %w", s, err)
+	}
+	return l, l.validate()
+}
+
+func (l Layout) String() string {
+	return fmt.Sprintf("%d:%d", l.KeySize, l.ValueSize)
+}
+
+func (l Layout) validate() error {
+	if l.KeySize <= 0 || l.ValueSize < 0 {
+		return fmt.Errorf("invalid layout %s", l)
+	}
+	return nil
+}
+
+// Size returns the size of one record in bytes
+func (l Layout) Size() int {
+	return l.KeySize + l.ValueSize
+}
+
+// Key returns the key of a record
+func (l Layout) Key(record []byte) []byte {
+	return record[:l.KeySize]
+}
+
+// Value returns the value of a record
+func (l Layout) Value(record []byte) []byte {
+	return record[l.KeySize:l.Size()]
+}
+
+// Compare orders two records by key
+func (l Layout) Compare(a, b []byte) int {
+	return bytes.Compare(a[:l.KeySize], b[:l.KeySize])
+}
+
+// Uint64Key encodes v as a big-endian key of the layout's key size, dropping
+// the high bytes when the key is shorter than 8 bytes
+func (l Layout) Uint64Key(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	if l.KeySize <= 8 {
+		return b[8-l.KeySize:]
+	}
+	return append(make([]byte, l.KeySize-8), b...)
+}
+
+// Coordinates decodes a value holding latitude and longitude as float64 bits,
+// as in the Beacon layout
+func Coordinates(value []byte) (lat, lon float64) {
+	return math.Float64frombits(binary.BigEndian.Uint64(value[:8])),
+		math.Float64frombits(binary.BigEndian.Uint64(value[8:16]))
+}
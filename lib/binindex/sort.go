@@ -0,0 +1,258 @@
+package binindex
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+type SortOptions struct {
+	// ChunkRecords is the number of records sorted in memory at once
+	ChunkRecords int
+	// MaxOpenFiles limits how many sorted chunks are merged in one pass
+	MaxOpenFiles int
+	// TempDir holds the sorted chunks, defaulting to os.TempDir
+	TempDir string
+	// Unique keeps only the first record of every key
+	Unique bool
+	// Progress is called with the number of records read so far
+	Progress func(records int64)
+}
+
+var DefaultSortOptions = SortOptions{
+	ChunkRecords: 1 << 20,
+	MaxOpenFiles: 100,
+}
+
+const bufferSize = 4 << 20
+
+// Sort reads records from in and writes them to out ordered by key. Chunks
+// are sorted in memory and spilled to temporary files, then merged with a
+// heap, in several passes when there are more chunks than MaxOpenFiles.
+func Sort(in io.Reader, out io.Writer, layout Layout, options SortOptions) error {
+	if err := layout.validate(); err != nil {
+		return err
+	}
+	if options.ChunkRecords <= 0 {
+		options.ChunkRecords = DefaultSortOptions.ChunkRecords
+	}
+	if options.MaxOpenFiles < 2 {
+		options.MaxOpenFiles = DefaultSortOptions.MaxOpenFiles
+	}
+	chunks, err := splitChunks(in, layout, options)
+	defer func() {
+		for _, c := range chunks {
+			os.Remove(c)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	// Merge into temporary files until one pass can produce the output
+	for len(chunks) > options.MaxOpenFiles {
+		var merged []string
+		for len(chunks) > 0 {
+			n := min(len(chunks), options.MaxOpenFiles)
+			name, err := mergeToTemp(chunks[:n], layout, options)
+			for _, c := range chunks[:n] {
+				os.Remove(c)
+			}
+			chunks = chunks[n:]
+			if err != nil {
+				// Leave everything to the deferred cleanup
+				chunks = append(append(chunks, merged...), name)
+				return err
+			}
+			merged = append(merged, name)
+		}
+		chunks = merged
+	}
+	w := bufio.NewWriterSize(out, bufferSize)
+	if err := mergeFiles(chunks, w, layout, options.Unique); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// SortFile sorts the records of one file into another
+func SortFile(inPath, outPath string, layout Layout, options SortOptions) error {
+	in, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	if err := Sort(in, out, layout, options); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func splitChunks(in io.Reader, layout Layout, options SortOptions) ([]string, error) {
+	size := layout.Size()
+	r := bufio.NewReaderSize(in, bufferSize)
+	chunk := make([]byte, options.ChunkRecords*size)
+	var chunks []string
+	var total int64
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if err == io.ErrUnexpectedEOF {
+			if n%size != 0 {
+				return chunks, fmt.Errorf("input ends with a partial record of %d bytes", n%size)
+			}
+		} else if err == io.EOF {
+			break
+		} else if err != nil {
+			return chunks, err
+		}
+		records := chunk[:n]
+		name, err := writeSortedChunk(records, layout, options)
+		if err != nil {
+			return chunks, err
+		}
+		chunks = append(chunks, name)
+		total += int64(n / size)
+		if options.Progress != nil {
+			options.Progress(total)
+		}
+		if n < len(chunk) {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+func writeSortedChunk(records []byte, layout Layout, options SortOptions) (string, error) {
+	size := layout.Size()
+	order := make([][]byte, len(records)/size)
+	for i := range order {
+		order[i] = records[i*size : (i+1)*size]
+	}
+	slices.SortStableFunc(order, layout.Compare)
+	f, err := os.CreateTemp(options.TempDir, "binindex-*.chunk")
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriterSize(f, bufferSize)
+	for _, record := range order {
+		if _, err := w.Write(record); err != nil {
+			f.Close()
+			return f.Name(), err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return f.Name(), err
+	}
+	return f.Name(), f.Close()
+}
+
+func mergeToTemp(chunks []string, layout Layout, options SortOptions) (string, error) {
+	f, err := os.CreateTemp(options.TempDir, "binindex-*.chunk")
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriterSize(f, bufferSize)
+	err = mergeFiles(chunks, w, layout, options.Unique)
+	if err == nil {
+		err = w.Flush()
+	}
+	return f.Name(), errors.Join(err, f.Close())
+}
+
+func mergeFiles(names []string, w io.Writer, layout Layout, unique bool) error {
+	readers := make([]io.Reader, len(names))
+	for i, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers[i] = bufio.NewReaderSize(f, bufferSize/len(names)+layout.Size())
+	}
+	return Merge(readers, w, layout, unique)
+}
+
+type cursor struct {
+	r      io.Reader
+	record []byte
+	// order breaks ties so equal keys keep their input order
+	order int
+}
+
+type mergeHeap struct {
+	layout  Layout
+	cursors []*cursor
+}
+
+func (h *mergeHeap) Len() int { return len(h.cursors) }
+func (h *mergeHeap) Less(i, j int) bool {
+	if c := h.layout.Compare(h.cursors[i].record, h.cursors[j].record); c != 0 {
+		return c < 0
+	}
+	return h.cursors[i].order < h.cursors[j].order
+}
+func (h *mergeHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+func (h *mergeHeap) Push(x any)    { h.cursors = append(h.cursors, x.(*cursor)) }
+func (h *mergeHeap) Pop() any {
+	c := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return c
+}
+
+// Merge performs a k-way merge of sorted record streams
+func Merge(inputs []io.Reader, w io.Writer, layout Layout, unique bool) error {
+	h := &mergeHeap{layout: layout}
+	for i, r := range inputs {
+		c := &cursor{r: r, record: make([]byte, layout.Size()), order: i}
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.cursors = append(h.cursors, c)
+		}
+	}
+	heap.Init(h)
+	var last []byte
+	for h.Len() > 0 {
+		c := h.cursors[0]
+		if !unique || last == nil || layout.Compare(last, c.record) != 0 {
+			if _, err := w.Write(c.record); err != nil {
+				return err
+			}
+			if unique {
+				last = append(last[:0], c.record...)
+			}
+		}
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+func (c *cursor) next() (bool, error) {
+	_, err := io.ReadFull(c.r, c.record)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err == io.ErrUnexpectedEOF {
+		return false, errors.New("input ends with a partial record")
+	}
+	return err == nil, err
+}
@@ -0,0 +1,92 @@
+// Package capture reads traffic captured from our own devices, as mitmproxy
+// dumps, HAR files or JSONL with one HAR entry per line, and decodes the
+// requests to Apple's location endpoints into ARPC headers and pb messages
+// for analysis.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Flow is one HTTP request and its response as stored in a capture
+type Flow struct {
+	Time          time.Time
+	Method        string
+	URL           string
+	RequestHeader http.Header
+	Request       []byte
+	// Status is 0 when the capture has no response
+	Status         int
+	ResponseHeader http.Header
+	Response       []byte
+}
+
+// RequestBody returns the request with any Content-Encoding removed
+func (f *Flow) RequestBody() ([]byte, error) {
+	return decodeContent(f.RequestHeader, f.Request)
+}
+
+// ResponseBody returns the response with any Content-Encoding removed
+func (f *Flow) ResponseBody() ([]byte, error) {
+	return decodeContent(f.ResponseHeader, f.Response)
+}
+
+func decodeContent(h http.Header, b []byte) ([]byte, error) {
+	var r io.Reader
+	var err error
+	switch enc := strings.ToLower(strings.TrimSpace(h.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return b, nil
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(b))
+	case "deflate":
+		// Servers send both zlib and raw deflate as "deflate"
+		r, err = zlib.NewReader(bytes.NewReader(b))
+		if err != nil {
+			r, err = flate.NewReader(bytes.NewReader(b)), nil
+		}
+	default:
+		return b, fmt.Errorf("unsupported content encoding %q", enc)
+	}
+	if err != nil {
+		return b, err
+	}
+	return io.ReadAll(r)
+}
+
+// Open reads every flow in a capture file. HAR (.har) and JSONL (.jsonl)
+// files are told apart by name or by starting with '{', and anything else is
+// read as a mitmproxy dump.
+func Open(path string) ([]Flow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	switch {
+	case strings.HasSuffix(path, ".har"):
+		return ReadHAR(br)
+	case strings.HasSuffix(path, ".jsonl"):
+		return ReadJSONL(br)
+	}
+	first, _ := br.Peek(1)
+	if len(first) == 1 && first[0] == '{' {
+		// A HAR file is one object, JSONL is one per line
+		if line, _ := br.Peek(br.Size()); isHAR(line) {
+			return ReadHAR(br)
+		}
+		return ReadJSONL(br)
+	}
+	return ReadMitmproxy(br)
+}
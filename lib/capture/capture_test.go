@@ -0,0 +1,201 @@
+package capture_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/capture"
+	"github.com/acheong08/apple-corelocation-experiments/pb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// tnet encodes v as a tnetstring the way mitmproxy does
+func tnet(v any) string {
+	var data string
+	var typ byte
+	switch v := v.(type) {
+	case []byte:
+		data, typ = string(v), ','
+	case string:
+		data, typ = v, ';'
+	case int:
+		data, typ = fmt.Sprint(v), '#'
+	case float64:
+		data, typ = fmt.Sprint(v), '^'
+	case nil:
+		typ = '~'
+	case []any:
+		for _, item := range v {
+			data += tnet(item)
+		}
+		typ = ']'
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			data += tnet(k) + tnet(v[k])
+		}
+		typ = '}'
+	}
+	return fmt.Sprintf("%d:%s%c", len(data), data, typ)
+}
+
+func wlocBodies(t *testing.T) (request, response []byte) {
+	t.Helper()
+	payload, err := proto.Marshal(&pb.AppleWLoc{WifiDevices: []*pb.WifiDevice{{Bssid: "aa:bb:cc:dd:ee:ff"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err = (&lib.ArpcRequest{Version: "1", Locale: "en_US", AppIdentifier: "com.apple.locationd",
+		OsVersion: "17.5.21F79", FunctionId: 1, Payload: payload}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := proto.Marshal(&pb.AppleWLoc{WifiDevices: []*pb.WifiDevice{{Bssid: "11:22:33:44:55:66"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return request, append(make([]byte, 10), resp...)
+}
+
+func TestReadMitmproxy(t *testing.T) {
+	request, response := wlocBodies(t)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(response)
+	zw.Close()
+	flow := map[string]any{
+		"type": "http",
+		"request": map[string]any{
+			"method": []byte("POST"), "scheme": []byte("https"), "host": "gs-loc.apple.com", "port": 443,
+			"path": []byte("/clls/wloc"), "timestamp_start": 1718000000.5,
+			"headers": []any{[]any{[]byte("Content-Type"), []byte("application/x-www-form-urlencoded")}},
+			"content": request,
+		},
+		"response": map[string]any{
+			"status_code": 200,
+			"headers":     []any{[]any{[]byte("content-encoding"), []byte("gzip")}},
+			"content":     gz.Bytes(),
+		},
+	}
+	dns := map[string]any{"type": "dns"}
+	flows, err := capture.ReadMitmproxy(strings.NewReader(tnet(dns) + tnet(flow)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 {
+		t.Fatalf("got %d flows, want 1", len(flows))
+	}
+	if flows[0].URL != "https://gs-loc.apple.com/clls/wloc" || flows[0].Time.Unix() != 1718000000 {
+		t.Errorf("got flow %s at %v", flows[0].URL, flows[0].Time)
+	}
+
+	e := capture.Decode(flows[0])
+	if len(e.Errors) > 0 {
+		t.Fatal(e.Errors)
+	}
+	if e.Endpoint != capture.Wloc || e.Arpc.AppIdentifier != "com.apple.locationd" {
+		t.Errorf("got endpoint %q with %+v", e.Endpoint, e.Arpc)
+	}
+	if got := e.Request.(*pb.AppleWLoc).GetWifiDevices()[0].GetBssid(); got != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("request has %s", got)
+	}
+	if got := e.Response.(*pb.AppleWLoc).GetWifiDevices()[0].GetBssid(); got != "11:22:33:44:55:66" {
+		t.Errorf("response has %s", got)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"11:22:33:44:55:66"`)) || bytes.Contains(b, []byte("request_body")) {
+		t.Errorf("unexpected JSON %s", b)
+	}
+}
+
+func harEntry(method, url string, headers map[string]string, request []byte, status int, response []byte) map[string]any {
+	var h []map[string]string
+	for k, v := range headers {
+		h = append(h, map[string]string{"name": k, "value": v})
+	}
+	e := map[string]any{
+		"startedDateTime": "2024-06-10T06:13:20.000Z",
+		"request":         map[string]any{"method": method, "url": url, "headers": h},
+		"response": map[string]any{"status": status, "headers": []any{}, "content": map[string]any{
+			"text": base64.StdEncoding.EncodeToString(response), "encoding": "base64",
+		}},
+	}
+	if request != nil {
+		e["request"].(map[string]any)["postData"] = map[string]any{
+			"text": base64.StdEncoding.EncodeToString(request), "encoding": "base64",
+		}
+	}
+	return e
+}
+
+func TestOpen(t *testing.T) {
+	request, response := wlocBodies(t)
+	tile, err := proto.Marshal(&pb.WifiTile{Region: []*pb.WifiTile_Region{{Devices: []*pb.WifiTile_Device{{Bssid: 0x112233445566}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []map[string]any{
+		harEntry("POST", "https://gs-loc.apple.com/clls/wloc", nil, request, 200, response),
+		harEntry("GET", "https://gspe85-ssl.ls.apple.com/wifi_request_tile", map[string]string{"X-tilekey": "81644"}, nil, 200, tile),
+		harEntry("GET", "https://example.com/", nil, nil, 404, nil),
+	}
+
+	dir := t.TempDir()
+	har, err := json.Marshal(map[string]any{"log": map[string]any{"entries": entries}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jsonl []byte
+	for _, e := range entries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jsonl = append(append(jsonl, b...), '\n')
+	}
+	for name, content := range map[string][]byte{"capture.har": har, "capture.json": har, "capture.jsonl": jsonl, "capture.txt": jsonl} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		flows, err := capture.Open(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(flows) != 3 {
+			t.Fatalf("%s: got %d flows, want 3", name, len(flows))
+		}
+
+		wloc := capture.Decode(flows[0])
+		if len(wloc.Errors) > 0 || wloc.Request == nil || wloc.Response == nil {
+			t.Errorf("%s: wloc not decoded: %v", name, wloc.Errors)
+		}
+		tile := capture.Decode(flows[1])
+		if tile.Endpoint != capture.Tile || tile.TileKey != 81644 {
+			t.Errorf("%s: got %q for tile %d", name, tile.Endpoint, tile.TileKey)
+		}
+		if got := tile.Response.(*pb.WifiTile).GetRegion()[0].GetDevices()[0].GetBssid(); got != 0x112233445566 {
+			t.Errorf("%s: tile has %x", name, got)
+		}
+		if other := capture.Decode(flows[2]); other.Endpoint != "" || other.Request != nil {
+			t.Errorf("%s: decoded %s as %q", name, other.URL, other.Endpoint)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/pb"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Endpoint is one of Apple's location endpoints
+type Endpoint string
+
+const (
+	// Wloc looks up access points and cells, /clls/wloc
+	Wloc Endpoint = "wloc"
+	// Tile downloads the access points of a tile, /wifi_request_tile
+	Tile Endpoint = "tile"
+	// PbcWloc uploads what a device has seen, /hcy/pbcwloc
+	PbcWloc Endpoint = "pbcwloc"
+)
+
+// EndpointOf recognises the endpoint of a URL, returning "" for others
+func EndpointOf(rawURL string) Endpoint {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case strings.HasSuffix(u.Path, "/clls/wloc"):
+		return Wloc
+	case strings.HasSuffix(u.Path, "/wifi_request_tile"):
+		return Tile
+	case strings.HasSuffix(u.Path, "/hcy/pbcwloc"):
+		return PbcWloc
+	}
+	return ""
+}
+
+// wlocResponseHeader is skipped before the protobuf of a wloc response, as
+// lib.RequestWloc does
+const wlocResponseHeader = 10
+
+// Exchange is a decoded request to one of Apple's endpoints and its response
+type Exchange struct {
+	Time     time.Time
+	Endpoint Endpoint
+	Method   string
+	URL      string
+	Status   int
+	// Arpc is the framing of a wloc or pbcwloc request, without its payload
+	Arpc *lib.ArpcRequest
+	// TileKey is the X-tilekey of a tile request
+	TileKey int64
+	// Request is a *pb.AppleWLoc or *pb.PbcWlocRequest, nil if it could not
+	// be decoded
+	Request proto.Message
+	// Response is a *pb.AppleWLoc or *pb.WifiTile, nil if it could not be
+	// decoded. pbcwloc responses are never decoded.
+	Response proto.Message
+	// RequestBody and ResponseBody are the bodies without Content-Encoding
+	RequestBody  []byte
+	ResponseBody []byte
+	// Errors explains what could not be decoded
+	Errors []string
+}
+
+// Decode decodes a flow to a recognised endpoint. Anything that cannot be
+// decoded is noted in Errors and left in the raw bodies.
+func Decode(f Flow) Exchange {
+	e := Exchange{
+		Time:     f.Time,
+		Endpoint: EndpointOf(f.URL),
+		Method:   f.Method,
+		URL:      f.URL,
+		Status:   f.Status,
+	}
+	var err error
+	if e.RequestBody, err = f.RequestBody(); err != nil {
+		e.fail("request", err)
+	}
+	if e.ResponseBody, err = f.ResponseBody(); err != nil {
+		e.fail("response", err)
+	}
+	switch e.Endpoint {
+	case Wloc:
+		e.decodeArpc(&pb.AppleWLoc{})
+		if len(e.ResponseBody) > wlocResponseHeader && e.Status == 200 {
+			e.Response = e.unmarshal("response", e.ResponseBody[wlocResponseHeader:], &pb.AppleWLoc{})
+		}
+	case PbcWloc:
+		e.decodeArpc(&pb.PbcWlocRequest{})
+	case Tile:
+		if key := f.RequestHeader.Get("X-tilekey"); key != "" {
+			if e.TileKey, err = strconv.ParseInt(key, 10, 64); err != nil {
+				e.fail("tile key", err)
+			}
+		}
+		if len(e.ResponseBody) > 0 && e.Status == 200 {
+			e.Response = e.unmarshal("response", e.ResponseBody, &pb.WifiTile{})
+		}
+	}
+	return e
+}
+
+func (e *Exchange) decodeArpc(m proto.Message) {
+	var a lib.ArpcRequest
+	if err := a.Deserialize(e.RequestBody); err != nil {
+		e.fail("arpc", err)
+		return
+	}
+	e.Request = e.unmarshal("request", a.Payload, m)
+	a.Payload = nil
+	e.Arpc = &a
+}
+
+func (e *Exchange) unmarshal(part string, b []byte, m proto.Message) proto.Message {
+	if err := proto.Unmarshal(b, m); err != nil {
+		e.fail(part, err)
+		return nil
+	}
+	return m
+}
+
+func (e *Exchange) fail(part string, err error) {
+	e.Errors = append(e.Errors, fmt.Sprintf("%s: %v", part, err))
+}
+
+// MarshalJSON writes the messages with protojson, and the raw bodies only
+// when they were not decoded
+func (e Exchange) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Time         time.Time        `json:"time"`
+		Endpoint     Endpoint         `json:"endpoint,omitempty"`
+		Method       string           `json:"method"`
+		URL          string           `json:"url"`
+		Status       int              `json:"status"`
+		Arpc         *lib.ArpcRequest `json:"arpc,omitempty"`
+		TileKey      int64            `json:"tile_key,omitempty"`
+		Request      json.RawMessage  `json:"request,omitempty"`
+		Response     json.RawMessage  `json:"response,omitempty"`
+		RequestBody  []byte           `json:"request_body,omitempty"`
+		ResponseBody []byte           `json:"response_body,omitempty"`
+		Errors       []string         `json:"errors,omitempty"`
+	}{
+		Time:     e.Time,
+		Endpoint: e.Endpoint,
+		Method:   e.Method,
+		URL:      e.URL,
+		Status:   e.Status,
+		Arpc:     e.Arpc,
+		TileKey:  e.TileKey,
+		Errors:   e.Errors,
+	}
+	var err error
+	if out.Request, err = MessageJSON(e.Request); err != nil {
+		return nil, err
+	}
+	if out.Response, err = MessageJSON(e.Response); err != nil {
+		return nil, err
+	}
+	if e.Request == nil {
+		out.RequestBody = e.RequestBody
+	}
+	if e.Response == nil {
+		out.ResponseBody = e.ResponseBody
+	}
+	return json.Marshal(out)
+}
+
+// MessageJSON encodes m with protojson, returning nil for a nil message
+func MessageJSON(m proto.Message) (json.RawMessage, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return protojson.Marshal(m)
+}
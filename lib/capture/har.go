@@ -0,0 +1,134 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// harEntry is the part of a HAR 1.2 entry that is read
+type harEntry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Request         struct {
+		Method   string      `json:"method"`
+		URL      string      `json:"url"`
+		Headers  []harHeader `json:"headers"`
+		PostData *struct {
+			Text string `json:"text"`
+			// Encoding is not in the HAR spec, but browsers and mitmproxy
+			// set it to base64 for binary bodies as they do for responses
+			Encoding string `json:"encoding"`
+		} `json:"postData"`
+	} `json:"request"`
+	Response struct {
+		Status  int         `json:"status"`
+		Headers []harHeader `json:"headers"`
+		Content struct {
+			Text     string `json:"text"`
+			Encoding string `json:"encoding"`
+		} `json:"content"`
+	} `json:"response"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// isHAR reports whether b, the start of a JSON file, opens a HAR log rather
+// than a line of JSONL
+func isHAR(b []byte) bool {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return false
+	}
+	t, err := dec.Token()
+	return err == nil && t == "log"
+}
+
+// ReadHAR reads the entries of a HAR file
+func ReadHAR(r io.Reader) ([]Flow, error) {
+	var har struct {
+		Log struct {
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.NewDecoder(r).Decode(&har); err != nil {
+		return nil, err
+	}
+	flows := make([]Flow, 0, len(har.Log.Entries))
+	for i, e := range har.Log.Entries {
+		f, err := e.flow()
+		if err != nil {
+			return flows, fmt.Errorf("entry %d: %w", i, err)
+		}
+		flows = append(flows, f)
+	}
+	return flows, nil
+}
+
+// ReadJSONL reads one HAR entry per line, as written by capture proxies
+// that stream rather than save a whole HAR file
+func ReadJSONL(r io.Reader) ([]Flow, error) {
+	var flows []Flow
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e harEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return flows, fmt.Errorf("line %d: %w", line, err)
+		}
+		f, err := e.flow()
+		if err != nil {
+			return flows, fmt.Errorf("line %d: %w", line, err)
+		}
+		flows = append(flows, f)
+	}
+	return flows, sc.Err()
+}
+
+func (e *harEntry) flow() (Flow, error) {
+	f := Flow{
+		Time:           e.StartedDateTime,
+		Method:         e.Request.Method,
+		URL:            e.Request.URL,
+		RequestHeader:  harHeaders(e.Request.Headers),
+		Status:         e.Response.Status,
+		ResponseHeader: harHeaders(e.Response.Headers),
+	}
+	var err error
+	if p := e.Request.PostData; p != nil {
+		if f.Request, err = harText(p.Text, p.Encoding); err != nil {
+			return f, fmt.Errorf("request body: %w", err)
+		}
+	}
+	if f.Response, err = harText(e.Response.Content.Text, e.Response.Content.Encoding); err != nil {
+		return f, fmt.Errorf("response body: %w", err)
+	}
+	return f, nil
+}
+
+// harHeaders drops Content-Encoding, since HAR bodies are saved decoded
+func harHeaders(headers []harHeader) http.Header {
+	h := make(http.Header, len(headers))
+	for _, v := range headers {
+		h.Add(v.Name, v.Value)
+	}
+	h.Del("Content-Encoding")
+	return h
+}
+
+func harText(text, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
@@ -0,0 +1,182 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ReadMitmproxy reads the HTTP flows of a mitmproxy dump, as written by
+// mitmdump -w or saved from mitmweb. Other flows, such as DNS, are skipped.
+func ReadMitmproxy(r io.Reader) ([]Flow, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var flows []Flow
+	for len(bytes.TrimSpace(b)) > 0 {
+		var v any
+		v, b, err = parseTnetstring(bytes.TrimLeft(b, " \t\r\n"))
+		if err != nil {
+			return flows, fmt.Errorf("flow %d: %w", len(flows), err)
+		}
+		state, ok := v.(map[string]any)
+		if !ok {
+			return flows, fmt.Errorf("flow %d is not a dictionary", len(flows))
+		}
+		if t := str(state["type"]); t != "" && t != "http" {
+			continue
+		}
+		f, err := mitmproxyFlow(state)
+		if err != nil {
+			return flows, fmt.Errorf("flow %d: %w", len(flows), err)
+		}
+		flows = append(flows, f)
+	}
+	return flows, nil
+}
+
+func mitmproxyFlow(state map[string]any) (Flow, error) {
+	req, ok := state["request"].(map[string]any)
+	if !ok {
+		return Flow{}, errors.New("no request")
+	}
+	host := str(req["host"])
+	if a := str(req["authority"]); a != "" {
+		host = a
+	} else if port, ok := req["port"].(int64); ok {
+		host = hostPort(host, port, str(req["scheme"]))
+	}
+	u := url.URL{Scheme: str(req["scheme"]), Host: host}
+	f := Flow{
+		Time:          unixFloat(req["timestamp_start"]),
+		Method:        str(req["method"]),
+		URL:           u.String() + str(req["path"]),
+		RequestHeader: mitmproxyHeaders(req["headers"]),
+		Request:       raw(req["content"]),
+	}
+	if resp, ok := state["response"].(map[string]any); ok {
+		code, _ := resp["status_code"].(int64)
+		f.Status = int(code)
+		f.ResponseHeader = mitmproxyHeaders(resp["headers"])
+		f.Response = raw(resp["content"])
+	}
+	return f, nil
+}
+
+func hostPort(host string, port int64, scheme string) string {
+	if scheme == "https" && port == 443 || scheme == "http" && port == 80 {
+		return host
+	}
+	return host + ":" + strconv.FormatInt(port, 10)
+}
+
+// mitmproxyHeaders reads headers stored as a list of [name, value] pairs
+func mitmproxyHeaders(v any) http.Header {
+	h := make(http.Header)
+	fields, _ := v.([]any)
+	for _, field := range fields {
+		if kv, ok := field.([]any); ok && len(kv) == 2 {
+			h.Add(str(kv[0]), str(kv[1]))
+		}
+	}
+	return h
+}
+
+func unixFloat(v any) time.Time {
+	switch t := v.(type) {
+	case float64:
+		sec, frac := math.Modf(t)
+		return time.Unix(int64(sec), int64(frac*1e9))
+	case int64:
+		return time.Unix(t, 0)
+	}
+	return time.Time{}
+}
+
+// str reads text stored as either bytes or a string, as older dumps use bytes
+// for everything
+func str(v any) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	}
+	return ""
+}
+
+func raw(v any) []byte {
+	switch b := v.(type) {
+	case []byte:
+		return b
+	case string:
+		return []byte(b)
+	}
+	return nil
+}
+
+// parseTnetstring reads one value of the tnetstring format mitmproxy uses,
+// returning what follows it. Byte strings are []byte, text is string,
+// integers are int64, lists are []any and dictionaries map[string]any.
+func parseTnetstring(b []byte) (any, []byte, error) {
+	colon := bytes.IndexByte(b, ':')
+	if colon < 1 || colon > 10 {
+		return nil, nil, errors.New("malformed tnetstring length")
+	}
+	n, err := strconv.Atoi(string(b[:colon]))
+	if err != nil || n < 0 {
+		return nil, nil, errors.New("malformed tnetstring length")
+	}
+	end := colon + 1 + n
+	if end >= len(b) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	data, typ, rest := b[colon+1:end], b[end], b[end+1:]
+	switch typ {
+	case ',':
+		return data, rest, nil
+	case ';':
+		return string(data), rest, nil
+	case '#':
+		i, err := strconv.ParseInt(string(data), 10, 64)
+		return i, rest, err
+	case '^':
+		f, err := strconv.ParseFloat(string(data), 64)
+		return f, rest, err
+	case '!':
+		return string(data) == "true", rest, nil
+	case '~':
+		return nil, rest, nil
+	case ']':
+		list := []any{}
+		for len(data) > 0 {
+			var v any
+			if v, data, err = parseTnetstring(data); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, v)
+		}
+		return list, rest, nil
+	case '}':
+		dict := make(map[string]any)
+		for len(data) > 0 {
+			var k, v any
+			if k, data, err = parseTnetstring(data); err != nil {
+				return nil, nil, err
+			}
+			if v, data, err = parseTnetstring(data); err != nil {
+				return nil, nil, err
+			}
+			dict[str(k)] = v
+		}
+		return dict, rest, nil
+	}
+	return nil, nil, fmt.Errorf("unknown tnetstring type %q", typ)
+}
@@ -0,0 +1,529 @@
+package crawl_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/crawl"
+	"github.com/acheong08/apple-corelocation-experiments/lib/emulator"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+
+	"github.com/paulmach/orb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var area = orb.Bound{Min: orb.Point{-3.2, 51.47}, Max: orb.Point{-3.15, 51.5}}
+
+// sink collects every access point it is given
+type sink struct {
+	lock sync.Mutex
+	aps  map[mac.Addr]bool
+}
+
+func (s *sink) Tile(tileKey int64, aps []lib.AP, err error) { s.APs(aps) }
+
+func (s *sink) APs(aps []lib.AP) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.aps == nil {
+		s.aps = make(map[mac.Addr]bool)
+	}
+	for _, ap := range aps {
+		s.aps[ap.BSSID] = true
+	}
+}
+
+func (s *sink) len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.aps)
+}
+
+func setup(t *testing.T, n int) (*emulator.Emulator, lib.Modifier, *crawl.Frontier) {
+	e := emulator.New(emulator.Random(1, area, n))
+	e.Limit = 30
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	f, err := crawl.OpenFrontier(filepath.Join(t.TempDir(), "crawl.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return e, lib.Options.WithEndpoint(srv.URL), f
+}
+
+func TestTileCrawl(t *testing.T) {
+	ctx := context.Background()
+	e, endpoint, f := setup(t, 300)
+	var s sink
+	var jobs []crawl.Job
+	for _, key := range e.Tiles() {
+		// Each tile and an empty one next to it
+		jobs = append(jobs, crawl.TileJob(key), crawl.TileJob(key+1<<20))
+	}
+	if _, err := f.Push(ctx, jobs...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rate limited halfway through
+	var tiles atomic.Int32
+	e.Fail = func(r *http.Request) int {
+		if tiles.Add(1) > 2 {
+			return 503
+		}
+		return 0
+	}
+	engine := crawl.New(f).WithWorkers(1).Handle(crawl.KindTile, crawl.TileHandler(&s, endpoint))
+	if err := engine.Run(ctx); !errors.Is(err, crawl.ErrStop) {
+		t.Fatalf("Run returned %v, want ErrStop", err)
+	}
+	counts, err := f.Counts(ctx, crawl.KindTile)
+	if err != nil || counts.Done != 2 || counts.Leased != 0 || counts.Pending == 0 {
+		t.Fatalf("unexpected counts %+v: %v", counts, err)
+	}
+
+	e.Fail = nil
+	if err := crawl.New(f).WithWorkers(4).Handle(crawl.KindTile, crawl.TileHandler(&s, endpoint)).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.len() != 300 {
+		t.Errorf("found %d access points, want 300", s.len())
+	}
+	counts, _ = f.Counts(ctx, crawl.KindTile)
+	if counts.Done != int64(len(jobs)) {
+		t.Errorf("unexpected counts %+v for %d tiles", counts, len(jobs))
+	}
+	// Each tile once, and the one rate limited twice
+	if got := e.Requests("/wifi_request_tile"); got != len(jobs)+1 {
+		t.Errorf("made %d tile requests for %d tiles", got, len(jobs))
+	}
+}
+
+func TestExpandResume(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	e, endpoint, f := setup(t, 500)
+	var s sink
+	handler := crawl.ExpandHandler(&s, nil, endpoint)
+	var handled atomic.Int32
+	stopping := func(ctx context.Context, job crawl.Job) ([]crawl.Job, error) {
+		if handled.Add(1) == 20 {
+			cancel()
+		}
+		return handler(ctx, job)
+	}
+	seed := crawl.BSSIDJob(emulator.Random(1, area, 1)[0], 0)
+	if _, err := f.Push(ctx, seed); err != nil {
+		t.Fatal(err)
+	}
+	if err := crawl.New(f).WithWorkers(4).Handle(crawl.KindBSSID, stopping).Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run returned %v, want context.Canceled", err)
+	}
+	paused, _ := f.Counts(context.Background(), "")
+	if paused.Leased != 0 || paused.Pending == 0 {
+		t.Fatalf("unexpected counts after cancelling %+v", paused)
+	}
+
+	if err := crawl.New(f).WithWorkers(4).Handle(crawl.KindBSSID, handler).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	counts, _ := f.Counts(context.Background(), "")
+	if counts.Pending != 0 || counts.Failed != 0 || counts.Done <= paused.Done {
+		t.Fatalf("unexpected counts %+v", counts)
+	}
+	// Jobs finished before the pause were not repeated. Those cancelled
+	// mid-request may have been.
+	if got := e.Requests("/clls/wloc"); int64(got) > counts.Done+4 {
+		t.Errorf("made %d requests for %d jobs", got, counts.Done)
+	}
+	if s.len() < 400 {
+		t.Errorf("expanded to only %d of 500 access points", s.len())
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	_, endpoint, f := setup(t, 100)
+	var s sink
+	for _, ap := range emulator.Random(1, area, 3) {
+		f.Push(ctx, crawl.BSSIDJob(ap, 0))
+	}
+	// Another worker takes the jobs and dies
+	leased, err := f.Lease(ctx, "dead", 10, 100*time.Millisecond)
+	if err != nil || len(leased) != 3 {
+		t.Fatalf("leased %d: %v", len(leased), err)
+	}
+	if more, _ := f.Lease(ctx, "live", 10, time.Minute); len(more) != 0 {
+		t.Fatalf("leased %d jobs twice", len(more))
+	}
+	start := time.Now()
+	if err := crawl.New(f).WithOwner("live").WithPoll(10*time.Millisecond).
+		Handle(crawl.KindBSSID, crawl.ExpandHandler(&s, nil, endpoint)).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 90*time.Millisecond {
+		t.Error("jobs taken before their lease expired")
+	}
+	if counts, _ := f.Counts(ctx, ""); counts.Pending+counts.Leased+counts.Failed != 0 || s.len() != 100 {
+		t.Errorf("unexpected counts %+v with %d access points", counts, s.len())
+	}
+}
+
+func TestLateSettle(t *testing.T) {
+	ctx := context.Background()
+	_, _, f := setup(t, 0)
+	f.Push(ctx, crawl.TileJob(81644))
+	stale, err := f.Lease(ctx, "slow", 1, 10*time.Millisecond)
+	if err != nil || len(stale) != 1 {
+		t.Fatalf("leased %d: %v", len(stale), err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if jobs, err := f.Lease(ctx, "fast", 1, time.Minute); err != nil || len(jobs) != 1 {
+		t.Fatalf("expired lease not reclaimed: %d jobs, %v", len(jobs), err)
+	}
+
+	// The first worker finishing late leaves the job to the second
+	if err := f.Fail(ctx, "slow", stale[0], errors.New("timeout"), 3); !errors.Is(err, crawl.ErrLeaseLost) {
+		t.Errorf("late Fail returned %v", err)
+	}
+	if err := f.Complete(ctx, "slow", stale[0].ID); !errors.Is(err, crawl.ErrLeaseLost) {
+		t.Errorf("late Complete returned %v", err)
+	}
+	if jobs, _ := f.Lease(ctx, "third", 1, time.Minute); len(jobs) != 0 {
+		t.Fatal("job leased while the second worker holds it")
+	}
+	if err := f.Complete(ctx, "fast", stale[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if counts, _ := f.Counts(ctx, ""); counts.Done != 1 || counts.Leased+counts.Pending != 0 {
+		t.Errorf("unexpected counts %+v", counts)
+	}
+}
+
+func TestGridFeed(t *testing.T) {
+	ctx := context.Background()
+	_, _, f := setup(t, 0)
+	skipped := 0
+	opts := crawl.GridOptions{
+		Skip: func(lat, lon float64) bool {
+			skipped++
+			return skipped == 2
+		},
+		Shards: 4,
+		Size:   5,
+		Lease:  50 * time.Millisecond,
+	}
+	if more, err := crawl.GridFeed("grid", opts)(ctx, f, "a"); !more || err != nil {
+		t.Fatalf("feed returned %t: %v", more, err)
+	}
+	if more, err := crawl.GridFeed("grid", opts)(ctx, f, "b"); !more || err != nil {
+		t.Fatalf("feed returned %t: %v", more, err)
+	}
+	shards, err := f.Shards(ctx, "grid")
+	if err != nil || len(shards) != 4 {
+		t.Fatalf("found %d shards: %v", len(shards), err)
+	}
+	if a := shards[0]; a.Owner != "a" || a.Next != (crawl.GridPosition{X: 6, Y: 0}) || a.Last.Y != 2047 {
+		t.Errorf("unexpected first shard %+v", a)
+	}
+	if b := shards[1]; b.Owner != "b" || b.First.Y != 2048 || b.Next.X != 5 {
+		t.Errorf("unexpected second shard %+v", b)
+	}
+
+	// a stops, and c carries on with its shard once the lease expires
+	time.Sleep(60 * time.Millisecond)
+	if _, err := crawl.GridFeed("grid", opts)(ctx, f, "c"); err != nil {
+		t.Fatal(err)
+	}
+	shards, _ = f.Shards(ctx, "grid")
+	if c := shards[0]; c.Owner != "c" || c.Next.X != 11 {
+		t.Errorf("shard not taken over: %+v", c)
+	}
+	if counts, _ := f.Counts(ctx, crawl.KindTile); counts.Pending != 15 {
+		t.Fatalf("pushed %d tiles", counts.Pending)
+	}
+
+	// Restarting with more shards keeps the saved ones rather than overlap them
+	opts.Shards = 8
+	if _, err := crawl.GridFeed("grid", opts)(ctx, f, "d"); err != nil {
+		t.Fatal(err)
+	}
+	if shards, _ = f.Shards(ctx, "grid"); len(shards) != 4 {
+		t.Errorf("found %d shards after changing -shards", len(shards))
+	}
+}
+
+func TestGridFeedRace(t *testing.T) {
+	ctx := context.Background()
+	_, _, f := setup(t, 0)
+	// A worker started with -shards 4 has saved its count but not yet created
+	// the shards when another starts with -shards 8
+	if err := f.SaveCheckpoint(ctx, "grid:shards", 4); err != nil {
+		t.Fatal(err)
+	}
+	opts := crawl.GridOptions{Shards: 8, Size: 1, Lease: time.Minute}
+	if _, err := crawl.GridFeed("grid", opts)(ctx, f, "b"); err != nil {
+		t.Fatal(err)
+	}
+	opts.Shards = 4
+	if _, err := crawl.GridFeed("grid", opts)(ctx, f, "a"); err != nil {
+		t.Fatal(err)
+	}
+	shards, err := f.Shards(ctx, "grid")
+	if err != nil || len(shards) != 4 {
+		t.Fatalf("found %d shards: %v", len(shards), err)
+	}
+	for i, s := range shards {
+		if i > 0 && s.First.Y != shards[i-1].Last.Y+1 {
+			t.Fatalf("shard %d starts at row %d after %d", i, s.First.Y, shards[i-1].Last.Y)
+		}
+	}
+}
+
+func TestRegion(t *testing.T) {
+	ctx := context.Background()
+	aps := emulator.Random(1, area, 500)
+	e, endpoint, f := setup(t, 500)
+
+	// The western half of the area
+	r, err := crawl.ParseRegion("-3.2,51.47,-3.175,51.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Contains(51.48, -3.19) || r.Contains(51.48, -3.16) {
+		t.Error("Contains is wrong either side of the boundary")
+	}
+	tiles := r.Tiles(nil)
+	inside := 0
+	for _, key := range e.Tiles() {
+		if slices.Contains(tiles, key) {
+			inside++
+		}
+	}
+	if inside == 0 || inside == len(e.Tiles()) || len(tiles) > 20 {
+		t.Errorf("%d tiles intersect, %d of them with access points", len(tiles), inside)
+	}
+	feed := crawl.GridFeed("region", crawl.GridOptions{Region: r, Shards: 1, Size: 1000, Lease: time.Minute})
+	if more, err := feed(ctx, f, "a"); !more || err != nil {
+		t.Fatalf("feed returned %t: %v", more, err)
+	}
+	if more, err := feed(ctx, f, "a"); more || err != nil {
+		t.Fatalf("finished feed returned %t: %v", more, err)
+	}
+	if counts, _ := f.Counts(ctx, crawl.KindTile); counts.Pending != int64(len(tiles)) {
+		t.Errorf("pushed %d of %d tiles", counts.Pending, len(tiles))
+	}
+
+	// Expansion stops at the boundary
+	var s sink
+	handler := crawl.ExpandHandler(&s, r, endpoint)
+	var lock sync.Mutex
+	var expanded []mac.Addr
+	recording := func(ctx context.Context, job crawl.Job) ([]crawl.Job, error) {
+		lock.Lock()
+		expanded = append(expanded, mac.Addr(job.Arg))
+		lock.Unlock()
+		return handler(ctx, job)
+	}
+	for _, ap := range aps {
+		if r.Contains(ap.Location.Lat, ap.Location.Long) {
+			f.Push(ctx, crawl.BSSIDJob(ap, 0))
+			break
+		}
+	}
+	if err := crawl.New(f).Handle(crawl.KindBSSID, recording).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(expanded) < 2 {
+		t.Fatalf("expanded from %d access points", len(expanded))
+	}
+	for _, bssid := range expanded {
+		l := aps[bssid-0x001a11000000].Location
+		if !r.Contains(l.Lat, l.Long) {
+			t.Errorf("expanded from %s at %v outside the region", bssid, l)
+		}
+	}
+	if s.len() <= len(expanded) {
+		t.Errorf("found %d access points from %d queries", s.len(), len(expanded))
+	}
+
+	path := filepath.Join(t.TempDir(), "triangle.geojson")
+	os.WriteFile(path, []byte(`{"type":"FeatureCollection","features":[{"type":"Feature","properties":{},
+		"geometry":{"type":"Polygon","coordinates":[[[-3.2,51.47],[-3.15,51.47],[-3.2,51.5],[-3.2,51.47]]]}}]}`), 0o644)
+	if r, err := crawl.ParseRegion(path); err != nil || !r.Contains(51.471, -3.199) || r.Contains(51.499, -3.151) {
+		t.Errorf("unexpected GeoJSON region: %v", err)
+	}
+	if r, err := crawl.ParseRegion("cn"); err != nil || !r.Contains(39.9, 116.4) || r.Contains(51.5, -0.1) {
+		t.Errorf("unexpected country region: %v", err)
+	}
+	if r, err := crawl.ParseRegion("fr"); err != nil || !r.Contains(48.86, 2.35) || r.Contains(52.52, 13.4) {
+		t.Errorf("unexpected country region: %v", err)
+	}
+	if _, err := crawl.ParseRegion("zz"); err == nil {
+		t.Error("parsed an unknown country")
+	}
+	if _, err := crawl.ParseRegion("1,2,3"); err == nil {
+		t.Error("parsed an invalid region")
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	ctx := context.Background()
+	e, endpoint, f := setup(t, 100)
+	reg := prometheus.NewRegistry()
+	metrics := crawl.NewMetrics(reg, f)
+	var jobs []crawl.Job
+	for _, key := range e.Tiles() {
+		jobs = append(jobs, crawl.TileJob(key), crawl.TileJob(key+1<<20))
+	}
+	f.Push(ctx, jobs...)
+	engine := crawl.New(f).WithMetrics(metrics).
+		Handle(crawl.KindTile, crawl.TileHandler(metrics.Sink(&sink{}), endpoint, lib.Options.WithClient(metrics.Client())))
+	if err := engine.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(crawl.MonitorHandler(engine, reg))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st crawl.Status
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Running || st.Done != int64(len(jobs)) || st.Queue[crawl.KindTile].Done != int64(len(jobs)) {
+		t.Errorf("unexpected status %+v", st)
+	}
+
+	resp, err = http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	empty := len(jobs) - len(e.Tiles())
+	for _, want := range []string{
+		fmt.Sprintf(`wloc_requests_total{endpoint="/wifi_request_tile",status="200"} %d`, len(e.Tiles())),
+		fmt.Sprintf(`wloc_requests_total{endpoint="/wifi_request_tile",status="404"} %d`, empty),
+		fmt.Sprintf(`crawl_tiles_empty_total %d`, empty),
+		`crawl_aps_discovered_total 100`,
+		fmt.Sprintf(`crawl_jobs_total{kind="tile",result="done"} %d`, len(jobs)),
+		fmt.Sprintf(`crawl_queue_jobs{kind="tile",state="done"} %d`, len(jobs)),
+		`wloc_request_duration_seconds_count{endpoint="/wifi_request_tile"}`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+}
+
+// TestWorkers runs several engines, as separate processes would, on one
+// frontier. Set CRAWL_TEST_POSTGRES to a database URL to also run it on
+// Postgres.
+func TestWorkers(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "crawl.db")
+		testWorkers(t, path)
+	})
+	t.Run("postgres", func(t *testing.T) {
+		url := os.Getenv("CRAWL_TEST_POSTGRES")
+		if url == "" {
+			t.Skip("CRAWL_TEST_POSTGRES not set")
+		}
+		// A schema of its own so runs don't see each other's jobs
+		db, err := sql.Open("pgx", url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		schema := fmt.Sprintf("crawl_test_%d", time.Now().UnixNano())
+		if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		testWorkers(t, url+sep+"search_path="+schema)
+	})
+}
+
+func testWorkers(t *testing.T, path string) {
+	ctx := context.Background()
+	e, endpoint, _ := setup(t, 300)
+	r, err := crawl.ParseRegion("-3.2,51.47,-3.15,51.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := crawl.GridOptions{Region: r, Shards: 4, Size: 1, Lease: 100 * time.Millisecond}
+
+	open := func() *crawl.Frontier {
+		f, err := crawl.OpenFrontier(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	// A worker that takes a shard and dies
+	dead := open()
+	if _, err := crawl.GridFeed("grid", opts)(ctx, dead, "dead"); err != nil {
+		t.Fatal(err)
+	}
+
+	var s sink
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		f := open()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = crawl.New(f).WithOwner(fmt.Sprint("worker", i)).WithWorkers(2).WithPoll(10*time.Millisecond).
+				WithLease(time.Minute).WithFeed(crawl.GridFeed("grid", opts)).
+				Handle(crawl.KindTile, crawl.TileHandler(&s, endpoint)).Run(ctx)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tiles := r.Tiles(nil)
+	if got := e.Requests("/wifi_request_tile"); got != len(tiles) {
+		t.Errorf("made %d requests for %d tiles", got, len(tiles))
+	}
+	if s.len() != 300 {
+		t.Errorf("found %d access points, want 300", s.len())
+	}
+	shards, err := dead.Shards(ctx, "grid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, shard := range shards {
+		if shard.State != crawl.Done || shard.Owner == "dead" {
+			t.Errorf("unexpected shard %+v", shard)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStop is returned by a handler to stop the whole crawl, such as when
+// rate limited. The job is returned to the queue.
+var ErrStop = errors.New("crawl stopped")
+
+// Handler runs a job and returns any jobs discovered by it. Discovered jobs
+// are pushed even if the handler fails.
+type Handler func(ctx context.Context, job Job) ([]Job, error)
+
+// Feed pushes more jobs when the frontier runs dry, returning false once it
+// has nothing left to add. Owner names the engine calling it.
+type Feed func(ctx context.Context, f *Frontier, owner string) (bool, error)
+
+// Engine leases jobs from a frontier and runs them on a pool of workers
+type Engine struct {
+	frontier *Frontier
+	handlers map[string]Handler
+	feed     Feed
+	owner    string
+	workers  int
+	lease    time.Duration
+	attempts int
+	poll     time.Duration
+	metrics  *Metrics
+	report   time.Duration
+
+	inflight atomic.Int64
+	done     atomic.Int64
+	retried  atomic.Int64
+	failed   atomic.Int64
+	lock     sync.Mutex
+	started  time.Time
+	running  bool
+	lastErr  string
+	stopped  string
+}
+
+// New creates an engine with 8 workers, 5 minute leases and 3 attempts per
+// job
+func New(f *Frontier) *Engine {
+	host, _ := os.Hostname()
+	return &Engine{
+		frontier: f,
+		handlers: make(map[string]Handler),
+		owner:    fmt.Sprintf("%s:%d", host, os.Getpid()),
+		workers:  8,
+		lease:    5 * time.Minute,
+		attempts: 3,
+		poll:     time.Second,
+	}
+}
+
+// Handle registers the handler for a kind of job
+func (e *Engine) Handle(kind string, h Handler) *Engine {
+	e.handlers[kind] = h
+	return e
+}
+
+// WithFeed sets the function called for more jobs when the frontier is empty
+func (e *Engine) WithFeed(feed Feed) *Engine {
+	e.feed = feed
+	return e
+}
+
+// WithWorkers sets how many jobs run at once
+func (e *Engine) WithWorkers(n int) *Engine {
+	e.workers = max(n, 1)
+	return e
+}
+
+// WithLease sets how long a job may run before another worker can take it.
+// It should be well above the slowest request.
+func (e *Engine) WithLease(d time.Duration) *Engine {
+	e.lease = d
+	return e
+}
+
+// WithAttempts sets how many times a job may fail before it is given up on
+func (e *Engine) WithAttempts(n int) *Engine {
+	e.attempts = max(n, 1)
+	return e
+}
+
+// WithOwner names this engine's leases. It defaults to the hostname and
+// process ID and must be unique among engines sharing a frontier.
+func (e *Engine) WithOwner(owner string) *Engine {
+	e.owner = owner
+	return e
+}
+
+// WithPoll sets how long to wait before checking the frontier again while
+// jobs leased elsewhere are still running
+func (e *Engine) WithPoll(d time.Duration) *Engine {
+	e.poll = d
+	return e
+}
+
+// WithMetrics records job results in m
+func (e *Engine) WithMetrics(m *Metrics) *Engine {
+	e.metrics = m
+	return e
+}
+
+// WithReport logs progress every d
+func (e *Engine) WithReport(d time.Duration) *Engine {
+	e.report = d
+	return e
+}
+
+// Run works through the frontier until it is empty and the feed is
+// exhausted, a handler returns ErrStop or ctx is cancelled. Jobs still
+// leased by this engine are released before returning, so cancelling is a
+// clean way to pause a crawl.
+func (e *Engine) Run(ctx context.Context) error {
+	runCtx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	// Bookkeeping must still happen after the crawl is stopped
+	bg := context.WithoutCancel(ctx)
+	e.lock.Lock()
+	e.started, e.running, e.stopped = time.Now(), true, ""
+	e.lock.Unlock()
+	if e.report > 0 {
+		go e.reportEvery(runCtx)
+	}
+
+	jobs := make(chan Job)
+	finished := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	for range e.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if err := e.run(runCtx, bg, job); err != nil {
+					stop(err)
+				}
+				e.inflight.Add(-1)
+				select {
+				case finished <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+
+	err := e.dispatch(runCtx, jobs, finished)
+	close(jobs)
+	wg.Wait()
+	if _, rerr := e.frontier.Release(bg, e.owner); rerr != nil && err == nil {
+		err = rerr
+	}
+	if err == nil {
+		err = context.Cause(runCtx)
+	}
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	e.lock.Lock()
+	e.running = false
+	if err != nil {
+		e.stopped = err.Error()
+	}
+	e.lock.Unlock()
+	return err
+}
+
+func (e *Engine) dispatch(ctx context.Context, jobs chan<- Job, finished <-chan struct{}) error {
+	fed := e.feed == nil
+	for ctx.Err() == nil {
+		// Read before leasing: if nothing was running then, nothing can
+		// have pushed jobs since
+		idle := e.inflight.Load() == 0
+		batch, err := e.frontier.Lease(ctx, e.owner, e.workers, e.lease)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if len(batch) < e.workers && !fed {
+			more, err := e.feed(ctx, e.frontier, e.owner)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			fed = !more
+		}
+		if len(batch) == 0 {
+			if idle && fed {
+				counts, err := e.frontier.Counts(ctx, "")
+				if err != nil {
+					return err
+				}
+				// Another engine still holds leases that may expire
+				if counts.Leased == 0 && counts.Pending == 0 {
+					return nil
+				}
+			}
+			select {
+			case <-ctx.Done():
+			case <-finished:
+			case <-time.After(e.poll):
+			}
+			continue
+		}
+		for _, job := range batch {
+			e.inflight.Add(1)
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				// The rest are released with the owner's other leases
+				e.inflight.Add(-1)
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// run handles one job, returning an error only if the crawl must stop
+func (e *Engine) run(ctx, bg context.Context, job Job) error {
+	h, ok := e.handlers[job.Kind]
+	if !ok {
+		e.failed.Add(1)
+		return e.settled(job, e.frontier.Fail(bg, e.owner, Job{ID: job.ID, Attempts: e.attempts}, fmt.Errorf("no handler for %q jobs", job.Kind), e.attempts))
+	}
+	start := time.Now()
+	next, err := h(ctx, job)
+	if len(next) > 0 {
+		if _, perr := e.frontier.Push(bg, next...); perr != nil {
+			return perr
+		}
+	}
+	switch {
+	case err == nil:
+		e.done.Add(1)
+		e.metrics.job(job.Kind, "done", time.Since(start))
+		return e.settled(job, e.frontier.Complete(bg, e.owner, job.ID))
+	case errors.Is(err, ErrStop):
+		e.metrics.job(job.Kind, "stopped", time.Since(start))
+		slog.Warn("stopping crawl", "kind", job.Kind, "key", job.Key, "err", err)
+		return err
+	case ctx.Err() != nil:
+		// Released on return
+		return nil
+	default:
+		e.setError(err)
+		result := "retry"
+		if job.Attempts+1 >= e.attempts {
+			result = "failed"
+			e.failed.Add(1)
+		} else {
+			e.retried.Add(1)
+		}
+		e.metrics.job(job.Kind, result, time.Since(start))
+		slog.Warn("job failed", "kind", job.Kind, "key", job.Key, "attempt", job.Attempts+1, "err", err)
+		return e.settled(job, e.frontier.Fail(bg, e.owner, job, err, e.attempts))
+	}
+}
+
+// settled passes on an error from recording a job's outcome, except a lost
+// lease: the job is then another worker's to finish
+func (e *Engine) settled(job Job, err error) error {
+	if errors.Is(err, ErrLeaseLost) {
+		slog.Warn("job lease lost", "kind", job.Kind, "key", job.Key)
+		return nil
+	}
+	return err
+}
+
+func (e *Engine) setError(err error) {
+	e.lock.Lock()
+	e.lastErr = err.Error()
+	e.lock.Unlock()
+}
+
+// Status is a snapshot of a crawl for monitoring
+type Status struct {
+	Owner    string    `json:"owner"`
+	Running  bool      `json:"running"`
+	Started  time.Time `json:"started"`
+	Workers  int       `json:"workers"`
+	InFlight int64     `json:"in_flight"`
+	// Jobs finished by this engine since it started
+	Done    int64   `json:"done"`
+	Retried int64   `json:"retried"`
+	Failed  int64   `json:"failed"`
+	Rate    float64 `json:"jobs_per_second"`
+	// LastError is the most recent job failure
+	LastError string `json:"last_error,omitempty"`
+	// Stopped is why the last run ended, if not by finishing
+	Stopped string            `json:"stopped,omitempty"`
+	Queue   map[string]Counts `json:"queue"`
+}
+
+// Status reports the engine's progress and the depth of the frontier
+func (e *Engine) Status(ctx context.Context) (Status, error) {
+	e.lock.Lock()
+	st := Status{
+		Owner:     e.owner,
+		Running:   e.running,
+		Started:   e.started,
+		Workers:   e.workers,
+		LastError: e.lastErr,
+		Stopped:   e.stopped,
+	}
+	e.lock.Unlock()
+	st.InFlight = e.inflight.Load()
+	st.Done = e.done.Load()
+	st.Retried = e.retried.Load()
+	st.Failed = e.failed.Load()
+	if elapsed := time.Since(st.Started).Seconds(); !st.Started.IsZero() && elapsed > 0 {
+		st.Rate = float64(st.Done) / elapsed
+	}
+	var err error
+	st.Queue, err = e.frontier.CountsByKind(ctx)
+	return st, err
+}
+
+func (e *Engine) reportEvery(ctx context.Context) {
+	t := time.NewTicker(e.report)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		st, err := e.Status(ctx)
+		if err != nil {
+			continue
+		}
+		var pending, done int64
+		for _, c := range st.Queue {
+			pending += c.Pending + c.Leased
+			done += c.Done
+		}
+		slog.Info("crawl progress", "done", st.Done, "failed", st.Failed, "in_flight", st.InFlight,
+			"rate", fmt.Sprintf("%.1f/s", st.Rate), "queued", pending, "total_done", done)
+	}
+}
@@ -0,0 +1,346 @@
+// Package crawl runs crawls of Apple's location services from a durable
+// frontier. Jobs are kept in SQLite or Postgres and leased to workers, so a
+// crawl can be stopped or crash at any point and resume where it left off,
+// and any number of processes can share the work. Jobs run at least once: a
+// job whose lease expires is run again by another worker, and only the
+// current lease owner can mark it done or failed, so handlers must tolerate
+// repeats.
+package crawl
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Job states, also used for shards
+const (
+	Pending = iota
+	Leased
+	Done
+	Failed
+)
+
+// Job is one unit of work. Jobs are unique by kind and key, so pushing a job
+// that was seen before is ignored, which is what deduplicates a crawl.
+type Job struct {
+	ID   int64
+	Kind string
+	Key  int64
+	// Arg is passed to the handler, such as the BSSID to expand from
+	Arg int64
+	// Higher priorities are leased first
+	Priority int
+	// Attempts counts previous failures
+	Attempts int
+}
+
+// Frontier is the queue of jobs shared by every worker of a crawl. Several
+// processes may open the same SQLite file, and processes on different
+// machines can share a Postgres database. Leases are timed by each
+// process's clock, so those clocks should agree to well within a lease.
+type Frontier struct {
+	db       *sql.DB
+	postgres bool
+}
+
+// OpenFrontier creates or upgrades the frontier in the SQLite file at path,
+// or in Postgres if path is a postgres:// URL
+func OpenFrontier(path string) (*Frontier, error) {
+	var f Frontier
+	var err error
+	if strings.HasPrefix(path, "postgres://") || strings.HasPrefix(path, "postgresql://") {
+		f.postgres = true
+		f.db, err = sql.Open("pgx", path)
+	} else {
+		f.db, err = sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(30000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := f.migrate(); err != nil {
+		f.db.Close()
+		return nil, fmt.Errorf("failed to migrate frontier: %w", err)
+	}
+	return &f, nil
+}
+
+func (f *Frontier) Close() error {
+	return f.db.Close()
+}
+
+// rebind rewrites ? placeholders for Postgres
+func (f *Frontier) rebind(query string) string {
+	if !f.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (f *Frontier) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return f.db.ExecContext(ctx, f.rebind(query), args...)
+}
+
+func (f *Frontier) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return f.db.QueryContext(ctx, f.rebind(query), args...)
+}
+
+// skipLocked stops concurrent leases in Postgres from waiting on each other.
+// SQLite serialises writers instead.
+func (f *Frontier) skipLocked() string {
+	if f.postgres {
+		return " FOR UPDATE SKIP LOCKED"
+	}
+	return ""
+}
+
+// Push adds jobs that have not been seen before and returns how many were new
+func (f *Frontier) Push(ctx context.Context, jobs ...Job) (int, error) {
+	if len(jobs) == 0 {
+		return 0, nil
+	}
+	tx, err := f.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, f.rebind("INSERT INTO jobs (kind, key, arg, priority) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING"))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	added := 0
+	for _, j := range jobs {
+		res, err := stmt.ExecContext(ctx, j.Kind, j.Key, j.Arg, j.Priority)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		added += int(n)
+	}
+	return added, tx.Commit()
+}
+
+// Lease hands up to n pending jobs to owner until the lease expires, after
+// which any worker may take them. Expired leases are reclaimed first.
+func (f *Frontier) Lease(ctx context.Context, owner string, n int, d time.Duration) ([]Job, error) {
+	now := time.Now()
+	if _, err := f.exec(ctx, "UPDATE jobs SET state = ?, owner = NULL WHERE state = ? AND lease_until < ?",
+		Pending, Leased, now.UnixMilli()); err != nil {
+		return nil, err
+	}
+	rows, err := f.query(ctx, `UPDATE jobs SET state = ?, owner = ?, lease_until = ?
+		WHERE state = ? AND id IN (SELECT id FROM jobs WHERE state = ? ORDER BY priority DESC, id LIMIT ?`+f.skipLocked()+`)
+		RETURNING id, kind, key, arg, priority, attempts`,
+		Leased, owner, now.Add(d).UnixMilli(), Pending, Pending, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var jobs []Job
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(&j.ID, &j.Kind, &j.Key, &j.Arg, &j.Priority, &j.Attempts); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// Complete marks jobs leased by owner as done. It returns ErrLeaseLost if
+// any of them has since been reclaimed, leaving those to their new owner.
+func (f *Frontier) Complete(ctx context.Context, owner string, ids ...int64) error {
+	lost := false
+	for _, id := range ids {
+		res, err := f.exec(ctx, "UPDATE jobs SET state = ?, owner = NULL, error = NULL WHERE id = ? AND owner = ? AND state = ?",
+			Done, id, owner, Leased)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			lost = true
+		}
+	}
+	if lost {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Fail records an error on a job leased by owner, returning it to the queue
+// until it has failed maxAttempts times. It returns ErrLeaseLost if the job
+// has since been reclaimed.
+func (f *Frontier) Fail(ctx context.Context, owner string, job Job, cause error, maxAttempts int) error {
+	state := Pending
+	if job.Attempts+1 >= maxAttempts {
+		state = Failed
+	}
+	res, err := f.exec(ctx, "UPDATE jobs SET state = ?, owner = NULL, attempts = attempts + 1, error = ? WHERE id = ? AND owner = ? AND state = ?",
+		state, cause.Error(), job.ID, owner, Leased)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.Join(ErrLeaseLost, err)
+	}
+	return nil
+}
+
+// Release returns jobs and shards leased by owner to the queue, or every
+// leased job and shard if owner is empty, and returns the number of jobs
+func (f *Frontier) Release(ctx context.Context, owner string) (int, error) {
+	where := " WHERE state = ?"
+	args := []any{Pending, Leased}
+	if owner != "" {
+		where += " AND owner = ?"
+		args = append(args, owner)
+	}
+	if _, err := f.exec(ctx, "UPDATE shards SET state = ?, owner = NULL"+where, args...); err != nil {
+		return 0, err
+	}
+	res, err := f.exec(ctx, "UPDATE jobs SET state = ?, owner = NULL"+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Requeue gives failed jobs of a kind, or of every kind if empty, another
+// set of attempts
+func (f *Frontier) Requeue(ctx context.Context, kind string) (int, error) {
+	query := "UPDATE jobs SET state = ?, attempts = 0 WHERE state = ?"
+	args := []any{Pending, Failed}
+	if kind != "" {
+		query += " AND kind = ?"
+		args = append(args, kind)
+	}
+	res, err := f.exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// Counts is the number of jobs in each state
+type Counts struct {
+	Pending int64 `json:"pending"`
+	Leased  int64 `json:"leased"`
+	Done    int64 `json:"done"`
+	Failed  int64 `json:"failed"`
+}
+
+// Counts returns the number of jobs of a kind, or of every kind if empty
+func (f *Frontier) Counts(ctx context.Context, kind string) (Counts, error) {
+	query := "SELECT kind, state, COUNT(*) FROM jobs"
+	var args []any
+	if kind != "" {
+		query += " WHERE kind = ?"
+		args = append(args, kind)
+	}
+	kinds, err := f.counts(ctx, query+" GROUP BY kind, state", args...)
+	var total Counts
+	for _, c := range kinds {
+		total.Pending += c.Pending
+		total.Leased += c.Leased
+		total.Done += c.Done
+		total.Failed += c.Failed
+	}
+	return total, err
+}
+
+// CountsByKind returns the number of jobs in each state for every kind
+func (f *Frontier) CountsByKind(ctx context.Context) (map[string]Counts, error) {
+	return f.counts(ctx, "SELECT kind, state, COUNT(*) FROM jobs GROUP BY kind, state")
+}
+
+func (f *Frontier) counts(ctx context.Context, query string, args ...any) (map[string]Counts, error) {
+	rows, err := f.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	kinds := make(map[string]Counts)
+	for rows.Next() {
+		var kind string
+		var state int
+		var n int64
+		if err := rows.Scan(&kind, &state, &n); err != nil {
+			return nil, err
+		}
+		c := kinds[kind]
+		switch state {
+		case Pending:
+			c.Pending = n
+		case Leased:
+			c.Leased = n
+		case Done:
+			c.Done = n
+		case Failed:
+			c.Failed = n
+		}
+		kinds[kind] = c
+	}
+	return kinds, rows.Err()
+}
+
+// SaveCheckpoint stores v as JSON under name, for feeders to resume from
+func (f *Frontier) SaveCheckpoint(ctx context.Context, name string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = f.exec(ctx, "INSERT INTO checkpoints (name, value) VALUES (?, ?) ON CONFLICT (name) DO UPDATE SET value = excluded.value", name, b)
+	return err
+}
+
+// InitCheckpoint saves v under name unless a checkpoint is already there,
+// then decodes the saved one into v, so feeders starting at the same time
+// agree on the first value written
+func (f *Frontier) InitCheckpoint(ctx context.Context, name string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := f.exec(ctx, "INSERT INTO checkpoints (name, value) VALUES (?, ?) ON CONFLICT (name) DO NOTHING", name, b); err != nil {
+		return err
+	}
+	if ok, err := f.LoadCheckpoint(ctx, name, v); err != nil || !ok {
+		return errors.Join(errors.New("checkpoint "+name+" not saved"), err)
+	}
+	return nil
+}
+
+// LoadCheckpoint decodes the checkpoint saved under name into v, returning
+// false if there is none
+func (f *Frontier) LoadCheckpoint(ctx context.Context, name string, v any) (bool, error) {
+	var b []byte
+	err := f.db.QueryRowContext(ctx, f.rebind("SELECT value FROM checkpoints WHERE name = ?"), name).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(b, v)
+}
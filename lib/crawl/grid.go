@@ -0,0 +1,138 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+)
+
+// GridPosition is a level 13 tile in the order of morton.Pack's arguments
+type GridPosition struct {
+	X, Y int
+}
+
+// GridOptions configure a grid feed
+type GridOptions struct {
+	// Region limits the walk to tiles intersecting it, or nil for every tile
+	Region *Region
+	// Skip leaves out tiles, such as those at sea, by their corner
+	Skip func(lat, lon float64) bool
+	// Shards splits the grid into bands of Y so several workers can walk it
+	// at once. The first worker to start the feed fixes the count and later
+	// values are ignored, since bands of another size would overlap.
+	Shards int
+	// Size is the most tile jobs pushed at a time
+	Size int
+	// Lease is how long a worker may hold a shard without pushing from it
+	// before another takes it over
+	Lease time.Duration
+}
+
+var DefaultGridOptions = GridOptions{
+	Shards: 64,
+	Size:   5000,
+	Lease:  10 * time.Minute,
+}
+
+// GridFeed walks the level 13 tile grid, X first, pushing tile jobs. The
+// grid is split into shards leased to one worker at a time, and each
+// shard's position is saved after every push, so workers can join or leave
+// a crawl at any time and a shard whose worker died is carried on from where
+// it stopped.
+func GridFeed(name string, opts GridOptions) Feed {
+	const maxTile = 1<<13 - 1
+	first, last := GridPosition{0, 0}, GridPosition{maxTile, maxTile}
+	if opts.Region != nil {
+		first.X, first.Y, last.X, last.Y = opts.Region.tileRange()
+	}
+	skip := func(x, y int) bool {
+		if opts.Region != nil && !opts.Region.Intersects(x, y) {
+			return true
+		}
+		return opts.Skip != nil && opts.Skip(morton.FromTile(x, y, 13))
+	}
+
+	rows := last.Y - first.Y + 1
+	bands := func(n int) []Shard {
+		var shards []Shard
+		for i := range n {
+			s := Shard{
+				Feed:  name,
+				ID:    i,
+				First: GridPosition{first.X, first.Y + i*rows/n},
+				Last:  GridPosition{last.X, first.Y + (i+1)*rows/n - 1},
+			}
+			s.Next = s.First
+			shards = append(shards, s)
+		}
+		return shards
+	}
+
+	created := false
+	var current *Shard
+	return func(ctx context.Context, f *Frontier, owner string) (bool, error) {
+		if !created {
+			existing, err := f.Shards(ctx, name)
+			if err != nil {
+				return false, err
+			}
+			// The first worker to save the shard count decides it, and the
+			// rest create the same bands, which the frontier ignores
+			n := min(max(opts.Shards, 1), rows)
+			if len(existing) > 0 {
+				// Feeds sharded before the count was saved
+				n = len(existing)
+			}
+			if err := f.InitCheckpoint(ctx, name+":shards", &n); err != nil {
+				return false, err
+			}
+			if err := f.CreateShards(ctx, bands(n)...); err != nil {
+				return false, err
+			}
+			created = true
+		}
+		if current == nil {
+			s, ok, err := f.LeaseShard(ctx, name, owner, opts.Lease)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				// Shards held by other workers may still come back
+				all, err := f.Shards(ctx, name)
+				for _, s := range all {
+					if s.State != Done {
+						return true, err
+					}
+				}
+				return false, err
+			}
+			current = &s
+		}
+
+		s := current
+		var jobs []Job
+		for !s.Finished() && len(jobs) < opts.Size {
+			if !skip(s.Next.X, s.Next.Y) {
+				jobs = append(jobs, TileJob(morton.Pack(s.Next.X, s.Next.Y, 13)))
+			}
+			s.Next.X++
+			if s.Next.X > s.Last.X {
+				s.Next.X = s.First.X
+				s.Next.Y++
+			}
+		}
+		// Pushed first, so a crash in between only pushes some tiles twice,
+		// which the frontier ignores
+		if _, err := f.Push(ctx, jobs...); err != nil {
+			return false, err
+		}
+		err := f.SaveShard(ctx, *s, owner, opts.Lease)
+		if errors.Is(err, ErrLeaseLost) || err == nil && s.Finished() {
+			current = nil
+			return true, nil
+		}
+		return err == nil, err
+	}
+}
@@ -0,0 +1,28 @@
+package crawl
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MonitorHandler serves /metrics from g and, if e is not nil, the engine's Status
+// as JSON on /status
+func MonitorHandler(e *Engine, g prometheus.Gatherer) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+	if e != nil {
+		mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+			st, err := e.Status(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(st)
+		})
+	}
+	return mux
+}
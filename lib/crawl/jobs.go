@@ -0,0 +1,90 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+)
+
+// Job kinds
+const (
+	KindTile  = "tile"
+	KindBSSID = "bssid"
+)
+
+// ExpandLevel is the morton level BSSID jobs are deduplicated at. Only one
+// access point in each cell, about 40m across, is expanded from.
+const ExpandLevel = 20
+
+// TileJob fetches a level 13 tile
+func TileJob(tileKey int64) Job {
+	return Job{Kind: KindTile, Key: tileKey}
+}
+
+// BSSIDJob expands from an access point to its neighbours
+func BSSIDJob(ap lib.AP, priority int) Job {
+	l := ap.Location.ToWGS84()
+	return Job{
+		Kind:     KindBSSID,
+		Key:      morton.Encode(l.Lat, l.Long, ExpandLevel),
+		Arg:      int64(ap.BSSID),
+		Priority: priority,
+	}
+}
+
+// Sink receives the results of tile and BSSID jobs
+type Sink interface {
+	// Tile is called for every attempt at fetching a tile
+	Tile(tileKey int64, aps []lib.AP, err error)
+	// APs is called with the access points returned for a BSSID
+	APs(aps []lib.AP)
+}
+
+// TileHandler fetches tiles into sink. Missing tiles complete the job and
+// rate limiting stops the crawl.
+func TileHandler(sink Sink, options ...lib.Modifier) Handler {
+	return func(ctx context.Context, job Job) ([]Job, error) {
+		aps, err := lib.GetTile(job.Key, options...)
+		sink.Tile(job.Key, aps, err)
+		var status *lib.StatusError
+		if errors.As(err, &status) {
+			switch status.Code {
+			case 404:
+				return nil, nil
+			case 503:
+				return nil, fmt.Errorf("%w: rate limited", ErrStop)
+			}
+		}
+		return nil, err
+	}
+}
+
+// ExpandHandler queries the BSSID of a job and queues a job for each access
+// point returned. Discovered jobs get a higher priority than the job they
+// came from, so each area is explored before moving on to the next seed. If
+// r is not nil, access points outside it are still sent to sink but not
+// expanded from.
+func ExpandHandler(sink Sink, r *Region, options ...lib.Modifier) Handler {
+	return func(ctx context.Context, job Job) ([]Job, error) {
+		aps, err := lib.QueryBssid([]string{mac.Addr(job.Arg).String()}, 0, options...)
+		if err != nil {
+			return nil, err
+		}
+		sink.APs(aps)
+		next := make([]Job, 0, len(aps))
+		for _, ap := range aps {
+			if r != nil {
+				l := ap.Location.ToWGS84()
+				if !r.Contains(l.Lat, l.Long) {
+					continue
+				}
+			}
+			next = append(next, BSSIDJob(ap, job.Priority+1))
+		}
+		return next, nil
+	}
+}
@@ -0,0 +1,164 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the Prometheus collectors for a crawl. A nil *Metrics records
+// nothing.
+type Metrics struct {
+	requests   *prometheus.CounterVec
+	latency    *prometheus.HistogramVec
+	throttled  prometheus.Counter
+	jobs       *prometheus.CounterVec
+	jobLatency *prometheus.HistogramVec
+	aps        prometheus.Counter
+	emptyTiles prometheus.Counter
+}
+
+// NewMetrics registers the crawl's collectors with reg, including the depth
+// of the frontier's queue, which is read on every scrape
+func NewMetrics(reg prometheus.Registerer, f *Frontier) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wloc_requests_total",
+			Help: "Requests to Apple by endpoint and HTTP status, or \"error\" when there was no response",
+		}, []string{"endpoint", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wloc_request_duration_seconds",
+			Help:    "Time until Apple's response headers arrived",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		}, []string{"endpoint"}),
+		throttled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "wloc_throttled_total",
+			Help: "Responses with status 429 or 503",
+		}),
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "crawl_jobs_total",
+			Help: "Jobs run by kind and result: done, retry, failed or stopped",
+		}, []string{"kind", "result"}),
+		jobLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "crawl_job_duration_seconds",
+			Help:    "Time taken by each job, including retries inside the handler",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+		}, []string{"kind"}),
+		aps: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "crawl_aps_discovered_total",
+			Help: "Access points returned, including ones seen before",
+		}),
+		emptyTiles: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "crawl_tiles_empty_total",
+			Help: "Tiles that were missing (404) or held no access points",
+		}),
+	}
+	reg.MustRegister(m.requests, m.latency, m.throttled, m.jobs, m.jobLatency, m.aps, m.emptyTiles)
+	if f != nil {
+		reg.MustRegister(queueCollector{f})
+	}
+	return m
+}
+
+// Client returns an HTTP client that records every request, for
+// lib.Options.WithClient
+func (m *Metrics) Client() *http.Client {
+	if m == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: transport{m, http.DefaultTransport}}
+}
+
+type transport struct {
+	m    *Metrics
+	base http.RoundTripper
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	endpoint := req.URL.Path
+	t.m.latency.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		t.m.requests.WithLabelValues(endpoint, "error").Inc()
+		return resp, err
+	}
+	t.m.requests.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		t.m.throttled.Inc()
+	}
+	return resp, nil
+}
+
+// Sink wraps s to count the access points and empty tiles passing through
+func (m *Metrics) Sink(s Sink) Sink {
+	if m == nil {
+		return s
+	}
+	return metricsSink{m, s}
+}
+
+type metricsSink struct {
+	m *Metrics
+	Sink
+}
+
+func (s metricsSink) Tile(tileKey int64, aps []lib.AP, err error) {
+	var status *lib.StatusError
+	if err == nil && len(aps) == 0 || errors.As(err, &status) && status.Code == 404 {
+		s.m.emptyTiles.Inc()
+	}
+	s.m.aps.Add(float64(len(aps)))
+	s.Sink.Tile(tileKey, aps, err)
+}
+
+func (s metricsSink) APs(aps []lib.AP) {
+	s.m.aps.Add(float64(len(aps)))
+	s.Sink.APs(aps)
+}
+
+func (m *Metrics) job(kind, result string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.jobs.WithLabelValues(kind, result).Inc()
+	m.jobLatency.WithLabelValues(kind).Observe(d.Seconds())
+}
+
+// queueCollector reports the number of jobs in each state
+type queueCollector struct {
+	f *Frontier
+}
+
+var queueDesc = prometheus.NewDesc("crawl_queue_jobs", "Jobs in the frontier by kind and state", []string{"kind", "state"}, nil)
+
+func (c queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDesc
+}
+
+func (c queueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	kinds, err := c.f.CountsByKind(ctx)
+	if err != nil {
+		slog.Warn("failed to count jobs", "err", err)
+		return
+	}
+	for kind, counts := range kinds {
+		for state, n := range map[string]int64{
+			"pending": counts.Pending,
+			"leased":  counts.Leased,
+			"done":    counts.Done,
+			"failed":  counts.Failed,
+		} {
+			ch <- prometheus.MustNewConstMetric(queueDesc, prometheus.GaugeValue, float64(n), kind, state)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package crawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+	"github.com/acheong08/apple-corelocation-experiments/lib/shapefiles"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+)
+
+// Region limits a crawl to an area given in WGS-84
+type Region struct {
+	// Name is the spec the region was parsed from
+	Name     string
+	polygons orb.MultiPolygon
+	bound    orb.Bound
+	// Level 13 tiles classified as inside (shapefiles.Water), crossed by the
+	// boundary (shapefiles.Coast) or outside (shapefiles.Land)
+	tiles *shapefiles.Bitmap
+}
+
+// NewRegion covers the given polygons
+func NewRegion(name string, polygons orb.MultiPolygon) *Region {
+	return &Region{
+		Name:     name,
+		polygons: polygons,
+		bound:    polygons.Bound(),
+		tiles:    shapefiles.RasterizeWater(polygons, 13, shapefiles.TileFromWGS84(13)),
+	}
+}
+
+// ParseRegion reads a bounding box as "west,south,east,north", a GeoJSON
+// file of polygons or an ISO 3166-1 alpha-2 country code from the shapefiles
+// dataset.
+func ParseRegion(spec string) (*Region, error) {
+	if parts := strings.Split(spec, ","); len(parts) == 4 {
+		var v [4]float64
+		for i, p := range parts {
+			var err error
+			if v[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64); err != nil {
+				return nil, fmt.Errorf("invalid bounding box %q: %w", spec, err)
+			}
+		}
+		b := orb.Bound{Min: orb.Point{v[0], v[1]}, Max: orb.Point{v[2], v[3]}}
+		if b.Min.Lon() >= b.Max.Lon() || b.Min.Lat() >= b.Max.Lat() || b.Min.Lat() < -90 || b.Max.Lat() > 90 ||
+			b.Min.Lon() < -180 || b.Max.Lon() > 180 {
+			return nil, fmt.Errorf("invalid bounding box %q", spec)
+		}
+		return NewRegion(spec, orb.MultiPolygon{b.ToPolygon()}), nil
+	}
+	if strings.HasSuffix(spec, ".geojson") || strings.HasSuffix(spec, ".json") {
+		b, err := os.ReadFile(spec)
+		if err != nil {
+			return nil, err
+		}
+		polygons, err := parseGeoJSON(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", spec, err)
+		}
+		return NewRegion(spec, polygons), nil
+	}
+	if len(spec) == 2 {
+		polygons := shapefiles.Default().Countries[strings.ToUpper(spec)]
+		if len(polygons) == 0 {
+			return nil, fmt.Errorf("no boundary for country %q", spec)
+		}
+		return NewRegion(strings.ToUpper(spec), polygons), nil
+	}
+	return nil, fmt.Errorf("unknown region %q, want west,south,east,north, a .geojson file or a country code", spec)
+}
+
+// parseGeoJSON collects the polygons of a feature collection, feature or
+// bare geometry
+func parseGeoJSON(b []byte) (orb.MultiPolygon, error) {
+	var probe struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, err
+	}
+	var geometries []orb.Geometry
+	switch probe.Type {
+	case "FeatureCollection":
+		fc, err := geojson.UnmarshalFeatureCollection(b)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fc.Features {
+			geometries = append(geometries, f.Geometry)
+		}
+	case "Feature":
+		f, err := geojson.UnmarshalFeature(b)
+		if err != nil {
+			return nil, err
+		}
+		geometries = append(geometries, f.Geometry)
+	default:
+		g, err := geojson.UnmarshalGeometry(b)
+		if err != nil {
+			return nil, err
+		}
+		geometries = append(geometries, g.Geometry())
+	}
+	var polygons orb.MultiPolygon
+	for _, g := range geometries {
+		switch g := g.(type) {
+		case orb.Polygon:
+			polygons = append(polygons, g)
+		case orb.MultiPolygon:
+			polygons = append(polygons, g...)
+		}
+	}
+	if len(polygons) == 0 {
+		return nil, fmt.Errorf("no polygons found")
+	}
+	return polygons, nil
+}
+
+// Bound is the bounding box of the region
+func (r *Region) Bound() orb.Bound {
+	return r.bound
+}
+
+// Contains reports whether a WGS-84 point is inside the region
+func (r *Region) Contains(lat, lon float64) bool {
+	p := orb.Point{lon, lat}
+	if !r.bound.Contains(p) {
+		return false
+	}
+	switch r.tiles.Classify(lat, lon) {
+	case shapefiles.Land:
+		return false
+	case shapefiles.Water:
+		return true
+	}
+	return planar.MultiPolygonContains(r.polygons, p)
+}
+
+// Intersects reports whether any of a level 13 tile is inside the region.
+// Arguments are in the order of morton.Pack.
+func (r *Region) Intersects(mLat, mLong int) bool {
+	return r.tiles.At(mLong, mLat) != shapefiles.Land
+}
+
+// tileRange is the smallest rectangle of level 13 tiles covering the region
+func (r *Region) tileRange() (minLat, minLong, maxLat, maxLong int) {
+	project := shapefiles.TileFromWGS84(13)
+	const n = 1 << 13
+	// Tile rows count down from the north
+	x0, y0 := project(orb.Point{r.bound.Min.Lon(), r.bound.Max.Lat()})
+	x1, y1 := project(orb.Point{r.bound.Max.Lon(), r.bound.Min.Lat()})
+	clamp := func(v float64) int {
+		return min(max(int(v), 0), n-1)
+	}
+	return clamp(y0), clamp(x0), clamp(y1), clamp(x1)
+}
+
+// Tiles lists the level 13 tiles intersecting the region, leaving out those
+// for which skip returns true
+func (r *Region) Tiles(skip func(lat, lon float64) bool) []int64 {
+	minLat, minLong, maxLat, maxLong := r.tileRange()
+	var keys []int64
+	for mLong := minLong; mLong <= maxLong; mLong++ {
+		for mLat := minLat; mLat <= maxLat; mLat++ {
+			if !r.Intersects(mLat, mLong) || skip != nil && skip(morton.FromTile(mLat, mLong, 13)) {
+				continue
+			}
+			keys = append(keys, morton.Pack(mLat, mLong, 13))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,167 @@
+package crawl
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Migrations are applied in order, with the number applied kept in PRAGMA
+// user_version for SQLite and in frontier_version for Postgres. The two
+// lists must stay the same length. Never edit a released migration, append
+// a new one instead.
+var sqliteMigrations = []string{
+	// 1: jobs and checkpoints
+	`
+	CREATE TABLE jobs (
+		id INTEGER PRIMARY KEY,
+		kind TEXT NOT NULL,
+		key INTEGER NOT NULL,
+		arg INTEGER NOT NULL,
+		priority INTEGER NOT NULL,
+		state INTEGER NOT NULL DEFAULT 0,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		owner TEXT,
+		-- unix milliseconds
+		lease_until INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		UNIQUE (kind, key)
+	);
+	CREATE INDEX jobs_ready ON jobs (state, priority DESC, id);
+
+	CREATE TABLE checkpoints (
+		name TEXT PRIMARY KEY,
+		value BLOB NOT NULL
+	);
+	`,
+	// 2: shards of a feed's tile grid, leased like jobs
+	`
+	CREATE TABLE shards (
+		feed TEXT NOT NULL,
+		shard INTEGER NOT NULL,
+		-- the rectangle covered and the next position, in the order of
+		-- morton.Pack's arguments
+		first_x INTEGER NOT NULL,
+		first_y INTEGER NOT NULL,
+		last_x INTEGER NOT NULL,
+		last_y INTEGER NOT NULL,
+		next_x INTEGER NOT NULL,
+		next_y INTEGER NOT NULL,
+		state INTEGER NOT NULL DEFAULT 0,
+		owner TEXT,
+		lease_until INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (feed, shard)
+	);
+	`,
+}
+
+var postgresMigrations = []string{
+	// 1: jobs and checkpoints
+	`
+	CREATE TABLE jobs (
+		id BIGSERIAL PRIMARY KEY,
+		kind TEXT NOT NULL,
+		key BIGINT NOT NULL,
+		arg BIGINT NOT NULL,
+		priority INTEGER NOT NULL,
+		state INTEGER NOT NULL DEFAULT 0,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		owner TEXT,
+		lease_until BIGINT NOT NULL DEFAULT 0,
+		error TEXT,
+		UNIQUE (kind, key)
+	);
+	CREATE INDEX jobs_ready ON jobs (state, priority DESC, id);
+
+	CREATE TABLE checkpoints (
+		name TEXT PRIMARY KEY,
+		value BYTEA NOT NULL
+	);
+	`,
+	// 2: shards
+	`
+	CREATE TABLE shards (
+		feed TEXT NOT NULL,
+		shard INTEGER NOT NULL,
+		first_x INTEGER NOT NULL,
+		first_y INTEGER NOT NULL,
+		last_x INTEGER NOT NULL,
+		last_y INTEGER NOT NULL,
+		next_x INTEGER NOT NULL,
+		next_y INTEGER NOT NULL,
+		state INTEGER NOT NULL DEFAULT 0,
+		owner TEXT,
+		lease_until BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (feed, shard)
+	);
+	`,
+}
+
+// migrate applies each missing migration in its own transaction. Processes
+// opening the frontier at once are serialised by SQLite's write lock or a
+// Postgres advisory lock.
+func (f *Frontier) migrate() error {
+	ctx := context.Background()
+	migrations := sqliteMigrations
+	if f.postgres {
+		migrations = postgresMigrations
+		if _, err := f.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS frontier_version (version INTEGER NOT NULL)"); err != nil {
+			return err
+		}
+	}
+	for {
+		tx, err := f.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		version, err := f.version(ctx, tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if version > len(migrations) {
+			tx.Rollback()
+			return fmt.Errorf("schema version %d is newer than this build supports (%d)", version, len(migrations))
+		}
+		if version == len(migrations) {
+			return tx.Commit()
+		}
+		if _, err := tx.ExecContext(ctx, migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if err := f.setVersion(ctx, tx, version+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *Frontier) version(ctx context.Context, tx *sql.Tx) (int, error) {
+	var version int
+	if !f.postgres {
+		err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+		return version, err
+	}
+	// Held until the transaction ends
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('frontier_version'))"); err != nil {
+		return 0, err
+	}
+	err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM frontier_version").Scan(&version)
+	return version, err
+}
+
+func (f *Frontier) setVersion(ctx context.Context, tx *sql.Tx, version int) error {
+	var err error
+	if f.postgres {
+		if _, err = tx.ExecContext(ctx, "DELETE FROM frontier_version"); err == nil {
+			_, err = tx.ExecContext(ctx, "INSERT INTO frontier_version (version) VALUES ($1)", version)
+		}
+	} else {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version))
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package crawl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrLeaseLost is returned when saving a shard or settling a job whose lease
+// expired and was taken by another worker
+var ErrLeaseLost = errors.New("lease lost")
+
+// Shard is a rectangle of a feed's tile grid that is walked by one worker at
+// a time
+type Shard struct {
+	Feed        string
+	ID          int
+	First, Last GridPosition
+	// Next is the first tile not yet pushed
+	Next  GridPosition
+	State int
+	Owner string
+}
+
+// Finished reports whether every tile in the shard has been pushed
+func (s Shard) Finished() bool {
+	return s.Next.Y > s.Last.Y
+}
+
+// CreateShards adds the shards of a feed, ignoring any that already exist so
+// every worker can call it on start
+func (f *Frontier) CreateShards(ctx context.Context, shards ...Shard) error {
+	for _, s := range shards {
+		_, err := f.exec(ctx, `INSERT INTO shards (feed, shard, first_x, first_y, last_x, last_y, next_x, next_y)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+			s.Feed, s.ID, s.First.X, s.First.Y, s.Last.X, s.Last.Y, s.Next.X, s.Next.Y)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const shardColumns = "feed, shard, first_x, first_y, last_x, last_y, next_x, next_y, state, COALESCE(owner, '')"
+
+func scanShard(rows *sql.Rows) (Shard, error) {
+	var s Shard
+	err := rows.Scan(&s.Feed, &s.ID, &s.First.X, &s.First.Y, &s.Last.X, &s.Last.Y, &s.Next.X, &s.Next.Y, &s.State, &s.Owner)
+	return s, err
+}
+
+// LeaseShard hands the first pending shard of a feed to owner, reclaiming
+// expired leases first. It returns false if every shard is done or leased.
+func (f *Frontier) LeaseShard(ctx context.Context, feed, owner string, d time.Duration) (Shard, bool, error) {
+	now := time.Now()
+	if _, err := f.exec(ctx, "UPDATE shards SET state = ?, owner = NULL WHERE feed = ? AND state = ? AND lease_until < ?",
+		Pending, feed, Leased, now.UnixMilli()); err != nil {
+		return Shard{}, false, err
+	}
+	rows, err := f.query(ctx, `UPDATE shards SET state = ?, owner = ?, lease_until = ?
+		WHERE feed = ? AND state = ? AND shard IN (SELECT shard FROM shards WHERE feed = ? AND state = ? ORDER BY shard LIMIT 1`+f.skipLocked()+`)
+		RETURNING `+shardColumns,
+		Leased, owner, now.Add(d).UnixMilli(), feed, Pending, feed, Pending)
+	if err != nil {
+		return Shard{}, false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return Shard{}, false, rows.Err()
+	}
+	s, err := scanShard(rows)
+	return s, err == nil, err
+}
+
+// SaveShard records how far owner has walked a shard and extends its lease,
+// marking it done once finished
+func (f *Frontier) SaveShard(ctx context.Context, s Shard, owner string, d time.Duration) error {
+	state := Leased
+	if s.Finished() {
+		state = Done
+	}
+	res, err := f.exec(ctx, `UPDATE shards SET next_x = ?, next_y = ?, state = ?, lease_until = ?
+		WHERE feed = ? AND shard = ? AND owner = ? AND state = ?`,
+		s.Next.X, s.Next.Y, state, time.Now().Add(d).UnixMilli(), s.Feed, s.ID, owner, Leased)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.Join(ErrLeaseLost, err)
+	}
+	return nil
+}
+
+// MoveShard sets where a shard continues from, marking it pending again
+func (f *Frontier) MoveShard(ctx context.Context, feed string, id int, next GridPosition) error {
+	res, err := f.exec(ctx, "UPDATE shards SET next_x = ?, next_y = ?, state = ?, owner = NULL WHERE feed = ? AND shard = ?",
+		next.X, next.Y, Pending, feed, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Shards lists the shards of a feed, or of every feed if empty
+func (f *Frontier) Shards(ctx context.Context, feed string) ([]Shard, error) {
+	query := "SELECT " + shardColumns + " FROM shards"
+	var args []any
+	if feed != "" {
+		query += " WHERE feed = ?"
+		args = append(args, feed)
+	}
+	rows, err := f.query(ctx, query+" ORDER BY feed, shard", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var shards []Shard
+	for rows.Next() {
+		s, err := scanShard(rows)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, s)
+	}
+	return shards, rows.Err()
+}
@@ -0,0 +1,43 @@
+package crawl
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+)
+
+// StoreSink writes results to a store, attributed to source
+type StoreSink struct {
+	aps     *store.Writer[store.AP]
+	fetches *store.Writer[store.Fetch]
+}
+
+func NewStoreSink(s *store.Store, source string) *StoreSink {
+	return &StoreSink{
+		aps:     s.APWriter(source),
+		fetches: s.FetchWriter().WithBatchSize(100),
+	}
+}
+
+func (s *StoreSink) Tile(tileKey int64, aps []lib.AP, err error) {
+	if err := s.fetches.Add(store.NewFetch(tileKey, aps, err)); err != nil {
+		slog.Warn("failed to log fetch", "tile", tileKey, "err", err)
+	}
+	s.APs(aps)
+}
+
+func (s *StoreSink) APs(aps []lib.AP) {
+	if len(aps) == 0 {
+		return
+	}
+	if err := s.aps.Add(store.NewAPs(aps)...); err != nil {
+		slog.Warn("failed to insert access points", "err", err)
+	}
+}
+
+// Close writes anything still buffered
+func (s *StoreSink) Close() error {
+	return errors.Join(s.aps.Close(), s.fetches.Close())
+}
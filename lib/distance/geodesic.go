@@ -0,0 +1,29 @@
+package distance
+
+import (
+	"math"
+
+	"github.com/jftuga/geodist"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+)
+
+// Geodesic returns the distance in metres between two points on the WGS-84
+// ellipsoid. Vincenty's formula is used, falling back to haversine for the
+// near-antipodal pairs where it fails to converge.
+func Geodesic(a, b Point) float64 {
+	p1 := geodist.Coord{Lat: a.Y, Lon: a.X}
+	p2 := geodist.Coord{Lat: b.Y, Lon: b.X}
+	_, km, err := geodist.VincentyDistance(p1, p2)
+	if err != nil {
+		return geo.DistanceHaversine(orb.Point{a.X, a.Y}, orb.Point{b.X, b.Y})
+	}
+	return km * 1000
+}
+
+// Bearing returns the initial bearing from a to b in degrees clockwise from
+// true north, in the range [0, 360).
+func Bearing(a, b Point) float64 {
+	bearing := geo.Bearing(orb.Point{a.X, a.Y}, orb.Point{b.X, b.Y})
+	return math.Mod(bearing+360, 360)
+}
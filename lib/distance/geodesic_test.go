@@ -0,0 +1,36 @@
+package distance_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/distance"
+)
+
+func TestGeodesic(t *testing.T) {
+	// Cardiff to London, ~211km
+	cardiff := distance.Point{Y: 51.481583, X: -3.179090}
+	london := distance.Point{Y: 51.507351, X: -0.127758}
+	d := distance.Geodesic(cardiff, london)
+	if math.Abs(d-211_900) > 1000 {
+		t.Fatalf("unexpected distance %f", d)
+	}
+	if distance.Geodesic(cardiff, cardiff) != 0 {
+		t.Fatal("distance to self should be zero")
+	}
+}
+
+func TestBearing(t *testing.T) {
+	origin := distance.Point{}
+	cases := map[float64]distance.Point{
+		0:   {Y: 1},
+		90:  {X: 1},
+		180: {Y: -1},
+		270: {X: -1},
+	}
+	for want, p := range cases {
+		if got := distance.Bearing(origin, p); math.Abs(got-want) > 1e-9 {
+			t.Errorf("bearing to %v: got %f, want %f", p, got, want)
+		}
+	}
+}
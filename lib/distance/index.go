@@ -0,0 +1,349 @@
+package distance
+
+import (
+	"container/heap"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/paulmach/orb"
+)
+
+// Index is a KD-tree over points projected onto the unit sphere. Working in
+// 3D avoids special cases at the poles and the antimeridian, and chord length
+// orders points the same way as great-circle distance does.
+//
+// Deleted points are tombstoned and the tree is rebuilt once they outnumber
+// the live points. An Index is safe for concurrent use.
+type Index struct {
+	root    *node
+	size    int
+	deleted int
+	lock    sync.RWMutex
+}
+
+type node struct {
+	point       Point
+	v           [3]float64
+	axis        int
+	deleted     bool
+	left, right *node
+	// Bounds of the subtree, both on the sphere and in degrees
+	min, max       [3]float64
+	minLat, maxLat float64
+	minLon, maxLon float64
+}
+
+// NewIndex bulk loads a balanced index from the given points
+func NewIndex(points []Point) *Index {
+	nodes := make([]*node, len(points))
+	for i, p := range points {
+		nodes[i] = newNode(p)
+	}
+	return &Index{root: build(nodes, 0), size: len(points)}
+}
+
+func newNode(p Point) *node {
+	v := toVector(p)
+	return &node{
+		point:  p,
+		v:      v,
+		min:    v,
+		max:    v,
+		minLat: p.Y,
+		maxLat: p.Y,
+		minLon: p.X,
+		maxLon: p.X,
+	}
+}
+
+func toVector(p Point) [3]float64 {
+	lat := p.Y * math.Pi / 180
+	lon := p.X * math.Pi / 180
+	return [3]float64{
+		math.Cos(lat) * math.Cos(lon),
+		math.Cos(lat) * math.Sin(lon),
+		math.Sin(lat),
+	}
+}
+
+func build(nodes []*node, axis int) *node {
+	if len(nodes) == 0 {
+		return nil
+	}
+	slices.SortFunc(nodes, func(a, b *node) int {
+		switch {
+		case a.v[axis] < b.v[axis]:
+			return -1
+		case a.v[axis] > b.v[axis]:
+			return 1
+		}
+		return 0
+	})
+	mid := len(nodes) / 2
+	n := nodes[mid]
+	n.axis = axis
+	n.left = build(nodes[:mid], (axis+1)%3)
+	n.right = build(nodes[mid+1:], (axis+1)%3)
+	n.min, n.max = n.v, n.v
+	n.minLat, n.maxLat = n.point.Y, n.point.Y
+	n.minLon, n.maxLon = n.point.X, n.point.X
+	for _, child := range []*node{n.left, n.right} {
+		if child != nil {
+			n.extend(child)
+		}
+	}
+	return n
+}
+
+// extend grows the bounds of n to cover those of o
+func (n *node) extend(o *node) {
+	for i := range 3 {
+		n.min[i] = math.Min(n.min[i], o.min[i])
+		n.max[i] = math.Max(n.max[i], o.max[i])
+	}
+	n.minLat = math.Min(n.minLat, o.minLat)
+	n.maxLat = math.Max(n.maxLat, o.maxLat)
+	n.minLon = math.Min(n.minLon, o.minLon)
+	n.maxLon = math.Max(n.maxLon, o.maxLon)
+}
+
+// boxDistance is the squared chord distance from v to the subtree bounds
+func (n *node) boxDistance(v [3]float64) float64 {
+	var d float64
+	for i := range 3 {
+		if v[i] < n.min[i] {
+			d += (n.min[i] - v[i]) * (n.min[i] - v[i])
+		} else if v[i] > n.max[i] {
+			d += (v[i] - n.max[i]) * (v[i] - n.max[i])
+		}
+	}
+	return d
+}
+
+func (n *node) boxContains(v [3]float64) bool {
+	for i := range 3 {
+		if v[i] < n.min[i] || v[i] > n.max[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func chordDistance(a, b [3]float64) float64 {
+	var d float64
+	for i := range 3 {
+		d += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return d
+}
+
+// Len returns the number of live points in the index
+func (idx *Index) Len() int {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	return idx.size
+}
+
+// Insert adds a point to the index. Points are not deduplicated.
+func (idx *Index) Insert(p Point) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	idx.size++
+	leaf := newNode(p)
+	if idx.root == nil {
+		idx.root = leaf
+		return
+	}
+	n := idx.root
+	for {
+		n.extend(leaf)
+		next := &n.right
+		if leaf.v[n.axis] < n.v[n.axis] {
+			next = &n.left
+		}
+		if *next == nil {
+			leaf.axis = (n.axis + 1) % 3
+			*next = leaf
+			return
+		}
+		n = *next
+	}
+}
+
+// Delete removes a point with the same Id and coordinates as p, reporting
+// whether one was found.
+func (idx *Index) Delete(p Point) bool {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	v := toVector(p)
+	var remove func(n *node) bool
+	remove = func(n *node) bool {
+		if n == nil || !n.boxContains(v) {
+			return false
+		}
+		if !n.deleted && n.point == p {
+			n.deleted = true
+			return true
+		}
+		return remove(n.left) || remove(n.right)
+	}
+	if !remove(idx.root) {
+		return false
+	}
+	idx.size--
+	idx.deleted++
+	if idx.deleted > idx.size {
+		idx.rebuild()
+	}
+	return true
+}
+
+func (idx *Index) rebuild() {
+	nodes := make([]*node, 0, idx.size)
+	var collect func(n *node)
+	collect = func(n *node) {
+		if n == nil {
+			return
+		}
+		collect(n.left)
+		collect(n.right)
+		if !n.deleted {
+			nodes = append(nodes, newNode(n.point))
+		}
+	}
+	collect(idx.root)
+	idx.root = build(nodes, 0)
+	idx.deleted = 0
+}
+
+// Within returns every point inside the given bounds, where X is longitude
+// and Y is latitude. A bound whose Min longitude is greater than its Max is
+// treated as crossing the antimeridian.
+func (idx *Index) Within(b orb.Bound) []Point {
+	if b.Min.Lon() > b.Max.Lon() {
+		east := orb.Bound{Min: b.Min, Max: orb.Point{180, b.Max.Lat()}}
+		west := orb.Bound{Min: orb.Point{-180, b.Min.Lat()}, Max: b.Max}
+		return append(idx.Within(east), idx.Within(west)...)
+	}
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	var result []Point
+	var search func(n *node)
+	search = func(n *node) {
+		if n == nil {
+			return
+		}
+		if n.maxLat < b.Min.Lat() || n.minLat > b.Max.Lat() || n.maxLon < b.Min.Lon() || n.minLon > b.Max.Lon() {
+			return
+		}
+		if !n.deleted && b.Contains(orb.Point{n.point.X, n.point.Y}) {
+			result = append(result, n.point)
+		}
+		search(n.left)
+		search(n.right)
+	}
+	search(idx.root)
+	return result
+}
+
+// Radius returns every point within the given number of metres of center,
+// closest first.
+func (idx *Index) Radius(center Point, metres float64) []Point {
+	angle := math.Min(metres/orb.EarthRadius, math.Pi)
+	chord := 2 * math.Sin(angle/2)
+	limit := chord * chord
+	v := toVector(center)
+
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	var found []candidate
+	var search func(n *node)
+	search = func(n *node) {
+		if n == nil || n.boxDistance(v) > limit {
+			return
+		}
+		if d := chordDistance(v, n.v); !n.deleted && d <= limit {
+			found = append(found, candidate{n.point, d})
+		}
+		search(n.left)
+		search(n.right)
+	}
+	search(idx.root)
+	return sortCandidates(found)
+}
+
+// Nearest returns up to k points closest to center, closest first
+func (idx *Index) Nearest(center Point, k int) []Point {
+	if k <= 0 {
+		return nil
+	}
+	v := toVector(center)
+
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	best := &candidateHeap{}
+	var search func(n *node)
+	search = func(n *node) {
+		if n == nil {
+			return
+		}
+		if best.Len() == k && n.boxDistance(v) > (*best)[0].distance {
+			return
+		}
+		if !n.deleted {
+			d := chordDistance(v, n.v)
+			if best.Len() < k {
+				heap.Push(best, candidate{n.point, d})
+			} else if d < (*best)[0].distance {
+				(*best)[0] = candidate{n.point, d}
+				heap.Fix(best, 0)
+			}
+		}
+		// Descend into the side containing the target first to tighten the bound
+		first, second := n.left, n.right
+		if v[n.axis] >= n.v[n.axis] {
+			first, second = second, first
+		}
+		search(first)
+		search(second)
+	}
+	search(idx.root)
+	return sortCandidates(*best)
+}
+
+type candidate struct {
+	point    Point
+	distance float64
+}
+
+func sortCandidates(c []candidate) []Point {
+	slices.SortFunc(c, func(a, b candidate) int {
+		switch {
+		case a.distance < b.distance:
+			return -1
+		case a.distance > b.distance:
+			return 1
+		}
+		return 0
+	})
+	points := make([]Point, len(c))
+	for i := range c {
+		points[i] = c[i].point
+	}
+	return points
+}
+
+// candidateHeap is a max-heap so the worst of the current k is at the root
+type candidateHeap []candidate
+
+func (h candidateHeap) Len() int           { return len(h) }
+func (h candidateHeap) Less(i, j int) bool { return h[i].distance > h[j].distance }
+func (h candidateHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *candidateHeap) Push(x any)        { *h = append(*h, x.(candidate)) }
+func (h *candidateHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
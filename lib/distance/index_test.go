@@ -0,0 +1,105 @@
+package distance_test
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/distance"
+	"github.com/paulmach/orb"
+)
+
+func randomPoints(r *rand.Rand, n int) []distance.Point {
+	points := make([]distance.Point, n)
+	for i := range points {
+		points[i] = distance.Point{
+			Id: fmt.Sprint(i),
+			Y:  r.Float64()*2 - 1 + 51.5,
+			X:  r.Float64()*2 - 1 - 3.1,
+		}
+	}
+	return points
+}
+
+func ids(points []distance.Point) []string {
+	s := make([]string, len(points))
+	for i, p := range points {
+		s[i] = p.Id
+	}
+	return s
+}
+
+func TestIndexNearest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	points := randomPoints(r, 2000)
+	idx := distance.NewIndex(points[:1000])
+	for _, p := range points[1000:] {
+		idx.Insert(p)
+	}
+	target := distance.Point{Y: 51.5, X: -3.1}
+	want := slices.Clone(points)
+	slices.SortFunc(want, func(a, b distance.Point) int {
+		da, db := distance.Geodesic(target, a), distance.Geodesic(target, b)
+		if da < db {
+			return -1
+		}
+		return 1
+	})
+	// Ranking is spherical, so only compare membership against the ellipsoid
+	got := idx.Nearest(target, 10)
+	if !slices.Equal(sorted(ids(got)), sorted(ids(want[:10]))) {
+		t.Fatalf("got %v, want %v", ids(got), ids(want[:10]))
+	}
+
+	within := idx.Radius(target, 5000)
+	for _, p := range within {
+		if distance.Geodesic(target, p) > 5050 {
+			t.Fatalf("%s is outside the radius", p.Id)
+		}
+	}
+	if len(within) == 0 {
+		t.Fatal("expected points within radius")
+	}
+}
+
+func TestIndexDelete(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	points := randomPoints(r, 500)
+	idx := distance.NewIndex(points)
+	for _, p := range points[:400] {
+		if !idx.Delete(p) {
+			t.Fatalf("failed to delete %s", p.Id)
+		}
+	}
+	if idx.Delete(points[0]) {
+		t.Fatal("deleted the same point twice")
+	}
+	if idx.Len() != 100 {
+		t.Fatalf("expected 100 points, got %d", idx.Len())
+	}
+	all := idx.Within(orb.Bound{Min: orb.Point{-180, -90}, Max: orb.Point{180, 90}})
+	if !slices.Equal(sorted(ids(all)), sorted(ids(points[400:]))) {
+		t.Fatal("bbox query returned deleted points")
+	}
+}
+
+func TestIndexAntimeridian(t *testing.T) {
+	idx := distance.NewIndex([]distance.Point{
+		{Id: "east", Y: 0, X: 179.9},
+		{Id: "west", Y: 0, X: -179.9},
+		{Id: "far", Y: 0, X: 0},
+	})
+	got := idx.Within(orb.Bound{Min: orb.Point{179, -1}, Max: orb.Point{-179, 1}})
+	if !slices.Equal(sorted(ids(got)), []string{"east", "west"}) {
+		t.Fatalf("unexpected points %v", ids(got))
+	}
+	if n := idx.Nearest(distance.Point{Y: 0, X: 180}, 2); !slices.Equal(sorted(ids(n)), []string{"east", "west"}) {
+		t.Fatalf("unexpected neighbours %v", ids(n))
+	}
+}
+
+func sorted(s []string) []string {
+	slices.Sort(s)
+	return s
+}
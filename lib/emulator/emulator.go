@@ -0,0 +1,210 @@
+// Package emulator serves a synthetic set of access points over the same
+// endpoints as Apple's wloc and tile servers, so crawlers can be tested
+// offline with lib.Options.WithEndpoint.
+package emulator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/distance"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+	"github.com/acheong08/apple-corelocation-experiments/pb"
+
+	"github.com/paulmach/orb"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultLimit is roughly how many neighbours Apple returns for a BSSID
+const DefaultLimit = 400
+
+// Emulator is an http.Handler for /wifi_request_tile and /clls/wloc
+type Emulator struct {
+	tiles map[int64][]lib.AP
+	aps   map[mac.Addr]lib.AP
+	index *distance.Index
+
+	// Limit is the most access points returned for a wloc query, including
+	// the one asked for
+	Limit int
+	// Fail, if set, is called before every request and a non-zero result
+	// is sent as the status code instead of a response
+	Fail func(r *http.Request) int
+
+	lock     sync.Mutex
+	requests map[string]int
+}
+
+// New serves the given access points, which should be in WGS-84
+func New(aps []lib.AP) *Emulator {
+	e := &Emulator{
+		tiles:    make(map[int64][]lib.AP),
+		aps:      make(map[mac.Addr]lib.AP, len(aps)),
+		Limit:    DefaultLimit,
+		requests: make(map[string]int),
+	}
+	points := make([]distance.Point, len(aps))
+	for i, ap := range aps {
+		key := morton.Encode(ap.Location.Lat, ap.Location.Long, 13)
+		e.tiles[key] = append(e.tiles[key], ap)
+		e.aps[ap.BSSID] = ap
+		points[i] = distance.Point{Id: ap.BSSID.String(), X: ap.Location.Long, Y: ap.Location.Lat}
+	}
+	e.index = distance.NewIndex(points)
+	return e
+}
+
+// Random generates n access points uniformly within a bound, with
+// deterministic BSSIDs and positions for a given seed
+func Random(seed uint64, bound orb.Bound, n int) []lib.AP {
+	r := rand.New(rand.NewPCG(seed, seed))
+	aps := make([]lib.AP, n)
+	for i := range aps {
+		aps[i] = lib.AP{
+			// Vendor assigned, so not filtered as randomised
+			BSSID: mac.Addr(0x001a11000000 + uint64(i)),
+			Location: lib.Location{
+				Lat:  bound.Min.Lat() + r.Float64()*(bound.Max.Lat()-bound.Min.Lat()),
+				Long: bound.Min.Lon() + r.Float64()*(bound.Max.Lon()-bound.Min.Lon()),
+			},
+		}
+	}
+	return aps
+}
+
+// Tiles returns the keys of every tile holding an access point
+func (e *Emulator) Tiles() []int64 {
+	keys := make([]int64, 0, len(e.tiles))
+	for key := range e.tiles {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Requests returns how many requests were made to a path
+func (e *Emulator) Requests(path string) int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.requests[path]
+}
+
+func (e *Emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.lock.Lock()
+	e.requests[r.URL.Path]++
+	e.lock.Unlock()
+	if e.Fail != nil {
+		if status := e.Fail(r); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	switch r.URL.Path {
+	case "/wifi_request_tile":
+		e.serveTile(w, r)
+	case "/clls/wloc":
+		e.serveWloc(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (e *Emulator) serveTile(w http.ResponseWriter, r *http.Request) {
+	key, err := strconv.ParseInt(r.Header.Get("X-tilekey"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad tile key", http.StatusBadRequest)
+		return
+	}
+	aps := e.tiles[key]
+	if len(aps) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	region := &pb.WifiTile_Region{}
+	for _, ap := range aps {
+		region.Devices = append(region.Devices, &pb.WifiTile_Device{
+			Bssid: int64(ap.BSSID),
+			Entry: &pb.WifiTile_TileLocation{
+				Lat:  int32(lib.IntFromCoord(ap.Location.Lat, 7)),
+				Long: int32(lib.IntFromCoord(ap.Location.Long, 7)),
+			},
+		})
+	}
+	b, err := proto.Marshal(&pb.WifiTile{Region: []*pb.WifiTile_Region{region}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(b)
+}
+
+// The 8 bytes Apple sends before the length of the protobuf
+var responseHeader = []byte{0, 1, 0, 0, 0, 1, 0, 0}
+
+func (e *Emulator) serveWloc(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var arpc lib.ArpcRequest
+	if err := arpc.Deserialize(body); err != nil {
+		http.Error(w, "invalid arpc", http.StatusBadRequest)
+		return
+	}
+	var req pb.AppleWLoc
+	if err := proto.Unmarshal(arpc.Payload, &req); err != nil {
+		http.Error(w, "invalid protobuf", http.StatusBadRequest)
+		return
+	}
+	var resp pb.AppleWLoc
+	for _, d := range req.GetWifiDevices() {
+		bssid, err := mac.ParseAddr(d.GetBssid())
+		ap, ok := e.aps[bssid]
+		if err != nil || !ok {
+			// Unknown access points come back at -180, -180
+			resp.WifiDevices = append(resp.WifiDevices, device(d.GetBssid(), -180, -180))
+			continue
+		}
+		resp.WifiDevices = append(resp.WifiDevices, device(ap.BSSID.AppleString(), ap.Location.Lat, ap.Location.Long))
+		if req.GetNumWifiResults() != 0 {
+			continue
+		}
+		centre := distance.Point{X: ap.Location.Long, Y: ap.Location.Lat}
+		for _, p := range e.index.Nearest(centre, e.Limit) {
+			if p.Id == ap.BSSID.String() {
+				continue
+			}
+			n := e.aps[mac.MustParseAddr(p.Id)]
+			resp.WifiDevices = append(resp.WifiDevices, device(n.BSSID.AppleString(), n.Location.Lat, n.Location.Long))
+		}
+	}
+	b, err := proto.Marshal(&resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(b) > 0xffff {
+		http.Error(w, fmt.Sprintf("response of %d bytes is too long", len(b)), http.StatusInternalServerError)
+		return
+	}
+	out := binary.BigEndian.AppendUint16(append([]byte{}, responseHeader...), uint16(len(b)))
+	w.Write(append(out, b...))
+}
+
+func device(bssid string, lat, lon float64) *pb.WifiDevice {
+	latInt, lonInt := lib.IntFromCoord(lat, 8), lib.IntFromCoord(lon, 8)
+	return &pb.WifiDevice{
+		Bssid: bssid,
+		Location: &pb.Location{
+			Latitude:  &latInt,
+			Longitude: &lonInt,
+		},
+	}
+}
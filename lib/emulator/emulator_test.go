@@ -0,0 +1,134 @@
+package emulator_test
+
+import (
+	"cmp"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/distance"
+	"github.com/acheong08/apple-corelocation-experiments/lib/emulator"
+
+	"github.com/paulmach/orb"
+)
+
+func TestClient(t *testing.T) {
+	aps := emulator.Random(1, orb.Bound{Min: orb.Point{-3.2, 51.47}, Max: orb.Point{-3.15, 51.5}}, 500)
+	e := emulator.New(aps)
+	e.Limit = 50
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	endpoint := lib.Options.WithEndpoint(srv.URL)
+
+	found, err := lib.QueryBssid([]string{aps[0].BSSID.String()}, 0, endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 50 || found[0].BSSID != aps[0].BSSID {
+		t.Fatalf("got %d access points", len(found))
+	}
+	if math.Abs(found[0].Location.Lat-aps[0].Location.Lat) > 1e-7 {
+		t.Errorf("position %v, want %v", found[0].Location, aps[0].Location)
+	}
+	before := e.Requests("/clls/wloc")
+	if found, err := lib.QueryBssid([]string{"00:00:00:00:00:01"}, 1, endpoint); err != nil || len(found) != 0 {
+		t.Errorf("unknown BSSID returned %v: %v", found, err)
+	}
+	if n := e.Requests("/clls/wloc") - before; n != 1 {
+		t.Errorf("unknown BSSID took %d requests by default, want 1", n)
+	}
+	// Automatic region selection tries China when nothing is found
+	before = e.Requests("/clls/wloc")
+	if _, err := lib.QueryBssid([]string{"00:00:00:00:00:01"}, 1, endpoint, lib.Options.WithRegion(lib.Options.Auto)); err != nil {
+		t.Fatal(err)
+	}
+	if n := e.Requests("/clls/wloc") - before; n != 2 {
+		t.Errorf("unknown BSSID took %d requests with Auto, want 2", n)
+	}
+
+	total := 0
+	for _, key := range e.Tiles() {
+		tile, err := lib.GetTile(key, endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += len(tile)
+	}
+	if total != len(aps) {
+		t.Errorf("tiles hold %d access points, want %d", total, len(aps))
+	}
+	var status *lib.StatusError
+	if _, err := lib.GetTile(0, endpoint); !errors.As(err, &status) || status.Code != 404 {
+		t.Errorf("empty tile returned %v", err)
+	}
+}
+
+func TestNearest(t *testing.T) {
+	bound := orb.Bound{Min: orb.Point{-3.2, 51.47}, Max: orb.Point{-3.15, 51.5}}
+	aps := emulator.Random(2, bound, 300)
+	e := emulator.New(aps)
+	e.Limit = 20
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	endpoint := lib.Options.WithQueryOptions(lib.Options.WithEndpoint(srv.URL))
+	lat, long := 51.485, -3.175
+	target := distance.Point{Y: lat, X: long}
+
+	// Every access point by distance, to check against
+	byDistance := slices.Clone(aps)
+	slices.SortFunc(byDistance, func(a, b lib.AP) int {
+		return cmp.Compare(
+			distance.Geodesic(target, distance.Point{Y: a.Location.Lat, X: a.Location.Long}),
+			distance.Geodesic(target, distance.Point{Y: b.Location.Lat, X: b.Location.Long}))
+	})
+	sorted := func(ns []lib.Neighbour) bool {
+		return slices.IsSortedFunc(ns, func(a, b lib.Neighbour) int { return cmp.Compare(a.Distance, b.Distance) })
+	}
+
+	found, err := lib.Nearest(lat, long, lib.Options.WithK(5), lib.Options.WithRequestBudget(50), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 5 || !sorted(found) {
+		t.Fatalf("got %d neighbours, sorted %v", len(found), sorted(found))
+	}
+	for i, n := range found {
+		if n.BSSID != byDistance[i].BSSID {
+			t.Errorf("neighbour %d is %s at %.0fm, want %s", i, n.BSSID, n.Distance, byDistance[i].BSSID)
+		}
+	}
+
+	found, err = lib.Nearest(lat, long, lib.Options.WithK(0), lib.Options.WithRadius(300), lib.Options.WithRequestBudget(50), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) == 0 || !sorted(found) || found[len(found)-1].Distance > 300 {
+		t.Errorf("radius search returned %d neighbours", len(found))
+	}
+
+	before := e.Requests("/wifi_request_tile") + e.Requests("/clls/wloc")
+	if _, err := lib.Nearest(lat, long, lib.Options.WithK(5), lib.Options.WithRequestBudget(3), endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if n := e.Requests("/wifi_request_tile") + e.Requests("/clls/wloc") - before; n > 3 {
+		t.Errorf("made %d requests with a budget of 3", n)
+	}
+
+	// A failed query is skipped instead of losing what was already found
+	failed := false
+	e.Fail = func(r *http.Request) int {
+		if r.URL.Path == "/clls/wloc" && !failed {
+			failed = true
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+	found, err = lib.Nearest(lat, long, lib.Options.WithK(5), lib.Options.WithRequestBudget(50), endpoint)
+	if err != nil || !failed || len(found) != 5 {
+		t.Errorf("got %d neighbours after a failure: %v", len(found), err)
+	}
+}
@@ -0,0 +1,138 @@
+// Package export writes located access points and cell towers in formats
+// other tools understand: GeoJSON FeatureCollections, KML, CSV and GPX
+// waypoints. Coordinates are always WGS-84, which all of these formats
+// require, so China endpoint results are converted.
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+)
+
+type Format string
+
+const (
+	GeoJSON Format = "geojson"
+	KML     Format = "kml"
+	CSV     Format = "csv"
+	GPX     Format = "gpx"
+)
+
+// Formats lists every supported format
+var Formats = []Format{GeoJSON, KML, CSV, GPX}
+
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if strings.EqualFold(s, string(f)) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown export format %q, expected one of %v", s, Formats)
+}
+
+// Record is one located item and its metadata
+type Record struct {
+	Name          string
+	Lat, Lon, Alt float64
+	// Properties are kept in order so CSV columns are stable
+	Properties []Property
+}
+
+type Property struct {
+	Key   string
+	Value any
+}
+
+// FromAPs converts access points, including their vendor when it was looked
+// up
+func FromAPs(aps []lib.AP) []Record {
+	records := make([]Record, len(aps))
+	for i, ap := range aps {
+		loc := ap.Location.ToWGS84()
+		props := []Property{
+			{"type", "wifi"},
+			{"bssid", ap.BSSID.String()},
+			{"source_datum", ap.Location.Datum.String()},
+		}
+		if ap.Vendor != nil {
+			props = append(props,
+				Property{"vendor", ap.Vendor.Name},
+				Property{"vendor_prefix", fmt.Sprintf("%s/%d", ap.Vendor.Prefix, ap.Vendor.Bits)},
+				Property{"mobile_vendor", ap.Vendor.Mobile},
+			)
+		}
+		records[i] = Record{
+			Name:       ap.BSSID.String(),
+			Lat:        loc.Lat,
+			Lon:        loc.Long,
+			Alt:        loc.Alt,
+			Properties: props,
+		}
+	}
+	return records
+}
+
+// FromCells converts cell towers, named MCC-MNC-TAC-CellID
+func FromCells(cells []lib.Cell) []Record {
+	records := make([]Record, len(cells))
+	for i, c := range cells {
+		loc := c.Location.ToWGS84()
+		records[i] = Record{
+			Name: fmt.Sprintf("%d-%d-%d-%d", c.Tower.Mcc, c.Tower.Mnc, c.Tower.TacId, c.Tower.CellId),
+			Lat:  loc.Lat,
+			Lon:  loc.Long,
+			Alt:  loc.Alt,
+			Properties: []Property{
+				{"type", "cell"},
+				{"mcc", c.Tower.Mcc},
+				{"mnc", c.Tower.Mnc},
+				{"tac", c.Tower.TacId},
+				{"cell_id", c.Tower.CellId},
+				{"source_datum", c.Location.Datum.String()},
+			},
+		}
+	}
+	return records
+}
+
+// Write encodes the records in the given format
+func Write(w io.Writer, format Format, records []Record) error {
+	switch format {
+	case GeoJSON:
+		return writeGeoJSON(w, records)
+	case KML:
+		return writeKML(w, records)
+	case CSV:
+		return writeCSV(w, records)
+	case GPX:
+		return writeGPX(w, records)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// columns returns every property key in the order first seen
+func columns(records []Record) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, r := range records {
+		for _, p := range r.Properties {
+			if !seen[p.Key] {
+				seen[p.Key] = true
+				keys = append(keys, p.Key)
+			}
+		}
+	}
+	return keys
+}
+
+func (r Record) property(key string) (any, bool) {
+	for _, p := range r.Properties {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
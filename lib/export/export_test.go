@@ -0,0 +1,120 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/export"
+	"github.com/acheong08/apple-corelocation-experiments/lib/geo/datum"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/oui"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func records() []export.Record {
+	aps := []lib.AP{
+		{BSSID: mac.MustParseAddr("98:8f:00:54:4a:09"), Location: lib.Location{Lat: 51.5, Long: -3.2}},
+		{
+			BSSID:    mac.MustParseAddr("00:03:93:01:02:03"),
+			Location: lib.Location{Lat: 39.9, Long: 116.4, Datum: datum.GCJ02},
+			Vendor:   &oui.Vendor{Name: "Apple, Inc.", Prefix: 0x000393000000, Bits: oui.MAL, Mobile: true},
+		},
+	}
+	cells := []lib.Cell{{
+		Tower:    lib.TowerInfo{Mcc: 234, Mnc: 10, CellId: 1234, TacId: 56},
+		Location: lib.Location{Lat: 51.4, Long: -3.1, Alt: 12},
+	}}
+	return append(export.FromAPs(aps), export.FromCells(cells)...)
+}
+
+func TestGeoJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := export.Write(&buf, export.GeoJSON, records()); err != nil {
+		t.Fatal(err)
+	}
+	fc, err := geojson.UnmarshalFeatureCollection(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fc.Features) != 3 {
+		t.Fatalf("got %d features", len(fc.Features))
+	}
+	if p := fc.Features[0].Geometry.(orb.Point); p.Lon() != -3.2 || p.Lat() != 51.5 {
+		t.Errorf("unexpected point %v", p)
+	}
+	// China results are converted, moving them by a few hundred metres
+	if p := fc.Features[1].Geometry.(orb.Point); math.Abs(p.Lat()-39.9) < 1e-4 || math.Abs(p.Lat()-39.9) > 0.01 {
+		t.Errorf("GCJ-02 point not converted: %v", p)
+	}
+	props := fc.Features[1].Properties
+	if props["vendor"] != "Apple, Inc." || props["mobile_vendor"] != true || props["source_datum"] != "GCJ-02" {
+		t.Errorf("unexpected properties %v", props)
+	}
+	if fc.Features[2].Properties["mcc"] != 234.0 || fc.Features[2].Properties["name"] != "234-10-56-1234" {
+		t.Errorf("unexpected cell properties %v", fc.Features[2].Properties)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := export.Write(&buf, export.CSV, records()); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "name,lat,lon,alt,type,bssid,source_datum,vendor,vendor_prefix,mobile_vendor,mcc,mnc,tac,cell_id"
+	if got := strings.Join(rows[0], ","); got != want {
+		t.Fatalf("header %q, want %q", got, want)
+	}
+	if len(rows) != 4 || rows[1][0] != "98:8f:00:54:4a:09" || rows[1][7] != "" || rows[3][3] != "12" || rows[3][10] != "234" {
+		t.Errorf("unexpected rows %q", rows)
+	}
+}
+
+func TestXML(t *testing.T) {
+	for _, format := range []export.Format{export.KML, export.GPX} {
+		var buf bytes.Buffer
+		if err := export.Write(&buf, format, records()); err != nil {
+			t.Fatal(err)
+		}
+		// Every placemark or waypoint must parse back with its name
+		var doc struct {
+			Placemarks []string `xml:"Document>Placemark>name"`
+			Waypoints  []string `xml:"wpt>name"`
+		}
+		if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if n := len(doc.Placemarks) + len(doc.Waypoints); n != 3 {
+			t.Errorf("%s: parsed %d named points", format, n)
+		}
+	}
+	var buf bytes.Buffer
+	export.Write(&buf, export.KML, records())
+	if !strings.Contains(buf.String(), `<Data name="vendor">`) || !strings.Contains(buf.String(), "<coordinates>-3.1,51.4,12</coordinates>") {
+		t.Errorf("unexpected KML:\n%s", buf.String())
+	}
+	buf.Reset()
+	export.Write(&buf, export.GPX, records())
+	if !strings.Contains(buf.String(), `<wpt lat="51.4" lon="-3.1">`) || !strings.Contains(buf.String(), "<type>cell</type>") {
+		t.Errorf("unexpected GPX:\n%s", buf.String())
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if f, err := export.ParseFormat("GeoJSON"); err != nil || f != export.GeoJSON {
+		t.Errorf("ParseFormat(GeoJSON) = %q, %v", f, err)
+	}
+	if _, err := export.ParseFormat("shp"); err == nil {
+		t.Error("accepted unknown format")
+	}
+}
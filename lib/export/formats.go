@@ -0,0 +1,149 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+func writeGeoJSON(w io.Writer, records []Record) error {
+	fc := geojson.NewFeatureCollection()
+	for _, r := range records {
+		f := geojson.NewFeature(orb.Point{r.Lon, r.Lat})
+		f.Properties["name"] = r.Name
+		if r.Alt != 0 {
+			f.Properties["altitude"] = r.Alt
+		}
+		for _, p := range r.Properties {
+			f.Properties[p.Key] = p.Value
+		}
+		fc.Append(f)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(fc)
+}
+
+func writeCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+	keys := columns(records)
+	if err := cw.Write(append([]string{"name", "lat", "lon", "alt"}, keys...)); err != nil {
+		return err
+	}
+	for _, r := range records {
+		row := []string{r.Name, formatFloat(r.Lat), formatFloat(r.Lon), formatFloat(r.Alt)}
+		for _, k := range keys {
+			v, ok := r.property(k)
+			if !ok {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, fmt.Sprint(v))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPlacemark struct {
+	Name         string    `xml:"name"`
+	ExtendedData []kmlData `xml:"ExtendedData>Data"`
+	Coordinates  string    `xml:"Point>coordinates"`
+}
+
+type kmlDocument struct {
+	XMLName    xml.Name       `xml:"kml"`
+	Namespace  string         `xml:"xmlns,attr"`
+	Placemarks []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+func writeKML(w io.Writer, records []Record) error {
+	doc := kmlDocument{Namespace: "http://www.opengis.net/kml/2.2"}
+	for _, r := range records {
+		pm := kmlPlacemark{
+			Name:        r.Name,
+			Coordinates: formatFloat(r.Lon) + "," + formatFloat(r.Lat) + "," + formatFloat(r.Alt),
+		}
+		for _, p := range r.Properties {
+			pm.ExtendedData = append(pm.ExtendedData, kmlData{Name: p.Key, Value: fmt.Sprint(p.Value)})
+		}
+		doc.Placemarks = append(doc.Placemarks, pm)
+	}
+	return writeXML(w, doc)
+}
+
+type gpxWaypoint struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  *float64 `xml:"ele,omitempty"`
+	Name string   `xml:"name"`
+	// GPX has no free-form metadata, so properties go in the description
+	Desc string `xml:"desc,omitempty"`
+	Type string `xml:"type,omitempty"`
+}
+
+type gpxDocument struct {
+	XMLName   xml.Name      `xml:"gpx"`
+	Namespace string        `xml:"xmlns,attr"`
+	Version   string        `xml:"version,attr"`
+	Creator   string        `xml:"creator,attr"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+}
+
+func writeGPX(w io.Writer, records []Record) error {
+	doc := gpxDocument{
+		Namespace: "http://www.topografix.com/GPX/1/1",
+		Version:   "1.1",
+		Creator:   "apple-corelocation-experiments",
+	}
+	for _, r := range records {
+		wpt := gpxWaypoint{Lat: r.Lat, Lon: r.Lon, Name: r.Name}
+		if r.Alt != 0 {
+			alt := r.Alt
+			wpt.Ele = &alt
+		}
+		desc := make([]string, 0, len(r.Properties))
+		for _, p := range r.Properties {
+			if p.Key == "type" {
+				wpt.Type = fmt.Sprint(p.Value)
+				continue
+			}
+			desc = append(desc, fmt.Sprintf("%s=%v", p.Key, p.Value))
+		}
+		wpt.Desc = strings.Join(desc, "; ")
+		doc.Waypoints = append(doc.Waypoints, wpt)
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", " ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,146 @@
+package framing
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// encoding describes how a field is stored
+func (f Field) encoding() string {
+	order := "little endian"
+	if f.BigEndian {
+		order = "big endian"
+	}
+	switch f.Kind {
+	case String, Length:
+		return fmt.Sprintf("uint%d %s length", f.Width*8, order)
+	case Int:
+		return fmt.Sprintf("uint%d %s", f.Width*8, order)
+	case Payload:
+		return "rest of message"
+	}
+	return fmt.Sprintf("%d bytes", f.Width)
+}
+
+func (f Field) example() string {
+	const max = 32
+	b := f.Example
+	if f.Kind == String {
+		return fmt.Sprintf("%q", b)
+	}
+	more := ""
+	if len(b) > max {
+		b, more = b[:max], fmt.Sprintf("... (%d bytes)", len(f.Example))
+	}
+	return fmt.Sprintf("%x%s", b, more)
+}
+
+// name is the Go name of field i in a generated parser
+func (f Field) name(i int) string {
+	if f.Kind == Payload {
+		return "Payload"
+	}
+	return fmt.Sprintf("%s%d", strings.ToUpper(f.Kind.String()[:1])+f.Kind.String()[1:], i+1)
+}
+
+// String describes the layout as a table. Offsets are only known up to the
+// first variable length field.
+func (l *Layout) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-6s  %-3s  %-7s  %-26s  %s\n", "offset", "#", "kind", "encoding", "example")
+	offset := 0
+	for i, f := range l.Fields {
+		at := "?"
+		if offset >= 0 {
+			at = fmt.Sprint(offset)
+		}
+		fmt.Fprintf(&b, "%-6s  %-3d  %-7s  %-26s  %s\n", at, i+1, f.Kind, f.encoding(), f.example())
+		switch {
+		case offset < 0:
+		case f.Kind == String || f.Kind == Payload:
+			offset = -1
+		default:
+			offset += f.Width
+		}
+	}
+	return b.String()
+}
+
+// GoSource generates a Go file in package pkg with a struct called name and
+// a Parse function for it
+func (l *Layout) GoSource(pkg, name string) ([]byte, error) {
+	var body bytes.Buffer
+	var usesBinary, usesN bool
+	for i, f := range l.Fields {
+		field := f.name(i)
+		fmt.Fprintf(&body, "\n\t// %d: %s, %s\n", i+1, f.Kind, f.encoding())
+		switch f.Kind {
+		case Const:
+			fmt.Fprintf(&body, "if len(b) < %d || string(b[:%d]) != %q {\n", f.Width, f.Width, f.Value)
+			fmt.Fprintf(&body, "return nil, fmt.Errorf(\"field %d: expected %x\")\n}\n", i+1, f.Value)
+			fmt.Fprintf(&body, "b = b[%d:]\n", f.Width)
+		case Int, Bytes:
+			fmt.Fprintf(&body, "if len(b) < %d {\nreturn nil, fmt.Errorf(\"field %d: truncated\")\n}\n", f.Width, i+1)
+			if f.Kind == Int {
+				usesBinary = true
+				fmt.Fprintf(&body, "m.%s = %s\n", field, readUint(f, "b"))
+			} else {
+				fmt.Fprintf(&body, "m.%s = b[:%d]\n", field, f.Width)
+			}
+			fmt.Fprintf(&body, "b = b[%d:]\n", f.Width)
+		case String, Length:
+			usesBinary, usesN = true, true
+			fmt.Fprintf(&body, "if len(b) < %d {\nreturn nil, fmt.Errorf(\"field %d: truncated\")\n}\n", f.Width, i+1)
+			fmt.Fprintf(&body, "n = int(%s)\nb = b[%d:]\n", readUint(f, "b"), f.Width)
+			if f.Kind == String {
+				fmt.Fprintf(&body, "if len(b) < n {\nreturn nil, fmt.Errorf(\"field %d: length %%d exceeds the %%d bytes left\", n, len(b))\n}\n", i+1)
+				fmt.Fprintf(&body, "m.%s = string(b[:n])\nb = b[n:]\n", field)
+			} else {
+				fmt.Fprintf(&body, "if n != len(b) {\nreturn nil, fmt.Errorf(\"field %d: length %%d does not match the %%d bytes after it\", n, len(b))\n}\n", i+1)
+			}
+		case Payload:
+			fmt.Fprintf(&body, "m.Payload = b\nb = nil\n")
+		}
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by lib/framing. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	if usesBinary {
+		src.WriteString("\"encoding/binary\"\n")
+	}
+	src.WriteString("\"fmt\"\n)\n\n")
+	fmt.Fprintf(&src, "// %s is a message framed as:\n//\n", name)
+	for _, line := range strings.Split(strings.TrimSuffix(l.String(), "\n"), "\n") {
+		fmt.Fprintf(&src, "//\t%s\n", line)
+	}
+	fmt.Fprintf(&src, "type %s struct {\n", name)
+	for i, f := range l.Fields {
+		switch f.Kind {
+		case String:
+			fmt.Fprintf(&src, "%s string\n", f.name(i))
+		case Int:
+			fmt.Fprintf(&src, "%s uint%d\n", f.name(i), f.Width*8)
+		case Bytes, Payload:
+			fmt.Fprintf(&src, "%s []byte\n", f.name(i))
+		}
+	}
+	src.WriteString("}\n\n")
+	fmt.Fprintf(&src, "// Parse%s splits b into its fields. Byte slices share b's memory.\n", name)
+	fmt.Fprintf(&src, "func Parse%s(b []byte) (*%s, error) {\nvar m %s\n", name, name, name)
+	if usesN {
+		src.WriteString("var n int\n")
+	}
+	src.Write(body.Bytes())
+	src.WriteString("if len(b) > 0 {\nreturn nil, fmt.Errorf(\"%d bytes left over\", len(b))\n}\nreturn &m, nil\n}\n")
+	return format.Source(src.Bytes())
+}
+
+func readUint(f Field, b string) string {
+	order := "LittleEndian"
+	if f.BigEndian {
+		order = "BigEndian"
+	}
+	return fmt.Sprintf("binary.%s.Uint%d(%s)", order, f.Width*8, b)
+}
@@ -0,0 +1,105 @@
+package framing_test
+
+import (
+	"bytes"
+	"errors"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/framing"
+)
+
+func arpcSamples(t *testing.T) [][]byte {
+	t.Helper()
+	requests := []lib.ArpcRequest{
+		{Version: "1", Locale: "en-001_001", AppIdentifier: "com.apple.locationd", OsVersion: "17.5.21F79", FunctionId: 1, Payload: []byte{0x12, 0x13, 0x0a, 0x11}},
+		{Version: "1", Locale: "en_US", AppIdentifier: "com.apple.geod", OsVersion: "14.5.23F79", FunctionId: 1, Payload: bytes.Repeat([]byte{0x18}, 300)},
+		{Version: "1", Locale: "de_DE", AppIdentifier: "com.apple.locationd", OsVersion: "18.0", FunctionId: 1, Payload: nil},
+	}
+	var samples [][]byte
+	for _, r := range requests {
+		b, err := r.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, b)
+	}
+	return samples
+}
+
+func TestInfer(t *testing.T) {
+	samples := arpcSamples(t)
+	l, err := framing.Infer(samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []framing.Field{
+		{Kind: framing.Const, Width: 2},
+		{Kind: framing.String, Width: 2, BigEndian: true},
+		{Kind: framing.String, Width: 2, BigEndian: true},
+		{Kind: framing.String, Width: 2, BigEndian: true},
+		{Kind: framing.Const, Width: 4},
+		{Kind: framing.Length, Width: 4, BigEndian: true},
+		{Kind: framing.Payload},
+	}
+	if len(l.Fields) != len(want) {
+		t.Fatalf("got layout\n%s", l)
+	}
+	for i, f := range l.Fields {
+		if f.Kind != want[i].Kind || f.Width != want[i].Width || (f.Kind == framing.String || f.Kind == framing.Length) && !f.BigEndian {
+			t.Errorf("field %d is %s of width %d, want %s of width %d", i, f.Kind, f.Width, want[i].Kind, want[i].Width)
+		}
+	}
+	if !bytes.Equal(l.Fields[0].Value, []byte{0, 1}) {
+		t.Errorf("version is %x, want 0001", l.Fields[0].Value)
+	}
+	if !strings.Contains(l.String(), `"com.apple.locationd"`) {
+		t.Errorf("description lacks an example string:\n%s", l)
+	}
+
+	if err := l.Validate(samples); err != nil {
+		t.Fatal(err)
+	}
+	values, err := l.Parse(samples[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(values[2]) != "com.apple.geod" || len(values[6]) != 300 {
+		t.Errorf("parsed %q", values)
+	}
+
+	corrupt := bytes.Clone(samples[2])
+	corrupt[len(corrupt)-1]++
+	var sampleErr *framing.SampleError
+	if err := l.Validate(append(samples, corrupt)); !errors.As(err, &sampleErr) || sampleErr.Sample != 3 {
+		t.Errorf("corrupt sample gave %v", err)
+	}
+}
+
+func TestInferTooFewSamples(t *testing.T) {
+	if _, err := framing.Infer(arpcSamples(t)[:1]); err == nil {
+		t.Error("one sample was accepted")
+	}
+}
+
+func TestGoSource(t *testing.T) {
+	l, err := framing.Infer(arpcSamples(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := l.GoSource("arpc", "Request")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "request.go", src, 0); err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	for _, s := range []string{"func ParseRequest(b []byte) (*Request, error)", "String2 string", "Payload []byte"} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Errorf("generated source lacks %q:\n%s", s, src)
+		}
+	}
+}
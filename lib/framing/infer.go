@@ -0,0 +1,305 @@
+// Package framing infers the binary framing of captured messages, such as the
+// ARPC header in front of Apple's protobuf payloads. Given several samples of
+// the same endpoint it finds the constant bytes, length-prefixed (Pascal)
+// strings, integers and the length field that delimits the payload, then
+// describes the layout, checks it against every sample and generates a Go
+// parser for it.
+package framing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+type Kind int
+
+const (
+	// Const bytes are the same in every sample
+	Const Kind = iota
+	// String is text prefixed by its length
+	String
+	// Int is an integer that differs between samples
+	Int
+	// Bytes differ between samples and have no recognised structure
+	Bytes
+	// Length counts the bytes of the Payload that follows it
+	Length
+	// Payload is the rest of the message
+	Payload
+)
+
+func (k Kind) String() string {
+	return [...]string{"const", "string", "int", "bytes", "length", "payload"}[k]
+}
+
+// Field is one part of a layout
+type Field struct {
+	Kind Kind
+	// Width is the size in bytes of a Const, Int or Bytes field, or of the
+	// length prefix of a String or Length
+	Width int
+	// BigEndian is the byte order of length prefixes. Integers cannot be
+	// told apart and are assumed to be big endian like the rest of Apple's
+	// framing.
+	BigEndian bool
+	// Value is the content of a Const
+	Value []byte
+	// Example is a value from the first sample, for descriptions
+	Example []byte
+}
+
+// Layout is the inferred framing of a message
+type Layout struct {
+	Fields []Field
+}
+
+// maxString bounds the strings Infer will accept, so binary data is not
+// mistaken for them
+const maxString = 1024
+
+// prefix is a candidate length encoding
+type prefix struct {
+	width     int
+	bigEndian bool
+}
+
+// prefixes are tried in order. Two bytes are preferred since Apple's strings
+// use them, and big endian since Apple's protocols use network order.
+var prefixes = []prefix{{2, true}, {2, false}, {4, true}, {4, false}}
+
+func (p prefix) read(b []byte) (int, bool) {
+	if len(b) < p.width {
+		return 0, false
+	}
+	switch {
+	case p.width == 2 && p.bigEndian:
+		return int(binary.BigEndian.Uint16(b)), true
+	case p.width == 2:
+		return int(binary.LittleEndian.Uint16(b)), true
+	case p.bigEndian:
+		return int(binary.BigEndian.Uint32(b)), true
+	default:
+		return int(binary.LittleEndian.Uint32(b)), true
+	}
+}
+
+// Infer finds the layout shared by samples. More samples give fewer
+// coincidences, and at least two are needed to tell constants from values.
+func Infer(samples [][]byte) (*Layout, error) {
+	if len(samples) < 2 {
+		return nil, errors.New("at least two samples are needed")
+	}
+	pos := make([]int, len(samples))
+	var l Layout
+	// run collects constant or varying bytes until something structured
+	var run []int
+	runKind := Const
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		f := Field{Kind: runKind, Width: len(run), BigEndian: true}
+		if runKind == Const {
+			f.Value = samples[0][run[0] : run[0]+len(run)]
+		} else if f.Width == 2 || f.Width == 4 || f.Width == 8 {
+			f.Kind = Int
+		}
+		f.Example = samples[0][run[0] : run[0]+len(run)]
+		l.Fields = append(l.Fields, f)
+		run = nil
+	}
+	for {
+		if done(samples, pos) {
+			flush()
+			return &l, nil
+		}
+		if p, ok := payloadLength(samples, pos); ok {
+			flush()
+			l.Fields = append(l.Fields,
+				Field{Kind: Length, Width: p.width, BigEndian: p.bigEndian, Example: samples[0][pos[0] : pos[0]+p.width]},
+				Field{Kind: Payload, Example: samples[0][pos[0]+p.width:]},
+			)
+			return &l, nil
+		}
+		if p, ok := pascalString(samples, pos); ok {
+			flush()
+			n, _ := p.read(samples[0][pos[0]:])
+			l.Fields = append(l.Fields, Field{Kind: String, Width: p.width, BigEndian: p.bigEndian,
+				Example: samples[0][pos[0]+p.width : pos[0]+p.width+n]})
+			for i, s := range samples {
+				n, _ := p.read(s[pos[i]:])
+				pos[i] += p.width + n
+			}
+			continue
+		}
+		if ended(samples, pos) {
+			// Some samples end here and others do not
+			flush()
+			l.Fields = append(l.Fields, Field{Kind: Payload, Example: samples[0][pos[0]:]})
+			return &l, nil
+		}
+		kind := Bytes
+		if common(samples, pos) {
+			kind = Const
+		}
+		if kind != runKind {
+			flush()
+			runKind = kind
+		}
+		run = append(run, pos[0])
+		for i := range pos {
+			pos[i]++
+		}
+	}
+}
+
+func done(samples [][]byte, pos []int) bool {
+	for i, s := range samples {
+		if pos[i] < len(s) {
+			return false
+		}
+	}
+	return true
+}
+
+func ended(samples [][]byte, pos []int) bool {
+	for i, s := range samples {
+		if pos[i] >= len(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// common reports whether every sample has the same byte at its position
+func common(samples [][]byte, pos []int) bool {
+	for i, s := range samples {
+		if s[pos[i]] != samples[0][pos[0]] {
+			return false
+		}
+	}
+	return true
+}
+
+// payloadLength finds a prefix counting every remaining byte of each sample
+func payloadLength(samples [][]byte, pos []int) (prefix, bool) {
+next:
+	for _, p := range prefixes {
+		for i, s := range samples {
+			n, ok := p.read(s[pos[i]:])
+			if !ok || n != len(s)-pos[i]-p.width {
+				continue next
+			}
+		}
+		return p, true
+	}
+	return prefix{}, false
+}
+
+// pascalString finds a prefix followed by that much text in every sample,
+// with at least one sample not empty
+func pascalString(samples [][]byte, pos []int) (prefix, bool) {
+next:
+	for _, p := range prefixes {
+		empty := true
+		for i, s := range samples {
+			n, ok := p.read(s[pos[i]:])
+			start := pos[i] + p.width
+			if !ok || n > maxString || start+n > len(s) || !isText(s[start:start+n]) {
+				continue next
+			}
+			empty = empty && n == 0
+		}
+		if !empty {
+			return p, true
+		}
+	}
+	return prefix{}, false
+}
+
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if r < ' ' || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate parses every sample with the layout, reporting the first that
+// does not fit
+func (l *Layout) Validate(samples [][]byte) error {
+	for i, s := range samples {
+		if _, err := l.Parse(s); err != nil {
+			return &SampleError{Sample: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// SampleError is a sample that does not fit a layout
+type SampleError struct {
+	Sample int
+	Err    error
+}
+
+func (e *SampleError) Error() string {
+	return fmt.Sprintf("sample %d: %v", e.Sample, e.Err)
+}
+
+func (e *SampleError) Unwrap() error {
+	return e.Err
+}
+
+// Parse splits b into the value of each field. Strings are returned without
+// their length prefix.
+func (l *Layout) Parse(b []byte) ([][]byte, error) {
+	values := make([][]byte, 0, len(l.Fields))
+	pos := 0
+	for i, f := range l.Fields {
+		var size int
+		switch f.Kind {
+		case Const, Int, Bytes:
+			size = f.Width
+		case String, Length:
+			n, ok := prefix{f.Width, f.BigEndian}.read(b[pos:])
+			if !ok {
+				return nil, fieldError(i, f, pos, "truncated length")
+			}
+			size = f.Width
+			if f.Kind == String {
+				size += n
+			} else if n != len(b)-pos-f.Width {
+				return nil, fieldError(i, f, pos, fmt.Sprintf("length %d does not match the %d bytes after it", n, len(b)-pos-f.Width))
+			}
+		case Payload:
+			size = len(b) - pos
+		}
+		if pos+size > len(b) {
+			return nil, fieldError(i, f, pos, "truncated")
+		}
+		v := b[pos : pos+size]
+		if f.Kind == Const && !bytes.Equal(v, f.Value) {
+			return nil, fieldError(i, f, pos, "unexpected constant")
+		}
+		if f.Kind == String {
+			v = v[f.Width:]
+		}
+		values = append(values, v)
+		pos += size
+	}
+	if pos != len(b) {
+		return nil, fmt.Errorf("%d bytes left over", len(b)-pos)
+	}
+	return values, nil
+}
+
+func fieldError(i int, f Field, pos int, msg string) error {
+	return fmt.Errorf("field %d (%s) at offset %d: %s", i, f.Kind, pos, msg)
+}
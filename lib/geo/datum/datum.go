@@ -0,0 +1,159 @@
+// Package datum converts between WGS-84 and the obfuscated datums required
+// for maps in mainland China: GCJ-02, used by Apple and AutoNavi, and BD-09,
+// used by Baidu.
+package datum
+
+import (
+	"fmt"
+	"math"
+)
+
+type Datum uint8
+
+const (
+	WGS84 Datum = iota
+	GCJ02
+	BD09
+)
+
+func (d Datum) String() string {
+	switch d {
+	case WGS84:
+		return "WGS-84"
+	case GCJ02:
+		return "GCJ-02"
+	case BD09:
+		return "BD-09"
+	}
+	return fmt.Sprintf("Datum(%d)", d)
+}
+
+func (d Datum) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Datum) UnmarshalText(text []byte) error {
+	for _, candidate := range []Datum{WGS84, GCJ02, BD09} {
+		if candidate.String() == string(text) {
+			*d = candidate
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown datum %q", text)
+}
+
+// Krasovsky 1940 ellipsoid, as used by GCJ-02
+const (
+	krasovskyA  = 6378245.0
+	krasovskyEE = 0.00669342162296594323
+	baiduXPi    = math.Pi * 3000.0 / 180.0
+)
+
+// OutOfChina is the rough bounding box outside which GCJ-02 applies no offset
+func OutOfChina(lat, lon float64) bool {
+	return lon < 72.004 || lon > 137.8347 || lat < 0.8293 || lat > 55.8271
+}
+
+func transformLat(x, y float64) float64 {
+	ret := -100.0 + 2.0*x + 3.0*y + 0.2*y*y + 0.1*x*y + 0.2*math.Sqrt(math.Abs(x))
+	ret += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(y*math.Pi) + 40.0*math.Sin(y/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (160.0*math.Sin(y/12.0*math.Pi) + 320*math.Sin(y*math.Pi/30.0)) * 2.0 / 3.0
+	return ret
+}
+
+func transformLon(x, y float64) float64 {
+	ret := 300.0 + x + 2.0*y + 0.1*x*x + 0.1*x*y + 0.1*math.Sqrt(math.Abs(x))
+	ret += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(x*math.Pi) + 40.0*math.Sin(x/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (150.0*math.Sin(x/12.0*math.Pi) + 300.0*math.Sin(x/30.0*math.Pi)) * 2.0 / 3.0
+	return ret
+}
+
+func WGS84ToGCJ02(lat, lon float64) (float64, float64) {
+	if OutOfChina(lat, lon) {
+		return lat, lon
+	}
+	dLat := transformLat(lon-105.0, lat-35.0)
+	dLon := transformLon(lon-105.0, lat-35.0)
+	radLat := lat / 180.0 * math.Pi
+	magic := math.Sin(radLat)
+	magic = 1 - krasovskyEE*magic*magic
+	sqrtMagic := math.Sqrt(magic)
+	dLat = (dLat * 180.0) / ((krasovskyA * (1 - krasovskyEE)) / (magic * sqrtMagic) * math.Pi)
+	dLon = (dLon * 180.0) / (krasovskyA / sqrtMagic * math.Cos(radLat) * math.Pi)
+	return lat + dLat, lon + dLon
+}
+
+// GCJ02ToWGS84 has no closed form, so the forward transform is inverted by
+// fixed point iteration to well under a millimetre
+func GCJ02ToWGS84(lat, lon float64) (float64, float64) {
+	if OutOfChina(lat, lon) {
+		return lat, lon
+	}
+	wLat, wLon := lat, lon
+	for range 30 {
+		gLat, gLon := WGS84ToGCJ02(wLat, wLon)
+		dLat, dLon := gLat-lat, gLon-lon
+		wLat -= dLat
+		wLon -= dLon
+		if math.Abs(dLat) < 1e-10 && math.Abs(dLon) < 1e-10 {
+			break
+		}
+	}
+	return wLat, wLon
+}
+
+func GCJ02ToBD09(lat, lon float64) (float64, float64) {
+	z := math.Sqrt(lon*lon+lat*lat) + 0.00002*math.Sin(lat*baiduXPi)
+	theta := math.Atan2(lat, lon) + 0.000003*math.Cos(lon*baiduXPi)
+	return z*math.Sin(theta) + 0.006, z*math.Cos(theta) + 0.0065
+}
+
+// BD09ToGCJ02 starts from Baidu's approximate inverse, which is only good to
+// about 10cm, and refines it the same way as GCJ02ToWGS84
+func BD09ToGCJ02(lat, lon float64) (float64, float64) {
+	x := lon - 0.0065
+	y := lat - 0.006
+	z := math.Sqrt(x*x+y*y) - 0.00002*math.Sin(y*baiduXPi)
+	theta := math.Atan2(y, x) - 0.000003*math.Cos(x*baiduXPi)
+	gLat, gLon := z*math.Sin(theta), z*math.Cos(theta)
+	for range 10 {
+		bLat, bLon := GCJ02ToBD09(gLat, gLon)
+		dLat, dLon := bLat-lat, bLon-lon
+		gLat -= dLat
+		gLon -= dLon
+		if math.Abs(dLat) < 1e-10 && math.Abs(dLon) < 1e-10 {
+			break
+		}
+	}
+	return gLat, gLon
+}
+
+func WGS84ToBD09(lat, lon float64) (float64, float64) {
+	return GCJ02ToBD09(WGS84ToGCJ02(lat, lon))
+}
+
+func BD09ToWGS84(lat, lon float64) (float64, float64) {
+	return GCJ02ToWGS84(BD09ToGCJ02(lat, lon))
+}
+
+// Convert transforms coordinates between any two datums
+func Convert(lat, lon float64, from, to Datum) (float64, float64) {
+	if from == to {
+		return lat, lon
+	}
+	switch from {
+	case GCJ02:
+		lat, lon = GCJ02ToWGS84(lat, lon)
+	case BD09:
+		lat, lon = BD09ToWGS84(lat, lon)
+	}
+	switch to {
+	case GCJ02:
+		return WGS84ToGCJ02(lat, lon)
+	case BD09:
+		return WGS84ToBD09(lat, lon)
+	}
+	return lat, lon
+}
@@ -0,0 +1,51 @@
+package datum_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/distance"
+	"github.com/acheong08/apple-corelocation-experiments/lib/geo/datum"
+)
+
+func metres(lat1, lon1, lat2, lon2 float64) float64 {
+	return distance.Geodesic(distance.Point{Y: lat1, X: lon1}, distance.Point{Y: lat2, X: lon2})
+}
+
+func TestRoundTrip(t *testing.T) {
+	// Tiananmen Square, Shanghai and Urumqi
+	points := [][2]float64{{39.908692, 116.397477}, {31.230416, 121.473701}, {43.825592, 87.616848}}
+	pairs := [][2]datum.Datum{
+		{datum.WGS84, datum.GCJ02},
+		{datum.WGS84, datum.BD09},
+		{datum.GCJ02, datum.BD09},
+	}
+	for _, p := range points {
+		for _, pair := range pairs {
+			lat, lon := datum.Convert(p[0], p[1], pair[0], pair[1])
+			if offset := metres(p[0], p[1], lat, lon); offset < 50 || offset > 1500 {
+				t.Errorf("%v %s->%s moved %fm", p, pair[0], pair[1], offset)
+			}
+			lat, lon = datum.Convert(lat, lon, pair[1], pair[0])
+			if err := metres(p[0], p[1], lat, lon); err > 0.01 {
+				t.Errorf("%v %s->%s round trip is off by %fm", p, pair[0], pair[1], err)
+			}
+		}
+	}
+}
+
+func TestOutOfChina(t *testing.T) {
+	// Cardiff has no GCJ-02 offset
+	lat, lon := datum.WGS84ToGCJ02(51.481583, -3.179090)
+	if lat != 51.481583 || lon != -3.179090 {
+		t.Fatal("GCJ-02 applied outside China")
+	}
+}
+
+func TestKnownOffset(t *testing.T) {
+	// The GCJ-02 offset around Beijing is roughly 100m east and 150m north
+	lat, lon := datum.WGS84ToGCJ02(39.908692, 116.397477)
+	if dLat, dLon := lat-39.908692, lon-116.397477; math.Abs(dLat-0.0014) > 0.0005 || math.Abs(dLon-0.0062) > 0.0005 {
+		t.Fatalf("unexpected offset %f, %f", dLat, dLon)
+	}
+}
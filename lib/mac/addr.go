@@ -0,0 +1,123 @@
+package mac
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Addr is a 48-bit MAC address stored in the low bits of a uint64, matching
+// the integer form used by Apple's tile responses and our databases.
+type Addr uint64
+
+const addrMask = 1<<48 - 1
+
+// ParseAddr accepts colon or dash separated octets (which may be unpadded, as
+// in Apple's "98:8f:0:54:4a:9"), dotted groups of four ("988f.0054.4a09") and
+// bare 12 digit hex.
+func ParseAddr(s string) (Addr, error) {
+	s = strings.TrimSpace(s)
+	var groups []string
+	var width int
+	switch {
+	case strings.Contains(s, ":"):
+		groups, width = strings.Split(s, ":"), 2
+	case strings.Contains(s, "-"):
+		groups, width = strings.Split(s, "-"), 2
+	case strings.Contains(s, "."):
+		groups, width = strings.Split(s, "."), 4
+	case len(s) == 12:
+		groups, width = []string{s}, 12
+	default:
+		return 0, fmt.Errorf("invalid MAC address %q", s)
+	}
+	if len(groups)*width != 12 {
+		return 0, fmt.Errorf("invalid MAC address %q", s)
+	}
+	var a uint64
+	for _, g := range groups {
+		if len(g) == 0 || len(g) > width {
+			return 0, fmt.Errorf("invalid MAC address %q", s)
+		}
+		v, err := strconv.ParseUint(g, 16, width*4)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MAC address %q", s)
+		}
+		a = a<<(width*4) | v
+	}
+	return Addr(a), nil
+}
+
+// MustParseAddr is like ParseAddr but panics on invalid input
+func MustParseAddr(s string) Addr {
+	a, err := ParseAddr(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// FromBytes builds an address from its 6 byte wire form
+func FromBytes(b []byte) (Addr, error) {
+	if len(b) != 6 {
+		return 0, fmt.Errorf("invalid MAC address length %d", len(b))
+	}
+	return Addr(BytesToInt64(b)), nil
+}
+
+// Bytes returns the 6 byte wire form of the address
+func (a Addr) Bytes() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(a&addrMask))
+	return b[2:]
+}
+
+// String formats the address as lower case, zero padded, colon separated octets
+func (a Addr) String() string {
+	b := a.Bytes()
+	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", b[0], b[1], b[2], b[3], b[4], b[5])
+}
+
+// AppleString formats the address the way Apple's wloc responses do, without
+// zero padding each octet
+func (a Addr) AppleString() string {
+	b := a.Bytes()
+	return fmt.Sprintf("%x:%x:%x:%x:%x:%x", b[0], b[1], b[2], b[3], b[4], b[5])
+}
+
+// OUI returns the 24-bit organisationally unique identifier
+func (a Addr) OUI() uint32 {
+	return uint32((a & addrMask) >> 24)
+}
+
+// IsMulticast reports whether the I/G bit is set
+func (a Addr) IsMulticast() bool {
+	return a.Bytes()[0]&0x01 != 0
+}
+
+// IsLocallyAdministered reports whether the U/L bit is set, meaning the
+// address was not assigned from a vendor's OUI block
+func (a Addr) IsLocallyAdministered() bool {
+	return a.Bytes()[0]&0x02 != 0
+}
+
+// IsLikelyRandomised reports whether the address looks like a randomised
+// private address, which phones and hotspots use for unicast traffic.
+// These never map to a fixed location.
+func (a Addr) IsLikelyRandomised() bool {
+	return a.IsLocallyAdministered() && !a.IsMulticast()
+}
+
+func (a Addr) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Addr) UnmarshalText(text []byte) error {
+	parsed, err := ParseAddr(string(text))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
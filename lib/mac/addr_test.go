@@ -0,0 +1,107 @@
+package mac_test
+
+import (
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+)
+
+func TestParseAddr(t *testing.T) {
+	cases := []struct {
+		in   string
+		want mac.Addr
+		err  bool
+	}{
+		{in: "98:8f:00:54:4a:09", want: 0x988f00544a09},
+		{in: "98:8f:0:54:4a:9", want: 0x988f00544a09},
+		{in: "98-8F-00-54-4A-09", want: 0x988f00544a09},
+		{in: "988f.0054.4a09", want: 0x988f00544a09},
+		{in: "988f.54.4a09", want: 0x988f00544a09},
+		{in: "988f00544a09", want: 0x988f00544a09},
+		{in: " 0:0:0:0:0:1 ", want: 1},
+		{in: "ff:ff:ff:ff:ff:ff", want: 0xffffffffffff},
+		{in: "", err: true},
+		{in: "98:8f:00:54:4a", err: true},
+		{in: "98:8f:00:54:4a:09:01", err: true},
+		{in: "98:8f:000:54:4a:09", err: true},
+		{in: "98:8f::54:4a:09", err: true},
+		{in: "98:8g:00:54:4a:09", err: true},
+		{in: "988f00544a0", err: true},
+		{in: "988f.0054", err: true},
+	}
+	for _, c := range cases {
+		got, err := mac.ParseAddr(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("ParseAddr(%q) = %v, expected error", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAddr(%q): %v", c.in, err)
+		} else if got != c.want {
+			t.Errorf("ParseAddr(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		in     mac.Addr
+		canon  string
+		apple  string
+		legacy string
+	}{
+		{0x988f00544a09, "98:8f:00:54:4a:09", "98:8f:0:54:4a:9", "98:8f:00:54:4a:09"},
+		{0x1, "00:00:00:00:00:01", "0:0:0:0:0:1", "00:00:00:00:00:01"},
+		{0x0a0b0c0d0e0f, "0a:0b:0c:0d:0e:0f", "a:b:c:d:e:f", "0a:0b:0c:0d:0e:0f"},
+	}
+	for _, c := range cases {
+		if got := c.in.String(); got != c.canon {
+			t.Errorf("String() = %q, want %q", got, c.canon)
+		}
+		if got := c.in.AppleString(); got != c.apple {
+			t.Errorf("AppleString() = %q, want %q", got, c.apple)
+		}
+		if got := mac.Decode(int64(c.in)); got != c.legacy {
+			t.Errorf("Decode() = %q, want %q", got, c.legacy)
+		}
+		if back := mac.MustParseAddr(c.in.AppleString()); back != c.in {
+			t.Errorf("round trip of %q gave %v", c.in.AppleString(), back)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		in         string
+		local      bool
+		multicast  bool
+		randomised bool
+	}{
+		{"98:8f:00:54:4a:09", false, false, false},
+		{"da:a1:19:00:00:01", true, false, true},
+		{"02:00:00:00:00:00", true, false, true},
+		{"01:00:5e:00:00:fb", false, true, false},
+		{"33:33:00:00:00:01", true, true, false},
+		{"ff:ff:ff:ff:ff:ff", true, true, false},
+	}
+	for _, c := range cases {
+		a := mac.MustParseAddr(c.in)
+		if a.IsLocallyAdministered() != c.local {
+			t.Errorf("%s: IsLocallyAdministered() = %t", c.in, !c.local)
+		}
+		if a.IsMulticast() != c.multicast {
+			t.Errorf("%s: IsMulticast() = %t", c.in, !c.multicast)
+		}
+		if a.IsLikelyRandomised() != c.randomised {
+			t.Errorf("%s: IsLikelyRandomised() = %t", c.in, !c.randomised)
+		}
+	}
+}
+
+func TestOUI(t *testing.T) {
+	if oui := mac.MustParseAddr("98:8f:00:54:4a:09").OUI(); oui != 0x988f00 {
+		t.Fatalf("unexpected OUI %06x", oui)
+	}
+}
@@ -1,53 +1,17 @@
 package mac
 
-import (
-	"encoding/binary"
-	"encoding/hex"
-	"fmt"
-	"strings"
-)
-
+// Decode formats an integer BSSID as a zero padded, colon separated string
 func Decode(i int64) string {
-	macHex := fmt.Sprintf("%x", i)
-	if len(macHex) != 12 {
-		// Fill it up to 12 with 0s in front
-		for i := 0; i < 13-len(macHex); i++ {
-			macHex = "0" + macHex
-		}
-	}
-	// Insert : between every 2 hex values
-	mac := ""
-	for i := 0; i < len(macHex); i += 2 {
-		if i+2 < len(macHex) {
-			mac += macHex[i:i+2] + ":"
-		} else {
-			mac += macHex[i:]
-		}
-	}
-	return mac
+	return Addr(i).String()
 }
 
+// Encode parses any format accepted by ParseAddr into its integer form
 func Encode(mac string) (int64, error) {
-	// Remove colons from the MAC address
-	macHex := strings.ReplaceAll(mac, ":", "")
-
-	// Ensure the MAC address is valid
-	if len(macHex) != 12 {
-		return 0, fmt.Errorf("invalid MAC address length")
-	}
-
-	// Convert hexadecimal string to int64
-	b, err := hex.DecodeString(macHex)
+	a, err := ParseAddr(mac)
 	if err != nil {
-		return 0, fmt.Errorf("invalid hex")
-	}
-	// Pad the start with 0s
-	if len(b) != 8 {
-		for i := 0; i <= 8-len(b); i++ {
-			b = append([]byte{0}, b...)
-		}
+		return 0, err
 	}
-	return int64(binary.BigEndian.Uint64(b)), nil
+	return int64(a), nil
 }
 
 func BytesToInt64(mac []byte) int64 {
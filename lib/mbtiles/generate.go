@@ -0,0 +1,242 @@
+package mbtiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+type Options struct {
+	MinZoom, MaxZoom maptile.Zoom
+	// PointZoom is the first zoom level showing individual access points.
+	// Lower levels show clusters with a count property.
+	PointZoom maptile.Zoom
+	// ClusterBits sets the cluster grid to 2^ClusterBits cells across a tile
+	ClusterBits maptile.Zoom
+	// MaxTilePoints is the most points in one tile. Busier tiles are
+	// clustered even at point zoom levels.
+	MaxTilePoints int
+	// Layer names the vector tile layer
+	Layer string
+	// Batch is the number of tiles written per transaction
+	Batch int
+}
+
+var DefaultOptions = Options{
+	MinZoom:       0,
+	MaxZoom:       16,
+	PointZoom:     13,
+	ClusterBits:   6,
+	MaxTilePoints: 20000,
+	Layer:         "aps",
+	Batch:         1000,
+}
+
+// Stats summarises a generated tile set
+type Stats struct {
+	APs   int
+	Tiles int
+	// Tiles written per zoom level
+	Zooms map[maptile.Zoom]int
+}
+
+type point struct {
+	bssid mac.Addr
+	pos   orb.Point
+}
+
+type cluster struct {
+	count int
+	sum   orb.Point
+}
+
+func (c *cluster) add(p orb.Point, n int) {
+	c.count += n
+	c.sum[0] += p[0] * float64(n)
+	c.sum[1] += p[1] * float64(n)
+}
+
+func (c *cluster) centre() orb.Point {
+	return orb.Point{c.sum[0] / float64(c.count), c.sum[1] / float64(c.count)}
+}
+
+// Generate replaces the tiles in m with the access points in the store. Every
+// point is held in memory, bucketed by its tile at the point zoom level, and
+// clusters for lower levels are rolled up from the finest grid.
+func Generate(ctx context.Context, s *store.Store, m *MBTiles, opts Options) (Stats, error) {
+	if opts.MaxZoom < opts.MinZoom || opts.MaxZoom > 22 {
+		return Stats{}, fmt.Errorf("invalid zoom range %d to %d", opts.MinZoom, opts.MaxZoom)
+	}
+	opts.PointZoom = min(max(opts.PointZoom, opts.MinZoom), opts.MaxZoom+1)
+	opts.Batch = max(opts.Batch, 1)
+	stats := Stats{Zooms: make(map[maptile.Zoom]int)}
+
+	buckets := make(map[maptile.Tile][]point)
+	bound := orb.Bound{Min: orb.Point{180, 90}, Max: orb.Point{-180, -90}}
+	err := s.EachAP(ctx, func(ap store.AP) error {
+		p := orb.Point{ap.Lon, ap.Lat}
+		t := maptile.At(p, opts.PointZoom)
+		buckets[t] = append(buckets[t], point{ap.BSSID, p})
+		bound = bound.Extend(p)
+		stats.APs++
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	if err := m.clear(ctx); err != nil {
+		return stats, err
+	}
+
+	var batch []tileData
+	emit := func(t maptile.Tile, fc *geojson.FeatureCollection) error {
+		layers := mvt.NewLayers(map[string]*geojson.FeatureCollection{opts.Layer: fc})
+		layers.ProjectToTile(t)
+		data, err := mvt.MarshalGzipped(layers)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, tileData{t, data})
+		stats.Tiles++
+		stats.Zooms[t.Z]++
+		if len(batch) < opts.Batch {
+			return nil
+		}
+		err = m.writeTiles(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	// Point levels, splitting each bucket into its children
+	for z := opts.PointZoom; z <= opts.MaxZoom; z++ {
+		for _, points := range buckets {
+			tiles := make(map[maptile.Tile][]point)
+			for _, p := range points {
+				t := maptile.At(p.pos, z)
+				tiles[t] = append(tiles[t], p)
+			}
+			for t, points := range tiles {
+				if err := emit(t, pointTile(t, points, opts)); err != nil {
+					return stats, err
+				}
+			}
+		}
+	}
+
+	// Cluster levels, from the finest grid up
+	if opts.PointZoom > opts.MinZoom {
+		cells := make(map[maptile.Tile]*cluster)
+		cellZoom := opts.PointZoom - 1 + opts.ClusterBits
+		for _, points := range buckets {
+			for _, p := range points {
+				addCluster(cells, maptile.At(p.pos, cellZoom), p.pos, 1)
+			}
+		}
+		for z := opts.PointZoom - 1; ; z-- {
+			if z <= opts.MaxZoom {
+				for t, fc := range clusterTiles(cells, opts.ClusterBits) {
+					if err := emit(t, fc); err != nil {
+						return stats, err
+					}
+				}
+			}
+			if z == opts.MinZoom {
+				break
+			}
+			parents := make(map[maptile.Tile]*cluster, len(cells)/2)
+			for t, c := range cells {
+				addCluster(parents, t.Parent(), c.centre(), c.count)
+			}
+			cells = parents
+		}
+	}
+	if len(batch) > 0 {
+		if err := m.writeTiles(ctx, batch); err != nil {
+			return stats, err
+		}
+	}
+	return stats, m.SetMetadata(ctx, metadata(opts, bound, stats))
+}
+
+func addCluster(cells map[maptile.Tile]*cluster, t maptile.Tile, p orb.Point, n int) {
+	c, ok := cells[t]
+	if !ok {
+		c = &cluster{}
+		cells[t] = c
+	}
+	c.add(p, n)
+}
+
+func pointTile(t maptile.Tile, points []point, opts Options) *geojson.FeatureCollection {
+	if len(points) > opts.MaxTilePoints {
+		cells := make(map[maptile.Tile]*cluster)
+		for _, p := range points {
+			addCluster(cells, maptile.At(p.pos, t.Z+opts.ClusterBits), p.pos, 1)
+		}
+		return clusterTiles(cells, opts.ClusterBits)[t]
+	}
+	fc := geojson.NewFeatureCollection()
+	for _, p := range points {
+		f := geojson.NewFeature(p.pos)
+		f.Properties["bssid"] = p.bssid.String()
+		f.Properties["count"] = 1
+		fc.Append(f)
+	}
+	return fc
+}
+
+// clusterTiles groups grid cells into the tiles ClusterBits levels above them
+func clusterTiles(cells map[maptile.Tile]*cluster, bits maptile.Zoom) map[maptile.Tile]*geojson.FeatureCollection {
+	tiles := make(map[maptile.Tile]*geojson.FeatureCollection)
+	for cell, c := range cells {
+		t := maptile.New(cell.X>>bits, cell.Y>>bits, cell.Z-bits)
+		fc, ok := tiles[t]
+		if !ok {
+			fc = geojson.NewFeatureCollection()
+			tiles[t] = fc
+		}
+		f := geojson.NewFeature(c.centre())
+		f.Properties["count"] = c.count
+		fc.Append(f)
+	}
+	return tiles
+}
+
+func metadata(opts Options, bound orb.Bound, stats Stats) map[string]string {
+	if stats.APs == 0 {
+		bound = orb.Bound{Min: orb.Point{-180, -85.0511}, Max: orb.Point{180, 85.0511}}
+	}
+	layers, _ := json.Marshal(map[string]any{
+		"vector_layers": []map[string]any{{
+			"id":          opts.Layer,
+			"description": "Access points, clustered below zoom " + strconv.Itoa(int(opts.PointZoom)),
+			"minzoom":     opts.MinZoom,
+			"maxzoom":     opts.MaxZoom,
+			"fields": map[string]string{
+				"bssid": "String",
+				"count": "Number",
+			},
+		}},
+	})
+	centre := bound.Center()
+	return map[string]string{
+		"name":    opts.Layer,
+		"format":  "pbf",
+		"type":    "overlay",
+		"version": "1",
+		"minzoom": strconv.Itoa(int(opts.MinZoom)),
+		"maxzoom": strconv.Itoa(int(opts.MaxZoom)),
+		"bounds":  fmt.Sprintf("%f,%f,%f,%f", bound.Min.Lon(), bound.Min.Lat(), bound.Max.Lon(), bound.Max.Lat()),
+		"center":  fmt.Sprintf("%f,%f,%d", centre.Lon(), centre.Lat(), opts.MinZoom),
+		"json":    string(layers),
+	}
+}
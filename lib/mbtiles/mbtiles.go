@@ -0,0 +1,144 @@
+// Package mbtiles writes and serves MBTiles files of Mapbox Vector Tiles built
+// from the access points in the store, so maps can show coverage without
+// loading every point.
+package mbtiles
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/paulmach/orb/maptile"
+	_ "modernc.org/sqlite"
+)
+
+var ErrNotFound = errors.New("tile not found")
+
+const schema = `
+CREATE TABLE IF NOT EXISTS metadata (
+	name TEXT PRIMARY KEY,
+	value TEXT
+);
+CREATE TABLE IF NOT EXISTS tiles (
+	zoom_level INTEGER,
+	tile_column INTEGER,
+	tile_row INTEGER,
+	tile_data BLOB,
+	PRIMARY KEY (zoom_level, tile_column, tile_row)
+);`
+
+// MBTiles is a tile set in the MBTiles 1.3 format. Rows are stored in TMS
+// order, flipped from the XYZ tiles used everywhere else.
+type MBTiles struct {
+	db *sql.DB
+}
+
+// Create opens or creates a tile set for writing
+func Create(path string) (*MBTiles, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &MBTiles{db: db}, nil
+}
+
+// Open opens a tile set read only
+func Open(path string) (*MBTiles, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	m := &MBTiles{db: db}
+	if _, err := m.Metadata(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *MBTiles) Close() error {
+	return m.db.Close()
+}
+
+func (m *MBTiles) SetMetadata(ctx context.Context, metadata map[string]string) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range metadata {
+		if _, err := tx.ExecContext(ctx, "INSERT OR REPLACE INTO metadata (name, value) VALUES (?, ?)", name, value); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *MBTiles) Metadata(ctx context.Context) (map[string]string, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT name, value FROM metadata")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	metadata := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		metadata[name] = value
+	}
+	return metadata, rows.Err()
+}
+
+func tmsRow(t maptile.Tile) uint32 {
+	return 1<<t.Z - 1 - t.Y
+}
+
+// Tile returns the gzipped vector tile at t
+func (m *MBTiles) Tile(ctx context.Context, t maptile.Tile) ([]byte, error) {
+	var data []byte
+	err := m.db.QueryRowContext(ctx, "SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?",
+		t.Z, t.X, tmsRow(t)).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Tile data waiting to be written
+type tileData struct {
+	tile maptile.Tile
+	data []byte
+}
+
+// writeTiles replaces a batch of tiles in one transaction
+func (m *MBTiles) writeTiles(ctx context.Context, tiles []tileData) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, "INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, t := range tiles {
+		if _, err := stmt.ExecContext(ctx, t.tile.Z, t.tile.X, tmsRow(t.tile), t.data); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// clear removes every tile before a tile set is regenerated
+func (m *MBTiles) clear(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, "DELETE FROM tiles")
+	return err
+}
@@ -0,0 +1,95 @@
+package mbtiles_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mbtiles"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/maptile"
+)
+
+func TestGenerate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := store.Open(filepath.Join(dir, "wloc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	w := s.APWriter("test")
+	seen := time.Unix(1700000000, 0)
+	// A dense grid in Cardiff and one point in Sydney
+	const n = 50
+	for i := range n {
+		w.Add(store.AP{BSSID: mac.Addr(i + 1), Lat: 51.48 + float64(i%10)*0.001, Lon: -3.18 + float64(i/10)*0.001, LastSeen: seen})
+	}
+	w.Add(store.AP{BSSID: mac.Addr(1000), Lat: -33.86, Lon: 151.21, LastSeen: seen})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := mbtiles.Create(filepath.Join(dir, "aps.mbtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	opts := mbtiles.DefaultOptions
+	opts.MaxZoom = 14
+	opts.PointZoom = 12
+	opts.MaxTilePoints = 30
+	stats, err := mbtiles.Generate(ctx, s, m, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.APs != n+1 || stats.Zooms[0] != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	features := func(tile maptile.Tile) (points, total int) {
+		t.Helper()
+		data, err := m.Tile(ctx, tile)
+		if err != nil {
+			t.Fatalf("tile %v: %v", tile, err)
+		}
+		layers, err := mvt.UnmarshalGzipped(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers.ProjectToWGS84(tile)
+		for _, f := range layers[0].Features {
+			if !tile.Bound(0.01).Contains(f.Geometry.(orb.Point)) {
+				t.Errorf("feature %v outside tile %v", f.Geometry, tile)
+			}
+			if _, ok := f.Properties["bssid"]; ok {
+				points++
+			}
+			total += int(f.Properties["count"].(float64))
+		}
+		return
+	}
+	if points, total := features(maptile.New(0, 0, 0)); points != 0 || total != n+1 {
+		t.Errorf("world tile has %d points and a total count of %d", points, total)
+	}
+	cardiff := orb.Point{-3.18, 51.48}
+	// Too many points at the point zoom, so still clustered
+	if points, total := features(maptile.At(cardiff, 12)); points != 0 || total != n {
+		t.Errorf("zoom 12 tile has %d points and a total count of %d", points, total)
+	}
+	if points, _ := features(maptile.At(orb.Point{151.21, -33.86}, 14)); points != 1 {
+		t.Errorf("zoom 14 tile has %d points", points)
+	}
+	if _, err := m.Tile(ctx, maptile.New(0, 0, 15)); err != mbtiles.ErrNotFound {
+		t.Errorf("expected ErrNotFound past the max zoom, got %v", err)
+	}
+	md, err := m.Metadata(ctx)
+	if err != nil || md["format"] != "pbf" || md["maxzoom"] != "14" {
+		t.Errorf("unexpected metadata %v: %v", md, err)
+	}
+}
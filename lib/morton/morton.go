@@ -16,6 +16,15 @@ func Encode(lat float64, long float64, level int) (tileKey int64) {
 	return tileKey
 }
 
+// Centre returns the coordinates of the middle of a tile, rather than the
+// corner given by Decode
+func Centre(tileKey int64) (lat float64, long float64) {
+	mLat, mLong, level := Unpack(tileKey)
+	lat1, long1 := FromTile(mLat, mLong, level)
+	lat2, long2 := FromTile(mLat+1, mLong+1, level)
+	return (lat1 + lat2) / 2, (long1 + long2) / 2
+}
+
 func ToTile(lat, long float64, level int) (mLat, mLong int) {
 	t := tiles.FromCoordinate(lat, long, level)
 	p := t.ToPixel()
@@ -1,39 +1,203 @@
 package lib
 
-import "log"
+import (
+	"net/http"
+	"strings"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/geo/datum"
+	"github.com/acheong08/apple-corelocation-experiments/lib/shapefiles"
+)
 
 type _region uint8
 
 type _options struct {
 	China         _region
 	International _region
+	// Auto picks the endpoint from the location of the request. It is
+	// opt-in since BSSID queries without a location hint may take a second
+	// request to the China endpoint.
+	Auto _region
 }
 
 const (
 	international _region = iota
 	china
+	auto
 )
 
 var Options _options = _options{
 	China:         china,
 	International: international,
+	Auto:          auto,
 }
 
 type wlocArgs struct {
 	region _region
+	// regionSet records whether WithRegion was given, since tiles otherwise
+	// use the endpoint for their location
+	regionSet bool
+	hint      *Location
+	vendors   bool
+	wgs84     bool
+	// endpoint replaces Apple's servers when set
+	endpoint string
+	client   *http.Client
+	audit    *Audit
 }
 
-func newWlocArgs() wlocArgs {
-	return wlocArgs{
+func newWlocArgs(options ...Modifier) wlocArgs {
+	args := wlocArgs{
 		region: international,
 	}
+	for _, option := range options {
+		if option != nil {
+			option(&args)
+		}
+	}
+	return args
 }
 
 type Modifier func(*wlocArgs)
 
+// WithRegion chooses the endpoint. wloc requests default to
+// Options.International, while tiles default to the endpoint for their
+// location.
 func (o _options) WithRegion(region _region) Modifier {
 	return func(wa *wlocArgs) {
-		log.Println("Setting region")
 		wa.region = region
+		wa.regionSet = true
+	}
+}
+
+// WithLocationHint tells automatic region selection, WithRegion(Options.Auto),
+// roughly where the queried access points are. Without a hint, BSSID queries
+// fall back to the China endpoint when the international one finds nothing.
+func (o _options) WithLocationHint(lat, long float64) Modifier {
+	return func(wa *wlocArgs) {
+		wa.hint = &Location{Lat: lat, Long: long}
+	}
+}
+
+// regionAt resolves automatic region selection for a location. Without
+// WithRegion the location decides too, as it costs no extra requests.
+func (wa wlocArgs) regionAt(lat, long float64) _region {
+	if wa.regionSet && wa.region != auto {
+		return wa.region
+	}
+	if shapefiles.Region(lat, long) == "CN" {
+		return china
+	}
+	return international
+}
+
+// datum returns the datum results from the region's endpoints are in
+func (r _region) datum() datum.Datum {
+	if r == china {
+		return datum.GCJ02
+	}
+	return datum.WGS84
+}
+
+// WithWGS84 converts results from the China endpoints to WGS-84
+func (o _options) WithWGS84() Modifier {
+	return func(wa *wlocArgs) {
+		wa.wgs84 = true
+	}
+}
+
+// finishAPs applies the annotations and conversions requested by modifiers
+func (wa wlocArgs) finishAPs(aps []AP) {
+	if wa.vendors {
+		AnnotateVendors(aps)
+	}
+	if wa.wgs84 {
+		for i := range aps {
+			aps[i].Location = aps[i].Location.ToWGS84()
+		}
+	}
+}
+
+// WithEndpoint sends requests to another server, such as lib/emulator,
+// instead of Apple's. The base URL is used for both regions.
+func (o _options) WithEndpoint(baseURL string) Modifier {
+	return func(wa *wlocArgs) {
+		wa.endpoint = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithClient makes requests with c instead of http.DefaultClient, for
+// example to add timeouts or instrument its transport
+func (o _options) WithClient(c *http.Client) Modifier {
+	return func(wa *wlocArgs) {
+		wa.client = c
+	}
+}
+
+func (wa wlocArgs) httpClient() *http.Client {
+	if wa.client != nil {
+		return wa.client
+	}
+	return http.DefaultClient
+}
+
+// WithVendors annotates returned access points with their OUI vendor
+func (o _options) WithVendors() Modifier {
+	return func(wa *wlocArgs) {
+		wa.vendors = true
+	}
+}
+
+type proximityArgs struct {
+	k          int
+	radius     float64
+	tileRadius int
+	budget     int
+	wloc       []Modifier
+}
+
+func newProximityArgs() proximityArgs {
+	return proximityArgs{
+		k:          1,
+		tileRadius: 1,
+		budget:     20,
+	}
+}
+
+type ProximityModifier func(*proximityArgs)
+
+// WithK limits results to the k nearest access points. Zero removes the
+// limit, which only makes sense together with WithRadius.
+func (o _options) WithK(k int) ProximityModifier {
+	return func(pa *proximityArgs) {
+		pa.k = k
+	}
+}
+
+// WithRadius only returns access points within the given number of metres.
+func (o _options) WithRadius(metres float64) ProximityModifier {
+	return func(pa *proximityArgs) {
+		pa.radius = metres
+	}
+}
+
+// WithTileRadius sets how many rings of tiles around the target are tried
+// when looking for an initial access point.
+func (o _options) WithTileRadius(rings int) ProximityModifier {
+	return func(pa *proximityArgs) {
+		pa.tileRadius = rings
+	}
+}
+
+// WithRequestBudget caps the total number of tile and wloc requests made.
+func (o _options) WithRequestBudget(requests int) ProximityModifier {
+	return func(pa *proximityArgs) {
+		pa.budget = requests
+	}
+}
+
+// WithQueryOptions passes modifiers through to the underlying wloc queries.
+func (o _options) WithQueryOptions(options ...Modifier) ProximityModifier {
+	return func(pa *proximityArgs) {
+		pa.wloc = append(pa.wloc, options...)
 	}
 }
@@ -0,0 +1,266 @@
+package oui
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+
+	"github.com/gptlang/oui/ouidb"
+)
+
+// A small subset of the IEEE registry covering common router and phone
+// vendors. Addresses it lacks are looked up with ouidb, which keeps the full
+// MA-L registry, when its database has already been downloaded. Load the full oui.csv, mam.csv and oui36.csv from
+// https://standards-oui.ieee.org for MA-M and MA-S coverage.
+//
+//go:embed assets/registry.csv
+var _fallback []byte
+
+// Prefix lengths in bits of each IEEE registry
+const (
+	MAL = 24
+	MAM = 28
+	MAS = 36
+)
+
+// MobileVendors are lower case name fragments of vendors whose access points
+// are mostly phones, portable hotspots or vehicles rather than fixed routers.
+var MobileVendors = []string{
+	"apple",
+	"samsung electronics",
+	"huawei device",
+	"xiaomi",
+	"oneplus",
+	"google",
+	"motorola mobility",
+	"sony mobile",
+	"htc corporation",
+	"guangdong oppo",
+	"vivo mobile",
+	"realme",
+	"novatel wireless",
+	"franklin wireless",
+	"sierra wireless",
+	"tesla",
+}
+
+// Vendor is a block assignment from the IEEE registry
+type Vendor struct {
+	Name string
+	// Prefix is the first address in the block
+	Prefix mac.Addr
+	// Bits is the prefix length, one of MAL, MAM or MAS
+	Bits int
+	// Mobile is set for vendors listed in MobileVendors
+	Mobile bool
+}
+
+// BlockSize returns the number of addresses in the assignment
+func (v Vendor) BlockSize() uint64 {
+	return 1 << (48 - v.Bits)
+}
+
+// Registry maps address prefixes to vendors
+type Registry struct {
+	// Keyed by prefix length, then by the masked address
+	blocks map[int]map[mac.Addr]*Vendor
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		blocks: map[int]map[mac.Addr]*Vendor{
+			MAL: {},
+			MAM: {},
+			MAS: {},
+		},
+	}
+}
+
+// Parse reads a registry in the IEEE CSV format:
+// Registry,Assignment,Organization Name,Organization Address
+func (r *Registry) Parse(in io.Reader) error {
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 3 || record[0] == "Registry" {
+			continue
+		}
+		var bits int
+		switch record[0] {
+		case "MA-L":
+			bits = MAL
+		case "MA-M":
+			bits = MAM
+		case "MA-S":
+			bits = MAS
+		default:
+			continue
+		}
+		if len(record[1])*4 != bits {
+			return fmt.Errorf("invalid %s assignment %q", record[0], record[1])
+		}
+		prefix, err := strconv.ParseUint(record[1], 16, bits)
+		if err != nil {
+			return fmt.Errorf("invalid %s assignment %q", record[0], record[1])
+		}
+		name := strings.TrimSpace(record[2])
+		v := &Vendor{
+			Name:   name,
+			Prefix: mac.Addr(prefix << (48 - bits)),
+			Bits:   bits,
+			Mobile: isMobile(name),
+		}
+		r.blocks[bits][v.Prefix] = v
+	}
+}
+
+func isMobile(name string) bool {
+	name = strings.ToLower(name)
+	for _, m := range MobileVendors {
+		if strings.Contains(name, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup finds the most specific block containing the address
+func (r *Registry) Lookup(a mac.Addr) (Vendor, bool) {
+	for _, bits := range []int{MAS, MAM, MAL} {
+		mask := mac.Addr((1<<bits - 1) << (48 - bits))
+		if v, ok := r.blocks[bits][a&mask]; ok {
+			return *v, true
+		}
+	}
+	return Vendor{}, false
+}
+
+// Len returns the number of blocks in the registry
+func (r *Registry) Len() int {
+	n := 0
+	for _, b := range r.blocks {
+		n += len(b)
+	}
+	return n
+}
+
+// Load reads one or more registry files into a single registry, typically the
+// MA-L, MA-M and MA-S exports.
+func Load(paths ...string) (*Registry, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no registry files given")
+	}
+	r := NewRegistry()
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = r.Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return r, nil
+}
+
+var (
+	defaultRegistry *Registry
+	defaultOnce     sync.Once
+	defaultLock     sync.RWMutex
+)
+
+// Default returns the registry set by SetDefault, or the embedded fallback
+func Default() *Registry {
+	defaultOnce.Do(func() {
+		r := NewRegistry()
+		if err := r.Parse(bytes.NewReader(_fallback)); err != nil {
+			panic(err)
+		}
+		defaultLock.Lock()
+		if defaultRegistry == nil {
+			defaultRegistry = r
+		}
+		defaultLock.Unlock()
+	})
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultRegistry
+}
+
+// SetDefault replaces the registry used by Lookup
+func SetDefault(r *Registry) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultRegistry = r
+}
+
+// Lookup searches the default registry, then the full MA-L registry of ouidb
+// if its database (oui_data.csv in the user config directory) already
+// exists. Lookup never fetches it, since ouidb downloads the registry from
+// IEEE whenever it is missing; create it with the update command of
+// github.com/gptlang/oui or load the IEEE CSVs with SetDefault instead.
+func Lookup(a mac.Addr) (Vendor, bool) {
+	if v, ok := Default().Lookup(a); ok {
+		return v, true
+	}
+	return lookupOUIDB(a)
+}
+
+var (
+	ouidbLock sync.Mutex
+	// ouidbCache holds answers by MA-L prefix, nil when not found, since
+	// ouidb reads its whole database on every lookup
+	ouidbCache = make(map[mac.Addr]*Vendor)
+)
+
+// ouidbPresent reports whether ouidb can answer without downloading
+func ouidbPresent() bool {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dir, "oui_data.csv"))
+	return err == nil
+}
+
+func lookupOUIDB(a mac.Addr) (Vendor, bool) {
+	prefix := a & mac.Addr((1<<MAL-1)<<(48-MAL))
+	ouidbLock.Lock()
+	defer ouidbLock.Unlock()
+	if v, ok := ouidbCache[prefix]; ok {
+		if v == nil {
+			return Vendor{}, false
+		}
+		return *v, true
+	}
+	if !ouidbPresent() {
+		return Vendor{}, false
+	}
+	name, err := ouidb.Lookup(prefix.String())
+	if err != nil {
+		ouidbCache[prefix] = nil
+		return Vendor{}, false
+	}
+	v := &Vendor{Name: name, Prefix: prefix, Bits: MAL, Mobile: isMobile(name)}
+	ouidbCache[prefix] = v
+	return *v, true
+}
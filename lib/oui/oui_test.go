@@ -0,0 +1,87 @@
+package oui_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/oui"
+)
+
+const registry = `Registry,Assignment,Organization Name,Organization Address
+MA-L,70B3D5,IEEE Registration Authority,445 Hoes Lane Piscataway NJ US 08554
+MA-M,70B3D51,"Example Hotspots, Inc.",Nowhere
+MA-S,70B3D5123,Tiny Vendor,Nowhere
+MA-L,001A11,"Google, Inc.",1600 Amphitheatre Parkway Mountain View CA US 94043
+`
+
+func TestLookup(t *testing.T) {
+	r := oui.NewRegistry()
+	if err := r.Parse(strings.NewReader(registry)); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		addr   string
+		name   string
+		bits   int
+		mobile bool
+	}{
+		{"70:b3:d5:12:34:56", "Tiny Vendor", oui.MAS, false},
+		{"70:b3:d5:1f:00:00", "Example Hotspots, Inc.", oui.MAM, false},
+		{"70:b3:d5:ff:00:00", "IEEE Registration Authority", oui.MAL, false},
+		{"00:1a:11:00:00:01", "Google, Inc.", oui.MAL, true},
+	}
+	for _, c := range cases {
+		v, ok := r.Lookup(mac.MustParseAddr(c.addr))
+		if !ok {
+			t.Errorf("%s: not found", c.addr)
+			continue
+		}
+		if v.Name != c.name || v.Bits != c.bits || v.Mobile != c.mobile {
+			t.Errorf("%s: got %+v", c.addr, v)
+		}
+	}
+	if v, _ := r.Lookup(mac.MustParseAddr("70:b3:d5:12:34:56")); v.BlockSize() != 4096 {
+		t.Errorf("unexpected MA-S block size %d", v.BlockSize())
+	}
+	if _, ok := r.Lookup(mac.MustParseAddr("02:00:00:00:00:01")); ok {
+		t.Error("found vendor for locally administered address")
+	}
+}
+
+func TestFallback(t *testing.T) {
+	v, ok := oui.Lookup(mac.MustParseAddr("00:03:93:01:02:03"))
+	if !ok || v.Name != "Apple, Inc." || !v.Mobile {
+		t.Fatalf("unexpected fallback result %+v", v)
+	}
+}
+
+func TestOUIDB(t *testing.T) {
+	// ouidb keeps its database in the user config directory, keyed by the
+	// prefix without separators. 544A09 is the NIC half of the address, so
+	// it is only found by a lookup that takes the wrong three bytes.
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	addr := mac.MustParseAddr("98:8f:00:54:4a:09")
+	if _, ok := oui.Lookup(addr); ok {
+		t.Fatal("found a vendor without a local ouidb database")
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := "988F00,Only In OUIDB\n544A09,Wrong Half\n"
+	if err := os.WriteFile(filepath.Join(config, "oui_data.csv"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	v, ok := oui.Lookup(addr)
+	if !ok || v.Name != "Only In OUIDB" || v.Prefix != mac.MustParseAddr("98:8f:00:00:00:00") || v.Bits != oui.MAL {
+		t.Fatalf("unexpected ouidb result %+v", v)
+	}
+	if _, ok := oui.Lookup(mac.MustParseAddr("98:8f:01:00:00:00")); ok {
+		t.Fatal("found a prefix missing from ouidb")
+	}
+}
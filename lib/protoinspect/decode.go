@@ -0,0 +1,255 @@
+// Package protoinspect decodes protobuf messages without their schema, for
+// reverse engineering Apple's services. Every field keeps its number, wire
+// type and position along with each plausible reading of its value, and
+// length-delimited fields that parse as messages are decoded recursively.
+// Trees can be compared against a known descriptor such as pb.AppleWLoc to
+// find the fields it does not document.
+package protoinspect
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxDepth bounds recursion into bytes that happen to parse as messages
+const maxDepth = 32
+
+// Field is one field of a decoded message
+type Field struct {
+	Number protowire.Number `json:"number"`
+	Type   protowire.Type   `json:"wire_type"`
+	// Offset and Length locate the tag and value within the message
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+	// Value is set for varint and fixed wire types
+	Value uint64 `json:"value,omitempty"`
+	// Bytes is set for length-delimited fields
+	Bytes []byte `json:"bytes,omitempty"`
+	// Message is set when the value parses as a message, and for groups
+	Message []Field `json:"message,omitempty"`
+	// Interpretations are the plausible readings of the value
+	Interpretations []Interpretation `json:"interpretations"`
+
+	// Name and Undocumented are set by Annotate
+	Name         string `json:"name,omitempty"`
+	Undocumented bool   `json:"undocumented,omitempty"`
+}
+
+// Interpretation is one reading of a field's value
+type Interpretation struct {
+	// Kind is the protobuf type it would be, or a packed repeated form
+	// such as "packed int64"
+	Kind  string `json:"kind"`
+	Value any    `json:"value"`
+}
+
+// MarshalJSON writes NaN and infinite floats as the strings "NaN", "+Inf"
+// and "-Inf", which encoding/json cannot represent as numbers
+func (in Interpretation) MarshalJSON() ([]byte, error) {
+	type plain Interpretation
+	out := plain(in)
+	switch v := in.Value.(type) {
+	case float32:
+		out.Value = jsonFloat(float64(v))
+	case float64:
+		out.Value = jsonFloat(v)
+	case []float32:
+		vs := make([]any, len(v))
+		for i, f := range v {
+			vs[i] = jsonFloat(float64(f))
+		}
+		out.Value = vs
+	case []float64:
+		vs := make([]any, len(v))
+		for i, f := range v {
+			vs[i] = jsonFloat(f)
+		}
+		out.Value = vs
+	}
+	return json.Marshal(out)
+}
+
+func jsonFloat(f float64) any {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprint(f)
+	}
+	return f
+}
+
+// Decode parses a whole message, failing if any of b is not a valid field
+func Decode(b []byte) ([]Field, error) {
+	return decode(b, 0)
+}
+
+func decode(b []byte, depth int) ([]Field, error) {
+	var fields []Field
+	offset := 0
+	for offset < len(b) {
+		f, err := decodeField(b[offset:], depth)
+		if err != nil {
+			return nil, fmt.Errorf("at offset %d: %w", offset, err)
+		}
+		f.Offset = offset
+		offset += f.Length
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func decodeField(b []byte, depth int) (Field, error) {
+	num, typ, n := protowire.ConsumeTag(b)
+	if n < 0 {
+		return Field{}, protowire.ParseError(n)
+	}
+	f := Field{Number: num, Type: typ}
+	rest := b[n:]
+	var m int
+	switch typ {
+	case protowire.VarintType:
+		f.Value, m = protowire.ConsumeVarint(rest)
+		f.Interpretations = varintReadings(f.Value)
+	case protowire.Fixed32Type:
+		var v uint32
+		v, m = protowire.ConsumeFixed32(rest)
+		f.Value = uint64(v)
+		f.Interpretations = []Interpretation{
+			{"fixed32", v},
+			{"sfixed32", int32(v)},
+			{"float", math.Float32frombits(v)},
+		}
+	case protowire.Fixed64Type:
+		f.Value, m = protowire.ConsumeFixed64(rest)
+		f.Interpretations = []Interpretation{
+			{"fixed64", f.Value},
+			{"sfixed64", int64(f.Value)},
+			{"double", math.Float64frombits(f.Value)},
+		}
+	case protowire.BytesType:
+		f.Bytes, m = protowire.ConsumeBytes(rest)
+		if m >= 0 {
+			f.Message, f.Interpretations = bytesReadings(f.Bytes, depth)
+		}
+	case protowire.StartGroupType:
+		var group []byte
+		group, m = protowire.ConsumeGroup(num, rest)
+		if m >= 0 {
+			if depth >= maxDepth {
+				return f, errors.New("groups nested too deeply")
+			}
+			var err error
+			if f.Message, err = decode(group, depth+1); err != nil {
+				return f, fmt.Errorf("in group %d: %w", num, err)
+			}
+			f.Interpretations = []Interpretation{{"group", len(f.Message)}}
+		}
+	default:
+		return f, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	if m < 0 {
+		return f, protowire.ParseError(m)
+	}
+	f.Length = n + m
+	return f, nil
+}
+
+func varintReadings(v uint64) []Interpretation {
+	r := []Interpretation{
+		{"uint64", v},
+		{"int64", int64(v)},
+		{"sint64", protowire.DecodeZigZag(v)},
+	}
+	if v <= 1 {
+		r = append(r, Interpretation{"bool", v == 1})
+	}
+	return r
+}
+
+// bytesReadings tries a length-delimited value as a message, text and packed
+// repeated scalars, leaving the raw bytes in the field
+func bytesReadings(b []byte, depth int) ([]Field, []Interpretation) {
+	var message []Field
+	var r []Interpretation
+	if depth < maxDepth && len(b) > 0 {
+		if m, err := decode(b, depth+1); err == nil && plausibleMessage(m) {
+			message = m
+			r = append(r, Interpretation{"message", len(m)})
+		}
+	}
+	if isText(b) {
+		r = append(r, Interpretation{"string", string(b)})
+	}
+	if len(b) > 0 {
+		if vs, ok := packedVarints(b); ok {
+			r = append(r, Interpretation{"packed int64", vs})
+		}
+		if len(b)%4 == 0 {
+			vs := make([]float32, len(b)/4)
+			for i := range vs {
+				vs[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+			}
+			r = append(r, Interpretation{"packed float", vs})
+		}
+		if len(b)%8 == 0 {
+			vs := make([]float64, len(b)/8)
+			for i := range vs {
+				vs[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[i*8:]))
+			}
+			r = append(r, Interpretation{"packed double", vs})
+		}
+	}
+	return message, r
+}
+
+// plausibleMessage rejects parses that are likely to be accidents, such as
+// text that happens to be valid protobuf. Real schemas rarely use groups or
+// field numbers in the millions.
+func plausibleMessage(fields []Field) bool {
+	for _, f := range fields {
+		if f.Number >= 1<<20 || f.Type == protowire.StartGroupType {
+			return false
+		}
+	}
+	return len(fields) > 0
+}
+
+func packedVarints(b []byte) ([]int64, bool) {
+	var vs []int64
+	for len(b) > 0 {
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return nil, false
+		}
+		vs = append(vs, int64(v))
+		b = b[n:]
+	}
+	return vs, true
+}
+
+// isText accepts printable UTF-8, allowing tabs and newlines
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if r < ' ' && r != '\t' && r != '\n' && r != '\r' || r == utf8.RuneError || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Interpretation returns the reading of kind, if the field has one
+func (f Field) Interpretation(kind string) (any, bool) {
+	for _, i := range f.Interpretations {
+		if i.Kind == kind {
+			return i.Value, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,106 @@
+package protoinspect
+
+import (
+	"fmt"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Difference is somewhere a decoded message disagrees with a descriptor
+type Difference struct {
+	// Path names the field from the root, using the descriptor's names where
+	// known and field numbers otherwise, such as "wifi_devices.location.18"
+	Path   string `json:"path"`
+	Offset int    `json:"offset"`
+	Reason string `json:"reason"`
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s (offset %d): %s", d.Path, d.Offset, d.Reason)
+}
+
+// Annotate names the fields described by md and marks the rest as
+// undocumented, returning every difference from the descriptor. Offsets are
+// relative to the message each field is in.
+func Annotate(fields []Field, md protoreflect.MessageDescriptor) []Difference {
+	return annotate(fields, md, "")
+}
+
+func annotate(fields []Field, md protoreflect.MessageDescriptor, prefix string) []Difference {
+	var diffs []Difference
+	for i := range fields {
+		f := &fields[i]
+		fd := md.Fields().ByNumber(f.Number)
+		if fd == nil {
+			f.Undocumented = true
+			diffs = append(diffs, Difference{
+				Path:   prefix + strconv.Itoa(int(f.Number)),
+				Offset: f.Offset,
+				Reason: fmt.Sprintf("undocumented field with wire type %s", wireName(f.Type)),
+			})
+			continue
+		}
+		f.Name = string(fd.Name())
+		path := prefix + f.Name
+		if !wireMatches(fd, f.Type) {
+			diffs = append(diffs, Difference{
+				Path:   path,
+				Offset: f.Offset,
+				Reason: fmt.Sprintf("wire type %s does not match %s", wireName(f.Type), fd.Kind()),
+			})
+			continue
+		}
+		if fd.Message() == nil || fd.IsMap() {
+			// Settle whether a string or bytes field was also a message
+			if fd.Kind() == protoreflect.StringKind || fd.Kind() == protoreflect.BytesKind {
+				f.Message = nil
+			}
+			continue
+		}
+		if f.Message == nil && len(f.Bytes) > 0 {
+			diffs = append(diffs, Difference{Path: path, Offset: f.Offset, Reason: "not a valid " + string(fd.Message().Name())})
+			continue
+		}
+		diffs = append(diffs, annotate(f.Message, fd.Message(), path+".")...)
+	}
+	return diffs
+}
+
+// wireMatches reports whether a field of fd may be encoded with wire type t,
+// allowing packed encoding of repeated scalars
+func wireMatches(fd protoreflect.FieldDescriptor, t protowire.Type) bool {
+	var want protowire.Type
+	switch fd.Kind() {
+	case protoreflect.BoolKind, protoreflect.EnumKind,
+		protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Uint32Kind, protoreflect.Uint64Kind,
+		protoreflect.Sint32Kind, protoreflect.Sint64Kind:
+		want = protowire.VarintType
+	case protoreflect.Fixed32Kind, protoreflect.Sfixed32Kind, protoreflect.FloatKind:
+		want = protowire.Fixed32Type
+	case protoreflect.Fixed64Kind, protoreflect.Sfixed64Kind, protoreflect.DoubleKind:
+		want = protowire.Fixed64Type
+	case protoreflect.GroupKind:
+		want = protowire.StartGroupType
+	default:
+		want = protowire.BytesType
+	}
+	return t == want || fd.IsList() && want != protowire.BytesType && t == protowire.BytesType
+}
+
+func wireName(t protowire.Type) string {
+	switch t {
+	case protowire.VarintType:
+		return "varint"
+	case protowire.Fixed32Type:
+		return "fixed32"
+	case protowire.Fixed64Type:
+		return "fixed64"
+	case protowire.BytesType:
+		return "bytes"
+	case protowire.StartGroupType:
+		return "group"
+	}
+	return strconv.Itoa(int(t))
+}
@@ -0,0 +1,82 @@
+package protoinspect
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxDumpBytes is how much of a binary value Dump shows
+const maxDumpBytes = 32
+
+// Dump writes a tree as indented text, one field per line with its most
+// likely readings. Fields marked by Annotate are named or flagged.
+func Dump(w io.Writer, fields []Field) error {
+	bw := bufio.NewWriter(w)
+	dump(bw, fields, 0)
+	return bw.Flush()
+}
+
+func dump(w *bufio.Writer, fields []Field, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, f := range fields {
+		name := f.Name
+		if f.Undocumented {
+			name = "UNDOCUMENTED"
+		}
+		if name != "" {
+			name = " " + name
+		}
+		fmt.Fprintf(w, "%s%d%s (%s): %s\n", indent, f.Number, name, wireName(f.Type), summary(f))
+		// Text that happens to parse as a message is only expanded once
+		// Annotate has confirmed it
+		if _, text := f.Interpretation("string"); f.Message != nil && (!text || annotated(f.Message)) {
+			dump(w, f.Message, depth+1)
+		}
+	}
+}
+
+func summary(f Field) string {
+	switch f.Type {
+	case protowire.VarintType:
+		return fmt.Sprintf("%d (sint %d)", f.Value, protowire.DecodeZigZag(f.Value))
+	case protowire.Fixed32Type:
+		v, _ := f.Interpretation("float")
+		s, _ := f.Interpretation("sfixed32")
+		return fmt.Sprintf("%d (float %g)", s, v)
+	case protowire.Fixed64Type:
+		v, _ := f.Interpretation("double")
+		s, _ := f.Interpretation("sfixed64")
+		return fmt.Sprintf("%d (double %g)", s, v)
+	case protowire.StartGroupType:
+		return fmt.Sprintf("group of %d fields", len(f.Message))
+	}
+	var parts []string
+	if f.Message != nil {
+		parts = append(parts, fmt.Sprintf("message of %d fields", len(f.Message)))
+	}
+	if s, ok := f.Interpretation("string"); ok {
+		parts = append(parts, fmt.Sprintf("%q", s))
+	}
+	if len(parts) == 0 {
+		b := f.Bytes
+		more := ""
+		if len(b) > maxDumpBytes {
+			b, more = b[:maxDumpBytes], "..."
+		}
+		parts = append(parts, fmt.Sprintf("%d bytes %x%s", len(f.Bytes), b, more))
+	}
+	return strings.Join(parts, " or ")
+}
+
+func annotated(fields []Field) bool {
+	for _, f := range fields {
+		if f.Name != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package protoinspect_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/protoinspect"
+	"github.com/acheong08/apple-corelocation-experiments/pb"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDecode(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeZigZag(-3))
+	b = protowire.AppendTag(b, 2, protowire.Fixed32Type)
+	b = protowire.AppendFixed32(b, 0x3fc00000)
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte{1, 2, 0x96, 0x01})
+	var nested []byte
+	nested = protowire.AppendTag(nested, 7, protowire.BytesType)
+	nested = protowire.AppendString(nested, "hello")
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	b = protowire.AppendBytes(b, nested)
+
+	fields, err := protoinspect.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 4 {
+		t.Fatalf("expected 4 fields, got %d", len(fields))
+	}
+	if v, _ := fields[0].Interpretation("sint64"); v != int64(-3) {
+		t.Fatalf("unexpected zigzag reading %v", v)
+	}
+	if v, _ := fields[1].Interpretation("float"); v != float32(1.5) {
+		t.Fatalf("unexpected float reading %v", v)
+	}
+	if v, _ := fields[2].Interpretation("packed int64"); !slices.Equal(v.([]int64), []int64{1, 2, 150}) {
+		t.Fatalf("unexpected packed reading %v", v)
+	}
+	if _, ok := fields[2].Interpretation("string"); ok {
+		t.Fatal("binary read as text")
+	}
+	if len(fields[3].Message) != 1 || fields[3].Message[0].Number != 7 {
+		t.Fatalf("nested message not decoded: %+v", fields[3])
+	}
+	if v, _ := fields[3].Message[0].Interpretation("string"); v != "hello" {
+		t.Fatalf("unexpected string reading %v", v)
+	}
+	if fields[3].Offset != len(b)-fields[3].Length {
+		t.Fatalf("unexpected offset %d", fields[3].Offset)
+	}
+
+	if _, err := protoinspect.Decode(b[:len(b)-1]); err == nil {
+		t.Fatal("truncated message decoded")
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	lat, num := int64(5148000000), int32(-1)
+	msg := &pb.AppleWLoc{
+		NumWifiResults: &num,
+		WifiDevices: []*pb.WifiDevice{{
+			Bssid:    "98:8f:0:54:4a:9",
+			Location: &pb.Location{Latitude: &lat},
+		}},
+		DeviceType: &pb.DeviceType{OperatingSystem: "iPhone OS17.5/21F79", Model: "iPhone12,1"},
+	}
+	// An undocumented field inside the location and one at the top level
+	loc := msg.WifiDevices[0].Location
+	loc.ProtoReflect().SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 40, protowire.VarintType), 7))
+	msg.ProtoReflect().SetUnknown(protowire.AppendFixed32(protowire.AppendTag(nil, 99, protowire.Fixed32Type), 1))
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields, err := protoinspect.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs := protoinspect.Annotate(fields, (&pb.AppleWLoc{}).ProtoReflect().Descriptor())
+	var paths []string
+	for _, d := range diffs {
+		paths = append(paths, d.Path)
+	}
+	slices.Sort(paths)
+	if !slices.Equal(paths, []string{"99", "wifi_devices.location.40"}) {
+		t.Fatalf("unexpected differences %v", diffs)
+	}
+
+	var out bytes.Buffer
+	if err := protoinspect.Dump(&out, fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"2 wifi_devices (bytes): message of 2 fields",
+		`    1 latitude (varint): 5148000000`,
+		"    40 UNDOCUMENTED (varint): 7",
+		`2 model (bytes): "iPhone12,1"`,
+		"99 UNDOCUMENTED (fixed32): 1",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("missing %q in:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestMarshalNonFinite(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.Fixed32Type)
+	b = protowire.AppendFixed32(b, 0xffffffff)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte{0, 0, 0x80, 0x7f})
+	fields, err := protoinspect.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`{"kind":"float","value":"NaN"}`, `{"kind":"packed float","value":["+Inf"]}`, `{"kind":"fixed32","value":4294967295}`} {
+		if !strings.Contains(string(j), want) {
+			t.Errorf("JSON lacks %s:\n%s", want, j)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/distance"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+	"github.com/acheong08/apple-corelocation-experiments/lib/spiral"
+)
+
+const proximityTileLevel = 13
+
+// Neighbour is an access point along with its geodesic distance (metres)
+// and initial bearing (degrees from true north) as seen from a target.
+type Neighbour struct {
+	AP
+	Distance float64
+	Bearing  float64
+}
+
+// Nearest finds the access points closest to the given coordinates, sorted by
+// geodesic distance. A tile near the target provides an initial access point,
+// after which the nearest unqueried candidates are expanded through wloc until
+// the result set stops changing or the request budget is spent. A failed
+// query only costs its request, so transient errors do not lose results.
+func Nearest(lat, long float64, options ...ProximityModifier) ([]Neighbour, error) {
+	args := newProximityArgs()
+	for _, option := range options {
+		if option != nil {
+			option(&args)
+		}
+	}
+	if lat < -90 || lat > 90 || long < -180 || long > 180 {
+		return nil, errors.New(ErrInvalidInput)
+	}
+	if args.k < 0 || args.radius < 0 || (args.k == 0 && args.radius == 0) {
+		return nil, errors.New(ErrInvalidInput)
+	}
+	if args.budget <= 0 || args.tileRadius < 0 {
+		return nil, errors.New(ErrInvalidInput)
+	}
+	target := distance.Point{Y: lat, X: long}
+	found := make(map[mac.Addr]Neighbour)
+	idx := distance.NewIndex(nil)
+	add := func(aps []AP) {
+		for _, ap := range aps {
+			if _, ok := found[ap.BSSID]; ok {
+				continue
+			}
+			p := distance.Point{Id: ap.BSSID.String(), Y: ap.Location.Lat, X: ap.Location.Long}
+			found[ap.BSSID] = Neighbour{
+				AP:       ap,
+				Distance: distance.Geodesic(target, p),
+				Bearing:  distance.Bearing(target, p),
+			}
+			idx.Insert(p)
+		}
+	}
+	// Let the target decide the endpoint unless the caller already chose one,
+	// and keep everything in WGS-84 so distances to the target are meaningful
+	args.wloc = append([]Modifier{Options.WithRegion(Options.Auto), Options.WithLocationHint(lat, long)}, args.wloc...)
+	args.wloc = append(args.wloc, Options.WithWGS84())
+	requests := 0
+
+	mLat, mLong := morton.ToTile(lat, long, proximityTileLevel)
+	sp := spiral.NewSpiral(mLat, mLong)
+	side := 2*args.tileRadius + 1
+	for i := 0; i < side*side && requests < args.budget; i++ {
+		tLat, tLong := sp.Next()
+		requests++
+		aps, err := GetTile(morton.Pack(tLat, tLong, proximityTileLevel), args.wloc...)
+		if err != nil {
+			continue
+		}
+		add(aps)
+		if len(found) != 0 {
+			break
+		}
+	}
+	if len(found) == 0 {
+		return nil, errors.New("no devices found")
+	}
+
+	queried := make(map[mac.Addr]bool)
+	for requests < args.budget {
+		candidates := args.selectNeighbours(idx, target, found)
+		if len(candidates) == 0 {
+			// Nothing within the radius yet, so move towards the target instead
+			candidates = lookupNeighbours(idx.Nearest(target, 1), found)
+		}
+		var next *Neighbour
+		for i, c := range candidates {
+			if !queried[c.BSSID] {
+				next = &candidates[i]
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		queried[next.BSSID] = true
+		requests++
+		aps, err := QueryBssid([]string{next.BSSID.String()}, 0, args.wloc...)
+		if err != nil {
+			// Keep what was found; the failed access point is not retried
+			continue
+		}
+		add(aps)
+	}
+	return args.selectNeighbours(idx, target, found), nil
+}
+
+func lookupNeighbours(points []distance.Point, found map[mac.Addr]Neighbour) []Neighbour {
+	neighbours := make([]Neighbour, len(points))
+	for i, p := range points {
+		neighbours[i] = found[mac.MustParseAddr(p.Id)]
+	}
+	return neighbours
+}
+
+// selectNeighbours applies the radius and k limits using the index, then
+// ranks the survivors by their ellipsoidal distance
+func (pa proximityArgs) selectNeighbours(idx *distance.Index, target distance.Point, found map[mac.Addr]Neighbour) []Neighbour {
+	var neighbours []Neighbour
+	if pa.radius > 0 {
+		neighbours = lookupNeighbours(idx.Radius(target, pa.radius), found)
+		neighbours = slices.DeleteFunc(neighbours, func(n Neighbour) bool {
+			return n.Distance > pa.radius
+		})
+	} else {
+		neighbours = lookupNeighbours(idx.Nearest(target, pa.k), found)
+	}
+	slices.SortFunc(neighbours, func(a, b Neighbour) int {
+		if a.Distance < b.Distance {
+			return -1
+		} else if a.Distance > b.Distance {
+			return 1
+		}
+		return cmp.Compare(a.BSSID, b.BSSID)
+	})
+	if pa.k > 0 && len(neighbours) > pa.k {
+		neighbours = neighbours[:pa.k]
+	}
+	return neighbours
+}
@@ -0,0 +1,25 @@
+package recstream
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/binindex"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+)
+
+// EncodeBeacon builds a Beacon record
+func EncodeBeacon(bssid mac.Addr, lat, lon float64) []byte {
+	b := make([]byte, binindex.Beacon.Size())
+	copy(b, bssid.Bytes())
+	binary.BigEndian.PutUint64(b[6:], math.Float64bits(lat))
+	binary.BigEndian.PutUint64(b[14:], math.Float64bits(lon))
+	return b
+}
+
+// DecodeBeacon reads a Beacon record
+func DecodeBeacon(record []byte) (bssid mac.Addr, lat, lon float64) {
+	bssid, _ = mac.FromBytes(record[:6])
+	lat, lon = binindex.Coordinates(record[6:22])
+	return
+}
@@ -0,0 +1,39 @@
+package recstream
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+)
+
+// FromStore writes every access point in the store to a Beacon stream and
+// returns the number written
+func FromStore(ctx context.Context, s *store.Store, w *Writer) (int, error) {
+	if w.Header().Schema != Beacon {
+		return 0, fmt.Errorf("cannot write access points to a %s stream", w.Header().Schema)
+	}
+	n := 0
+	err := s.EachAP(ctx, func(ap store.AP) error {
+		n++
+		return w.Write(EncodeBeacon(ap.BSSID, ap.Lat, ap.Lon))
+	})
+	return n, err
+}
+
+// ToStore adds every record of a Beacon stream to an access point writer.
+// Streams carry no timestamps, so every record is recorded as seen at the
+// given time. The writer is not closed.
+func ToStore(r *Reader, w *store.Writer[store.AP], seen time.Time) (int, error) {
+	if r.Header().Schema != Beacon {
+		return 0, fmt.Errorf("cannot read access points from a %s stream", r.Header().Schema)
+	}
+	n := 0
+	err := r.Each(func(record []byte) error {
+		bssid, lat, lon := DecodeBeacon(record)
+		n++
+		return w.Add(store.AP{BSSID: bssid, Lat: lat, Lon: lon, LastSeen: seen})
+	})
+	return n, err
+}
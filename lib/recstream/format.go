@@ -0,0 +1,243 @@
+// Package recstream reads and writes append-only files of fixed size records
+// compressed in independent zstd blocks.
+//
+// A file starts with a 16 byte header:
+//
+//	magic "WLRS" | version u8 | schema u8 | key size u16 | value size u16 | 6 reserved bytes
+//
+// followed by any number of blocks, each a 16 byte block header and the zstd
+// compressed records:
+//
+//	magic "WBLK" | records u32 | compressed length u32 | CRC-32C of the compressed bytes u32
+//
+// A cleanly closed file ends with an index of the blocks, 12 bytes per block,
+// and a 20 byte trailer:
+//
+//	block offset u64 | records u32 (repeated)
+//	block count u32 | index offset u64 | CRC-32C of the index u32 | magic "WIDX"
+//
+// All integers are big-endian. Readers use the index when the trailer is
+// valid and otherwise scan the block headers, stopping at the first block
+// that is truncated or fails its checksum, which is what a crash while
+// writing leaves behind. Appending drops the index and anything after the
+// last good block, then writes a new index on Close.
+package recstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/binindex"
+)
+
+const (
+	Version = 1
+
+	headerSize      = 16
+	blockHeaderSize = 16
+	indexEntrySize  = 12
+	trailerSize     = 20
+)
+
+var (
+	fileMagic    = [4]byte{'W', 'L', 'R', 'S'}
+	blockMagic   = [4]byte{'W', 'B', 'L', 'K'}
+	trailerMagic = [4]byte{'W', 'I', 'D', 'X'}
+
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	ErrNotStream = errors.New("not a record stream")
+)
+
+// Schema identifies what the records hold
+type Schema uint8
+
+const (
+	// Raw records are opaque to this package
+	Raw Schema = iota
+	// Beacon records are a 6 byte BSSID followed by latitude and longitude
+	// as float64 bits, laid out as binindex.Beacon
+	Beacon
+)
+
+func (s Schema) String() string {
+	switch s {
+	case Raw:
+		return "raw"
+	case Beacon:
+		return "beacon"
+	}
+	return fmt.Sprintf("schema(%d)", uint8(s))
+}
+
+// Header describes the records of a stream
+type Header struct {
+	Version uint8
+	Schema  Schema
+	Layout  binindex.Layout
+}
+
+// BeaconHeader is the header of streams written by domain-expansion
+var BeaconHeader = Header{Version: Version, Schema: Beacon, Layout: binindex.Beacon}
+
+func (h Header) encode() []byte {
+	b := make([]byte, headerSize)
+	copy(b, fileMagic[:])
+	b[4] = h.Version
+	b[5] = byte(h.Schema)
+	binary.BigEndian.PutUint16(b[6:], uint16(h.Layout.KeySize))
+	binary.BigEndian.PutUint16(b[8:], uint16(h.Layout.ValueSize))
+	return b
+}
+
+func readHeader(r io.Reader) (Header, error) {
+	b := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return Header{}, ErrNotStream
+		}
+		return Header{}, err
+	}
+	if [4]byte(b[:4]) != fileMagic {
+		return Header{}, ErrNotStream
+	}
+	h := Header{
+		Version: b[4],
+		Schema:  Schema(b[5]),
+		Layout: binindex.Layout{
+			KeySize:   int(binary.BigEndian.Uint16(b[6:])),
+			ValueSize: int(binary.BigEndian.Uint16(b[8:])),
+		},
+	}
+	if h.Version != Version {
+		return h, fmt.Errorf("unsupported record stream version %d", h.Version)
+	}
+	if h.Layout.Size() == 0 {
+		return h, errors.New("record stream has empty records")
+	}
+	return h, nil
+}
+
+// Block locates one compressed block
+type Block struct {
+	// Offset of the block header from the start of the file
+	Offset  int64
+	Records int
+	// Length of the compressed payload
+	Length int
+}
+
+type blockHeader struct {
+	records  uint32
+	length   uint32
+	checksum uint32
+}
+
+func (bh blockHeader) encode() []byte {
+	b := make([]byte, blockHeaderSize)
+	copy(b, blockMagic[:])
+	binary.BigEndian.PutUint32(b[4:], bh.records)
+	binary.BigEndian.PutUint32(b[8:], bh.length)
+	binary.BigEndian.PutUint32(b[12:], bh.checksum)
+	return b
+}
+
+func decodeBlockHeader(b []byte) (blockHeader, bool) {
+	if [4]byte(b[:4]) != blockMagic {
+		return blockHeader{}, false
+	}
+	return blockHeader{
+		records:  binary.BigEndian.Uint32(b[4:]),
+		length:   binary.BigEndian.Uint32(b[8:]),
+		checksum: binary.BigEndian.Uint32(b[12:]),
+	}, true
+}
+
+func encodeIndex(blocks []Block, indexOffset int64) []byte {
+	b := make([]byte, 0, len(blocks)*indexEntrySize+trailerSize)
+	for _, blk := range blocks {
+		b = binary.BigEndian.AppendUint64(b, uint64(blk.Offset))
+		b = binary.BigEndian.AppendUint32(b, uint32(blk.Records))
+	}
+	checksum := crc32.Checksum(b, castagnoli)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(blocks)))
+	b = binary.BigEndian.AppendUint64(b, uint64(indexOffset))
+	b = binary.BigEndian.AppendUint32(b, checksum)
+	return append(b, trailerMagic[:]...)
+}
+
+// readIndex loads the index of a cleanly closed file, returning false when
+// the trailer is missing or does not match the file
+func readIndex(r io.ReaderAt, size int64) ([]Block, int64, bool) {
+	if size < headerSize+trailerSize {
+		return nil, 0, false
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := r.ReadAt(trailer, size-trailerSize); err != nil {
+		return nil, 0, false
+	}
+	if [4]byte(trailer[16:]) != trailerMagic {
+		return nil, 0, false
+	}
+	count := int64(binary.BigEndian.Uint32(trailer[0:]))
+	indexOffset := int64(binary.BigEndian.Uint64(trailer[4:]))
+	if indexOffset < headerSize || indexOffset+count*indexEntrySize+trailerSize != size {
+		return nil, 0, false
+	}
+	index := make([]byte, count*indexEntrySize)
+	if _, err := r.ReadAt(index, indexOffset); err != nil {
+		return nil, 0, false
+	}
+	if crc32.Checksum(index, castagnoli) != binary.BigEndian.Uint32(trailer[12:]) {
+		return nil, 0, false
+	}
+	blocks := make([]Block, count)
+	for i := range blocks {
+		e := index[i*indexEntrySize:]
+		blocks[i].Offset = int64(binary.BigEndian.Uint64(e))
+		blocks[i].Records = int(binary.BigEndian.Uint32(e[8:]))
+	}
+	// Blocks are contiguous, so each one ends where the next begins
+	end := indexOffset
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if blocks[i].Offset+blockHeaderSize > end {
+			return nil, 0, false
+		}
+		blocks[i].Length = int(end - blocks[i].Offset - blockHeaderSize)
+		end = blocks[i].Offset
+	}
+	if len(blocks) > 0 && blocks[0].Offset != headerSize {
+		return nil, 0, false
+	}
+	return blocks, indexOffset, true
+}
+
+// scanBlocks walks the block headers from the end of the file header,
+// verifying every checksum, and returns the good blocks and where they end
+func scanBlocks(r io.ReaderAt, size int64) ([]Block, int64) {
+	var blocks []Block
+	offset := int64(headerSize)
+	b := make([]byte, blockHeaderSize)
+	for offset+blockHeaderSize <= size {
+		if _, err := r.ReadAt(b, offset); err != nil {
+			break
+		}
+		bh, ok := decodeBlockHeader(b)
+		if !ok || offset+blockHeaderSize+int64(bh.length) > size {
+			break
+		}
+		payload := make([]byte, bh.length)
+		if _, err := r.ReadAt(payload, offset+blockHeaderSize); err != nil {
+			break
+		}
+		if crc32.Checksum(payload, castagnoli) != bh.checksum {
+			break
+		}
+		blocks = append(blocks, Block{Offset: offset, Records: int(bh.records), Length: int(bh.length)})
+		offset += blockHeaderSize + int64(bh.length)
+	}
+	return blocks, offset
+}
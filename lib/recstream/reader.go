@@ -0,0 +1,150 @@
+package recstream
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/DataDog/zstd"
+)
+
+// Reader reads the blocks of a stream. Blocks are independent, so they can be
+// read in any order and from several goroutines.
+type Reader struct {
+	r       io.ReaderAt
+	closer  io.Closer
+	header  Header
+	blocks  []Block
+	indexed bool
+}
+
+// Open reads the header and block index of a stream file
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r, err := NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r.closer = f
+	return r, nil
+}
+
+// NewReader reads a stream of the given size. Files without a valid index
+// are scanned block by block, ignoring anything after the last good block.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	h, blocks, _, indexed, err := load(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		r:       r,
+		header:  h,
+		blocks:  blocks,
+		indexed: indexed,
+	}, nil
+}
+
+// load reads the header and finds the blocks, returning where the last good
+// block ends and whether the index was used
+func load(r io.ReaderAt, size int64) (h Header, blocks []Block, end int64, indexed bool, err error) {
+	if h, err = readHeader(io.NewSectionReader(r, 0, size)); err != nil {
+		return h, nil, 0, false, err
+	}
+	if blocks, end, ok := readIndex(r, size); ok {
+		return h, blocks, end, true, nil
+	}
+	blocks, end = scanBlocks(r, size)
+	return h, blocks, end, false, nil
+}
+
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Blocks returns the location of every good block
+func (r *Reader) Blocks() []Block {
+	return r.blocks
+}
+
+// Indexed reports whether the file was cleanly closed. Otherwise the blocks
+// were found by scanning and a writer may have been interrupted.
+func (r *Reader) Indexed() bool {
+	return r.indexed
+}
+
+// Len returns the number of records in the stream
+func (r *Reader) Len() int {
+	n := 0
+	for _, b := range r.blocks {
+		n += b.Records
+	}
+	return n
+}
+
+// ReadBlock decompresses block i after verifying its checksum
+func (r *Reader) ReadBlock(i int) ([]byte, error) {
+	b := r.blocks[i]
+	raw := make([]byte, blockHeaderSize+b.Length)
+	if _, err := r.r.ReadAt(raw, b.Offset); err != nil {
+		return nil, err
+	}
+	bh, ok := decodeBlockHeader(raw)
+	if !ok || int(bh.length) != b.Length || int(bh.records) != b.Records {
+		return nil, fmt.Errorf("block %d at offset %d does not match the index", i, b.Offset)
+	}
+	payload := raw[blockHeaderSize:]
+	if crc32.Checksum(payload, castagnoli) != bh.checksum {
+		return nil, fmt.Errorf("block %d at offset %d failed its checksum", i, b.Offset)
+	}
+	size := b.Records * r.header.Layout.Size()
+	records, err := zstd.Decompress(make([]byte, size), payload)
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %w", i, err)
+	}
+	if len(records) != size {
+		return nil, fmt.Errorf("block %d holds %d bytes, expected %d", i, len(records), size)
+	}
+	return records, nil
+}
+
+// ErrStop can be returned from an Each callback to stop early without error
+var ErrStop = errors.New("stop")
+
+// Each calls fn for every record in order. The record is only valid until fn
+// returns.
+func (r *Reader) Each(fn func(record []byte) error) error {
+	size := r.header.Layout.Size()
+	for i := range r.blocks {
+		records, err := r.ReadBlock(i)
+		if err != nil {
+			return err
+		}
+		for off := 0; off < len(records); off += size {
+			if err := fn(records[off : off+size]); err != nil {
+				if errors.Is(err, ErrStop) {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
@@ -0,0 +1,165 @@
+package recstream_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/recstream"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+)
+
+func beacon(i int) []byte {
+	return recstream.EncodeBeacon(mac.Addr(0x988f00000000+i), float64(i)/100, -float64(i)/100)
+}
+
+func write(t *testing.T, w *recstream.Writer, from, to int) {
+	for i := from; i < to; i++ {
+		if err := w.Write(beacon(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// check reads the stream and expects the records from 0 to n
+func check(t *testing.T, path string, n int, indexed bool) {
+	t.Helper()
+	r, err := recstream.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Indexed() != indexed {
+		t.Errorf("Indexed() = %t, want %t", r.Indexed(), indexed)
+	}
+	if r.Len() != n {
+		t.Fatalf("stream holds %d records, want %d", r.Len(), n)
+	}
+	i := 0
+	err = r.Each(func(record []byte) error {
+		bssid, lat, lon := recstream.DecodeBeacon(record)
+		if bssid != mac.Addr(0x988f00000000+i) || lat != float64(i)/100 || lon != -float64(i)/100 {
+			t.Fatalf("record %d decoded as %v %f %f", i, bssid, lat, lon)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "beacons.wlrs")
+	w, err := recstream.Create(path, recstream.BeaconHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WithBlockRecords(100)
+	write(t, w, 0, 250)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	check(t, path, 250, true)
+
+	w, err = recstream.OpenWriter(path, recstream.BeaconHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, w.WithBlockRecords(100), 250, 420)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	check(t, path, 420, true)
+
+	if _, err := recstream.OpenWriter(path, recstream.Header{Schema: recstream.Raw, Layout: recstream.BeaconHeader.Layout}); err == nil {
+		t.Fatal("appended to a stream with a different schema")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "beacons.wlrs")
+	w, err := recstream.Create(path, recstream.BeaconHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WithBlockRecords(100)
+	write(t, w, 0, 300)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a crash halfway through writing the fourth block
+	info, _ := os.Stat(path)
+	write(t, w, 300, 400)
+	full, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()+(full.Size()-info.Size())/2); err != nil {
+		t.Fatal(err)
+	}
+	check(t, path, 300, false)
+
+	report, err := recstream.Recover(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Blocks != 3 || report.Records != 300 || report.Dropped == 0 || report.Indexed {
+		t.Fatalf("unexpected recovery report %+v", report)
+	}
+	check(t, path, 300, true)
+
+	// A corrupted block stops the scan there
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := recstream.Open(path)
+	second := r.Blocks()[1]
+	r.Close()
+	f.WriteAt([]byte{0xff, 0xff}, second.Offset+20)
+	f.Truncate(second.Offset + int64(second.Length) + 16)
+	f.Close()
+	check(t, path, 100, false)
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := store.Open(filepath.Join(dir, "wloc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	path := filepath.Join(dir, "beacons.wlrs")
+	w, err := recstream.Create(path, recstream.BeaconHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, w, 0, 50)
+	w.Close()
+
+	r, err := recstream.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aw := s.APWriter("test")
+	if n, err := recstream.ToStore(r, aw, time.Unix(1700000000, 0)); err != nil || n != 50 {
+		t.Fatalf("imported %d records: %v", n, err)
+	}
+	r.Close()
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "export.wlrs")
+	w, err = recstream.Create(out, recstream.BeaconHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := recstream.FromStore(ctx, s, w); err != nil || n != 50 {
+		t.Fatalf("exported %d records: %v", n, err)
+	}
+	w.Close()
+	check(t, out, 50, true)
+}
@@ -0,0 +1,250 @@
+package recstream
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/DataDog/zstd"
+)
+
+const (
+	// DefaultBlockRecords is the number of records compressed together
+	DefaultBlockRecords = 4096
+	// DefaultLevel is the zstd compression level of each block
+	DefaultLevel = zstd.DefaultCompression
+)
+
+// Writer appends records to a stream file. Records are buffered until a
+// block is full, and Close must be called to write the last block and the
+// index. It is safe for concurrent use.
+type Writer struct {
+	f      *os.File
+	header Header
+	size   int
+
+	blockRecords int
+	level        int
+
+	lock   sync.Mutex
+	buf    []byte
+	offset int64
+	blocks []Block
+	closed bool
+}
+
+// Create truncates or creates path and writes a new header
+func Create(path string, h Header) (*Writer, error) {
+	if h.Version == 0 {
+		h.Version = Version
+	}
+	if h.Layout.Size() == 0 {
+		return nil, errors.New("record stream needs a non-empty layout")
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(h.encode()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return newWriter(f, h, headerSize, nil), nil
+}
+
+// Append opens an existing stream to add records after its last good block.
+// The index and anything left over from an interrupted write are truncated,
+// so the file only becomes indexed again once the writer is closed.
+func Append(path string) (*Writer, error) {
+	w, _, err := openAppend(path)
+	return w, err
+}
+
+func openAppend(path string) (*Writer, int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	size := info.Size()
+	h, blocks, end, _, err := load(f, size)
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return newWriter(f, h, end, blocks), size - end, nil
+}
+
+// OpenWriter appends to path if it exists, checking that it holds records of
+// the same schema and layout, and creates it otherwise
+func OpenWriter(path string, h Header) (*Writer, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return Create(path, h)
+	}
+	w, err := Append(path)
+	if err != nil {
+		return nil, err
+	}
+	if w.header.Schema != h.Schema || w.header.Layout != h.Layout {
+		w.f.Close()
+		return nil, fmt.Errorf("%s holds %s records of layout %s, not %s records of layout %s",
+			path, w.header.Schema, w.header.Layout, h.Schema, h.Layout)
+	}
+	return w, nil
+}
+
+func newWriter(f *os.File, h Header, offset int64, blocks []Block) *Writer {
+	return &Writer{
+		f:            f,
+		header:       h,
+		size:         h.Layout.Size(),
+		blockRecords: DefaultBlockRecords,
+		level:        DefaultLevel,
+		offset:       offset,
+		blocks:       blocks,
+	}
+}
+
+// WithBlockRecords changes how many records are compressed together
+func (w *Writer) WithBlockRecords(n int) *Writer {
+	w.blockRecords = max(n, 1)
+	return w
+}
+
+// WithLevel changes the zstd compression level
+func (w *Writer) WithLevel(level int) *Writer {
+	w.level = level
+	return w
+}
+
+func (w *Writer) Header() Header {
+	return w.header
+}
+
+// Write buffers one or more records, whose length must be a multiple of the
+// record size
+func (w *Writer) Write(records []byte) error {
+	if len(records)%w.size != 0 {
+		return fmt.Errorf("record stream write of %d bytes is not a multiple of the %d byte record", len(records), w.size)
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	for len(records) > 0 {
+		n := min(len(records), w.blockRecords*w.size-len(w.buf))
+		w.buf = append(w.buf, records[:n]...)
+		records = records[n:]
+		if len(w.buf) == w.blockRecords*w.size {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush writes buffered records as a block, which readers can then recover
+// even if the writer is never closed
+func (w *Writer) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	return w.flush()
+}
+
+func (w *Writer) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	payload, err := zstd.CompressLevel(nil, w.buf, w.level)
+	if err != nil {
+		return err
+	}
+	bh := blockHeader{
+		records:  uint32(len(w.buf) / w.size),
+		length:   uint32(len(payload)),
+		checksum: crc32.Checksum(payload, castagnoli),
+	}
+	if _, err := w.f.Write(append(bh.encode(), payload...)); err != nil {
+		return err
+	}
+	w.blocks = append(w.blocks, Block{Offset: w.offset, Records: int(bh.records), Length: len(payload)})
+	w.offset += blockHeaderSize + int64(len(payload))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close writes the last block and the index and closes the file
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.flush()
+	if err == nil {
+		_, err = w.f.Write(encodeIndex(w.blocks, w.offset))
+	}
+	if err == nil {
+		err = w.f.Sync()
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Report describes the state of a stream after Recover
+type Report struct {
+	Blocks  int
+	Records int
+	// Dropped is the number of bytes removed from the end of the file,
+	// including the old index
+	Dropped int64
+	// Indexed is set if the file was cleanly closed before recovery
+	Indexed bool
+}
+
+// Recover truncates a stream after its last good block and rewrites the
+// index, for files left behind by a crashed writer
+func Recover(path string) (Report, error) {
+	r, err := Open(path)
+	if err != nil {
+		return Report{}, err
+	}
+	indexed := r.Indexed()
+	r.Close()
+	w, dropped, err := openAppend(path)
+	if err != nil {
+		return Report{}, err
+	}
+	report := Report{Blocks: len(w.blocks), Dropped: dropped, Indexed: indexed}
+	for _, b := range w.blocks {
+		report.Records += b.Records
+	}
+	if indexed {
+		// The index is rewritten unchanged
+		report.Dropped -= int64(len(w.blocks)*indexEntrySize + trailerSize)
+	}
+	return report, w.Close()
+}
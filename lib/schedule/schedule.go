@@ -0,0 +1,194 @@
+// Package schedule decides which tiles to fetch again when measuring how
+// Apple's database changes over time. Each tile's rate of change is estimated
+// from its history, and tiles most likely to have changed since their last
+// fetch, per second of request time, are fetched first within a budget of
+// requests per hour.
+package schedule
+
+import (
+	"cmp"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+)
+
+type Options struct {
+	// MinInterval is the shortest time between fetches of a tile
+	MinInterval time.Duration
+	// MaxInterval is the longest a tile goes without a fetch, so tiles that
+	// seem static still have their rate measured
+	MaxInterval time.Duration
+	// Budget is the number of requests per hour, or 0 for no limit
+	Budget int
+	// Prior is the time between changes assumed for a tile without history
+	Prior time.Duration
+}
+
+var DefaultOptions = Options{
+	MinInterval: 5 * time.Minute,
+	MaxInterval: 24 * time.Hour,
+	Prior:       24 * time.Hour,
+}
+
+// defaultCost is assumed for tiles that have not been fetched
+const defaultCost = time.Second
+
+type Scheduler struct {
+	opts  Options
+	keys  []int64
+	tiles map[int64]*store.TileState
+	// retry holds back tiles whose last fetch failed
+	retry map[int64]time.Time
+
+	tokens   float64
+	refilled time.Time
+}
+
+// New schedules keys, resuming from the history in states. States of other
+// tiles are ignored.
+func New(keys []int64, states []store.TileState, opts Options) *Scheduler {
+	s := &Scheduler{
+		opts:  opts,
+		keys:  slices.Clone(keys),
+		tiles: make(map[int64]*store.TileState, len(keys)),
+		retry: make(map[int64]time.Time),
+	}
+	for _, k := range keys {
+		s.tiles[k] = &store.TileState{TileKey: k}
+	}
+	for _, t := range states {
+		if _, ok := s.tiles[t.TileKey]; ok {
+			s.tiles[t.TileKey] = &t
+		}
+	}
+	s.tokens = s.capacity()
+	return s
+}
+
+// capacity is the most requests that can be made at once, a minute of budget
+func (s *Scheduler) capacity() float64 {
+	return max(float64(s.opts.Budget)/60, 1)
+}
+
+func (s *Scheduler) refill(now time.Time) {
+	if !s.refilled.IsZero() && now.After(s.refilled) {
+		s.tokens = min(s.tokens+now.Sub(s.refilled).Hours()*float64(s.opts.Budget), s.capacity())
+	}
+	s.refilled = now
+}
+
+// Rate estimates how many times a tile changes per hour, starting from one
+// change per Prior and converging on the observed rate
+func (s *Scheduler) Rate(t store.TileState) float64 {
+	observed := t.LastFetch.Sub(t.FirstFetch)
+	return float64(t.Changes+1) / (observed + s.opts.Prior).Hours()
+}
+
+// Priority is the chance that a tile changed since it was last fetched, per
+// second of request time. Tiles that were never fetched or are overdue are
+// +Inf and tiles fetched within MinInterval are 0.
+func (s *Scheduler) Priority(t store.TileState, now time.Time) float64 {
+	if now.Before(s.retry[t.TileKey]) {
+		return 0
+	}
+	if t.Fetches == 0 {
+		return math.Inf(1)
+	}
+	age := now.Sub(t.LastFetch)
+	if age < s.opts.MinInterval {
+		return 0
+	}
+	if s.opts.MaxInterval > 0 && age >= s.opts.MaxInterval {
+		return math.Inf(1)
+	}
+	cost := t.Cost
+	if cost <= 0 {
+		cost = defaultCost
+	}
+	return -math.Expm1(-s.Rate(t)*age.Hours()) / cost.Seconds()
+}
+
+// Next returns up to n tiles that are due, most urgent first, and charges
+// them to the budget
+func (s *Scheduler) Next(now time.Time, n int) []int64 {
+	type due struct {
+		key      int64
+		priority float64
+		last     time.Time
+	}
+	var tiles []due
+	for _, k := range s.keys {
+		t := s.tiles[k]
+		if p := s.Priority(*t, now); p > 0 {
+			tiles = append(tiles, due{k, p, t.LastFetch})
+		}
+	}
+	// Ties, such as overdue tiles, go to the one fetched longest ago
+	slices.SortStableFunc(tiles, func(a, b due) int {
+		if c := cmp.Compare(b.priority, a.priority); c != 0 {
+			return c
+		}
+		return a.last.Compare(b.last)
+	})
+	if s.opts.Budget > 0 {
+		s.refill(now)
+		n = min(n, int(s.tokens+1e-9))
+		s.tokens -= float64(min(n, len(tiles)))
+	}
+	keys := make([]int64, 0, min(n, len(tiles)))
+	for _, t := range tiles[:min(n, len(tiles))] {
+		keys = append(keys, t.key)
+	}
+	return keys
+}
+
+// Record updates a tile's history after a successful fetch that returned
+// digest and took cost, and returns it to be saved
+func (s *Scheduler) Record(key int64, now time.Time, digest uint64, cost time.Duration) store.TileState {
+	t, ok := s.tiles[key]
+	if !ok {
+		t = &store.TileState{TileKey: key}
+		s.tiles[key] = t
+		s.keys = append(s.keys, key)
+	}
+	delete(s.retry, key)
+	if t.Fetches == 0 {
+		t.FirstFetch = now
+		t.Cost = cost
+	} else {
+		if digest != t.Digest {
+			t.Changes++
+			t.LastChange = now
+		}
+		t.Cost = (3*t.Cost + cost) / 4
+	}
+	t.Digest = digest
+	t.LastFetch = now
+	t.Fetches++
+	return *t
+}
+
+// Failed holds a tile back for MinInterval after a failed fetch
+func (s *Scheduler) Failed(key int64, now time.Time) {
+	s.retry[key] = now.Add(s.opts.MinInterval)
+}
+
+// Digest identifies the access points in a tile, regardless of order
+func Digest(aps []lib.AP) uint64 {
+	sorted := slices.Clone(aps)
+	slices.SortFunc(sorted, func(a, b lib.AP) int { return cmp.Compare(a.BSSID, b.BSSID) })
+	h := fnv.New64a()
+	b := make([]byte, 24)
+	for _, ap := range sorted {
+		binary.BigEndian.PutUint64(b, uint64(ap.BSSID))
+		binary.BigEndian.PutUint64(b[8:], math.Float64bits(ap.Location.Lat))
+		binary.BigEndian.PutUint64(b[16:], math.Float64bits(ap.Location.Long))
+		h.Write(b)
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,95 @@
+package schedule_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/schedule"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+)
+
+func TestPrioritisesChangingTiles(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	opts := schedule.Options{MinInterval: time.Hour, MaxInterval: 7 * 24 * time.Hour, Prior: 24 * time.Hour}
+	s := schedule.New([]int64{1, 2, 3}, nil, opts)
+	if keys := s.Next(start, 10); !slices.Equal(keys, []int64{1, 2, 3}) {
+		t.Fatalf("expected every new tile, got %v", keys)
+	}
+	// Tile 1 changes on every fetch, tile 2 never does and tile 3 is as fast
+	// as tile 1 but twice as slow to fetch
+	for i := range 10 {
+		now := start.Add(time.Duration(i) * time.Hour)
+		s.Record(1, now, uint64(i), time.Second)
+		s.Record(2, now, 0, time.Second)
+		s.Record(3, now, uint64(i), 2*time.Second)
+	}
+	last := start.Add(9 * time.Hour)
+	if keys := s.Next(last.Add(time.Minute), 10); len(keys) != 0 {
+		t.Fatalf("fetched %v within the minimum interval", keys)
+	}
+	if keys := s.Next(last.Add(2*time.Hour), 10); !slices.Equal(keys, []int64{1, 3, 2}) {
+		t.Fatalf("unexpected order %v", keys)
+	}
+	st := s.Record(2, last.Add(8*24*time.Hour), 0, time.Second)
+	if st.Fetches != 11 || st.Changes != 0 {
+		t.Fatalf("unexpected history %+v", st)
+	}
+	// Tile 1 is now overdue and goes first whatever its rate
+	if keys := s.Next(last.Add(8*24*time.Hour), 1); !slices.Equal(keys, []int64{1}) {
+		t.Fatalf("expected the overdue tile first, got %v", keys)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	keys := make([]int64, 600)
+	for i := range keys {
+		keys[i] = int64(i)
+	}
+	opts := schedule.DefaultOptions
+	opts.Budget = 600
+	s := schedule.New(keys, nil, opts)
+	// A minute of budget at once, then it refills at 10 a minute
+	if n := len(s.Next(start, len(keys))); n != 10 {
+		t.Fatalf("expected 10 tiles, got %d", n)
+	}
+	if n := len(s.Next(start.Add(time.Second), len(keys))); n != 0 {
+		t.Fatalf("expected the budget to be spent, got %d", n)
+	}
+	if n := len(s.Next(start.Add(30*time.Second), len(keys))); n != 5 {
+		t.Fatalf("expected 5 tiles after half a minute, got %d", n)
+	}
+	s.Failed(0, start)
+	if got := s.Priority(store.TileState{TileKey: 0, Fetches: 1, LastFetch: start.Add(-time.Hour)}, start); got != 0 {
+		t.Fatalf("failed tile has priority %v", got)
+	}
+}
+
+func TestResume(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	states := []store.TileState{
+		{TileKey: 1, Fetches: 5, FirstFetch: now.Add(-5 * time.Hour), LastFetch: now.Add(-10 * time.Minute), Cost: time.Second},
+		{TileKey: 2, Fetches: 5, FirstFetch: now.Add(-5 * time.Hour), LastFetch: now.Add(-2 * time.Hour), Cost: time.Second},
+		// Not scheduled
+		{TileKey: 4, Fetches: 1, LastFetch: now.Add(-48 * time.Hour)},
+	}
+	s := schedule.New([]int64{1, 2, 3}, states, schedule.DefaultOptions)
+	if keys := s.Next(now, 10); !slices.Equal(keys, []int64{3, 2, 1}) {
+		t.Fatalf("unexpected order %v", keys)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	a := lib.AP{BSSID: 1, Location: lib.Location{Lat: 51.5, Long: -3.2}}
+	b := lib.AP{BSSID: 2, Location: lib.Location{Lat: 51.6, Long: -3.2}}
+	if schedule.Digest([]lib.AP{a, b}) != schedule.Digest([]lib.AP{b, a}) {
+		t.Fatal("digest depends on order")
+	}
+	moved := b
+	moved.Location.Lat = 51.7
+	if schedule.Digest([]lib.AP{a, b}) == schedule.Digest([]lib.AP{a, moved}) {
+		t.Fatal("digest ignores moves")
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !noshapefiles
+
+package shapefiles
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// china.orb, countries.orb and water.morb are named so the build fails
+// without them, rather than IsInWater quietly returning false everywhere.
+// water.wbm is derived from water.morb by go generate and embedded with the
+// rest of the directory. Until it is generated, IsInWater tests polygons for
+// every point.
+//
+//go:generate go run ../../cmd/orbfiles -format bitmap -level 13 assets/water.morb assets/water.wbm
+//go:embed assets assets/china.orb assets/countries.orb assets/water.morb
+var _assets embed.FS
+
+func embedded() fs.FS {
+	sub, err := fs.Sub(_assets, "assets")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,11 @@
+//go:build noshapefiles
+
+package shapefiles
+
+import "io/fs"
+
+// embedded returns nil so binaries built with -tags noshapefiles carry no
+// boundaries unless one is loaded with LoadDir and SetDefault
+func embedded() fs.FS {
+	return nil
+}
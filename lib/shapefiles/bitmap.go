@@ -0,0 +1,250 @@
+package shapefiles
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"io"
+	"math"
+	"slices"
+
+	"github.com/paulmach/orb"
+)
+
+// Tile classes stored in a Bitmap
+const (
+	Land  = 0
+	Water = 1
+	// Coast tiles are partially covered and need a polygon test
+	Coast = 2
+)
+
+var bitmapMagic = [4]byte{'W', 'B', 'M', 'P'}
+
+const bitmapVersion = 1
+
+// Bitmap classifies every web mercator tile at one level as land, water or
+// coast using two bits per tile, so level 13 takes 16MiB in memory.
+type Bitmap struct {
+	Level int
+	cells []byte
+}
+
+func NewBitmap(level int) *Bitmap {
+	n := 1 << level
+	return &Bitmap{
+		Level: level,
+		cells: make([]byte, (n*n+3)/4),
+	}
+}
+
+func (b *Bitmap) At(x, y int) int {
+	i := y<<b.Level + x
+	return int(b.cells[i>>2]>>((i&3)*2)) & 3
+}
+
+func (b *Bitmap) Set(x, y, class int) {
+	i := y<<b.Level + x
+	shift := (i & 3) * 2
+	b.cells[i>>2] = b.cells[i>>2]&^(3<<shift) | byte(class)<<shift
+}
+
+// Classify returns the class of the tile containing the point
+func (b *Bitmap) Classify(lat, lon float64) int {
+	x, y := TileFromWGS84(b.Level)(orb.Point{lon, lat})
+	n := 1 << b.Level
+	return b.At(clampTile(x, n), clampTile(y, n))
+}
+
+// clampTile also handles the infinities produced at the poles
+func clampTile(v float64, n int) int {
+	if !(v >= 0) {
+		return 0
+	}
+	if v >= float64(n) {
+		return n - 1
+	}
+	return int(v)
+}
+
+// TileFromWGS84 projects longitude/latitude onto fractional tile coordinates
+func TileFromWGS84(level int) func(orb.Point) (float64, float64) {
+	n := float64(int(1) << level)
+	return func(p orb.Point) (float64, float64) {
+		lat := p.Lat() * math.Pi / 180
+		x := (p.Lon() + 180) / 360 * n
+		y := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * n
+		return x, y
+	}
+}
+
+// TileFromMercator projects EPSG:3857 metres onto fractional tile coordinates
+func TileFromMercator(level int) func(orb.Point) (float64, float64) {
+	n := float64(int(1) << level)
+	circumference := 2 * math.Pi * orb.EarthRadius
+	return func(p orb.Point) (float64, float64) {
+		return (p.X()/circumference + 0.5) * n, (0.5 - p.Y()/circumference) * n
+	}
+}
+
+// RasterizeWater builds a bitmap from water polygons. Every tile crossed by a
+// ring is coast, and the rest are filled by even-odd scanlines through the
+// centre of each row of tiles.
+func RasterizeWater(polygons []orb.Polygon, level int, project func(orb.Point) (float64, float64)) *Bitmap {
+	b := NewBitmap(level)
+	n := 1 << level
+	crossings := make([][]float64, n)
+	var coast [][2]int
+	for _, poly := range polygons {
+		for _, ring := range poly {
+			for i := 0; i+1 < len(ring); i++ {
+				x0, y0 := project(ring[i])
+				x1, y1 := project(ring[i+1])
+				if math.IsNaN(y0) || math.IsNaN(y1) || math.IsInf(y0, 0) || math.IsInf(y1, 0) {
+					continue
+				}
+				coast = traverse(coast, x0, y0, x1, y1, n)
+				lo, hi := math.Min(y0, y1), math.Max(y0, y1)
+				first := max(int(math.Ceil(lo-0.5)), 0)
+				for row := first; row < n && float64(row)+0.5 < hi; row++ {
+					yc := float64(row) + 0.5
+					crossings[row] = append(crossings[row], x0+(yc-y0)*(x1-x0)/(y1-y0))
+				}
+			}
+		}
+	}
+	for row, xs := range crossings {
+		slices.Sort(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			first := max(int(math.Ceil(xs[i]-0.5)), 0)
+			for col := first; col < n && float64(col)+0.5 < xs[i+1]; col++ {
+				b.Set(col, row, Water)
+			}
+		}
+	}
+	for _, c := range coast {
+		b.Set(c[0], c[1], Coast)
+	}
+	return b
+}
+
+// traverse appends every tile touched by the segment (Amanatides and Woo)
+func traverse(tiles [][2]int, x0, y0, x1, y1 float64, n int) [][2]int {
+	x, y := clampTile(x0, n), clampTile(y0, n)
+	endX, endY := clampTile(x1, n), clampTile(y1, n)
+	dx, dy := x1-x0, y1-y0
+	stepX, stepY := 1, 1
+	if dx < 0 {
+		stepX = -1
+	}
+	if dy < 0 {
+		stepY = -1
+	}
+	next := func(pos, d float64, cell, step int) (float64, float64) {
+		if d == 0 {
+			return math.Inf(1), math.Inf(1)
+		}
+		boundary := float64(cell)
+		if step > 0 {
+			boundary++
+		}
+		return (boundary - pos) / d, float64(step) / d
+	}
+	tMaxX, tDeltaX := next(x0, dx, x, stepX)
+	tMaxY, tDeltaY := next(y0, dy, y, stepY)
+	tiles = append(tiles, [2]int{x, y})
+	steps := abs(endX-x) + abs(endY-y)
+	for range steps {
+		if y == endY || (x != endX && tMaxX < tMaxY) {
+			x += stepX
+			tMaxX += tDeltaX
+		} else {
+			y += stepY
+			tMaxY += tDeltaY
+		}
+		tiles = append(tiles, [2]int{x, y})
+	}
+	return tiles
+}
+
+// WriteTo stores the bitmap as a small header followed by deflated cells
+func (b *Bitmap) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, 6)
+	copy(header, bitmapMagic[:])
+	header[4] = bitmapVersion
+	header[5] = byte(b.Level)
+	if _, err := w.Write(header); err != nil {
+		return 0, err
+	}
+	counter := &countingWriter{w: w, n: int64(len(header))}
+	fw, err := flate.NewWriter(counter, flate.BestCompression)
+	if err != nil {
+		return counter.n, err
+	}
+	if _, err := fw.Write(b.cells); err != nil {
+		return counter.n, err
+	}
+	err = fw.Close()
+	return counter.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func ReadBitmap(r io.Reader) (*Bitmap, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+	if [4]byte(header[:4]) != bitmapMagic {
+		return nil, errors.New("not a water bitmap")
+	}
+	if header[4] != bitmapVersion {
+		return nil, errors.New("unsupported water bitmap version")
+	}
+	level := int(header[5])
+	if level > 16 {
+		return nil, errors.New("water bitmap level too high")
+	}
+	b := NewBitmap(level)
+	fr := flate.NewReader(br)
+	defer fr.Close()
+	if _, err := io.ReadFull(fr, b.cells); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Coverage counts the tiles of each class
+func (b *Bitmap) Coverage() (land, water, coast int) {
+	n := 1 << b.Level
+	for y := range n {
+		for x := range n {
+			switch b.At(x, y) {
+			case Land:
+				land++
+			case Water:
+				water++
+			case Coast:
+				coast++
+			}
+		}
+	}
+	return
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
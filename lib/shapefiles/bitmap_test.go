@@ -0,0 +1,85 @@
+package shapefiles_test
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/shapefiles"
+	"github.com/paulmach/orb"
+)
+
+func TestRasterizeWater(t *testing.T) {
+	// A lake spanning tiles 8-16 on both axes at level 5 with an island
+	lake := orb.Polygon{
+		{{-90, 66}, {0, 66}, {0, -10}, {-90, -10}, {-90, 66}},
+		{{-50, 30}, {-40, 30}, {-40, 20}, {-50, 20}, {-50, 30}},
+	}
+	b := shapefiles.RasterizeWater([]orb.Polygon{lake}, 5, shapefiles.TileFromWGS84(5))
+	cases := []struct {
+		lat, lon float64
+		class    int
+	}{
+		{50, 120, shapefiles.Land},
+		{-60, -45, shapefiles.Land},
+		{55, -30, shapefiles.Water},
+		{5, -70, shapefiles.Water},
+		{66, -45, shapefiles.Coast},
+		{25, -45, shapefiles.Coast},
+		{20, 0, shapefiles.Coast},
+	}
+	for _, c := range cases {
+		if got := b.Classify(c.lat, c.lon); got != c.class {
+			t.Errorf("(%v, %v): got class %d, want %d", c.lat, c.lon, got, c.class)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := shapefiles.ReadBitmap(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	land, water, coast := b.Coverage()
+	l, w, c := read.Coverage()
+	if read.Level != 5 || l != land || w != water || c != coast {
+		t.Fatalf("round trip changed coverage: %d/%d/%d vs %d/%d/%d", l, w, c, land, water, coast)
+	}
+}
+
+func randomPoints(n int) []orb.Point {
+	r := rand.New(rand.NewPCG(1, 2))
+	points := make([]orb.Point, n)
+	for i := range points {
+		points[i] = orb.Point{r.Float64()*360 - 180, r.Float64()*170 - 85}
+	}
+	return points
+}
+
+func benchmarkIsInWater(b *testing.B, d *shapefiles.Dataset) {
+	points := randomPoints(1024)
+	b.ResetTimer()
+	for i := range b.N {
+		p := points[i%len(points)]
+		d.IsInWater(p.Lat(), p.Lon())
+	}
+}
+
+func BenchmarkIsInWaterPolygons(b *testing.B) {
+	benchmarkIsInWater(b, &shapefiles.Dataset{Waters: shapefiles.Default().Waters})
+}
+
+func BenchmarkIsInWaterBitmap(b *testing.B) {
+	d := shapefiles.Default()
+	bitmap := d.WaterBitmap
+	if bitmap == nil {
+		var polygons []orb.Polygon
+		for _, p := range d.Waters {
+			polygons = append(polygons, p...)
+		}
+		bitmap = shapefiles.RasterizeWater(polygons, 10, shapefiles.TileFromMercator(10))
+	}
+	benchmarkIsInWater(b, &shapefiles.Dataset{Waters: d.Waters, WaterBitmap: bitmap})
+}
@@ -1,10 +1,9 @@
 package shapefiles
 
-import (
-	"github.com/paulmach/orb"
-	"github.com/paulmach/orb/planar"
-)
+func (d *Dataset) IsInChina(lat, lon float64) bool {
+	return d.Region(lat, lon) == "CN"
+}
 
 func IsInChina(lat, lon float64) bool {
-	return planar.MultiPolygonContains(China, orb.Point{lon, lat})
+	return Default().IsInChina(lat, lon)
 }
@@ -0,0 +1,109 @@
+package shapefiles
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/paulmach/orb"
+)
+
+// Dataset holds the boundaries used for water and region detection. Every
+// file is optional, so a dataset may only cover some of the checks.
+type Dataset struct {
+	// China is read from china.orb
+	China []orb.Polygon
+	// Waters is read from water.morb
+	Waters map[int64][]orb.Polygon
+	// Countries is read from countries.orb and maps ISO 3166-1 alpha-2 codes
+	// to their boundaries
+	Countries map[string][]orb.Polygon
+	// WaterBitmap is read from water.wbm
+	WaterBitmap *Bitmap
+
+	indexOnce    sync.Once
+	countryIndex []country
+}
+
+// LoadDataset reads china.orb, water.morb, countries.orb and water.wbm from
+// the root of fsys, skipping any that do not exist. A warning is logged when
+// there is no water data at all.
+func LoadDataset(fsys fs.FS) (*Dataset, error) {
+	d := &Dataset{
+		Countries: make(map[string][]orb.Polygon),
+	}
+	if fsys == nil {
+		return d, nil
+	}
+	for name, v := range map[string]any{
+		"china.orb":     &d.China,
+		"water.morb":    &d.Waters,
+		"countries.orb": &d.Countries,
+	} {
+		b, err := fs.ReadFile(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(v); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	f, err := fsys.Open("water.wbm")
+	if err == nil {
+		d.WaterBitmap, err = ReadBitmap(f)
+		f.Close()
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("water.wbm: %w", err)
+	}
+	if d.Waters == nil && d.WaterBitmap == nil {
+		slog.Warn("shapefiles dataset has neither water.morb nor water.wbm, so nothing is considered water")
+	}
+	// The curated China boundary excludes the special administrative regions,
+	// which is what decides the endpoint, so it always takes precedence
+	if d.China != nil {
+		d.Countries["CN"] = d.China
+	}
+	return d, nil
+}
+
+// LoadDir reads a dataset from a directory laid out like assets/
+func LoadDir(path string) (*Dataset, error) {
+	return LoadDataset(os.DirFS(path))
+}
+
+var (
+	defaultDataset atomic.Pointer[Dataset]
+	defaultOnce    sync.Once
+)
+
+// Default returns the dataset set by SetDefault, decoding the embedded assets
+// on first use. Builds with the noshapefiles tag embed nothing, so region
+// detection always returns "" and nothing is considered water.
+func Default() *Dataset {
+	if d := defaultDataset.Load(); d != nil {
+		return d
+	}
+	defaultOnce.Do(func() {
+		d, err := LoadDataset(embedded())
+		if err != nil {
+			panic(err)
+		}
+		defaultDataset.CompareAndSwap(nil, d)
+	})
+	return defaultDataset.Load()
+}
+
+// SetDefault replaces the dataset used by the package level functions
+func SetDefault(d *Dataset) {
+	defaultDataset.Store(d)
+}
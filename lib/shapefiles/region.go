@@ -0,0 +1,54 @@
+package shapefiles
+
+import (
+	"sort"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+type country struct {
+	code     string
+	bound    orb.Bound
+	polygons []orb.Polygon
+}
+
+func (d *Dataset) indexCountries() {
+	d.countryIndex = make([]country, 0, len(d.Countries))
+	for code, polygons := range d.Countries {
+		if len(polygons) == 0 {
+			continue
+		}
+		d.countryIndex = append(d.countryIndex, country{
+			code:     code,
+			bound:    orb.MultiPolygon(polygons).Bound(),
+			polygons: polygons,
+		})
+	}
+	// Check smaller countries first so enclaves win over the surrounding country
+	sort.Slice(d.countryIndex, func(i, j int) bool {
+		return area(d.countryIndex[i].bound) < area(d.countryIndex[j].bound)
+	})
+}
+
+func area(b orb.Bound) float64 {
+	return (b.Max.X() - b.Min.X()) * (b.Max.Y() - b.Min.Y())
+}
+
+// Region returns the ISO 3166-1 alpha-2 code of the country containing the
+// point, or an empty string when it is not covered by any known boundary.
+func (d *Dataset) Region(lat, lon float64) string {
+	d.indexOnce.Do(d.indexCountries)
+	p := orb.Point{lon, lat}
+	for _, c := range d.countryIndex {
+		if c.bound.Contains(p) && planar.MultiPolygonContains(c.polygons, p) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// Region looks the point up in the default dataset
+func Region(lat, lon float64) string {
+	return Default().Region(lat, lon)
+}
@@ -1,3 +1,5 @@
+//go:build !noshapefiles
+
 package shapefiles_test
 
 import (
@@ -76,3 +78,44 @@ func TestWillNeverBeChina(t *testing.T) {
 		t.Fatal("alternate timeline")
 	}
 }
+
+func TestRegion(t *testing.T) {
+	cases := []struct {
+		name     string
+		lat, lon float64
+		want     string
+	}{
+		{"Beijing", 39.916668, 116.383331, "CN"},
+		{"Taipei", 25.033, 121.565, "TW"},
+		{"Tokyo", 35.6895, 139.6917, "JP"},
+		{"Paris", 48.8566, 2.3522, "FR"},
+		{"Berlin", 52.52, 13.405, "DE"},
+		{"Kansas City", 39.0997, -94.5786, "US"},
+		{"Brasilia", -15.7939, -47.8828, "BR"},
+		{"Nairobi", -1.2921, 36.8219, "KE"},
+		{"Alice Springs", -23.698, 133.8807, "AU"},
+		// The curated China boundary leaves out Hong Kong, which uses the
+		// international endpoint
+		{"Hong Kong", 22.338401, 114.165277, ""},
+		{"North Pacific", 32.890398, 146.864834, ""},
+		{"South Atlantic", -30, -15, ""},
+	}
+	for _, c := range cases {
+		if got := shapefiles.Region(c.lat, c.lon); got != c.want {
+			t.Errorf("%s is in %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	d, err := shapefiles.LoadDir("assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.IsInChina(39.916668, 116.383331) {
+		t.Fatal("Beijing not in China")
+	}
+	if region := (&shapefiles.Dataset{}).Region(39.916668, 116.383331); region != "" {
+		t.Fatalf("empty dataset returned region %q", region)
+	}
+}
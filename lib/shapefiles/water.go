@@ -10,14 +10,30 @@ import (
 
 const level = 7
 
-func IsInWater(lat, lon float64) bool {
+// IsInWater answers from WaterBitmap when it is loaded and only falls back to
+// the polygons for coast tiles. Without water.wbm every point is tested
+// against the polygons.
+func (d *Dataset) IsInWater(lat, lon float64) bool {
 	if lon == -180 || lon == 180 {
 		return true
 	}
+	if d.WaterBitmap != nil {
+		switch d.WaterBitmap.Classify(lat, lon) {
+		case Land:
+			return false
+		case Water:
+			return true
+		}
+	}
 	merc := project.WGS84.ToMercator(orb.Point{lon, lat})
-	polies, ok := Waters[morton.Encode(lat, lon, level)]
+	polies, ok := d.Waters[morton.Encode(lat, lon, level)]
 	if ok && planar.MultiPolygonContains(polies, merc) {
 		return true
 	}
 	return false
 }
+
+// IsInWater checks the default dataset
+func IsInWater(lat, lon float64) bool {
+	return Default().IsInWater(lat, lon)
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/distance"
+	"github.com/acheong08/apple-corelocation-experiments/lib/geo/datum"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+)
+
+// Regions recorded for each observation
+const (
+	International = "international"
+	China         = "china"
+)
+
+// TileLevel is the morton level of the tile_key columns, matching Apple's
+// wifi tiles
+const TileLevel = 13
+
+type AP struct {
+	BSSID    mac.Addr
+	Lat, Lon float64
+	TileKey  int64
+	Region   string
+	// Source is the name of the tool or dataset that last reported the AP
+	Source    string
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// NewAP converts a query result seen at the given time, converting China
+// endpoint results to WGS-84
+func NewAP(ap lib.AP, seen time.Time) AP {
+	region := International
+	if ap.Location.Datum == datum.GCJ02 {
+		region = China
+	}
+	loc := ap.Location.ToWGS84()
+	return AP{
+		BSSID:     ap.BSSID,
+		Lat:       loc.Lat,
+		Lon:       loc.Long,
+		TileKey:   morton.Encode(loc.Lat, loc.Long, TileLevel),
+		Region:    region,
+		FirstSeen: seen,
+		LastSeen:  seen,
+	}
+}
+
+// NewAPs converts a batch of results seen now
+func NewAPs(aps []lib.AP) []AP {
+	now := time.Now()
+	out := make([]AP, len(aps))
+	for i, ap := range aps {
+		out[i] = NewAP(ap, now)
+	}
+	return out
+}
+
+// APWriter upserts access points. An AP reported at a new position is moved,
+// and the previous position is kept in ap_moves. Observations older than the
+// stored one are ignored.
+func (s *Store) APWriter(source string) *Writer[AP] {
+	return newWriter(s, source, func(ctx context.Context, tx *sql.Tx, sourceID int64, rows []AP) error {
+		stmt, err := tx.PrepareContext(ctx, `
+			INSERT INTO aps (bssid, lat, lon, tile_key, region, source_id, first_seen, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (bssid) DO UPDATE SET
+				lat = excluded.lat,
+				lon = excluded.lon,
+				tile_key = excluded.tile_key,
+				region = excluded.region,
+				source_id = excluded.source_id,
+				last_seen = excluded.last_seen
+			WHERE excluded.last_seen >= aps.last_seen`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, ap := range rows {
+			if ap.TileKey == 0 {
+				ap.TileKey = morton.Encode(ap.Lat, ap.Lon, TileLevel)
+			}
+			if ap.Region == "" {
+				ap.Region = International
+			}
+			if ap.FirstSeen.IsZero() {
+				ap.FirstSeen = ap.LastSeen
+			}
+			if _, err := stmt.ExecContext(ctx, int64(ap.BSSID), ap.Lat, ap.Lon, ap.TileKey, ap.Region,
+				sourceID, ap.FirstSeen.Unix(), ap.LastSeen.Unix()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+const apColumns = `aps.bssid, aps.lat, aps.lon, aps.tile_key, aps.region, sources.name, aps.first_seen, aps.last_seen
+	FROM aps JOIN sources ON sources.id = aps.source_id`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAP(row scanner) (AP, error) {
+	var ap AP
+	var bssid, firstSeen, lastSeen int64
+	err := row.Scan(&bssid, &ap.Lat, &ap.Lon, &ap.TileKey, &ap.Region, &ap.Source, &firstSeen, &lastSeen)
+	ap.BSSID = mac.Addr(bssid)
+	ap.FirstSeen = time.Unix(firstSeen, 0)
+	ap.LastSeen = time.Unix(lastSeen, 0)
+	return ap, err
+}
+
+// AP returns the stored position of an access point
+func (s *Store) AP(ctx context.Context, bssid mac.Addr) (AP, error) {
+	ap, err := scanAP(s.db.QueryRowContext(ctx, "SELECT "+apColumns+" WHERE aps.bssid = ?", int64(bssid)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ap, ErrNotFound
+	}
+	return ap, err
+}
+
+// APsInTile returns every access point in a level 13 tile
+func (s *Store) APsInTile(ctx context.Context, tileKey int64) ([]AP, error) {
+	var aps []AP
+	err := s.eachAP(ctx, func(ap AP) error {
+		aps = append(aps, ap)
+		return nil
+	}, "SELECT "+apColumns+" WHERE aps.tile_key = ?", tileKey)
+	return aps, err
+}
+
+// EachAP calls fn for every access point until it returns an error
+func (s *Store) EachAP(ctx context.Context, fn func(AP) error) error {
+	return s.eachAP(ctx, fn, "SELECT "+apColumns)
+}
+
+// EachTileSeed calls fn with one access point from every tile, in tile order,
+// which is what the crawlers use to start exploring an area
+func (s *Store) EachTileSeed(ctx context.Context, fn func(AP) error) error {
+	return s.eachAP(ctx, fn, "SELECT "+apColumns+" WHERE aps.rowid IN (SELECT MIN(rowid) FROM aps GROUP BY tile_key) ORDER BY aps.tile_key")
+}
+
+func (s *Store) eachAP(ctx context.Context, fn func(AP) error, query string, args ...any) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ap, err := scanAP(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(ap); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// CountAPs returns the number of access points stored
+func (s *Store) CountAPs(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM aps").Scan(&n)
+	return n, err
+}
+
+// Index loads every access point into a spatial index keyed by BSSID
+func (s *Store) Index(ctx context.Context) (*distance.Index, error) {
+	var points []distance.Point
+	err := s.EachAP(ctx, func(ap AP) error {
+		points = append(points, distance.Point{Id: ap.BSSID.String(), X: ap.Lon, Y: ap.Lat})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return distance.NewIndex(points), nil
+}
+
+// Move is a change of position recorded when an AP was reported elsewhere
+type Move struct {
+	BSSID          mac.Addr
+	OldLat, OldLon float64
+	NewLat, NewLon float64
+	MovedAt        time.Time
+}
+
+// Moves returns the position history of an access point, oldest first
+func (s *Store) Moves(ctx context.Context, bssid mac.Addr) ([]Move, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT old_lat, old_lon, new_lat, new_lon, moved_at
+		FROM ap_moves WHERE bssid = ? ORDER BY moved_at, id`, int64(bssid))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var moves []Move
+	for rows.Next() {
+		m := Move{BSSID: bssid}
+		var movedAt int64
+		if err := rows.Scan(&m.OldLat, &m.OldLon, &m.NewLat, &m.NewLon, &movedAt); err != nil {
+			return nil, err
+		}
+		m.MovedAt = time.Unix(movedAt, 0)
+		moves = append(moves, m)
+	}
+	return moves, rows.Err()
+}
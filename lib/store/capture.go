@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/capture"
+)
+
+// SaveCaptures stores decoded exchanges, skipping any already imported. It
+// returns how many were new.
+func (s *Store) SaveCaptures(ctx context.Context, exchanges []capture.Exchange) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT OR IGNORE INTO captures
+		(digest, captured_at, endpoint, method, url, status, app, os_version, locale, tile_key, request, response, errors)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	added := 0
+	for _, e := range exchanges {
+		request, err := capture.MessageJSON(e.Request)
+		if err != nil {
+			return added, err
+		}
+		response, err := capture.MessageJSON(e.Response)
+		if err != nil {
+			return added, err
+		}
+		errs, err := json.Marshal(append([]string{}, e.Errors...))
+		if err != nil {
+			return added, err
+		}
+		var app, osVersion, locale string
+		if e.Arpc != nil {
+			app, osVersion, locale = e.Arpc.AppIdentifier, e.Arpc.OsVersion, e.Arpc.Locale
+		}
+		var tileKey sql.NullInt64
+		if e.Endpoint == capture.Tile {
+			tileKey = sql.NullInt64{Int64: e.TileKey, Valid: true}
+		}
+		res, err := stmt.ExecContext(ctx, captureDigest(e), e.Time.Unix(), string(e.Endpoint), e.Method, e.URL, e.Status,
+			app, osVersion, locale, tileKey, nullText(request), nullText(response), string(errs))
+		if err != nil {
+			return added, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			added++
+		}
+	}
+	return added, tx.Commit()
+}
+
+// captureDigest identifies an exchange by its time, URL and bodies
+func captureDigest(e capture.Exchange) int64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, e.Time.UnixNano())
+	for _, b := range [][]byte{[]byte(e.URL), e.RequestBody, e.ResponseBody} {
+		binary.Write(h, binary.BigEndian, uint64(len(b)))
+		h.Write(b)
+	}
+	return int64(h.Sum64())
+}
+
+func nullText(b []byte) sql.NullString {
+	return sql.NullString{String: string(b), Valid: b != nil}
+}
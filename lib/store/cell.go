@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/geo/datum"
+	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+)
+
+type Cell struct {
+	Tower     lib.TowerInfo
+	Lat, Lon  float64
+	TileKey   int64
+	Region    string
+	Source    string
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// NewCell converts a query result seen at the given time
+func NewCell(c lib.Cell, seen time.Time) Cell {
+	region := International
+	if c.Location.Datum == datum.GCJ02 {
+		region = China
+	}
+	loc := c.Location.ToWGS84()
+	return Cell{
+		Tower:     c.Tower,
+		Lat:       loc.Lat,
+		Lon:       loc.Long,
+		TileKey:   morton.Encode(loc.Lat, loc.Long, TileLevel),
+		Region:    region,
+		FirstSeen: seen,
+		LastSeen:  seen,
+	}
+}
+
+// CellWriter upserts cell towers, keeping the newest position
+func (s *Store) CellWriter(source string) *Writer[Cell] {
+	return newWriter(s, source, func(ctx context.Context, tx *sql.Tx, sourceID int64, rows []Cell) error {
+		stmt, err := tx.PrepareContext(ctx, `
+			INSERT INTO cells (mcc, mnc, cell_id, tac, lat, lon, tile_key, region, source_id, first_seen, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (mcc, mnc, cell_id, tac) DO UPDATE SET
+				lat = excluded.lat,
+				lon = excluded.lon,
+				tile_key = excluded.tile_key,
+				region = excluded.region,
+				source_id = excluded.source_id,
+				last_seen = excluded.last_seen
+			WHERE excluded.last_seen >= cells.last_seen`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, c := range rows {
+			if c.TileKey == 0 {
+				c.TileKey = morton.Encode(c.Lat, c.Lon, TileLevel)
+			}
+			if c.Region == "" {
+				c.Region = International
+			}
+			if c.FirstSeen.IsZero() {
+				c.FirstSeen = c.LastSeen
+			}
+			if _, err := stmt.ExecContext(ctx, c.Tower.Mcc, c.Tower.Mnc, c.Tower.CellId, c.Tower.TacId,
+				c.Lat, c.Lon, c.TileKey, c.Region, sourceID, c.FirstSeen.Unix(), c.LastSeen.Unix()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+const cellColumns = `cells.mcc, cells.mnc, cells.cell_id, cells.tac, cells.lat, cells.lon, cells.tile_key,
+	cells.region, sources.name, cells.first_seen, cells.last_seen
+	FROM cells JOIN sources ON sources.id = cells.source_id`
+
+func scanCell(row scanner) (Cell, error) {
+	var c Cell
+	var firstSeen, lastSeen int64
+	err := row.Scan(&c.Tower.Mcc, &c.Tower.Mnc, &c.Tower.CellId, &c.Tower.TacId, &c.Lat, &c.Lon, &c.TileKey,
+		&c.Region, &c.Source, &firstSeen, &lastSeen)
+	c.FirstSeen = time.Unix(firstSeen, 0)
+	c.LastSeen = time.Unix(lastSeen, 0)
+	return c, err
+}
+
+// Cell returns the stored position of a tower
+func (s *Store) Cell(ctx context.Context, tower lib.TowerInfo) (Cell, error) {
+	c, err := scanCell(s.db.QueryRowContext(ctx, "SELECT "+cellColumns+
+		" WHERE cells.mcc = ? AND cells.mnc = ? AND cells.cell_id = ? AND cells.tac = ?",
+		tower.Mcc, tower.Mnc, tower.CellId, tower.TacId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+// EachCell calls fn for every tower until it returns an error
+func (s *Store) EachCell(ctx context.Context, fn func(Cell) error) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+cellColumns)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanCell(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
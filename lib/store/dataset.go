@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+)
+
+// Names of the public datasets that can be imported
+const (
+	WiGLE      = "wigle"
+	Kismet     = "kismet"
+	OpenCellID = "opencellid"
+	MLS        = "mls"
+)
+
+// Dataset rows use the same AP and Cell types but are kept in their own
+// tables, one row per source, so importing a dataset never overwrites what
+// Apple returned. Their Region is always empty.
+
+// DatasetAPWriter upserts access points from a public dataset, keeping the
+// newest position reported by that source
+func (s *Store) DatasetAPWriter(source string) *Writer[AP] {
+	return newWriter(s, source, func(ctx context.Context, tx *sql.Tx, sourceID int64, rows []AP) error {
+		stmt, err := tx.PrepareContext(ctx, `
+			INSERT INTO dataset_aps (source_id, bssid, lat, lon, tile_key, first_seen, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (source_id, bssid) DO UPDATE SET
+				lat = excluded.lat,
+				lon = excluded.lon,
+				tile_key = excluded.tile_key,
+				first_seen = MIN(dataset_aps.first_seen, excluded.first_seen),
+				last_seen = excluded.last_seen
+			WHERE excluded.last_seen >= dataset_aps.last_seen`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, ap := range rows {
+			if ap.TileKey == 0 {
+				ap.TileKey = morton.Encode(ap.Lat, ap.Lon, TileLevel)
+			}
+			if ap.FirstSeen.IsZero() {
+				ap.FirstSeen = ap.LastSeen
+			}
+			if _, err := stmt.ExecContext(ctx, sourceID, int64(ap.BSSID), ap.Lat, ap.Lon, ap.TileKey,
+				ap.FirstSeen.Unix(), ap.LastSeen.Unix()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DatasetCellWriter upserts cell towers from a public dataset
+func (s *Store) DatasetCellWriter(source string) *Writer[Cell] {
+	return newWriter(s, source, func(ctx context.Context, tx *sql.Tx, sourceID int64, rows []Cell) error {
+		stmt, err := tx.PrepareContext(ctx, `
+			INSERT INTO dataset_cells (source_id, mcc, mnc, cell_id, tac, lat, lon, tile_key, first_seen, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (source_id, mcc, mnc, cell_id, tac) DO UPDATE SET
+				lat = excluded.lat,
+				lon = excluded.lon,
+				tile_key = excluded.tile_key,
+				first_seen = MIN(dataset_cells.first_seen, excluded.first_seen),
+				last_seen = excluded.last_seen
+			WHERE excluded.last_seen >= dataset_cells.last_seen`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, c := range rows {
+			if c.TileKey == 0 {
+				c.TileKey = morton.Encode(c.Lat, c.Lon, TileLevel)
+			}
+			if c.FirstSeen.IsZero() {
+				c.FirstSeen = c.LastSeen
+			}
+			if _, err := stmt.ExecContext(ctx, sourceID, c.Tower.Mcc, c.Tower.Mnc, c.Tower.CellId, c.Tower.TacId,
+				c.Lat, c.Lon, c.TileKey, c.FirstSeen.Unix(), c.LastSeen.Unix()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+const datasetAPColumns = `d.bssid, d.lat, d.lon, d.tile_key, '', sources.name, d.first_seen, d.last_seen
+	FROM dataset_aps d JOIN sources ON sources.id = d.source_id`
+
+const datasetCellColumns = `d.mcc, d.mnc, d.cell_id, d.tac, d.lat, d.lon, d.tile_key, '', sources.name, d.first_seen, d.last_seen
+	FROM dataset_cells d JOIN sources ON sources.id = d.source_id`
+
+// EachDatasetAP calls fn for every access point imported from source
+func (s *Store) EachDatasetAP(ctx context.Context, source string, fn func(AP) error) error {
+	return s.eachAP(ctx, fn, "SELECT "+datasetAPColumns+" WHERE sources.name = ?", source)
+}
+
+// EachDatasetCell calls fn for every tower imported from source
+func (s *Store) EachDatasetCell(ctx context.Context, source string, fn func(Cell) error) error {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+datasetCellColumns+" WHERE sources.name = ?", source)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanCell(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// CompareAPs calls fn with every access point from source that Apple also
+// returned, alongside Apple's position
+func (s *Store) CompareAPs(ctx context.Context, source string, fn func(dataset, apple AP) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT d.bssid, d.lat, d.lon, d.tile_key, d.first_seen, d.last_seen,
+			aps.lat, aps.lon, aps.tile_key, aps.region, aps.first_seen, aps.last_seen
+		FROM dataset_aps d
+		JOIN sources ON sources.id = d.source_id
+		JOIN aps ON aps.bssid = d.bssid
+		WHERE sources.name = ?`, source)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bssid, dFirst, dLast, aFirst, aLast int64
+		d := AP{Source: source}
+		var a AP
+		if err := rows.Scan(&bssid, &d.Lat, &d.Lon, &d.TileKey, &dFirst, &dLast,
+			&a.Lat, &a.Lon, &a.TileKey, &a.Region, &aFirst, &aLast); err != nil {
+			return err
+		}
+		d.BSSID = mac.Addr(bssid)
+		a.BSSID = d.BSSID
+		d.FirstSeen, d.LastSeen = time.Unix(dFirst, 0), time.Unix(dLast, 0)
+		a.FirstSeen, a.LastSeen = time.Unix(aFirst, 0), time.Unix(aLast, 0)
+		if err := fn(d, a); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
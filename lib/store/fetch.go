@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+	"github.com/acheong08/apple-corelocation-experiments/lib/shapefiles"
+)
+
+// Fetch is one request for a wifi tile
+type Fetch struct {
+	TileKey int64
+	Region  string
+	// Status is the HTTP status, or 0 when no response was received
+	Status    int
+	APCount   int
+	FetchedAt time.Time
+}
+
+// NewFetch records the outcome of lib.GetTile with automatic region selection
+func NewFetch(tileKey int64, aps []lib.AP, err error) Fetch {
+	region := International
+	if shapefiles.IsInChina(morton.Centre(tileKey)) {
+		region = China
+	}
+	f := Fetch{
+		TileKey:   tileKey,
+		Region:    region,
+		Status:    200,
+		APCount:   len(aps),
+		FetchedAt: time.Now(),
+	}
+	if err != nil {
+		f.Status = 0
+		var status *lib.StatusError
+		if errors.As(err, &status) {
+			f.Status = status.Code
+		}
+	}
+	return f
+}
+
+// FetchWriter appends to the tile fetch log
+func (s *Store) FetchWriter() *Writer[Fetch] {
+	return newWriter(s, "", func(ctx context.Context, tx *sql.Tx, _ int64, rows []Fetch) error {
+		stmt, err := tx.PrepareContext(ctx, `INSERT INTO tile_fetches (tile_key, region, status, ap_count, fetched_at)
+			VALUES (?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, f := range rows {
+			if f.Region == "" {
+				f.Region = International
+			}
+			if _, err := stmt.ExecContext(ctx, f.TileKey, f.Region, f.Status, f.APCount, f.FetchedAt.Unix()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LastFetch returns the most recent request for a tile
+func (s *Store) LastFetch(ctx context.Context, tileKey int64) (Fetch, error) {
+	f := Fetch{TileKey: tileKey}
+	var fetchedAt int64
+	err := s.db.QueryRowContext(ctx, `SELECT region, status, ap_count, fetched_at FROM tile_fetches
+		WHERE tile_key = ? ORDER BY fetched_at DESC, id DESC LIMIT 1`, tileKey).
+		Scan(&f.Region, &f.Status, &f.APCount, &fetchedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return f, ErrNotFound
+	}
+	f.FetchedAt = time.Unix(fetchedAt, 0)
+	return f, err
+}
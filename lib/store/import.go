@@ -0,0 +1,317 @@
+package store
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+)
+
+// openDataset opens a dataset file, decompressing it if it is gzipped as
+// most public dumps are
+func openDataset(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{zr, f}, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, f}, nil
+}
+
+// ImportDataset reads a WiGLE CSV, Kismet log or OpenCellID/MLS cell export,
+// telling them apart by content. Cell exports are recorded under source,
+// which should be OpenCellID or MLS as the two share a format.
+func (s *Store) ImportDataset(ctx context.Context, path, source string) (int, error) {
+	if strings.HasSuffix(path, ".kismet") {
+		return s.ImportKismet(ctx, path)
+	}
+	r, err := openDataset(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	br := bufio.NewReader(r)
+	if first, _ := br.Peek(len("WigleWifi")); string(first) == "WigleWifi" {
+		return s.importWiGLE(ctx, br)
+	}
+	return s.importCellCSV(ctx, br, source)
+}
+
+// ImportWiGLE reads a WiGLE app export. Wi-Fi networks become access points
+// and GSM, WCDMA, LTE and NR sightings become cells. Each row is a sighting,
+// so the newest position of a network wins.
+func (s *Store) ImportWiGLE(ctx context.Context, path string) (int, error) {
+	r, err := openDataset(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return s.importWiGLE(ctx, r)
+}
+
+func (s *Store) importWiGLE(ctx context.Context, in io.Reader) (int, error) {
+	br := bufio.NewReader(in)
+	// The first line holds the format version and device details
+	pre, err := br.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(pre, "WigleWifi") {
+		return 0, errors.New("not a WiGLE CSV export")
+	}
+	reader := csv.NewReader(br)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err != nil {
+		return 0, err
+	}
+	col, err := columnIndex(header, "MAC", "FirstSeen", "CurrentLatitude", "CurrentLongitude", "Type")
+	if err != nil {
+		return 0, err
+	}
+
+	aps := s.DatasetAPWriter(WiGLE)
+	cells := s.DatasetCellWriter(WiGLE)
+	n := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		if len(record) < len(header) {
+			continue
+		}
+		lat, errLat := strconv.ParseFloat(record[col["CurrentLatitude"]], 64)
+		lon, errLon := strconv.ParseFloat(record[col["CurrentLongitude"]], 64)
+		if errLat != nil || errLon != nil || !validPosition(lat, lon) {
+			continue
+		}
+		// WiGLE writes the device's local time without a zone
+		seen, err := time.Parse(time.DateTime, record[col["FirstSeen"]])
+		if err != nil {
+			continue
+		}
+		switch record[col["Type"]] {
+		case "WIFI":
+			bssid, err := mac.ParseAddr(record[col["MAC"]])
+			if err != nil {
+				continue
+			}
+			err = aps.Add(AP{BSSID: bssid, Lat: lat, Lon: lon, LastSeen: seen})
+			if err != nil {
+				return n, err
+			}
+		case "GSM", "WCDMA", "LTE", "NR":
+			tower, ok := parseWiGLECell(record[col["MAC"]])
+			if !ok {
+				continue
+			}
+			if err := cells.Add(Cell{Tower: tower, Lat: lat, Lon: lon, LastSeen: seen}); err != nil {
+				return n, err
+			}
+		default:
+			// Bluetooth and CDMA
+			continue
+		}
+		n++
+	}
+	if err := aps.Close(); err != nil {
+		return n, err
+	}
+	return n, cells.Close()
+}
+
+// parseWiGLECell reads the MCCMNC_LAC_CID identifiers WiGLE uses as the MAC
+// of cell sightings
+func parseWiGLECell(id string) (lib.TowerInfo, bool) {
+	parts := strings.Split(id, "_")
+	if len(parts) != 3 || len(parts[0]) < 5 || len(parts[0]) > 6 {
+		return lib.TowerInfo{}, false
+	}
+	mcc, err1 := strconv.ParseUint(parts[0][:3], 10, 32)
+	mnc, err2 := strconv.ParseUint(parts[0][3:], 10, 32)
+	tac, err3 := strconv.ParseUint(parts[1], 10, 32)
+	cellID, err4 := strconv.ParseUint(parts[2], 10, 32)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return lib.TowerInfo{}, false
+	}
+	return lib.TowerInfo{Mcc: uint32(mcc), Mnc: uint32(mnc), CellId: uint32(cellID), TacId: uint32(tac)}, true
+}
+
+// ImportKismet reads the access points from a .kismet log, placing each at
+// the average position Kismet saw it from. Devices seen without a GPS fix
+// are skipped.
+func (s *Store) ImportKismet(ctx context.Context, path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	rows, err := db.QueryContext(ctx, `SELECT devmac, first_time, last_time, avg_lat, avg_lon FROM devices
+		WHERE phyname = 'IEEE802.11' AND type = 'Wi-Fi AP'`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	w := s.DatasetAPWriter(Kismet)
+	n := 0
+	for rows.Next() {
+		var devmac string
+		var first, last int64
+		var lat, lon float64
+		if err := rows.Scan(&devmac, &first, &last, &lat, &lon); err != nil {
+			return n, err
+		}
+		if !validPosition(lat, lon) {
+			continue
+		}
+		bssid, err := mac.ParseAddr(devmac)
+		if err != nil {
+			continue
+		}
+		ap := AP{BSSID: bssid, Lat: lat, Lon: lon, FirstSeen: time.Unix(first, 0), LastSeen: time.Unix(last, 0)}
+		if err := w.Add(ap); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// Columns of OpenCellID and MLS cell exports, which older OpenCellID dumps
+// write without a header
+var cellCSVColumns = []string{"radio", "mcc", "net", "area", "cell", "unit", "lon", "lat", "range",
+	"samples", "changeable", "created", "updated", "averageSignal"}
+
+// ImportCellCSV reads an OpenCellID cell_towers.csv or MLS cell export,
+// gzipped or not, recording the cells under source. NR cells with 36 bit
+// identifiers do not fit Apple's schema and are skipped.
+func (s *Store) ImportCellCSV(ctx context.Context, path, source string) (int, error) {
+	r, err := openDataset(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return s.importCellCSV(ctx, r, source)
+}
+
+func (s *Store) importCellCSV(ctx context.Context, in io.Reader, source string) (int, error) {
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	record, err := reader.Read()
+	if err != nil {
+		return 0, err
+	}
+	header := cellCSVColumns
+	if record[0] == "radio" {
+		header = record
+		record = nil
+	}
+	col, err := columnIndex(header, "mcc", "net", "area", "cell", "lon", "lat", "updated")
+	if err != nil {
+		return 0, err
+	}
+	_, hasCreated := col["created"]
+
+	w := s.DatasetCellWriter(source)
+	n := 0
+	for {
+		if record == nil {
+			if record, err = reader.Read(); err == io.EOF {
+				break
+			} else if err != nil {
+				return n, err
+			}
+		}
+		row := record
+		record = nil
+		if len(row) < len(header) {
+			continue
+		}
+		var ids [4]uint64
+		var bad bool
+		for i, name := range []string{"mcc", "net", "area", "cell"} {
+			if ids[i], err = strconv.ParseUint(row[col[name]], 10, 32); err != nil {
+				bad = true
+			}
+		}
+		lat, errLat := strconv.ParseFloat(row[col["lat"]], 64)
+		lon, errLon := strconv.ParseFloat(row[col["lon"]], 64)
+		updated, errUpdated := strconv.ParseInt(row[col["updated"]], 10, 64)
+		if bad || errLat != nil || errLon != nil || errUpdated != nil || !validPosition(lat, lon) {
+			continue
+		}
+		c := Cell{
+			Tower:    lib.TowerInfo{Mcc: uint32(ids[0]), Mnc: uint32(ids[1]), TacId: uint32(ids[2]), CellId: uint32(ids[3])},
+			Lat:      lat,
+			Lon:      lon,
+			LastSeen: time.Unix(updated, 0),
+		}
+		if hasCreated {
+			if created, err := strconv.ParseInt(row[col["created"]], 10, 64); err == nil {
+				c.FirstSeen = time.Unix(created, 0)
+			}
+		}
+		if err := w.Add(c); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, w.Close()
+}
+
+// columnIndex maps the required column names to their position in header
+func columnIndex(header []string, required ...string) (map[string]int, error) {
+	col := make(map[string]int, len(header))
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	for _, name := range required {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+	return col, nil
+}
+
+// validPosition rejects the 0,0 and out of range positions that datasets
+// use for sightings without a fix
+func validPosition(lat, lon float64) bool {
+	return !(lat == 0 && lon == 0) && math.Abs(lat) <= 90 && math.Abs(lon) <= 180
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+)
+
+// Sources of the databases written before the store existed
+var legacyTables = map[string]string{
+	"seeds":          "seedcrawl",
+	"beacons":        "domain-expansion",
+	"current_bssids": "tile-sampler",
+}
+
+// ImportLegacy copies access points from the older per tool databases:
+// seeds.db from seedcrawl, beacons.db from domain-expansion (both with
+// integer BSSIDs and no timestamps, so the file's modification time is used)
+// and bssid_tracking.db from tile-sampler (text BSSIDs with a history in
+// location_changes). It returns the number of access points read.
+func (s *Store) ImportLegacy(ctx context.Context, path string) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	legacy, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer legacy.Close()
+
+	total := 0
+	for table, source := range legacyTables {
+		var exists bool
+		if err := legacy.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&exists); err != nil {
+			return total, err
+		}
+		if !exists {
+			continue
+		}
+		w := s.APWriter(source)
+		var n int
+		if table == "current_bssids" {
+			n, err = importTracking(ctx, legacy, w)
+			if err == nil {
+				err = s.importLocationChanges(ctx, legacy)
+			}
+		} else {
+			n, err = importIntegerTable(ctx, legacy, table, info.ModTime(), w)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return total, nil
+}
+
+func importIntegerTable(ctx context.Context, legacy *sql.DB, table string, seen time.Time, w *Writer[AP]) (int, error) {
+	rows, err := legacy.QueryContext(ctx, "SELECT bssid, lat, lon FROM "+table)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var bssid int64
+		ap := AP{LastSeen: seen}
+		if err := rows.Scan(&bssid, &ap.Lat, &ap.Lon); err != nil {
+			return n, err
+		}
+		ap.BSSID = mac.Addr(bssid)
+		if err := w.Add(ap); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+func importTracking(ctx context.Context, legacy *sql.DB, w *Writer[AP]) (int, error) {
+	rows, err := legacy.QueryContext(ctx, "SELECT bssid, lat, long, last_seen FROM current_bssids")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		var bssid string
+		var ap AP
+		if err := rows.Scan(&bssid, &ap.Lat, &ap.Lon, &ap.LastSeen); err != nil {
+			return n, err
+		}
+		if ap.BSSID, err = mac.ParseAddr(bssid); err != nil {
+			slog.Warn("skipping legacy row", "bssid", bssid, "err", err)
+			continue
+		}
+		if err := w.Add(ap); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+func (s *Store) importLocationChanges(ctx context.Context, legacy *sql.DB) error {
+	rows, err := legacy.QueryContext(ctx, "SELECT bssid, old_lat, old_long, new_lat, new_long, change_time FROM location_changes ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO ap_moves (bssid, old_lat, old_lon, new_lat, new_lon, moved_at)
+		VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for rows.Next() {
+		var bssid string
+		var m Move
+		if err := rows.Scan(&bssid, &m.OldLat, &m.OldLon, &m.NewLat, &m.NewLon, &m.MovedAt); err != nil {
+			return err
+		}
+		if m.BSSID, err = mac.ParseAddr(bssid); err != nil {
+			continue
+		}
+		if _, err := stmt.ExecContext(ctx, int64(m.BSSID), m.OldLat, m.OldLon, m.NewLat, m.NewLon, m.MovedAt.Unix()); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,158 @@
+package store
+
+// migrations are applied in order and the index of the last one applied is
+// kept in PRAGMA user_version. Never edit a released migration, append a new
+// one instead.
+var migrations = []string{
+	// 1: initial schema
+	`
+	CREATE TABLE sources (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE
+	);
+
+	CREATE TABLE aps (
+		bssid INTEGER PRIMARY KEY,
+		lat REAL NOT NULL,
+		lon REAL NOT NULL,
+		-- level 13 morton key of the position
+		tile_key INTEGER NOT NULL,
+		region TEXT NOT NULL,
+		source_id INTEGER NOT NULL REFERENCES sources(id),
+		-- unix seconds
+		first_seen INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL
+	);
+	CREATE INDEX aps_tile_key ON aps (tile_key);
+
+	CREATE TABLE ap_moves (
+		id INTEGER PRIMARY KEY,
+		bssid INTEGER NOT NULL,
+		old_lat REAL NOT NULL,
+		old_lon REAL NOT NULL,
+		new_lat REAL NOT NULL,
+		new_lon REAL NOT NULL,
+		moved_at INTEGER NOT NULL
+	);
+	CREATE INDEX ap_moves_bssid ON ap_moves (bssid);
+
+	CREATE TRIGGER aps_moved AFTER UPDATE OF lat, lon ON aps
+	WHEN old.lat != new.lat OR old.lon != new.lon
+	BEGIN
+		INSERT INTO ap_moves (bssid, old_lat, old_lon, new_lat, new_lon, moved_at)
+		VALUES (old.bssid, old.lat, old.lon, new.lat, new.lon, new.last_seen);
+	END;
+
+	CREATE TABLE cells (
+		mcc INTEGER NOT NULL,
+		mnc INTEGER NOT NULL,
+		cell_id INTEGER NOT NULL,
+		tac INTEGER NOT NULL,
+		lat REAL NOT NULL,
+		lon REAL NOT NULL,
+		tile_key INTEGER NOT NULL,
+		region TEXT NOT NULL,
+		source_id INTEGER NOT NULL REFERENCES sources(id),
+		first_seen INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		PRIMARY KEY (mcc, mnc, cell_id, tac)
+	);
+	CREATE INDEX cells_tile_key ON cells (tile_key);
+
+	CREATE TABLE tile_fetches (
+		id INTEGER PRIMARY KEY,
+		tile_key INTEGER NOT NULL,
+		region TEXT NOT NULL,
+		-- HTTP status, or 0 when the request failed before a response
+		status INTEGER NOT NULL,
+		ap_count INTEGER NOT NULL,
+		fetched_at INTEGER NOT NULL
+	);
+	CREATE INDEX tile_fetches_tile_key ON tile_fetches (tile_key, fetched_at);
+	`,
+	// 2: public datasets, kept per source so they can be compared with aps
+	// and cells
+	`
+	CREATE TABLE dataset_aps (
+		source_id INTEGER NOT NULL REFERENCES sources(id),
+		bssid INTEGER NOT NULL,
+		lat REAL NOT NULL,
+		lon REAL NOT NULL,
+		tile_key INTEGER NOT NULL,
+		first_seen INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		PRIMARY KEY (source_id, bssid)
+	);
+	CREATE INDEX dataset_aps_bssid ON dataset_aps (bssid);
+	CREATE INDEX dataset_aps_tile_key ON dataset_aps (tile_key);
+
+	CREATE TABLE dataset_cells (
+		source_id INTEGER NOT NULL REFERENCES sources(id),
+		mcc INTEGER NOT NULL,
+		mnc INTEGER NOT NULL,
+		cell_id INTEGER NOT NULL,
+		tac INTEGER NOT NULL,
+		lat REAL NOT NULL,
+		lon REAL NOT NULL,
+		tile_key INTEGER NOT NULL,
+		first_seen INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		PRIMARY KEY (source_id, mcc, mnc, cell_id, tac)
+	);
+	CREATE INDEX dataset_cells_tile_key ON dataset_cells (tile_key);
+	`,
+	// 3: what the tile-sampler scheduler knows about each tile it re-fetches
+	`
+	CREATE TABLE tile_states (
+		tile_key INTEGER PRIMARY KEY,
+		-- hash of the access points last returned for the tile
+		digest INTEGER NOT NULL,
+		-- unix seconds, 0 when never
+		first_fetch INTEGER NOT NULL,
+		last_fetch INTEGER NOT NULL,
+		last_change INTEGER NOT NULL,
+		fetches INTEGER NOT NULL,
+		changes INTEGER NOT NULL,
+		-- average request time in milliseconds
+		cost_ms INTEGER NOT NULL
+	);
+	`,
+	// 4: protobuf fields in Apple's responses that our .proto files lack
+	`
+	CREATE TABLE unknown_fields (
+		message TEXT NOT NULL,
+		number INTEGER NOT NULL,
+		wire_type INTEGER NOT NULL,
+		count INTEGER NOT NULL,
+		first_seen INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		-- JSON array of up to five distinct values
+		samples TEXT NOT NULL,
+		PRIMARY KEY (message, number, wire_type)
+	);
+	`,
+	// 5: our own devices' requests to Apple, imported from captures
+	`
+	CREATE TABLE captures (
+		id INTEGER PRIMARY KEY,
+		-- hash of the time and bodies so a capture can be imported twice
+		digest INTEGER NOT NULL UNIQUE,
+		captured_at INTEGER NOT NULL,
+		endpoint TEXT NOT NULL,
+		method TEXT NOT NULL,
+		url TEXT NOT NULL,
+		status INTEGER NOT NULL,
+		-- from the ARPC header, empty for tile requests
+		app TEXT NOT NULL,
+		os_version TEXT NOT NULL,
+		locale TEXT NOT NULL,
+		tile_key INTEGER,
+		-- protojson, NULL when not decoded
+		request TEXT,
+		response TEXT,
+		-- JSON array of what could not be decoded
+		errors TEXT NOT NULL
+	);
+	CREATE INDEX captures_endpoint ON captures (endpoint, captured_at);
+	`,
+}
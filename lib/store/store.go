@@ -0,0 +1,104 @@
+// Package store keeps access points, cell towers and the tile fetch log in one
+// SQLite database so the crawlers, samplers and importers share a schema.
+//
+// Coordinates are always stored in WGS-84. The region column records which
+// endpoint an observation came from, and MAC addresses are stored as the
+// integer form of mac.Addr. Public datasets such as WiGLE and OpenCellID are
+// imported into separate dataset tables so they never replace Apple's data.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+var ErrNotFound = errors.New("not found in store")
+
+type Store struct {
+	db *sql.DB
+
+	sourceLock sync.Mutex
+	sources    map[string]int64
+}
+
+// Open creates or upgrades the database at path to the latest schema
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{db: db, sources: make(map[string]int64)}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// DB exposes the underlying database for ad hoc queries
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// Version returns the schema version of the database
+func (s *Store) Version() (int, error) {
+	var v int
+	err := s.db.QueryRow("PRAGMA user_version").Scan(&v)
+	return v, err
+}
+
+func (s *Store) migrate() error {
+	version, err := s.Version()
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than this build supports (%d)", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA does not accept placeholders
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceID returns the id of a named source, creating it if needed
+func (s *Store) sourceID(ctx context.Context, name string) (int64, error) {
+	s.sourceLock.Lock()
+	defer s.sourceLock.Unlock()
+	if id, ok := s.sources[name]; ok {
+		return id, nil
+	}
+	if _, err := s.db.ExecContext(ctx, "INSERT OR IGNORE INTO sources (name) VALUES (?)", name); err != nil {
+		return 0, err
+	}
+	var id int64
+	if err := s.db.QueryRowContext(ctx, "SELECT id FROM sources WHERE name = ?", name).Scan(&id); err != nil {
+		return 0, err
+	}
+	s.sources[name] = id
+	return id, nil
+}
@@ -0,0 +1,356 @@
+package store_test
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/capture"
+	"github.com/acheong08/apple-corelocation-experiments/lib/geo/datum"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+	"github.com/acheong08/apple-corelocation-experiments/pb"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func open(t *testing.T) *store.Store {
+	s, err := store.Open(filepath.Join(t.TempDir(), "wloc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestAPWriter(t *testing.T) {
+	ctx := context.Background()
+	s := open(t)
+	if v, err := s.Version(); err != nil || v == 0 {
+		t.Fatalf("unexpected schema version %d: %v", v, err)
+	}
+	bssid := mac.MustParseAddr("98:8f:00:54:4a:09")
+	first := time.Unix(1700000000, 0)
+	w := s.APWriter("test").WithBatchSize(2)
+	if err := w.Add(store.NewAP(lib.AP{BSSID: bssid, Location: lib.Location{Lat: 51.5, Long: -3.2}}, first)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AP(ctx, bssid); !errors.Is(err, store.ErrNotFound) {
+		t.Fatal("row written before the batch was full")
+	}
+	// Moved, then an older observation that must not win
+	if err := w.Add(
+		store.NewAP(lib.AP{BSSID: bssid, Location: lib.Location{Lat: 51.6, Long: -3.2}}, first.Add(time.Hour)),
+		store.NewAP(lib.AP{BSSID: bssid, Location: lib.Location{Lat: 10, Long: 10}}, first.Add(-time.Hour)),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ap, err := s.AP(ctx, bssid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ap.Lat != 51.6 || ap.Source != "test" || !ap.FirstSeen.Equal(first) || !ap.LastSeen.Equal(first.Add(time.Hour)) {
+		t.Fatalf("unexpected row %+v", ap)
+	}
+	moves, err := s.Moves(ctx, bssid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 1 || moves[0].OldLat != 51.5 || moves[0].NewLat != 51.6 {
+		t.Fatalf("unexpected moves %+v", moves)
+	}
+	inTile, err := s.APsInTile(ctx, ap.TileKey)
+	if err != nil || len(inTile) != 1 {
+		t.Fatalf("APsInTile returned %v, %v", inTile, err)
+	}
+	idx, err := s.Index(ctx)
+	if err != nil || idx.Len() != 1 {
+		t.Fatalf("Index returned %v, %v", idx, err)
+	}
+}
+
+func TestChinaConvertedToWGS84(t *testing.T) {
+	ctx := context.Background()
+	s := open(t)
+	lat, lon := datum.WGS84ToGCJ02(39.9, 116.4)
+	w := s.APWriter("test")
+	ap := lib.AP{BSSID: 1, Location: lib.Location{Lat: lat, Long: lon, Datum: datum.GCJ02}}
+	if err := w.Add(store.NewAPs([]lib.AP{ap})...); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.AP(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Region != store.China || math.Abs(got.Lat-39.9) > 1e-6 || math.Abs(got.Lon-116.4) > 1e-6 {
+		t.Fatalf("unexpected row %+v", got)
+	}
+}
+
+func TestFetchLog(t *testing.T) {
+	ctx := context.Background()
+	s := open(t)
+	w := s.FetchWriter()
+	if err := w.Add(
+		store.NewFetch(81644851, nil, &lib.StatusError{Code: 404}),
+		store.NewFetch(81644851, make([]lib.AP, 3), nil),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := s.LastFetch(ctx, 81644851)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Status != 200 || f.APCount != 3 {
+		t.Fatalf("unexpected fetch %+v", f)
+	}
+}
+
+func TestTileState(t *testing.T) {
+	ctx := context.Background()
+	s := open(t)
+	if _, err := s.TileState(ctx, 81644851); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	want := store.TileState{
+		TileKey:    81644851,
+		Digest:     1 << 63,
+		FirstFetch: time.Unix(1700000000, 0),
+		LastFetch:  time.Unix(1700003600, 0),
+		Fetches:    2,
+		Cost:       1500 * time.Millisecond,
+	}
+	if err := s.SaveTileState(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.TileState(ctx, want.TileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if states, err := s.TileStates(ctx); err != nil || len(states) != 1 {
+		t.Fatalf("unexpected states %v: %v", states, err)
+	}
+}
+
+func TestUnknownFields(t *testing.T) {
+	ctx := context.Background()
+	s := open(t)
+	var reported []lib.UnknownField
+	audit := lib.NewAudit().OnNew(func(f lib.UnknownField) { reported = append(reported, f) })
+	response := func(v uint64) *pb.AppleWLoc {
+		loc := &pb.Location{}
+		loc.ProtoReflect().SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 40, protowire.VarintType), v))
+		return &pb.AppleWLoc{WifiDevices: []*pb.WifiDevice{{Bssid: "98:8f:0:54:4a:9", Location: loc}}}
+	}
+	audit.Observe(response(7))
+	audit.Observe(response(7))
+	if err := s.SaveUnknownFields(ctx, audit.Take()); err != nil {
+		t.Fatal(err)
+	}
+	audit.Observe(response(8))
+	if err := s.SaveUnknownFields(ctx, audit.Take()); err != nil {
+		t.Fatal(err)
+	}
+	if len(reported) != 1 || reported[0].Message != "Location" || reported[0].Number != 40 {
+		t.Fatalf("unexpected reports %+v", reported)
+	}
+	fields, err := s.UnknownFields(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 1 || fields[0].Count != 3 || !slices.Equal(fields[0].Samples, []string{"7", "8"}) {
+		t.Fatalf("unexpected fields %+v", fields)
+	}
+}
+
+func TestSaveCaptures(t *testing.T) {
+	ctx := context.Background()
+	s := open(t)
+	exchanges := []capture.Exchange{
+		{Time: time.Unix(1718000000, 0), Endpoint: capture.Tile, Method: "GET", URL: "https://gspe85-ssl.ls.apple.com/wifi_request_tile",
+			Status: 200, TileKey: 81644, Response: &pb.WifiTile{Unknown1: 1}, ResponseBody: []byte{8, 1}},
+		{Time: time.Unix(1718000001, 0), Endpoint: capture.Wloc, Method: "POST", URL: "https://gs-loc.apple.com/clls/wloc",
+			Status: 200, Arpc: &lib.ArpcRequest{AppIdentifier: "com.apple.locationd"}, RequestBody: []byte{0, 1},
+			Errors: []string{"arpc: unexpected EOF"}},
+	}
+	for _, want := range []int{2, 0} {
+		n, err := s.SaveCaptures(ctx, exchanges)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Fatalf("saved %d captures, want %d", n, want)
+		}
+	}
+	var tileKey sql.NullInt64
+	var response sql.NullString
+	if err := s.DB().QueryRow("SELECT tile_key, json_extract(response, '$.unknown1') FROM captures WHERE endpoint = 'tile'").Scan(&tileKey, &response); err != nil {
+		t.Fatal(err)
+	}
+	if tileKey.Int64 != 81644 || response.String != "1" {
+		t.Errorf("tile capture has key %v and response %v", tileKey, response)
+	}
+	var app, errs string
+	if err := s.DB().QueryRow("SELECT app, errors FROM captures WHERE endpoint = 'wloc' AND request IS NULL").Scan(&app, &errs); err != nil {
+		t.Fatal(err)
+	}
+	if app != "com.apple.locationd" || errs != `["arpc: unexpected EOF"]` {
+		t.Errorf("wloc capture has app %q and errors %s", app, errs)
+	}
+}
+
+func TestImportLegacy(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "bssid_tracking.db")
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"CREATE TABLE current_bssids (bssid TEXT PRIMARY KEY, lat REAL NOT NULL, long REAL NOT NULL, last_seen TIMESTAMP NOT NULL)",
+		"CREATE TABLE location_changes (id INTEGER PRIMARY KEY AUTOINCREMENT, bssid TEXT NOT NULL, old_lat REAL NOT NULL, old_long REAL NOT NULL, new_lat REAL NOT NULL, new_long REAL NOT NULL, change_time TIMESTAMP NOT NULL)",
+		"CREATE TABLE seeds (bssid INTEGER PRIMARY KEY, lat REAL NOT NULL, lon REAL NOT NULL)",
+	} {
+		if _, err := legacy.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	legacy.Exec("INSERT INTO current_bssids VALUES (?, ?, ?, ?)", "98:8f:00:54:4a:09", 1.5, 2.5, now)
+	legacy.Exec("INSERT INTO location_changes (bssid, old_lat, old_long, new_lat, new_long, change_time) VALUES (?, ?, ?, ?, ?, ?)", "98:8f:00:54:4a:09", 1, 2, 1.5, 2.5, now)
+	legacy.Exec("INSERT INTO seeds VALUES (?, ?, ?)", 42, 3.5, 4.5)
+	legacy.Close()
+
+	s := open(t)
+	n, err := s.ImportLegacy(ctx, path)
+	if err != nil || n != 2 {
+		t.Fatalf("imported %d: %v", n, err)
+	}
+	ap, err := s.AP(ctx, mac.MustParseAddr("98:8f:00:54:4a:09"))
+	if err != nil || ap.Source != "tile-sampler" || ap.LastSeen.Unix() != now.Unix() {
+		t.Fatalf("unexpected row %+v: %v", ap, err)
+	}
+	moves, err := s.Moves(ctx, ap.BSSID)
+	if err != nil || len(moves) != 1 {
+		t.Fatalf("unexpected moves %+v: %v", moves, err)
+	}
+	if _, err := s.AP(ctx, 42); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const wigleCSV = `WigleWifi-1.4,appRelease=2.64,model=Pixel 6,release=13,device=oriole,display=TQ3A,board=oriole,brand=google
+MAC,SSID,AuthMode,FirstSeen,Channel,RSSI,CurrentLatitude,CurrentLongitude,AltitudeMeters,AccuracyMeters,Type
+98:8f:00:54:4a:09,Home,[WPA2-PSK-CCMP][ESS],2023-11-14 10:00:00,6,-60,51.48,-3.18,20,5,WIFI
+98:8f:00:54:4a:09,Home,[WPA2-PSK-CCMP][ESS],2023-11-14 11:00:00,6,-50,51.481,-3.181,20,5,WIFI
+aa:bb:cc:dd:ee:ff,Phone,Misc [LE],2023-11-14 10:00:00,0,-70,51.48,-3.18,20,5,BLE
+234010_1234_5678901,Vodafone,LTE;gb,2023-11-14 10:00:00,1300,-90,51.47,-3.17,20,5,LTE
+00:11:22:33:44:55,NoFix,[ESS],2023-11-14 10:00:00,1,-80,0.0,0.0,0,0,WIFI
+`
+
+const cellCSV = `radio,mcc,net,area,cell,unit,lon,lat,range,samples,changeable,created,updated,averageSignal
+LTE,234,10,1234,5678901,0,-3.175,51.472,1000,12,1,1459813819,1700000000,0
+NR,234,10,1234,68719476735,0,-3.1,51.4,1000,1,1,1459813819,1700000000,0
+`
+
+func TestImportDatasets(t *testing.T) {
+	ctx := context.Background()
+	s := open(t)
+	dir := t.TempDir()
+
+	wigle := filepath.Join(dir, "wigle.csv")
+	os.WriteFile(wigle, []byte(wigleCSV), 0o644)
+	if n, err := s.ImportDataset(ctx, wigle, ""); err != nil || n != 3 {
+		t.Fatalf("imported %d WiGLE rows: %v", n, err)
+	}
+
+	// MLS exports are gzipped
+	mls := filepath.Join(dir, "MLS-full-cell-export.csv.gz")
+	f, _ := os.Create(mls)
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte(cellCSV))
+	zw.Close()
+	f.Close()
+	if n, err := s.ImportDataset(ctx, mls, store.MLS); err != nil || n != 1 {
+		t.Fatalf("imported %d MLS rows: %v", n, err)
+	}
+
+	kismet := filepath.Join(dir, "Kismet-20231114.kismet")
+	k, err := sql.Open("sqlite", kismet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = k.Exec(`CREATE TABLE devices (first_time INT, last_time INT, devkey TEXT, phyname TEXT, devmac TEXT,
+		strongest_signal INT, min_lat REAL, min_lon REAL, max_lat REAL, max_lon REAL, avg_lat REAL, avg_lon REAL,
+		bytes_data INT, type TEXT, device BLOB);
+	INSERT INTO devices (first_time, last_time, phyname, devmac, avg_lat, avg_lon, type) VALUES
+		(1699950000, 1699953600, 'IEEE802.11', '98:8F:00:54:4A:0A', 51.49, -3.19, 'Wi-Fi AP'),
+		(1699950000, 1699953600, 'IEEE802.11', 'DA:A1:19:00:00:01', 51.49, -3.19, 'Wi-Fi Client'),
+		(1699950000, 1699953600, 'IEEE802.11', '98:8F:00:54:4A:0B', 0, 0, 'Wi-Fi AP')`)
+	k.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.ImportDataset(ctx, kismet, ""); err != nil || n != 1 {
+		t.Fatalf("imported %d Kismet rows: %v", n, err)
+	}
+
+	var wigleAPs []store.AP
+	s.EachDatasetAP(ctx, store.WiGLE, func(ap store.AP) error {
+		wigleAPs = append(wigleAPs, ap)
+		return nil
+	})
+	if len(wigleAPs) != 1 || wigleAPs[0].Lat != 51.481 || wigleAPs[0].FirstSeen.Hour() != 10 {
+		t.Errorf("unexpected WiGLE access points %+v", wigleAPs)
+	}
+	var cells []store.Cell
+	for _, source := range []string{store.WiGLE, store.MLS} {
+		s.EachDatasetCell(ctx, source, func(c store.Cell) error {
+			cells = append(cells, c)
+			return nil
+		})
+	}
+	tower := lib.TowerInfo{Mcc: 234, Mnc: 10, CellId: 5678901, TacId: 1234}
+	if len(cells) != 2 || cells[0].Tower != tower || cells[1].Tower != tower || cells[1].FirstSeen.Unix() != 1459813819 {
+		t.Errorf("unexpected cells %+v", cells)
+	}
+
+	// Only the access point Apple also knows is compared, and Apple's
+	// position is untouched by the import
+	w := s.APWriter("test")
+	w.Add(store.AP{BSSID: mac.MustParseAddr("98:8f:00:54:4a:09"), Lat: 51.5, Lon: -3.2, LastSeen: time.Unix(1800000000, 0)})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compared := 0
+	err = s.CompareAPs(ctx, store.WiGLE, func(dataset, apple store.AP) error {
+		compared++
+		if dataset.Lat != 51.481 || apple.Lat != 51.5 {
+			t.Errorf("compared %+v with %+v", dataset, apple)
+		}
+		return nil
+	})
+	if err != nil || compared != 1 {
+		t.Errorf("compared %d access points: %v", compared, err)
+	}
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// TileState is the fetch history of a tile that is sampled repeatedly
+type TileState struct {
+	TileKey int64
+	// Digest identifies the access points last returned for the tile
+	Digest     uint64
+	FirstFetch time.Time
+	LastFetch  time.Time
+	// LastChange is the last fetch whose digest differed from the one before
+	LastChange time.Time
+	Fetches    int
+	Changes    int
+	// Cost is the average time taken to fetch the tile
+	Cost time.Duration
+}
+
+const tileStateColumns = "tile_key, digest, first_fetch, last_fetch, last_change, fetches, changes, cost_ms"
+
+// unixOrZero keeps the zero time as 0 rather than a large negative number
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+func scanTileState(row interface{ Scan(...any) error }) (TileState, error) {
+	var t TileState
+	var digest, first, last, change, cost int64
+	if err := row.Scan(&t.TileKey, &digest, &first, &last, &change, &t.Fetches, &t.Changes, &cost); err != nil {
+		return t, err
+	}
+	t.Digest = uint64(digest)
+	t.FirstFetch, t.LastFetch, t.LastChange = timeOrZero(first), timeOrZero(last), timeOrZero(change)
+	t.Cost = time.Duration(cost) * time.Millisecond
+	return t, nil
+}
+
+// TileState returns the fetch history of a tile
+func (s *Store) TileState(ctx context.Context, tileKey int64) (TileState, error) {
+	t, err := scanTileState(s.db.QueryRowContext(ctx, "SELECT "+tileStateColumns+" FROM tile_states WHERE tile_key = ?", tileKey))
+	if errors.Is(err, sql.ErrNoRows) {
+		return TileState{TileKey: tileKey}, ErrNotFound
+	}
+	return t, err
+}
+
+// TileStates returns the fetch history of every tile that has one
+func (s *Store) TileStates(ctx context.Context) ([]TileState, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+tileStateColumns+" FROM tile_states ORDER BY tile_key")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var states []TileState
+	for rows.Next() {
+		t, err := scanTileState(rows)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, t)
+	}
+	return states, rows.Err()
+}
+
+// SaveTileState replaces the fetch history of a tile
+func (s *Store) SaveTileState(ctx context.Context, t TileState) error {
+	_, err := s.db.ExecContext(ctx, "INSERT OR REPLACE INTO tile_states ("+tileStateColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		t.TileKey, int64(t.Digest), unixOrZero(t.FirstFetch), unixOrZero(t.LastFetch), unixOrZero(t.LastChange),
+		t.Fetches, t.Changes, t.Cost.Milliseconds())
+	return err
+}
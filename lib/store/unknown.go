@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+)
+
+// maxUnknownSamples matches the number of samples lib.Audit keeps
+const maxUnknownSamples = 5
+
+// SaveUnknownFields adds fields collected by lib.Audit.Take to the counts
+// already stored
+func (s *Store) SaveUnknownFields(ctx context.Context, fields []lib.UnknownField) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, f := range fields {
+		var count, first, last int64
+		var samples []byte
+		err := tx.QueryRowContext(ctx, `SELECT count, first_seen, last_seen, samples FROM unknown_fields
+			WHERE message = ? AND number = ? AND wire_type = ?`, f.Message, f.Number, f.WireType).
+			Scan(&count, &first, &last, &samples)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return err
+		default:
+			var old []string
+			if err := json.Unmarshal(samples, &old); err != nil {
+				return err
+			}
+			f.Count += count
+			f.FirstSeen = minTime(f.FirstSeen, time.Unix(first, 0))
+			f.LastSeen = maxTime(f.LastSeen, time.Unix(last, 0))
+			for _, v := range f.Samples {
+				if len(old) < maxUnknownSamples && !slices.Contains(old, v) {
+					old = append(old, v)
+				}
+			}
+			f.Samples = old
+		}
+		samples, err = json.Marshal(f.Samples)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO unknown_fields
+			(message, number, wire_type, count, first_seen, last_seen, samples) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			f.Message, f.Number, f.WireType, f.Count, f.FirstSeen.Unix(), f.LastSeen.Unix(), samples); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UnknownFields returns every unknown field recorded, by message and number
+func (s *Store) UnknownFields(ctx context.Context) ([]lib.UnknownField, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT message, number, wire_type, count, first_seen, last_seen, samples
+		FROM unknown_fields ORDER BY message, number, wire_type`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var fields []lib.UnknownField
+	for rows.Next() {
+		var f lib.UnknownField
+		var first, last int64
+		var samples []byte
+		if err := rows.Scan(&f.Message, &f.Number, &f.WireType, &f.Count, &first, &last, &samples); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(samples, &f.Samples); err != nil {
+			return nil, err
+		}
+		f.FirstSeen, f.LastSeen = time.Unix(first, 0), time.Unix(last, 0)
+		fields = append(fields, f)
+	}
+	return fields, rows.Err()
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// DefaultBatchSize is the number of rows a Writer buffers before inserting
+// them in a single transaction
+const DefaultBatchSize = 1000
+
+// Writer buffers rows and inserts each batch in one transaction. It is safe
+// for concurrent use, and Close must be called to write the last batch.
+type Writer[T any] struct {
+	s      *Store
+	size   int
+	source string
+	insert func(ctx context.Context, tx *sql.Tx, sourceID int64, rows []T) error
+
+	lock  sync.Mutex
+	batch []T
+}
+
+// newWriter creates a writer for rows attributed to source, which may be empty
+// for tables without one
+func newWriter[T any](s *Store, source string, insert func(context.Context, *sql.Tx, int64, []T) error) *Writer[T] {
+	return &Writer[T]{
+		s:      s,
+		size:   DefaultBatchSize,
+		source: source,
+		insert: insert,
+	}
+}
+
+// WithBatchSize changes how many rows are buffered before writing
+func (w *Writer[T]) WithBatchSize(n int) *Writer[T] {
+	w.size = max(n, 1)
+	return w
+}
+
+// Add buffers rows, writing the batch once it is full
+func (w *Writer[T]) Add(rows ...T) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.batch = append(w.batch, rows...)
+	if len(w.batch) < w.size {
+		return nil
+	}
+	return w.flush(context.Background())
+}
+
+// Flush writes any buffered rows
+func (w *Writer[T]) Flush(ctx context.Context) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.flush(ctx)
+}
+
+func (w *Writer[T]) flush(ctx context.Context) error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+	var sourceID int64
+	if w.source != "" {
+		var err error
+		if sourceID, err = w.s.sourceID(ctx, w.source); err != nil {
+			return err
+		}
+	}
+	tx, err := w.s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := w.insert(ctx, tx, sourceID, w.batch); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	w.batch = w.batch[:0]
+	return nil
+}
+
+func (w *Writer[T]) Close() error {
+	return w.Flush(context.Background())
+}
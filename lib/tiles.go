@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
 	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
-	"github.com/acheong08/apple-corelocation-experiments/lib/shapefiles"
 	"github.com/acheong08/apple-corelocation-experiments/pb"
 	"io"
 	"net/http"
@@ -12,13 +11,29 @@ import (
 	"google.golang.org/protobuf/proto"
 )
 
-func GetTile(tileKey int64) ([]AP, error) {
+// StatusError is returned when Apple replies with anything but 200. Tiles
+// without any access points are 404.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.Code)
+}
+
+func GetTile(tileKey int64, options ...Modifier) ([]AP, error) {
+	args := newWlocArgs(options...)
 	var tileURL string = "https://gspe85-ssl.ls.apple.com"
 
-	lat, lon, _ := morton.Decode(tileKey)
-	if shapefiles.IsInChina(lat, lon) {
+	// Use the centre since the corner of a border tile may be on the wrong side
+	lat, lon := morton.Centre(tileKey)
+	endpoint := args.regionAt(lat, lon)
+	if endpoint == china {
 		tileURL = "https://gspe85-cn-ssl.ls.apple.com"
 	}
+	if args.endpoint != "" {
+		tileURL = args.endpoint
+	}
 	tileURL = tileURL + "/wifi_request_tile"
 	req, err := http.NewRequest("GET", tileURL, nil)
 	if err != nil {
@@ -34,13 +49,13 @@ func GetTile(tileKey int64) ([]AP, error) {
 	} {
 		req.Header.Set(key, val)
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := args.httpClient().Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &StatusError{Code: resp.StatusCode}
 	}
 	wifuTile := &pb.WifiTile{}
 	b, err := io.ReadAll(resp.Body)
@@ -51,6 +66,7 @@ func GetTile(tileKey int64) ([]AP, error) {
 	if err != nil {
 		return nil, err
 	}
+	args.audit.Observe(wifuTile)
 	aps := make([]AP, 0)
 	max := 0
 	for _, region := range wifuTile.GetRegion() {
@@ -60,14 +76,17 @@ func GetTile(tileKey int64) ([]AP, error) {
 				continue
 			}
 			aps[max] = AP{
-				BSSID: mac.Decode(device.GetBssid()),
+				BSSID: mac.Addr(device.GetBssid()),
 				Location: Location{
-					Lat:  CoordFromInt(int64(device.GetEntry().GetLat()), -7),
-					Long: CoordFromInt(int64(device.GetEntry().GetLong()), -7),
+					Lat:   CoordFromInt(int64(device.GetEntry().GetLat()), -7),
+					Long:  CoordFromInt(int64(device.GetEntry().GetLong()), -7),
+					Datum: endpoint.datum(),
 				},
 			}
 			max++
 		}
 	}
-	return aps[:max], nil
+	aps = aps[:max]
+	args.finishAPs(aps)
+	return aps, nil
 }
@@ -0,0 +1,74 @@
+package tilestats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+// WriteMarkdown writes the report as Markdown tables. Per-tile time series
+// are left to the JSON form.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Tile churn report\n\nGenerated %s from %d tiles in %s intervals.\n\n",
+		r.Generated.Format(time.RFC3339), len(r.Tiles), seconds(float64(r.IntervalSeconds)))
+
+	fmt.Fprintln(bw, "## Update cadence")
+	fmt.Fprintln(bw)
+	c := r.Cadence
+	fmt.Fprintf(bw, "%d updates were seen, in %.1f%% of fetches. Tiles were fetched every %s and changed every %s (medians), or %s at the 90th percentile.\n",
+		c.Updates, c.ChangeRate*100, seconds(c.FetchGapSeconds), seconds(c.MedianGapSeconds), seconds(c.P90GapSeconds))
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "| Hour (UTC) | Updates |")
+	fmt.Fprintln(bw, "|---:|---:|")
+	for h, n := range r.Cadence.HourOfDay {
+		fmt.Fprintf(bw, "| %02d | %d |\n", h, n)
+	}
+
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "## Position jitter")
+	fmt.Fprintln(bw)
+	fmt.Fprintf(bw, "%d moves, median %.1f m, 90th percentile %.1f m, largest %.1f m.\n\n",
+		r.Jitter.Samples, r.Jitter.Median, r.Jitter.P90, r.Jitter.Max)
+	fmt.Fprintln(bw, "| Distance | Moves |")
+	fmt.Fprintln(bw, "|---|---:|")
+	lower := 0.0
+	for i, n := range r.Jitter.Counts {
+		if i < len(r.Jitter.Bounds) {
+			fmt.Fprintf(bw, "| %g–%g m | %d |\n", lower, r.Jitter.Bounds[i], n)
+			lower = r.Jitter.Bounds[i]
+		} else {
+			fmt.Fprintf(bw, "| over %g m | %d |\n", lower, n)
+		}
+	}
+
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "## Intervals")
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "| Start (UTC) | Fetches | Mean APs | Added | Removed | Moved |")
+	fmt.Fprintln(bw, "|---|---:|---:|---:|---:|---:|")
+	for _, b := range r.Intervals {
+		fmt.Fprintf(bw, "| %s | %d | %.1f | %d | %d | %d |\n",
+			b.Start.Format("2006-01-02 15:04"), b.Fetches, b.APs, b.Added, b.Removed, b.Moved)
+	}
+
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "## Tiles")
+	fmt.Fprintln(bw)
+	fmt.Fprintln(bw, "| Tile | Fetches | Failures | Added | Removed | Moved | Change rate | Median update gap | Median jitter |")
+	fmt.Fprintln(bw, "|---:|---:|---:|---:|---:|---:|---:|---:|---:|")
+	for _, t := range r.Tiles {
+		fmt.Fprintf(bw, "| %d | %d | %d | %d | %d | %d | %.1f%% | %s | %.1f m |\n",
+			t.TileKey, t.Fetches, t.Failures, t.Added, t.Removed, t.Moved,
+			t.Cadence.ChangeRate*100, seconds(t.Cadence.MedianGapSeconds), t.Jitter.Median)
+	}
+	return bw.Flush()
+}
+
+func seconds(s float64) string {
+	if s == 0 {
+		return "-"
+	}
+	return (time.Duration(s) * time.Second).String()
+}
@@ -0,0 +1,378 @@
+// Package tilestats summarises how the tiles sampled by tile-sampler change
+// over time: how many access points they hold, how many appear, disappear
+// and move in each interval, how far moves go and how often Apple appears to
+// publish updates. Reports only hold per-tile and per-interval aggregates,
+// never the history of an individual access point, so they can be shared.
+package tilestats
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib/distance"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+)
+
+type Options struct {
+	// Interval is the width of each bucket of the time series
+	Interval time.Duration
+	// Tiles limits the report to these tiles, or every fetched tile if empty
+	Tiles []int64
+	// Since and Until limit the fetches considered, if set
+	Since, Until time.Time
+}
+
+var DefaultOptions = Options{Interval: time.Hour}
+
+// JitterBounds are the upper edges in metres of the move distance histogram.
+// The last bucket has no upper edge.
+var JitterBounds = []float64{0.5, 1, 2, 5, 10, 25, 100, 1000}
+
+// clockSlack is how many seconds apart a fetch and the access points written
+// from it may be stamped
+const clockSlack = 5
+
+type Report struct {
+	Generated       time.Time `json:"generated"`
+	IntervalSeconds int64     `json:"interval_seconds"`
+	// Intervals sums every tile's buckets
+	Intervals []Bucket  `json:"intervals"`
+	Jitter    Histogram `json:"jitter"`
+	Cadence   Cadence   `json:"cadence"`
+	Tiles     []Tile    `json:"tiles"`
+}
+
+type Tile struct {
+	TileKey int64 `json:"tile_key"`
+	Fetches int   `json:"fetches"`
+	// Failures are fetches that got neither a tile nor a 404
+	Failures int       `json:"failures"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+	Added    int       `json:"added"`
+	Removed  int       `json:"removed"`
+	Moved    int       `json:"moved"`
+	Buckets  []Bucket  `json:"buckets"`
+	Jitter   Histogram `json:"jitter"`
+	Cadence  Cadence   `json:"cadence"`
+}
+
+// Bucket is one interval of a time series
+type Bucket struct {
+	Start   time.Time `json:"start"`
+	Fetches int       `json:"fetches"`
+	// APs is the mean number of access points returned per fetch
+	APs     float64 `json:"aps"`
+	Added   int     `json:"added"`
+	Removed int     `json:"removed"`
+	Moved   int     `json:"moved"`
+
+	ok, apSum int
+}
+
+// Histogram counts move distances in JitterBounds
+type Histogram struct {
+	Bounds  []float64 `json:"bounds_m"`
+	Counts  []int     `json:"counts"`
+	Samples int       `json:"samples"`
+	Median  float64   `json:"median_m"`
+	P90     float64   `json:"p90_m"`
+	Max     float64   `json:"max_m"`
+}
+
+// Cadence describes when changes were seen. An update is a fetch that
+// returned anything different from the fetch before, so updates can only be
+// timed as finely as the tile was sampled.
+type Cadence struct {
+	Updates int `json:"updates"`
+	// ChangeRate is the fraction of fetches after the first that saw an update
+	ChangeRate float64 `json:"change_rate"`
+	// FetchGapSeconds is the median time between fetches
+	FetchGapSeconds  float64 `json:"fetch_gap_seconds"`
+	MedianGapSeconds float64 `json:"median_gap_seconds"`
+	P90GapSeconds    float64 `json:"p90_gap_seconds"`
+	// HourOfDay counts updates by hour in UTC
+	HourOfDay [24]int `json:"hour_of_day_utc"`
+}
+
+type fetch struct {
+	at     int64
+	status int
+	aps    int
+}
+
+func (f fetch) ok() bool {
+	return f.status == 200 || f.status == 404
+}
+
+// Compute builds a report from the fetch log, access points and moves in s.
+// Intervals are whole seconds, so one under a second is an error.
+func Compute(ctx context.Context, s *store.Store, opts Options) (*Report, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultOptions.Interval
+	}
+	if opts.Interval < time.Second {
+		return nil, fmt.Errorf("interval %v is shorter than a second", opts.Interval)
+	}
+	fetches, err := loadFetches(ctx, s.DB(), opts)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]int64, 0, len(fetches))
+	for k := range fetches {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	r := &Report{Generated: time.Now().UTC(), IntervalSeconds: int64(opts.Interval / time.Second)}
+	intervals := make(map[int64]*Bucket)
+	var jitter, gaps, fetchGaps []float64
+	var updateTimes []int64
+	checked := 0
+	for _, k := range keys {
+		t, tj, tu, err := tileStats(ctx, s.DB(), k, fetches[k], opts.Interval)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range t.Buckets {
+			m, ok := intervals[b.Start.Unix()]
+			if !ok {
+				m = &Bucket{Start: b.Start}
+				intervals[b.Start.Unix()] = m
+			}
+			m.Fetches += b.Fetches
+			m.Added += b.Added
+			m.Removed += b.Removed
+			m.Moved += b.Moved
+			m.ok += b.ok
+			m.apSum += b.apSum
+		}
+		jitter = append(jitter, tj...)
+		gaps = append(gaps, diffs(tu)...)
+		fetchGaps = append(fetchGaps, diffs(okTimes(fetches[k]))...)
+		updateTimes = append(updateTimes, tu...)
+		checked += max(len(okTimes(fetches[k]))-1, 0)
+		r.Tiles = append(r.Tiles, t)
+	}
+	r.Intervals = sortBuckets(intervals)
+	r.Jitter = newHistogram(jitter)
+	r.Cadence = newCadence(updateTimes, gaps, fetchGaps, checked)
+	return r, nil
+}
+
+func loadFetches(ctx context.Context, db *sql.DB, opts Options) (map[int64][]fetch, error) {
+	query := "SELECT tile_key, status, ap_count, fetched_at FROM tile_fetches WHERE 1 = 1"
+	var args []any
+	if !opts.Since.IsZero() {
+		query += " AND fetched_at >= ?"
+		args = append(args, opts.Since.Unix())
+	}
+	if !opts.Until.IsZero() {
+		query += " AND fetched_at < ?"
+		args = append(args, opts.Until.Unix())
+	}
+	rows, err := db.QueryContext(ctx, query+" ORDER BY tile_key, fetched_at, id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var wanted map[int64]bool
+	if len(opts.Tiles) > 0 {
+		wanted = make(map[int64]bool, len(opts.Tiles))
+		for _, k := range opts.Tiles {
+			wanted[k] = true
+		}
+	}
+	fetches := make(map[int64][]fetch)
+	for rows.Next() {
+		var key int64
+		var f fetch
+		if err := rows.Scan(&key, &f.status, &f.aps, &f.at); err != nil {
+			return nil, err
+		}
+		if wanted == nil || wanted[key] {
+			fetches[key] = append(fetches[key], f)
+		}
+	}
+	return fetches, rows.Err()
+}
+
+// tileStats returns a tile's aggregates along with its move distances and
+// update times for the report totals
+func tileStats(ctx context.Context, db *sql.DB, key int64, fetches []fetch, interval time.Duration) (Tile, []float64, []int64, error) {
+	t := Tile{TileKey: key, Fetches: len(fetches)}
+	width := int64(interval / time.Second)
+	buckets := make(map[int64]*Bucket)
+	bucket := func(at int64) *Bucket {
+		start := at - at%width
+		b, ok := buckets[start]
+		if !ok {
+			b = &Bucket{Start: time.Unix(start, 0).UTC()}
+			buckets[start] = b
+		}
+		return b
+	}
+	for _, f := range fetches {
+		b := bucket(f.at)
+		b.Fetches++
+		if f.ok() {
+			b.ok++
+			b.apSum += f.aps
+		} else {
+			t.Failures++
+		}
+	}
+	ok := okTimes(fetches)
+	if len(ok) == 0 {
+		t.Buckets = sortBuckets(buckets)
+		return t, nil, nil, nil
+	}
+	first, last := ok[0], ok[len(ok)-1]
+	t.First, t.Last = time.Unix(first, 0).UTC(), time.Unix(last, 0).UTC()
+
+	// snap moves a change onto the fetch that saw it
+	snap := func(at int64) int64 {
+		i := sort.Search(len(ok), func(i int) bool { return ok[i] >= at-clockSlack })
+		if i < len(ok) && ok[i] <= at+clockSlack {
+			return ok[i]
+		}
+		return at
+	}
+	updates := make(map[int64]bool)
+
+	rows, err := db.QueryContext(ctx, "SELECT first_seen, last_seen FROM aps WHERE tile_key = ?", key)
+	if err != nil {
+		return t, nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var firstSeen, lastSeen int64
+		if err := rows.Scan(&firstSeen, &lastSeen); err != nil {
+			return t, nil, nil, err
+		}
+		if firstSeen > first+clockSlack && firstSeen <= last+clockSlack {
+			at := snap(firstSeen)
+			bucket(at).Added++
+			t.Added++
+			updates[at] = true
+		}
+		// Gone from the first fetch after it was last seen
+		if lastSeen >= first-clockSlack && lastSeen+clockSlack < last {
+			at := ok[sort.Search(len(ok), func(i int) bool { return ok[i] > lastSeen+clockSlack })]
+			bucket(at).Removed++
+			t.Removed++
+			updates[at] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return t, nil, nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT m.old_lat, m.old_lon, m.new_lat, m.new_lon, m.moved_at
+		FROM ap_moves m JOIN aps a ON a.bssid = m.bssid
+		WHERE a.tile_key = ? AND m.moved_at BETWEEN ? AND ?`, key, first-clockSlack, last+clockSlack)
+	if err != nil {
+		return t, nil, nil, err
+	}
+	defer rows.Close()
+	var jitter []float64
+	for rows.Next() {
+		var oldLat, oldLon, newLat, newLon float64
+		var movedAt int64
+		if err := rows.Scan(&oldLat, &oldLon, &newLat, &newLon, &movedAt); err != nil {
+			return t, nil, nil, err
+		}
+		at := snap(movedAt)
+		bucket(at).Moved++
+		t.Moved++
+		updates[at] = true
+		jitter = append(jitter, distance.Geodesic(distance.Point{X: oldLon, Y: oldLat}, distance.Point{X: newLon, Y: newLat}))
+	}
+	if err := rows.Err(); err != nil {
+		return t, nil, nil, err
+	}
+
+	times := make([]int64, 0, len(updates))
+	for at := range updates {
+		times = append(times, at)
+	}
+	slices.Sort(times)
+	t.Buckets = sortBuckets(buckets)
+	t.Jitter = newHistogram(jitter)
+	t.Cadence = newCadence(times, diffs(times), diffs(ok), len(ok)-1)
+	return t, jitter, times, nil
+}
+
+func okTimes(fetches []fetch) []int64 {
+	var times []int64
+	for _, f := range fetches {
+		if f.ok() {
+			times = append(times, f.at)
+		}
+	}
+	return times
+}
+
+// diffs returns the gaps in seconds between sorted times
+func diffs(times []int64) []float64 {
+	var d []float64
+	for i := 1; i < len(times); i++ {
+		d = append(d, float64(times[i]-times[i-1]))
+	}
+	return d
+}
+
+func sortBuckets(m map[int64]*Bucket) []Bucket {
+	buckets := make([]Bucket, 0, len(m))
+	for _, b := range m {
+		if b.ok > 0 {
+			b.APs = float64(b.apSum) / float64(b.ok)
+		}
+		buckets = append(buckets, *b)
+	}
+	slices.SortFunc(buckets, func(a, b Bucket) int { return a.Start.Compare(b.Start) })
+	return buckets
+}
+
+func newHistogram(values []float64) Histogram {
+	h := Histogram{Bounds: JitterBounds, Counts: make([]int, len(JitterBounds)+1), Samples: len(values)}
+	for _, v := range values {
+		h.Counts[sort.SearchFloat64s(JitterBounds, v)]++
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	h.Median, h.P90 = percentile(sorted, 0.5), percentile(sorted, 0.9)
+	if len(sorted) > 0 {
+		h.Max = sorted[len(sorted)-1]
+	}
+	return h
+}
+
+func newCadence(updates []int64, gaps, fetchGaps []float64, checked int) Cadence {
+	c := Cadence{Updates: len(updates)}
+	if checked > 0 {
+		c.ChangeRate = float64(len(updates)) / float64(checked)
+	}
+	for _, at := range updates {
+		c.HourOfDay[time.Unix(at, 0).UTC().Hour()]++
+	}
+	slices.Sort(gaps)
+	slices.Sort(fetchGaps)
+	c.MedianGapSeconds, c.P90GapSeconds = percentile(gaps, 0.5), percentile(gaps, 0.9)
+	c.FetchGapSeconds = percentile(fetchGaps, 0.5)
+	return c
+}
+
+// percentile uses the nearest rank of sorted values, 0 when there are none
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
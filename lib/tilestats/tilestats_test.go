@@ -0,0 +1,119 @@
+package tilestats_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acheong08/apple-corelocation-experiments/lib"
+	"github.com/acheong08/apple-corelocation-experiments/lib/morton"
+	"github.com/acheong08/apple-corelocation-experiments/lib/store"
+	"github.com/acheong08/apple-corelocation-experiments/lib/tilestats"
+)
+
+func TestCompute(t *testing.T) {
+	ctx := context.Background()
+	s, err := store.Open(filepath.Join(t.TempDir(), "wloc.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Four hourly fetches of one tile, and one that was throttled. The first
+	// access point moves by about a metre at the third fetch, the second is
+	// gone by then and a third appears at the last.
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	at := func(h int) time.Time { return start.Add(time.Duration(h) * time.Hour) }
+	key := morton.Encode(51.48, -3.18, store.TileLevel)
+	lat, lon := morton.Centre(key)
+	a := lib.AP{BSSID: 1, Location: lib.Location{Lat: lat, Long: lon}}
+	moved := lib.AP{BSSID: 1, Location: lib.Location{Lat: lat + 0.00001, Long: lon}}
+	b := lib.AP{BSSID: 2, Location: lib.Location{Lat: lat + 0.001, Long: lon}}
+	c := lib.AP{BSSID: 3, Location: lib.Location{Lat: lat + 0.002, Long: lon}}
+	seen := [][]lib.AP{{a, b}, {a, b}, {moved}, {moved, c}}
+
+	aps := s.APWriter("test").WithBatchSize(1)
+	fetches := s.FetchWriter()
+	for h, batch := range seen {
+		for _, ap := range batch {
+			if morton.Encode(ap.Location.Lat, ap.Location.Long, store.TileLevel) != key {
+				t.Fatal("test access points are in different tiles")
+			}
+			if err := aps.Add(store.NewAP(ap, at(h))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := fetches.Add(store.Fetch{TileKey: key, Status: 200, APCount: len(batch), FetchedAt: at(h)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fetches.Add(store.Fetch{TileKey: key, Status: 503, FetchedAt: at(3).Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := aps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fetches.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := tilestats.Compute(ctx, s, tilestats.DefaultOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Tiles) != 1 {
+		t.Fatalf("expected one tile, got %d", len(r.Tiles))
+	}
+	tile := r.Tiles[0]
+	if tile.Fetches != 5 || tile.Failures != 1 || tile.Added != 1 || tile.Removed != 1 || tile.Moved != 1 {
+		t.Fatalf("unexpected tile %+v", tile)
+	}
+	if len(r.Intervals) != 4 || r.Intervals[0].APs != 2 || r.Intervals[3].Fetches != 2 || r.Intervals[3].Added != 1 {
+		t.Fatalf("unexpected intervals %+v", r.Intervals)
+	}
+	if r.Intervals[2].Removed != 1 || r.Intervals[2].Moved != 1 {
+		t.Fatalf("expected the removal and move at the third fetch, got %+v", r.Intervals[2])
+	}
+	if r.Jitter.Samples != 1 || r.Jitter.Counts[2] != 1 {
+		t.Fatalf("unexpected jitter %+v", r.Jitter)
+	}
+	cad := r.Cadence
+	if cad.Updates != 2 || cad.MedianGapSeconds != 3600 || cad.FetchGapSeconds != 3600 || cad.HourOfDay[12] != 1 {
+		t.Fatalf("unexpected cadence %+v", cad)
+	}
+
+	// Nothing identifies an access point
+	b1, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var md bytes.Buffer
+	if err := r.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range []string{string(b1), md.String()} {
+		if strings.Contains(out, "bssid") || strings.Contains(out, fmt.Sprint(lat)) {
+			t.Fatal("report exposes access points")
+		}
+	}
+	if !strings.Contains(md.String(), "| 2024-05-01 12:00 | 1 | 1.0 | 0 | 1 | 1 |") {
+		t.Fatalf("unexpected markdown:\n%s", md.String())
+	}
+
+	r, err = tilestats.Compute(ctx, s, tilestats.Options{Interval: time.Hour, Until: at(2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tile := r.Tiles[0]; tile.Fetches != 2 || tile.Added+tile.Removed+tile.Moved != 0 {
+		t.Fatalf("changes outside the window were counted: %+v", tile)
+	}
+
+	if _, err := tilestats.Compute(ctx, s, tilestats.Options{Interval: 500 * time.Millisecond}); err == nil {
+		t.Fatal("accepted an interval under a second")
+	}
+}
@@ -1,8 +1,26 @@
 package lib
 
+import (
+	"github.com/acheong08/apple-corelocation-experiments/lib/geo/datum"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
+	"github.com/acheong08/apple-corelocation-experiments/lib/oui"
+)
+
 type AP struct {
-	BSSID    string
+	BSSID    mac.Addr
 	Location Location
+	// Vendor is only set when requested with Options.WithVendors
+	Vendor *oui.Vendor `json:",omitempty"`
+}
+
+// AnnotateVendors looks up the vendor of every access point in the default
+// OUI registry
+func AnnotateVendors(aps []AP) {
+	for i := range aps {
+		if v, ok := oui.Lookup(aps[i].BSSID); ok {
+			aps[i].Vendor = &v
+		}
+	}
 }
 
 type Cell struct {
@@ -19,4 +37,13 @@ type TowerInfo struct {
 
 type Location struct {
 	Long, Lat, Alt float64
+	// Datum of the coordinates. The China endpoints return GCJ-02.
+	Datum datum.Datum
+}
+
+// ToWGS84 returns the location converted to WGS-84
+func (l Location) ToWGS84() Location {
+	l.Lat, l.Long = datum.Convert(l.Lat, l.Long, l.Datum, datum.WGS84)
+	l.Datum = datum.WGS84
+	return l
 }
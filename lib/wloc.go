@@ -3,9 +3,10 @@ package lib
 import (
 	"bytes"
 	"errors"
+	"github.com/acheong08/apple-corelocation-experiments/lib/geo/datum"
+	"github.com/acheong08/apple-corelocation-experiments/lib/mac"
 	"github.com/acheong08/apple-corelocation-experiments/pb"
 	"io"
-	"log"
 	"math"
 	"net/http"
 
@@ -27,32 +28,27 @@ func SerializeProto(p protoreflect.ProtoMessage, arpcReq ArpcRequest) ([]byte, e
 }
 
 func RequestWloc(block *pb.AppleWLoc, options ...Modifier) (*pb.AppleWLoc, error) {
-	args := newWlocArgs()
-	if len(options) != 0 {
-		for _, option := range options {
-			if option != nil {
-				option(&args)
-			}
-		}
-	}
+	args := newWlocArgs(options...)
 	// Serialize to bytes
 	serializedBlock, err := SerializeProto(block, wlocArpcRequest)
 	if err != nil {
 		return nil, errors.New("failed to serialize protobuf")
 	}
 	var wlocURL string = "https://gs-loc.apple.com"
-	switch args.region {
+	switch args.wlocRegion(block) {
 	case Options.China:
-		log.Println("Using China API")
 		wlocURL = "https://gs-loc-cn.apple.com"
 	}
+	if args.endpoint != "" {
+		wlocURL = args.endpoint
+	}
 	wlocURL = wlocURL + "/clls/wloc"
 	// Make HTTP request
 	req, _ := http.NewRequest(http.MethodPost, wlocURL, bytes.NewReader(serializedBlock))
 	for key, val := range headers {
 		req.Header.Set(key, val)
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := args.httpClient().Do(req)
 	if err != nil {
 		return nil, errors.New("failed to make request")
 	}
@@ -61,7 +57,7 @@ func RequestWloc(block *pb.AppleWLoc, options ...Modifier) (*pb.AppleWLoc, error
 		if resp.StatusCode == 0 {
 			return nil, errors.New("cors issue probably")
 		}
-		return nil, errors.New(http.StatusText(resp.StatusCode))
+		return nil, &StatusError{Code: resp.StatusCode}
 	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -72,6 +68,7 @@ func RequestWloc(block *pb.AppleWLoc, options ...Modifier) (*pb.AppleWLoc, error
 	if err != nil {
 		return nil, errors.New("failed to unmarshal response protobuf")
 	}
+	args.audit.Observe(&respBlock)
 	return &respBlock, nil
 }
 
@@ -90,10 +87,25 @@ func QueryBssid(bssids []string, maxResults int32, options ...Modifier) ([]AP, e
 		block.WifiDevices[i] = &pb.WifiDevice{Bssid: bssid}
 	}
 	block.NumWifiResults = &maxResults
-	block, err := RequestWloc(block, options...)
+	args := newWlocArgs(options...)
+	respBlock, err := RequestWloc(block, options...)
 	if err != nil {
 		return nil, err
 	}
+	resp := apsFromWloc(respBlock, args.wlocRegion(block).datum())
+	if len(resp) == 0 && args.region == auto && args.hint == nil {
+		// Without a location to go on, try China before giving up
+		respBlock, err = RequestWloc(block, append(options, Options.WithRegion(Options.China))...)
+		if err != nil {
+			return nil, err
+		}
+		resp = apsFromWloc(respBlock, datum.GCJ02)
+	}
+	args.finishAPs(resp)
+	return resp, nil
+}
+
+func apsFromWloc(block *pb.AppleWLoc, coordDatum datum.Datum) []AP {
 	resp := make([]AP, len(block.GetWifiDevices()))
 	i := 0
 	for _, d := range block.GetWifiDevices() {
@@ -103,18 +115,22 @@ func QueryBssid(bssids []string, maxResults int32, options ...Modifier) ([]AP, e
 		if long == -180 && lat == -180 {
 			continue
 		}
+		bssid, err := mac.ParseAddr(d.GetBssid())
+		if err != nil {
+			continue
+		}
 		resp[i] = AP{
-			BSSID: d.GetBssid(),
+			BSSID: bssid,
 			Location: Location{
-				Long: long,
-				Lat:  lat,
-				Alt:  alt,
+				Long:  long,
+				Lat:   lat,
+				Alt:   alt,
+				Datum: coordDatum,
 			},
 		}
 		i++
 	}
-	resp = resp[:i]
-	return resp, nil
+	return resp[:i]
 }
 
 func QueryCell(mcc, mnc, cellid, tacid uint32, numResults int32, options ...Modifier) ([]Cell, error) {
@@ -131,6 +147,8 @@ func QueryCell(mcc, mnc, cellid, tacid uint32, numResults int32, options ...Modi
 			Model:           "iPhone12,1",
 		},
 	}
+	args := newWlocArgs(options...)
+	d := args.wlocRegion(block).datum()
 	block, err := RequestWloc(block, options...)
 	if err != nil {
 		return nil, err
@@ -145,15 +163,34 @@ func QueryCell(mcc, mnc, cellid, tacid uint32, numResults int32, options ...Modi
 				TacId:  c.GetTacId(),
 			},
 			Location: Location{
-				Long: CoordFromInt(c.GetLocation().GetLongitude(), -8),
-				Lat:  CoordFromInt(c.GetLocation().GetLatitude(), -8),
-				Alt:  CoordFromInt(c.GetLocation().GetAltitude(), -8),
+				Long:  CoordFromInt(c.GetLocation().GetLongitude(), -8),
+				Lat:   CoordFromInt(c.GetLocation().GetLatitude(), -8),
+				Alt:   CoordFromInt(c.GetLocation().GetAltitude(), -8),
+				Datum: d,
 			},
 		}
+		if args.wgs84 {
+			cells[i].Location = cells[i].Location.ToWGS84()
+		}
 	}
 	return cells, nil
 }
 
+// wlocRegion resolves automatic region selection for a wloc request
+func (wa wlocArgs) wlocRegion(block *pb.AppleWLoc) _region {
+	if wa.region != auto {
+		return wa.region
+	}
+	if wa.hint != nil {
+		return wa.regionAt(wa.hint.Lat, wa.hint.Long)
+	}
+	// Mobile country codes assigned to mainland China
+	if mcc := block.GetCellTowerRequest().GetMcc(); mcc == 460 || mcc == 461 {
+		return china
+	}
+	return international
+}
+
 func CoordFromInt(n int64, pow int) float64 {
 	return float64(n) * math.Pow10(pow)
 }